@@ -40,28 +40,58 @@ func main() {
 		"Plan vacation", "Clean kitchen", "Fix bug #42", "Review PR", "Learn Go generics",
 	}
 	tags := []string{"home", "work", "study", "health", "fun"}
+	priorities := []string{"low", "normal", "normal", "high", "urgent"}
 
 	now := time.Now().UTC()
 
-	stmt, err := db.Prepare(`INSERT INTO todos (id, title, completed, created_at, updated_at) VALUES ($1, $2, $3, $4, $5) ON CONFLICT (id) DO NOTHING`)
+	stmt, err := db.Prepare(`INSERT INTO todos (id, title, completed, status, completed_at, priority, due_at, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) ON CONFLICT (id) DO NOTHING`)
 	if err != nil {
 		log.Fatalf("prepare: %v", err)
 	}
 	defer func() { _ = stmt.Close() }()
 
+	if _, err := db.Exec(`INSERT INTO tags (name) SELECT unnest($1::text[]) ON CONFLICT (name) DO NOTHING`, tags); err != nil {
+		log.Fatalf("insert tag names: %v", err)
+	}
+	tagStmt, err := db.Prepare(`INSERT INTO todo_tags (todo_id, tag) SELECT $1, unnest($2::text[]) ON CONFLICT DO NOTHING`)
+	if err != nil {
+		log.Fatalf("prepare tags: %v", err)
+	}
+	defer func() { _ = tagStmt.Close() }()
+
 	inserted := 0
 	for i := 0; i < count; i++ {
 		id := uuid.NewString()
-		title := fmt.Sprintf("%s [%s] #%d", titles[rand.Intn(len(titles))], tags[rand.Intn(len(tags))], i+1)
+		title := fmt.Sprintf("%s #%d", titles[rand.Intn(len(titles))], i+1)
 		completed := i%3 == 0
+		status := "open"
+		if i%5 == 1 {
+			status = "in_progress"
+		}
 		createdAt := now.Add(-time.Duration(rand.Intn(96)) * time.Hour) // within last 4 days
 		updatedAt := createdAt
+		var completedAt *time.Time
 		if completed {
 			updatedAt = createdAt.Add(time.Duration(rand.Intn(12)) * time.Hour)
+			completedAt = &updatedAt
+			status = "done"
+		}
+		priority := priorities[rand.Intn(len(priorities))]
+		var dueAt *time.Time
+		if i%2 == 0 {
+			d := createdAt.Add(time.Duration(rand.Intn(168)) * time.Hour) // within a week of creation
+			dueAt = &d
 		}
-		if _, err := stmt.Exec(id, title, completed, createdAt, updatedAt); err != nil {
+		if _, err := stmt.Exec(id, title, completed, status, completedAt, priority, dueAt, createdAt, updatedAt); err != nil {
 			log.Fatalf("insert: %v", err)
 		}
+		todoTags := []string{tags[rand.Intn(len(tags))]}
+		if i%4 == 0 {
+			todoTags = append(todoTags, tags[rand.Intn(len(tags))])
+		}
+		if _, err := tagStmt.Exec(id, todoTags); err != nil {
+			log.Fatalf("insert tags: %v", err)
+		}
 		inserted++
 	}
 
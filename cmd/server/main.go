@@ -12,7 +12,11 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/jplaulau14/go-todo-api/internal/auth"
 	"github.com/jplaulau14/go-todo-api/internal/config"
+	"github.com/jplaulau14/go-todo-api/internal/idempotency"
+	"github.com/jplaulau14/go-todo-api/internal/jwt"
+	"github.com/jplaulau14/go-todo-api/internal/ratelimit"
 	"github.com/jplaulau14/go-todo-api/internal/reqctx"
 	"github.com/jplaulau14/go-todo-api/internal/todo"
 	"github.com/rs/cors"
@@ -54,8 +58,13 @@ func main() {
 	})
 
 	var (
-		repo todo.Repository
-		db   *sql.DB
+		repo   todo.Repository
+		lists  todo.ListRepository
+		items  todo.ChecklistRepository
+		grants todo.GrantRepository
+		idem   idempotency.Store
+		keys   auth.Store
+		db     *sql.DB
 	)
 	if dsn := cfg.DatabaseDSN; dsn != "" {
 		var err error
@@ -67,16 +76,27 @@ func main() {
 			if err := db.Ping(); err != nil {
 				logger.Error("db ping failed", "error", err)
 			} else {
-				repo = todo.NewPostgresRepository(db)
+				pg := todo.NewPostgresRepository(db)
+				repo, lists, items, grants = pg, pg, pg, pg
+				idem = idempotency.NewPostgresStore(db)
+				keys = auth.NewPostgresStore(db)
 			}
 		}
-	} else {
-		repo = todo.NewInMemoryRepository()
 	}
 	if repo == nil {
-		repo = todo.NewInMemoryRepository()
+		mem := todo.NewInMemoryRepository()
+		repo, lists, items, grants = mem, mem, mem, mem
+		idem = idempotency.NewMemoryStore()
+		keys = auth.NewMemoryStore()
 	}
-	todoHandler := todo.NewHTTPHandler(repo)
+	if cfg.AdminAPIKey != "" {
+		if err := auth.Bootstrap(context.Background(), keys, cfg.AdminAPIKey); err != nil {
+			logger.Error("could not register ADMIN_API_KEY", "error", err)
+			os.Exit(1)
+		}
+	}
+	todoHandler := todo.NewHTTPHandler(repo).WithLists(lists).WithChecklists(items).WithGrants(grants).
+		WithIdempotency(idem, cfg.IdempotencyTTL).WithImportLimit(cfg.ImportMaxBytes)
 	todoHandler.RegisterRoutes(mux)
 
 	mux.HandleFunc("/readyz", readyzHandler(db))
@@ -95,12 +115,56 @@ func main() {
 	addr := ":" + strconv.Itoa(cfg.Port)
 	_ = todoHandler.WithLogger(logger)
 
+	// Liveness and readiness probes stay open so that orchestrators need
+	// no key, and are not rate limited.
+	public := []string{"/healthz", "/readyz"}
+	// Rate limiting runs inside authentication so that it can tell clients
+	// apart by key or subject, and is guarded outside it by address so that
+	// requests authentication rejects are limited too.
+	limits := ratelimit.Options{
+		Read:           ratelimit.Limit{PerMinute: cfg.RateLimitRead, Burst: cfg.RateLimitReadBurst},
+		Write:          ratelimit.Limit{PerMinute: cfg.RateLimitWrite, Burst: cfg.RateLimitWriteBurst},
+		TrustedProxies: cfg.TrustedProxies,
+		Public:         public,
+	}
+	app := ratelimit.Middleware(limits)(mux)
+	switch cfg.AuthMode {
+	case config.AuthAPIKey, config.AuthJWT:
+		// Keys are only managed when they are checked; without
+		// authentication /admin/keys does not exist.
+		auth.NewHTTPHandler(keys).WithLogger(logger).RegisterRoutes(mux)
+		opts := auth.Options{Keys: keys, TenantClaim: cfg.JWTTenantClaim, Public: public, Logger: logger}
+		if cfg.AuthMode == config.AuthJWT {
+			var jwks jwt.Keys
+			if cfg.JWKSFile != "" {
+				file, err := jwt.LoadKeysFile(cfg.JWKSFile)
+				if err != nil {
+					logger.Error("could not load JWKS_FILE", "error", err)
+					os.Exit(1)
+				}
+				jwks = file
+			} else {
+				remote := jwt.NewRemoteKeys(cfg.JWKSURL, cfg.JWKSRefresh)
+				// Fetch early so that a bad URL shows up in the startup logs.
+				if _, err := remote.KeySet(context.Background(), false); err != nil {
+					logger.Warn("could not fetch JWKS_URL", "error", err)
+				}
+				jwks = remote
+			}
+			opts.JWT = jwt.NewVerifier(jwks, jwt.Options{Issuer: cfg.JWTIssuer, Audience: cfg.JWTAudience, Leeway: cfg.JWTClockSkew})
+		}
+		app = ratelimit.Guard(limits)(auth.Middleware(opts)(app))
+	default:
+		logger.Warn("authentication is disabled", "auth_mode", cfg.AuthMode)
+	}
+
 	corsHandler := cors.New(cors.Options{
 		AllowedOrigins:   cfg.AllowedOrigins,
-		AllowedMethods:   []string{http.MethodGet, http.MethodPost, http.MethodPatch, http.MethodDelete, http.MethodOptions},
-		AllowedHeaders:   []string{"Content-Type", "Authorization"},
+		AllowedMethods:   []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodOptions},
+		AllowedHeaders:   []string{"Content-Type", "Authorization", "If-Match", "If-None-Match", "If-Modified-Since", "Idempotency-Key"},
+		ExposedHeaders:   []string{"ETag", "Last-Modified", "Idempotent-Replayed", "Content-Disposition", "WWW-Authenticate", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Retry-After"},
 		AllowCredentials: false,
-	}).Handler(recoverMiddleware(logger, requestIDMiddleware(mux)))
+	}).Handler(recoverMiddleware(logger, requestIDMiddleware(app)))
 
 	handler := loggingMiddleware(logger, corsHandler)
 
@@ -113,6 +177,14 @@ func main() {
 		IdleTimeout:       60 * time.Second,
 	}
 
+	// Background jobs stop when the server shuts down.
+	jobs, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+	if cfg.TrashRetention > 0 {
+		go todo.PurgeTrash(jobs, repo, cfg.TrashRetention, min(cfg.TrashRetention, time.Hour), logger)
+	}
+	go idempotency.Sweep(jobs, idem, min(cfg.IdempotencyTTL, time.Hour), logger)
+
 	errCh := make(chan error, 1)
 	go func() {
 		logger.Info("server starting", "addr", addr)
@@ -0,0 +1,349 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jplaulau14/go-todo-api/internal/jwt"
+	"github.com/jplaulau14/go-todo-api/internal/reqctx"
+)
+
+func setupServer(t *testing.T) (http.Handler, *MemoryStore, string) {
+	t.Helper()
+	store := NewMemoryStore()
+	admin := "tk_" + strings.Repeat("b", 40)
+	if err := Bootstrap(context.Background(), store, admin); err != nil {
+		t.Fatalf("bootstrap: %v", err)
+	}
+	mux := http.NewServeMux()
+	NewHTTPHandler(store).RegisterRoutes(mux)
+	mux.HandleFunc("/todos", func(w http.ResponseWriter, r *http.Request) {
+		p, _ := reqctx.GetPrincipal(r.Context())
+		_, _ = w.Write([]byte(p.Subject))
+	})
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {})
+	return Middleware(Options{Keys: store, Public: []string{"/healthz"}})(mux), store, admin
+}
+
+func send(srv http.Handler, method, path, token, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	return w
+}
+
+func TestMiddleware_Scopes(t *testing.T) {
+	srv, store, admin := setupServer(t)
+
+	w := send(srv, http.MethodPost, "/admin/keys", admin, `{"name":"reader","scopes":["read","read"]}`)
+	if w.Code != http.StatusCreated || w.Header().Get("Location") == "" {
+		t.Fatalf("create key: %d %s", w.Code, w.Body)
+	}
+	var reader createdKey
+	_ = json.NewDecoder(w.Body).Decode(&reader)
+	if !strings.HasPrefix(reader.Token, reader.Prefix) || len(reader.Scopes) != 1 {
+		t.Fatalf("unexpected key: %+v", reader)
+	}
+	if stored, _ := store.Get(context.Background(), reader.ID); stored.Hash == "" || strings.Contains(stored.Hash, reader.Token) {
+		t.Fatalf("expected only a hash to be stored, got %q", stored.Hash)
+	}
+
+	cases := []struct {
+		method, path, token string
+		status              int
+	}{
+		{http.MethodGet, "/healthz", "", http.StatusOK},
+		{http.MethodGet, "/todos", "", http.StatusUnauthorized},
+		{http.MethodGet, "/todos", "tk_unknown-key-value", http.StatusUnauthorized},
+		{http.MethodGet, "/todos", "not-a-key", http.StatusUnauthorized},
+		{http.MethodGet, "/todos", reader.Token, http.StatusOK},
+		{http.MethodPost, "/todos", reader.Token, http.StatusForbidden},
+		{http.MethodGet, "/admin/keys", reader.Token, http.StatusForbidden},
+		{http.MethodPost, "/todos", admin, http.StatusOK},
+	}
+	for _, tc := range cases {
+		w := send(srv, tc.method, tc.path, tc.token, "")
+		if w.Code != tc.status {
+			t.Fatalf("%s %s with %q: expected %d, got %d", tc.method, tc.path, tc.token, tc.status, w.Code)
+		}
+		if tc.status == http.StatusUnauthorized && !strings.HasPrefix(w.Header().Get("WWW-Authenticate"), "Bearer") {
+			t.Fatalf("expected a Bearer challenge, got %q", w.Header().Get("WWW-Authenticate"))
+		}
+	}
+	w = send(srv, http.MethodPost, "/todos", reader.Token, "")
+	var e errorResponse
+	_ = json.NewDecoder(w.Body).Decode(&e)
+	if e.Code != "forbidden" || !strings.Contains(w.Header().Get("WWW-Authenticate"), `scope="write"`) {
+		t.Fatalf("unexpected forbidden response: %+v %q", e, w.Header().Get("WWW-Authenticate"))
+	}
+	if w := send(srv, http.MethodGet, "/todos", reader.Token, ""); w.Body.String() != reader.ID {
+		t.Fatalf("expected the key as principal, got %q", w.Body)
+	}
+
+	// Use is recorded, at most once per touchInterval.
+	used, _ := store.Get(context.Background(), reader.ID)
+	if used.LastUsedAt == nil {
+		t.Fatalf("expected last_used_at to be set")
+	}
+	send(srv, http.MethodGet, "/todos", reader.Token, "")
+	if again, _ := store.Get(context.Background(), reader.ID); !again.LastUsedAt.Equal(*used.LastUsedAt) {
+		t.Fatalf("expected last_used_at to be throttled")
+	}
+
+	// Revoked keys stop working at once.
+	if w := send(srv, http.MethodDelete, "/admin/keys/"+reader.ID, admin, ""); w.Code != http.StatusOK {
+		t.Fatalf("revoke: %d %s", w.Code, w.Body)
+	}
+	if w := send(srv, http.MethodGet, "/todos", reader.Token, ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected revoked key to be rejected, got %d", w.Code)
+	}
+}
+
+// hsToken signs claims with the HS256 key of the jwt package's test key set.
+func hsToken(claims map[string]any) string {
+	h, _ := json.Marshal(map[string]string{"alg": "HS256", "kid": "hs-1"})
+	c, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+	mac := hmac.New(sha256.New, []byte("test-only-hs256-secret-of-32-bytes!"))
+	mac.Write([]byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestMiddleware_JWT(t *testing.T) {
+	keys, err := jwt.LoadKeysFile("../jwt/testdata/jwks.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewMemoryStore()
+	admin := "tk_" + strings.Repeat("d", 40)
+	_ = Bootstrap(context.Background(), store, admin)
+	var got reqctx.Principal
+	srv := Middleware(Options{
+		Keys:        store,
+		JWT:         jwt.NewVerifier(keys, jwt.Options{Issuer: "https://issuer.example", Audience: "todo-api", Leeway: time.Minute}),
+		TenantClaim: "org",
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = reqctx.GetPrincipal(r.Context())
+	}))
+	exp := time.Now().Add(time.Hour).Unix()
+	token := func(overrides map[string]any) string {
+		c := map[string]any{"sub": "user-1", "iss": "https://issuer.example", "aud": "todo-api", "exp": exp, "scope": "openid read", "org": "acme"}
+		for k, v := range overrides {
+			c[k] = v
+		}
+		return hsToken(c)
+	}
+
+	if w := send(srv, http.MethodGet, "/todos", token(nil), ""); w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d %s", w.Code, w.Body)
+	}
+	if got.Subject != "user-1" || got.TenantID != "acme" || len(got.Scopes) != 1 || got.Claims["iss"] != "https://issuer.example" {
+		t.Fatalf("unexpected principal: %+v", got)
+	}
+	cases := []struct {
+		method, token string
+		status        int
+	}{
+		{http.MethodPost, token(nil), http.StatusForbidden},
+		{http.MethodPost, token(map[string]any{"scope": nil, "scp": []string{"write"}}), http.StatusOK},
+		{http.MethodGet, token(map[string]any{"scope": "openid"}), http.StatusForbidden},
+		{http.MethodGet, token(map[string]any{"exp": time.Now().Add(-time.Hour).Unix()}), http.StatusUnauthorized},
+		{http.MethodGet, token(map[string]any{"aud": "someone-else"}), http.StatusUnauthorized},
+		{http.MethodGet, token(map[string]any{"sub": ""}), http.StatusUnauthorized},
+		{http.MethodGet, "not.a.jwt", http.StatusUnauthorized},
+		// API keys keep working next to tokens.
+		{http.MethodPost, admin, http.StatusOK},
+	}
+	for i, tc := range cases {
+		w := send(srv, tc.method, "/todos", tc.token, "")
+		if w.Code != tc.status {
+			t.Fatalf("case %d: expected %d, got %d %s", i, tc.status, w.Code, w.Body)
+		}
+		if tc.status == http.StatusUnauthorized && !strings.Contains(w.Header().Get("WWW-Authenticate"), "invalid_token") {
+			t.Fatalf("case %d: expected an invalid_token challenge, got %q", i, w.Header().Get("WWW-Authenticate"))
+		}
+	}
+}
+
+func TestHTTP_AdminKeys(t *testing.T) {
+	srv, _, admin := setupServer(t)
+
+	for _, body := range []string{`{"name":"","scopes":["read"]}`, `{"name":"x","scopes":[]}`, `{"name":"x","scopes":["root"]}`, `{"name":"x","scopes":["read"],"extra":1}`} {
+		if w := send(srv, http.MethodPost, "/admin/keys", admin, body); w.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400 for %s, got %d", body, w.Code)
+		}
+	}
+	w := send(srv, http.MethodPost, "/admin/keys", admin, `{"name":"ci","scopes":["write"]}`)
+	var created createdKey
+	_ = json.NewDecoder(w.Body).Decode(&created)
+	if created.OwnerID != created.ID || created.TenantID != "" {
+		t.Fatalf("expected a key to own its data by default, got %+v", created.Key)
+	}
+	w = send(srv, http.MethodPost, "/admin/keys", admin, `{"name":"for alice","scopes":["read"],"owner_id":"alice","tenant_id":"acme"}`)
+	var delegated createdKey
+	_ = json.NewDecoder(w.Body).Decode(&delegated)
+	if w := send(srv, http.MethodGet, "/todos", delegated.Token, ""); w.Body.String() != "alice" {
+		t.Fatalf("expected the key to act for its owner, got %q", w.Body)
+	}
+	if w.Header().Get("Cache-Control") != "no-store" {
+		t.Fatalf("expected the token response not to be cached")
+	}
+
+	w = send(srv, http.MethodGet, "/admin/keys", admin, "")
+	var keys []map[string]any
+	_ = json.NewDecoder(w.Body).Decode(&keys)
+	if len(keys) != 3 || keys[1]["name"] != "ci" {
+		t.Fatalf("unexpected keys: %v", keys)
+	}
+	for _, k := range keys {
+		if _, ok := k["token"]; ok {
+			t.Fatalf("tokens must not be listed: %v", k)
+		}
+		if _, ok := k["hash"]; ok {
+			t.Fatalf("hashes must not be listed: %v", k)
+		}
+	}
+
+	w = send(srv, http.MethodDelete, "/admin/keys/"+created.ID, admin, "")
+	var revoked Key
+	_ = json.NewDecoder(w.Body).Decode(&revoked)
+	if revoked.RevokedAt == nil {
+		t.Fatalf("expected revoked_at, got %+v", revoked)
+	}
+	time.Sleep(time.Millisecond)
+	w = send(srv, http.MethodDelete, "/admin/keys/"+created.ID, admin, "")
+	var again Key
+	_ = json.NewDecoder(w.Body).Decode(&again)
+	if !again.RevokedAt.Equal(*revoked.RevokedAt) {
+		t.Fatalf("revoking twice must keep the first time")
+	}
+	if w := send(srv, http.MethodGet, "/admin/keys/missing", admin, ""); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", w.Code)
+	}
+
+	// Without authentication in front, nobody manages keys.
+	open := http.NewServeMux()
+	NewHTTPHandler(NewMemoryStore()).RegisterRoutes(open)
+	for _, path := range []string{"/admin/keys", "/admin/keys/" + created.ID} {
+		if w := send(open, http.MethodGet, path, "", ""); w.Code != http.StatusForbidden {
+			t.Fatalf("%s: expected 403 without a principal, got %d", path, w.Code)
+		}
+	}
+	if w := send(open, http.MethodPost, "/admin/keys", "", `{"name":"x","scopes":["admin"]}`); w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 creating a key without a principal, got %d", w.Code)
+	}
+}
+
+func TestHTTP_AdminKeysTenants(t *testing.T) {
+	jwks, err := jwt.LoadKeysFile("../jwt/testdata/jwks.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewMemoryStore()
+	operator := "tk_" + strings.Repeat("e", 40)
+	_ = Bootstrap(context.Background(), store, operator)
+	mux := http.NewServeMux()
+	NewHTTPHandler(store).RegisterRoutes(mux)
+	mux.HandleFunc("/todos", func(w http.ResponseWriter, r *http.Request) {
+		p, _ := reqctx.GetPrincipal(r.Context())
+		_, _ = w.Write([]byte(p.TenantID + "/" + p.Subject))
+	})
+	srv := Middleware(Options{
+		Keys:        store,
+		JWT:         jwt.NewVerifier(jwks, jwt.Options{Issuer: "https://issuer.example", Audience: "todo-api", Leeway: time.Minute}),
+		TenantClaim: "org",
+	})(mux)
+	admin := func(org string) string {
+		c := map[string]any{"sub": "admin-1", "iss": "https://issuer.example", "aud": "todo-api", "exp": time.Now().Add(time.Hour).Unix(), "scope": "admin"}
+		if org != "" {
+			c["org"] = org
+		}
+		return hsToken(c)
+	}
+	decode := func(w *httptest.ResponseRecorder) createdKey {
+		var k createdKey
+		_ = json.NewDecoder(w.Body).Decode(&k)
+		return k
+	}
+
+	victim := decode(send(srv, http.MethodPost, "/admin/keys", operator, `{"name":"bob","scopes":["write"],"owner_id":"bob","tenant_id":"globex"}`))
+
+	// An admin token from acme cannot mint a key into globex.
+	if w := send(srv, http.MethodPost, "/admin/keys", admin("acme"), `{"name":"x","scopes":["write"],"owner_id":"bob","tenant_id":"globex"}`); w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for another tenant, got %d %s", w.Code, w.Body)
+	}
+	w := send(srv, http.MethodPost, "/admin/keys", admin("acme"), `{"name":"ci","scopes":["admin"]}`)
+	own := decode(w)
+	if w.Code != http.StatusCreated || own.TenantID != "acme" {
+		t.Fatalf("expected a key in the caller's tenant, got %d %+v", w.Code, own.Key)
+	}
+	if w := send(srv, http.MethodGet, "/todos", own.Token, ""); w.Body.String() != "acme/"+own.ID {
+		t.Fatalf("expected the key to act in acme, got %q", w.Body)
+	}
+	// Neither can that key, nor a token without a tenant mint an operator.
+	if w := send(srv, http.MethodPost, "/admin/keys", own.Token, `{"name":"x","scopes":["read"],"tenant_id":"globex"}`); w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for a tenant's admin key, got %d", w.Code)
+	}
+	if w := send(srv, http.MethodPost, "/admin/keys", admin(""), `{"name":"x","scopes":["admin"]}`); w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for a tenantless admin key, got %d", w.Code)
+	}
+	// Nor can a tenant's admin mint a key acting for another subject, only
+	// for itself.
+	if w := send(srv, http.MethodPost, "/admin/keys", admin("acme"), `{"name":"x","scopes":["write"],"owner_id":"carol"}`); w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for another owner, got %d %s", w.Code, w.Body)
+	}
+	w = send(srv, http.MethodPost, "/admin/keys", admin("acme"), `{"name":"mine","scopes":["write"],"owner_id":"admin-1"}`)
+	if mine := decode(w); w.Code != http.StatusCreated || mine.OwnerID != "admin-1" {
+		t.Fatalf("expected a key acting for the caller, got %d %+v", w.Code, mine.Key)
+	}
+
+	// Other tenants' keys are invisible.
+	var keys []Key
+	_ = json.NewDecoder(send(srv, http.MethodGet, "/admin/keys", admin("acme"), "").Body).Decode(&keys)
+	if len(keys) != 2 || keys[0].TenantID != "acme" || keys[1].TenantID != "acme" {
+		t.Fatalf("expected only acme's keys, got %+v", keys)
+	}
+	for _, method := range []string{http.MethodGet, http.MethodDelete} {
+		if w := send(srv, method, "/admin/keys/"+victim.ID, admin("acme"), ""); w.Code != http.StatusNotFound {
+			t.Fatalf("%s: expected 404 for globex's key, got %d", method, w.Code)
+		}
+	}
+	if w := send(srv, http.MethodGet, "/todos", victim.Token, ""); w.Code != http.StatusOK {
+		t.Fatalf("expected globex's key to keep working, got %d", w.Code)
+	}
+	_ = json.NewDecoder(send(srv, http.MethodGet, "/admin/keys", operator, "").Body).Decode(&keys)
+	if len(keys) != 4 {
+		t.Fatalf("expected the operator to see every key, got %d", len(keys))
+	}
+}
+
+func TestBootstrap(t *testing.T) {
+	store := NewMemoryStore()
+	token := "tk_" + strings.Repeat("c", 40)
+	for i := 0; i < 2; i++ {
+		if err := Bootstrap(context.Background(), store, token); err != nil {
+			t.Fatalf("bootstrap %d: %v", i, err)
+		}
+	}
+	if keys, _ := store.List(context.Background()); len(keys) != 1 || !keys[0].Allows(ScopeAdmin) {
+		t.Fatalf("expected one admin key, got %+v", keys)
+	}
+	if err := Bootstrap(context.Background(), store, "tk_short"); err == nil {
+		t.Fatalf("expected short bootstrap keys to be rejected")
+	}
+}
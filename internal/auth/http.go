@@ -0,0 +1,265 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"mime"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jplaulau14/go-todo-api/internal/reqctx"
+)
+
+// HTTPHandler serves the /admin/keys routes for managing API keys.
+type HTTPHandler struct {
+	store  Store
+	logger *slog.Logger
+}
+
+func NewHTTPHandler(store Store) *HTTPHandler {
+	return &HTTPHandler{store: store, logger: slog.Default()}
+}
+
+func (h *HTTPHandler) WithLogger(logger *slog.Logger) *HTTPHandler {
+	if logger == nil {
+		return h
+	}
+	h.logger = logger
+	return h
+}
+
+// RegisterRoutes adds the /admin/keys routes. They answer 403 to requests
+// without a principal, so that key management is closed when the server
+// runs without authentication.
+func (h *HTTPHandler) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/admin/keys", func(w http.ResponseWriter, r *http.Request) {
+		if !authenticated(w, r) {
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			h.list(w, r)
+		case http.MethodPost:
+			h.create(w, r)
+		default:
+			writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	})
+	mux.HandleFunc("/admin/keys/", func(w http.ResponseWriter, r *http.Request) {
+		if !authenticated(w, r) {
+			return
+		}
+		id := strings.TrimPrefix(r.URL.Path, "/admin/keys/")
+		if id == "" || strings.Contains(id, "/") {
+			writeError(w, r, http.StatusNotFound, "route not found")
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			h.get(w, r, id)
+		case http.MethodDelete:
+			h.revoke(w, r, id)
+		default:
+			writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	})
+}
+
+// authenticated reports whether r carries a principal, writing 403 if not.
+func authenticated(w http.ResponseWriter, r *http.Request) bool {
+	if _, ok := reqctx.GetPrincipal(r.Context()); !ok {
+		writeError(w, r, http.StatusForbidden, "key management requires authentication")
+		return false
+	}
+	return true
+}
+
+// managerOf returns the tenant whose keys the caller may manage. Operators,
+// API keys without a tenant such as the bootstrap key, manage the keys of
+// every tenant; any other caller, including every JSON Web Token whatever
+// its scopes, only those of its own tenant. A caller nobody authenticated
+// is never an operator; the routes refuse it before asking.
+func managerOf(ctx context.Context) (tenant string, operator bool) {
+	p, ok := reqctx.GetPrincipal(ctx)
+	if !ok {
+		return "", false
+	}
+	return p.TenantID, p.KeyID != "" && p.TenantID == ""
+}
+
+// manages reports whether the caller may see and revoke key.
+func manages(ctx context.Context, key Key) bool {
+	tenant, operator := managerOf(ctx)
+	return operator || key.TenantID == tenant
+}
+
+// createdKey is a new key together with its token, which is only ever
+// returned here.
+type createdKey struct {
+	Key
+	Token string `json:"token"`
+}
+
+func (h *HTTPHandler) create(w http.ResponseWriter, r *http.Request) {
+	if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt != "application/json" {
+		writeError(w, r, http.StatusUnsupportedMediaType, "content-type must be application/json")
+		return
+	}
+	var req CreateKeyRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, r, http.StatusRequestEntityTooLarge, "request body too large")
+			return
+		}
+		writeError(w, r, http.StatusBadRequest, "invalid json")
+		return
+	}
+	if err := req.Validate(); err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	// Keys are created in the caller's tenant unless an operator says
+	// otherwise, and only operators create keys that are operators too or
+	// that act for someone other than the caller.
+	if tenant, operator := managerOf(r.Context()); !operator {
+		if p, _ := reqctx.GetPrincipal(r.Context()); req.OwnerID != "" && req.OwnerID != p.Subject {
+			writeError(w, r, http.StatusForbidden, "keys can only be created for yourself")
+			return
+		}
+		switch {
+		case req.TenantID == "":
+			req.TenantID = tenant
+		case req.TenantID != tenant:
+			writeError(w, r, http.StatusForbidden, "keys can only be created in your own tenant")
+			return
+		}
+		if req.TenantID == "" && slices.Contains(req.Scopes, ScopeAdmin) {
+			writeError(w, r, http.StatusForbidden, "only operator keys can create admin keys without a tenant")
+			return
+		}
+	}
+	token, err := NewToken()
+	var key Key
+	if err == nil {
+		key, err = newKey(req.Name, req.Scopes, token)
+		if req.OwnerID != "" {
+			key.OwnerID = req.OwnerID
+		}
+		key.TenantID = req.TenantID
+	}
+	if err == nil {
+		err = h.store.Create(r.Context(), key)
+	}
+	if err != nil {
+		h.logger.Error("could not create api key", "error", err, "request_id", reqctx.GetRequestID(r.Context()))
+		writeError(w, r, http.StatusInternalServerError, "could not create")
+		return
+	}
+	h.logger.Info("api key created", "key_id", key.ID, "scopes", req.Scopes, "owner_id", key.OwnerID, "tenant_id", key.TenantID, "request_id", reqctx.GetRequestID(r.Context()))
+	w.Header().Set("Location", "/admin/keys/"+key.ID)
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusCreated, createdKey{Key: key, Token: token})
+}
+
+// list returns the keys the caller manages.
+func (h *HTTPHandler) list(w http.ResponseWriter, r *http.Request) {
+	keys, err := h.store.List(r.Context())
+	if err != nil {
+		h.logger.Error("could not list api keys", "error", err, "request_id", reqctx.GetRequestID(r.Context()))
+		writeError(w, r, http.StatusInternalServerError, "could not list")
+		return
+	}
+	keys = slices.DeleteFunc(keys, func(k Key) bool { return !manages(r.Context(), k) })
+	writeJSON(w, http.StatusOK, keys)
+}
+
+// managedKey gets a key the caller manages. Keys of other tenants are
+// reported as ErrNotFound.
+func (h *HTTPHandler) managedKey(ctx context.Context, id string) (Key, error) {
+	key, err := h.store.Get(ctx, id)
+	if err == nil && !manages(ctx, key) {
+		return Key{}, ErrNotFound
+	}
+	return key, err
+}
+
+func (h *HTTPHandler) get(w http.ResponseWriter, r *http.Request, id string) {
+	key, err := h.managedKey(r.Context(), id)
+	if err != nil {
+		h.writeStoreError(w, r, id, err, "could not get")
+		return
+	}
+	writeJSON(w, http.StatusOK, key)
+}
+
+// revoke revokes a key. Keys are kept so that their history stays visible;
+// revoking one again is a no-op.
+func (h *HTTPHandler) revoke(w http.ResponseWriter, r *http.Request, id string) {
+	_, err := h.managedKey(r.Context(), id)
+	var key Key
+	if err == nil {
+		key, err = h.store.Revoke(r.Context(), id, time.Now())
+	}
+	if err != nil {
+		h.writeStoreError(w, r, id, err, "could not revoke")
+		return
+	}
+	h.logger.Info("api key revoked", "key_id", key.ID, "request_id", reqctx.GetRequestID(r.Context()))
+	writeJSON(w, http.StatusOK, key)
+}
+
+func (h *HTTPHandler) writeStoreError(w http.ResponseWriter, r *http.Request, id string, err error, msg string) {
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, r, http.StatusNotFound, "api key not found")
+		return
+	}
+	h.logger.Error(msg+" api key", "id", id, "error", err, "request_id", reqctx.GetRequestID(r.Context()))
+	writeError(w, r, http.StatusInternalServerError, msg)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// errorResponse has the shape of every error the API returns.
+type errorResponse struct {
+	Code      string `json:"code"`
+	String    string `json:"string"`
+	Message   string `json:"message"`
+	Status    int    `json:"status"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+var errorCodes = map[int]string{
+	http.StatusBadRequest:            "bad_request",
+	http.StatusUnauthorized:          "unauthorized",
+	http.StatusForbidden:             "forbidden",
+	http.StatusNotFound:              "not_found",
+	http.StatusMethodNotAllowed:      "method_not_allowed",
+	http.StatusRequestEntityTooLarge: "request_entity_too_large",
+	http.StatusUnsupportedMediaType:  "unsupported_media_type",
+	http.StatusInternalServerError:   "internal",
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	code, ok := errorCodes[status]
+	if !ok {
+		code = "error"
+	}
+	writeJSON(w, status, errorResponse{
+		Code:      code,
+		String:    http.StatusText(status),
+		Message:   message,
+		Status:    status,
+		RequestID: reqctx.GetRequestID(r.Context()),
+	})
+}
@@ -0,0 +1,197 @@
+// Package auth authenticates requests with API keys or JSON Web Tokens and
+// manages the API keys.
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrNotFound is returned for an unknown key.
+	ErrNotFound = errors.New("api key not found")
+	// ErrInvalidToken is returned for a string that is not an API key.
+	ErrInvalidToken = errors.New("malformed api key")
+)
+
+// Scope is a permission granted to a key. Scopes are ordered: write allows
+// everything read does, and admin everything write does.
+type Scope string
+
+const (
+	ScopeRead  Scope = "read"
+	ScopeWrite Scope = "write"
+	ScopeAdmin Scope = "admin"
+)
+
+var scopeRank = map[Scope]int{ScopeRead: 1, ScopeWrite: 2, ScopeAdmin: 3}
+
+func (s Scope) Valid() bool {
+	return scopeRank[s] > 0
+}
+
+// tokenPrefix starts every API key so that leaked keys are easy to
+// recognise and to tell apart from other bearer tokens.
+const tokenPrefix = "tk_"
+
+// displayLength is how many characters of a token are kept in clear to
+// help people tell their keys apart.
+const displayLength = len(tokenPrefix) + 8
+
+// minBootstrapLength is the shortest token Bootstrap accepts, since it is
+// chosen by an operator rather than generated.
+const minBootstrapLength = 32
+
+// Key is an API key. Only a hash of the token is stored; the token itself
+// is shown once, when the key is created.
+type Key struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Prefix is the start of the token.
+	Prefix string  `json:"prefix"`
+	Hash   string  `json:"-"`
+	Scopes []Scope `json:"scopes"`
+	// OwnerID is who the key acts for, and so whose todos it sees. It is
+	// the key's own ID unless another owner, such as the subject of a
+	// user's tokens, was given when the key was created.
+	OwnerID string `json:"owner_id"`
+	// TenantID is the tenant the key acts in, if any.
+	TenantID   string     `json:"tenant_id,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+}
+
+// Allows reports whether the key grants scope. Revoked keys grant nothing.
+func (k Key) Allows(scope Scope) bool {
+	return k.RevokedAt == nil && allows(k.Scopes, scope)
+}
+
+// allows reports whether any of scopes grants scope.
+func allows(scopes []Scope, scope Scope) bool {
+	for _, s := range scopes {
+		if scopeRank[s] >= scopeRank[scope] {
+			return true
+		}
+	}
+	return false
+}
+
+// NewToken generates a random API key token.
+func NewToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return tokenPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashToken returns the hash a key is stored and looked up by. Tokens are
+// long and random, so a fast hash is enough.
+func HashToken(token string) (string, error) {
+	if !strings.HasPrefix(token, tokenPrefix) || len(token) < displayLength {
+		return "", ErrInvalidToken
+	}
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// CreateKeyRequest is the body of POST /admin/keys.
+type CreateKeyRequest struct {
+	Name     string  `json:"name"`
+	Scopes   []Scope `json:"scopes"`
+	OwnerID  string  `json:"owner_id"`
+	TenantID string  `json:"tenant_id"`
+}
+
+// maxOwnerLength bounds owner and tenant IDs.
+const maxOwnerLength = 255
+
+// Validate checks the request and removes repeated scopes.
+func (req *CreateKeyRequest) Validate() error {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		return errors.New("name is required")
+	}
+	if len(req.Name) > 100 {
+		return errors.New("name must be at most 100 characters")
+	}
+	if len(req.Scopes) == 0 {
+		return errors.New("scopes is required")
+	}
+	if len(req.OwnerID) > maxOwnerLength || len(req.TenantID) > maxOwnerLength {
+		return fmt.Errorf("owner_id and tenant_id must be at most %d characters", maxOwnerLength)
+	}
+	var scopes []Scope
+	for _, s := range req.Scopes {
+		if !s.Valid() {
+			return fmt.Errorf("unknown scope %q; must be read, write or admin", s)
+		}
+		if !slices.Contains(scopes, s) {
+			scopes = append(scopes, s)
+		}
+	}
+	req.Scopes = scopes
+	return nil
+}
+
+// Store persists API keys.
+type Store interface {
+	// Create saves a new key, which must have its ID and Hash set.
+	Create(ctx context.Context, key Key) error
+	Get(ctx context.Context, id string) (Key, error)
+	// List returns every key, revoked ones included, oldest first.
+	List(ctx context.Context) ([]Key, error)
+	// Lookup finds the key with the given token hash, revoked or not.
+	Lookup(ctx context.Context, hash string) (Key, error)
+	// Revoke revokes a key at the given time. Revoking a revoked key
+	// keeps its original revocation time.
+	Revoke(ctx context.Context, id string, at time.Time) (Key, error)
+	// Touch records that a key was used at the given time.
+	Touch(ctx context.Context, id string, at time.Time) error
+}
+
+// Bootstrap makes sure token is an admin key in store, so that a fresh
+// deployment has a key to create the others with. A key with the same
+// token that was revoked stays revoked.
+func Bootstrap(ctx context.Context, store Store, token string) error {
+	if len(token) < minBootstrapLength {
+		return fmt.Errorf("%w: a bootstrap key needs at least %d characters", ErrInvalidToken, minBootstrapLength)
+	}
+	key, err := newKey("bootstrap", []Scope{ScopeAdmin}, token)
+	if err != nil {
+		return err
+	}
+	if _, err := store.Lookup(ctx, key.Hash); !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	return store.Create(ctx, key)
+}
+
+// newKey describes a new key for token.
+func newKey(name string, scopes []Scope, token string) (Key, error) {
+	hash, err := HashToken(token)
+	if err != nil {
+		return Key{}, err
+	}
+	id := uuid.NewString()
+	return Key{
+		ID:        id,
+		Name:      name,
+		Prefix:    token[:displayLength],
+		Hash:      hash,
+		Scopes:    scopes,
+		OwnerID:   id,
+		CreatedAt: time.Now().UTC().Truncate(time.Microsecond),
+	}, nil
+}
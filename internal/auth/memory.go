@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps keys in process memory, for development and tests.
+type MemoryStore struct {
+	mu   sync.RWMutex
+	keys map[string]Key
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{keys: make(map[string]Key)}
+}
+
+func (s *MemoryStore) Create(ctx context.Context, key Key) error {
+	_ = ctx
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key.Scopes = slices.Clone(key.Scopes)
+	s.keys[key.ID] = key
+	return nil
+}
+
+func (s *MemoryStore) Get(ctx context.Context, id string) (Key, error) {
+	_ = ctx
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	key, ok := s.keys[id]
+	if !ok {
+		return Key{}, ErrNotFound
+	}
+	return key, nil
+}
+
+func (s *MemoryStore) List(ctx context.Context) ([]Key, error) {
+	_ = ctx
+	s.mu.RLock()
+	keys := make([]Key, 0, len(s.keys))
+	for _, key := range s.keys {
+		keys = append(keys, key)
+	}
+	s.mu.RUnlock()
+	slices.SortFunc(keys, func(a, b Key) int {
+		if c := a.CreatedAt.Compare(b.CreatedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+	return keys, nil
+}
+
+func (s *MemoryStore) Lookup(ctx context.Context, hash string) (Key, error) {
+	_ = ctx
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, key := range s.keys {
+		if key.Hash == hash {
+			return key, nil
+		}
+	}
+	return Key{}, ErrNotFound
+}
+
+func (s *MemoryStore) Revoke(ctx context.Context, id string, at time.Time) (Key, error) {
+	_ = ctx
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, ok := s.keys[id]
+	if !ok {
+		return Key{}, ErrNotFound
+	}
+	if key.RevokedAt == nil {
+		at = at.UTC()
+		key.RevokedAt = &at
+		s.keys[id] = key
+	}
+	return key, nil
+}
+
+func (s *MemoryStore) Touch(ctx context.Context, id string, at time.Time) error {
+	_ = ctx
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, ok := s.keys[id]
+	if !ok {
+		return ErrNotFound
+	}
+	// Concurrent requests may touch out of order; never move backwards.
+	if at = at.UTC(); key.LastUsedAt == nil || at.After(*key.LastUsedAt) {
+		key.LastUsedAt = &at
+		s.keys[id] = key
+	}
+	return nil
+}
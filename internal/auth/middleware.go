@@ -0,0 +1,180 @@
+package auth
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jplaulau14/go-todo-api/internal/jwt"
+	"github.com/jplaulau14/go-todo-api/internal/reqctx"
+)
+
+// touchInterval limits how often a key's last-used time is written, so
+// that busy keys do not cost a write per request.
+const touchInterval = time.Minute
+
+// realm is sent in WWW-Authenticate challenges.
+const realm = "todo-api"
+
+// RequiredScope is the scope a request needs: admin under /admin, read for
+// safe methods and write for everything else.
+func RequiredScope(r *http.Request) Scope {
+	switch {
+	case r.URL.Path == "/admin" || strings.HasPrefix(r.URL.Path, "/admin/"):
+		return ScopeAdmin
+	case r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions:
+		return ScopeRead
+	default:
+		return ScopeWrite
+	}
+}
+
+// BearerToken returns the credentials of an "Authorization: Bearer" header.
+func BearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+// Options configure Middleware.
+type Options struct {
+	// Keys looks up API keys.
+	Keys Store
+	// JWT, if set, verifies bearer tokens that are not API keys as JSON Web
+	// Tokens.
+	JWT *jwt.Verifier
+	// TenantClaim names the claim of a JSON Web Token holding the caller's
+	// tenant. Tokens without it belong to no tenant.
+	TenantClaim string
+	// Public paths need no credentials.
+	Public []string
+	Logger *slog.Logger
+}
+
+// Middleware requires every request, except those for the public paths,
+// to carry an API key, or a JSON Web Token if opts.JWT is set, as a bearer
+// token with the scope RequiredScope asks for. The caller is stored as the
+// request's reqctx.Principal.
+func Middleware(opts Options) func(http.Handler) http.Handler {
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if slices.Contains(opts.Public, r.URL.Path) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			token, ok := BearerToken(r)
+			if !ok {
+				challenge(w, "")
+				writeError(w, r, http.StatusUnauthorized, "credentials are required")
+				return
+			}
+			var (
+				p      reqctx.Principal
+				scopes []Scope
+			)
+			if opts.JWT != nil && !strings.HasPrefix(token, tokenPrefix) {
+				claims, err := opts.JWT.Verify(r.Context(), token)
+				switch {
+				case errors.Is(err, jwt.ErrInvalid):
+					challenge(w, `error="invalid_token"`)
+					writeError(w, r, http.StatusUnauthorized, err.Error())
+					return
+				case err != nil:
+					opts.Logger.Error("could not verify token", "error", err, "request_id", reqctx.GetRequestID(r.Context()))
+					writeError(w, r, http.StatusInternalServerError, "could not authenticate")
+					return
+				case claims.Subject() == "":
+					challenge(w, `error="invalid_token"`)
+					writeError(w, r, http.StatusUnauthorized, "token has no subject")
+					return
+				}
+				scopes = claimScopes(claims)
+				p = reqctx.Principal{Subject: claims.Subject(), Claims: claims}
+				if opts.TenantClaim != "" {
+					p.TenantID, _ = claims[opts.TenantClaim].(string)
+				}
+			} else {
+				key, err := authenticate(r.Context(), opts.Keys, token)
+				switch {
+				case errors.Is(err, ErrInvalidToken) || errors.Is(err, ErrNotFound):
+					challenge(w, `error="invalid_token"`)
+					writeError(w, r, http.StatusUnauthorized, "invalid API key")
+					return
+				case err != nil:
+					opts.Logger.Error("could not look up api key", "error", err, "request_id", reqctx.GetRequestID(r.Context()))
+					writeError(w, r, http.StatusInternalServerError, "could not authenticate")
+					return
+				case key.RevokedAt != nil:
+					challenge(w, `error="invalid_token"`)
+					writeError(w, r, http.StatusUnauthorized, "API key has been revoked")
+					return
+				}
+				if now := time.Now().UTC(); key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) >= touchInterval {
+					if err := opts.Keys.Touch(context.WithoutCancel(r.Context()), key.ID, now); err != nil {
+						opts.Logger.Warn("could not record api key use", "key_id", key.ID, "error", err, "request_id", reqctx.GetRequestID(r.Context()))
+					}
+				}
+				scopes = key.Scopes
+				p = reqctx.Principal{Subject: cmp.Or(key.OwnerID, key.ID), TenantID: key.TenantID, KeyID: key.ID}
+			}
+			if scope := RequiredScope(r); !allows(scopes, scope) {
+				challenge(w, `error="insufficient_scope", scope="`+string(scope)+`"`)
+				writeError(w, r, http.StatusForbidden, "credentials lack the "+string(scope)+" scope")
+				return
+			}
+
+			p.Scopes = make([]string, len(scopes))
+			for i, s := range scopes {
+				p.Scopes[i] = string(s)
+			}
+			next.ServeHTTP(w, r.WithContext(reqctx.WithPrincipal(r.Context(), p)))
+		})
+	}
+}
+
+func authenticate(ctx context.Context, store Store, token string) (Key, error) {
+	hash, err := HashToken(token)
+	if err != nil {
+		return Key{}, err
+	}
+	return store.Lookup(ctx, hash)
+}
+
+// claimScopes returns the scopes a token grants: those of its
+// space-separated scope claim (RFC 8693) and of its scp claim, which some
+// identity providers send as an array. Unknown scopes are ignored.
+func claimScopes(claims jwt.Claims) []Scope {
+	var names []string
+	for _, s := range claims.Strings("scope") {
+		names = append(names, strings.Fields(s)...)
+	}
+	names = append(names, claims.Strings("scp")...)
+	var scopes []Scope
+	for _, name := range names {
+		if s := Scope(name); s.Valid() && !slices.Contains(scopes, s) {
+			scopes = append(scopes, s)
+		}
+	}
+	return scopes
+}
+
+// challenge sets the RFC 6750 WWW-Authenticate header, with params
+// describing what was wrong.
+func challenge(w http.ResponseWriter, params string) {
+	v := `Bearer realm="` + realm + `"`
+	if params != "" {
+		v += ", " + params
+	}
+	w.Header().Set("WWW-Authenticate", v)
+}
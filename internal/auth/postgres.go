@@ -0,0 +1,105 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// PostgresStore keeps keys in the api_keys table.
+type PostgresStore struct {
+	db *sql.DB
+}
+
+func NewPostgresStore(db *sql.DB) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+const keyColumns = `id, name, prefix, hash, array_to_json(scopes), owner_id, tenant_id, created_at, last_used_at, revoked_at`
+
+func scanKey(row interface{ Scan(...any) error }) (Key, error) {
+	var (
+		k                Key
+		scopes           []byte
+		lastUsed, revoke sql.NullTime
+	)
+	if err := row.Scan(&k.ID, &k.Name, &k.Prefix, &k.Hash, &scopes, &k.OwnerID, &k.TenantID, &k.CreatedAt, &lastUsed, &revoke); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Key{}, ErrNotFound
+		}
+		return Key{}, err
+	}
+	if err := json.Unmarshal(scopes, &k.Scopes); err != nil {
+		return Key{}, err
+	}
+	if lastUsed.Valid {
+		t := lastUsed.Time.UTC()
+		k.LastUsedAt = &t
+	}
+	if revoke.Valid {
+		t := revoke.Time.UTC()
+		k.RevokedAt = &t
+	}
+	k.CreatedAt = k.CreatedAt.UTC()
+	return k, nil
+}
+
+func (s *PostgresStore) Create(ctx context.Context, key Key) error {
+	scopes := make([]string, len(key.Scopes))
+	for i, scope := range key.Scopes {
+		scopes[i] = string(scope)
+	}
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO api_keys (id, name, prefix, hash, scopes, owner_id, tenant_id, created_at) VALUES ($1, $2, $3, $4, $5::text[], $6, $7, $8)`,
+		key.ID, key.Name, key.Prefix, key.Hash, scopes, key.OwnerID, key.TenantID, key.CreatedAt,
+	)
+	return err
+}
+
+func (s *PostgresStore) Get(ctx context.Context, id string) (Key, error) {
+	return scanKey(s.db.QueryRowContext(ctx, `SELECT `+keyColumns+` FROM api_keys WHERE id=$1`, id))
+}
+
+func (s *PostgresStore) List(ctx context.Context) ([]Key, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+keyColumns+` FROM api_keys ORDER BY created_at, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	keys := []Key{}
+	for rows.Next() {
+		k, err := scanKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+func (s *PostgresStore) Lookup(ctx context.Context, hash string) (Key, error) {
+	return scanKey(s.db.QueryRowContext(ctx, `SELECT `+keyColumns+` FROM api_keys WHERE hash=$1`, hash))
+}
+
+func (s *PostgresStore) Revoke(ctx context.Context, id string, at time.Time) (Key, error) {
+	return scanKey(s.db.QueryRowContext(ctx,
+		`UPDATE api_keys SET revoked_at=COALESCE(revoked_at, $2) WHERE id=$1 RETURNING `+keyColumns,
+		id, at.UTC(),
+	))
+}
+
+func (s *PostgresStore) Touch(ctx context.Context, id string, at time.Time) error {
+	// Concurrent requests may touch out of order; never move backwards.
+	res, err := s.db.ExecContext(ctx,
+		`UPDATE api_keys SET last_used_at=GREATEST(last_used_at, $2) WHERE id=$1`, id, at.UTC(),
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
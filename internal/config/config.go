@@ -2,9 +2,12 @@ package config
 
 import (
 	"errors"
+	"net/netip"
+	"net/url"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type LogLevel string
@@ -16,12 +19,57 @@ const (
 	LogError LogLevel = "error"
 )
 
+type AuthMode string
+
+const (
+	AuthNone   AuthMode = "none"
+	AuthAPIKey AuthMode = "api_key"
+	// AuthJWT accepts JSON Web Tokens as well as API keys.
+	AuthJWT AuthMode = "jwt"
+)
+
 type Config struct {
 	Port           int
 	DatabaseDSN    string
 	LogLevel       LogLevel
 	AllowedOrigins []string
 	Env            string
+	// TrashRetention is how long deleted todos stay in the trash before
+	// they are purged. Zero keeps them forever.
+	TrashRetention time.Duration
+	// IdempotencyTTL is how long responses to requests carrying an
+	// Idempotency-Key are kept for replay.
+	IdempotencyTTL time.Duration
+	// ImportMaxBytes is the largest body POST /todos/import accepts.
+	ImportMaxBytes int64
+	// AuthMode decides how requests are authenticated.
+	AuthMode AuthMode
+	// AdminAPIKey, if set, is registered as an admin API key at startup so
+	// that a new deployment can create its first keys.
+	AdminAPIKey string
+	// JWKSURL or JWKSFile is where the keys JSON Web Tokens are verified
+	// against come from when AuthMode is jwt. Exactly one is set.
+	JWKSURL  string
+	JWKSFile string
+	// JWKSRefresh is how long keys fetched from JWKSURL are cached.
+	JWKSRefresh time.Duration
+	// JWTIssuer and JWTAudience must match the iss and aud claims.
+	JWTIssuer   string
+	JWTAudience string
+	// JWTClockSkew is how far exp and nbf may be off.
+	JWTClockSkew time.Duration
+	// JWTTenantClaim names the claim holding the caller's tenant.
+	JWTTenantClaim string
+	// RateLimitRead and RateLimitWrite are how many reads and writes each
+	// client may make a minute, in bursts of up to RateLimitReadBurst and
+	// RateLimitWriteBurst. Zero turns a limit off.
+	RateLimitRead       int
+	RateLimitReadBurst  int
+	RateLimitWrite      int
+	RateLimitWriteBurst int
+	// TrustedProxies are the reverse proxies whose X-Forwarded-For header
+	// is believed when rate limiting by client address.
+	TrustedProxies []netip.Prefix
 }
 
 func Load() (Config, error) {
@@ -78,6 +126,117 @@ func Load() (Config, error) {
 		return Config{}, errors.New("invalid ENV (must be dev or prod)")
 	}
 
+	// Trash retention (Go duration, default 30 days; 0 disables purging)
+	retention, err := time.ParseDuration(getenv("TRASH_RETENTION", "720h"))
+	if err != nil || retention < 0 {
+		return Config{}, errors.New("invalid TRASH_RETENTION")
+	}
+	cfg.TrashRetention = retention
+
+	// Idempotency key lifetime (Go duration, default 24 hours)
+	idemTTL, err := time.ParseDuration(getenv("IDEMPOTENCY_TTL", "24h"))
+	if err != nil || idemTTL <= 0 {
+		return Config{}, errors.New("invalid IDEMPOTENCY_TTL")
+	}
+	cfg.IdempotencyTTL = idemTTL
+
+	// Import body limit (bytes, default 32 MiB)
+	importMax, err := strconv.ParseInt(getenv("IMPORT_MAX_BYTES", "33554432"), 10, 64)
+	if err != nil || importMax <= 0 {
+		return Config{}, errors.New("invalid IMPORT_MAX_BYTES")
+	}
+	cfg.ImportMaxBytes = importMax
+
+	// Authentication: none, api_key or jwt (default api_key in prod, none in dev)
+	defaultAuth := AuthNone
+	if cfg.Env == "prod" {
+		defaultAuth = AuthAPIKey
+	}
+	switch mode := AuthMode(strings.ToLower(getenv("AUTH_MODE", string(defaultAuth)))); mode {
+	case AuthNone, AuthAPIKey, AuthJWT:
+		cfg.AuthMode = mode
+	default:
+		return Config{}, errors.New("invalid AUTH_MODE (must be none, api_key or jwt)")
+	}
+	if cfg.Env == "prod" && cfg.AuthMode == AuthNone {
+		return Config{}, errors.New("AUTH_MODE cannot be none in prod")
+	}
+	cfg.AdminAPIKey = os.Getenv("ADMIN_API_KEY")
+	if cfg.AdminAPIKey != "" && (!strings.HasPrefix(cfg.AdminAPIKey, "tk_") || len(cfg.AdminAPIKey) < 32) {
+		return Config{}, errors.New("ADMIN_API_KEY must start with tk_ and be at least 32 characters")
+	}
+
+	// JSON Web Tokens: a key set from JWKS_URL or JWKS_FILE, the expected
+	// JWT_ISSUER and JWT_AUDIENCE, and the tolerated JWT_CLOCK_SKEW
+	cfg.JWKSURL = os.Getenv("JWKS_URL")
+	cfg.JWKSFile = os.Getenv("JWKS_FILE")
+	cfg.JWTIssuer = os.Getenv("JWT_ISSUER")
+	cfg.JWTAudience = os.Getenv("JWT_AUDIENCE")
+	refresh, err := time.ParseDuration(getenv("JWKS_REFRESH", "1h"))
+	if err != nil || refresh <= 0 {
+		return Config{}, errors.New("invalid JWKS_REFRESH")
+	}
+	cfg.JWKSRefresh = refresh
+	skew, err := time.ParseDuration(getenv("JWT_CLOCK_SKEW", "60s"))
+	if err != nil || skew < 0 || skew > 5*time.Minute {
+		return Config{}, errors.New("invalid JWT_CLOCK_SKEW (must be between 0 and 5m)")
+	}
+	cfg.JWTClockSkew = skew
+	cfg.JWTTenantClaim = getenv("JWT_TENANT_CLAIM", "tenant_id")
+	if cfg.AuthMode == AuthJWT {
+		if (cfg.JWKSURL == "") == (cfg.JWKSFile == "") {
+			return Config{}, errors.New("exactly one of JWKS_URL and JWKS_FILE must be set when AUTH_MODE is jwt")
+		}
+		if cfg.JWKSURL != "" {
+			u, err := url.Parse(cfg.JWKSURL)
+			if err != nil || u.Host == "" || !(u.Scheme == "https" || u.Scheme == "http" && cfg.Env == "dev") {
+				return Config{}, errors.New("invalid JWKS_URL (must be https in prod)")
+			}
+		}
+		if cfg.JWTIssuer == "" || cfg.JWTAudience == "" {
+			return Config{}, errors.New("JWT_ISSUER and JWT_AUDIENCE must be set when AUTH_MODE is jwt")
+		}
+	}
+
+	// Rate limits: requests a minute and burst size per client, for reads
+	// and for writes (0 turns a limit off)
+	for _, l := range []struct {
+		name  string
+		value *int
+		def   string
+	}{
+		{"RATE_LIMIT_READ", &cfg.RateLimitRead, "600"},
+		{"RATE_LIMIT_READ_BURST", &cfg.RateLimitReadBurst, "100"},
+		{"RATE_LIMIT_WRITE", &cfg.RateLimitWrite, "120"},
+		{"RATE_LIMIT_WRITE_BURST", &cfg.RateLimitWriteBurst, "20"},
+	} {
+		n, err := strconv.Atoi(getenv(l.name, l.def))
+		if err != nil || n < 0 {
+			return Config{}, errors.New("invalid " + l.name)
+		}
+		*l.value = n
+	}
+	if cfg.RateLimitRead > 0 && cfg.RateLimitReadBurst == 0 || cfg.RateLimitWrite > 0 && cfg.RateLimitWriteBurst == 0 {
+		return Config{}, errors.New("RATE_LIMIT_READ_BURST and RATE_LIMIT_WRITE_BURST must be positive when their limit is on")
+	}
+
+	// Trusted proxies (comma-separated addresses or CIDR ranges)
+	for _, p := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(p)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(p)
+			if addrErr != nil {
+				return Config{}, errors.New("invalid TRUSTED_PROXIES")
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		cfg.TrustedProxies = append(cfg.TrustedProxies, prefix.Masked())
+	}
+
 	// In prod, wildcard origins are not allowed
 	if cfg.Env == "prod" && len(cfg.AllowedOrigins) == 1 && cfg.AllowedOrigins[0] == "*" {
 		return Config{}, errors.New("ALLOWED_ORIGINS cannot be * in prod")
@@ -1,7 +1,9 @@
 package config
 
 import (
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestLoad_Defaults(t *testing.T) {
@@ -61,3 +63,170 @@ func TestLoad_ProdWildcardRejected(t *testing.T) {
 		t.Fatalf("expected error for wildcard in prod")
 	}
 }
+
+func TestLoad_TrashRetention(t *testing.T) {
+	t.Setenv("ALLOWED_ORIGINS", "*")
+	t.Setenv("TRASH_RETENTION", "")
+	cfg, err := Load()
+	if err != nil || cfg.TrashRetention != 30*24*time.Hour {
+		t.Fatalf("unexpected default retention: %v %v", cfg.TrashRetention, err)
+	}
+	t.Setenv("TRASH_RETENTION", "0")
+	if cfg, err := Load(); err != nil || cfg.TrashRetention != 0 {
+		t.Fatalf("expected zero retention: %v %v", cfg.TrashRetention, err)
+	}
+	for _, v := range []string{"soon", "-1h"} {
+		t.Setenv("TRASH_RETENTION", v)
+		if _, err := Load(); err == nil {
+			t.Fatalf("expected error for %q", v)
+		}
+	}
+}
+
+func TestLoad_IdempotencyTTL(t *testing.T) {
+	t.Setenv("ALLOWED_ORIGINS", "*")
+	t.Setenv("IDEMPOTENCY_TTL", "")
+	cfg, err := Load()
+	if err != nil || cfg.IdempotencyTTL != 24*time.Hour {
+		t.Fatalf("unexpected default ttl: %v %v", cfg.IdempotencyTTL, err)
+	}
+	t.Setenv("IDEMPOTENCY_TTL", "90m")
+	if cfg, err := Load(); err != nil || cfg.IdempotencyTTL != 90*time.Minute {
+		t.Fatalf("unexpected ttl: %v %v", cfg.IdempotencyTTL, err)
+	}
+	for _, v := range []string{"later", "0", "-1h"} {
+		t.Setenv("IDEMPOTENCY_TTL", v)
+		if _, err := Load(); err == nil {
+			t.Fatalf("expected error for %q", v)
+		}
+	}
+}
+
+func TestLoad_ImportMaxBytes(t *testing.T) {
+	t.Setenv("ALLOWED_ORIGINS", "*")
+	t.Setenv("IMPORT_MAX_BYTES", "")
+	cfg, err := Load()
+	if err != nil || cfg.ImportMaxBytes != 32<<20 {
+		t.Fatalf("unexpected default limit: %v %v", cfg.ImportMaxBytes, err)
+	}
+	t.Setenv("IMPORT_MAX_BYTES", "1048576")
+	if cfg, err := Load(); err != nil || cfg.ImportMaxBytes != 1<<20 {
+		t.Fatalf("unexpected limit: %v %v", cfg.ImportMaxBytes, err)
+	}
+	for _, v := range []string{"lots", "0", "-5", "1MB"} {
+		t.Setenv("IMPORT_MAX_BYTES", v)
+		if _, err := Load(); err == nil {
+			t.Fatalf("expected error for %q", v)
+		}
+	}
+}
+
+func TestLoad_AuthMode(t *testing.T) {
+	t.Setenv("ALLOWED_ORIGINS", "*")
+	t.Setenv("ENV", "dev")
+	t.Setenv("AUTH_MODE", "")
+	if cfg, err := Load(); err != nil || cfg.AuthMode != AuthNone {
+		t.Fatalf("expected no auth by default in dev: %v %v", cfg.AuthMode, err)
+	}
+	t.Setenv("AUTH_MODE", "API_KEY")
+	if cfg, err := Load(); err != nil || cfg.AuthMode != AuthAPIKey {
+		t.Fatalf("unexpected mode: %v %v", cfg.AuthMode, err)
+	}
+	t.Setenv("AUTH_MODE", "password")
+	if _, err := Load(); err == nil {
+		t.Fatalf("expected error for unknown mode")
+	}
+
+	t.Setenv("ENV", "prod")
+	t.Setenv("ALLOWED_ORIGINS", "https://example.com")
+	t.Setenv("AUTH_MODE", "")
+	if cfg, err := Load(); err != nil || cfg.AuthMode != AuthAPIKey {
+		t.Fatalf("expected api keys by default in prod: %v %v", cfg.AuthMode, err)
+	}
+	t.Setenv("AUTH_MODE", "none")
+	if _, err := Load(); err == nil {
+		t.Fatalf("expected error for no auth in prod")
+	}
+
+	t.Setenv("AUTH_MODE", "")
+	t.Setenv("ADMIN_API_KEY", "tk_short")
+	if _, err := Load(); err == nil {
+		t.Fatalf("expected error for a short admin key")
+	}
+	t.Setenv("ADMIN_API_KEY", "tk_"+strings.Repeat("a", 40))
+	if cfg, err := Load(); err != nil || cfg.AdminAPIKey == "" {
+		t.Fatalf("unexpected admin key: %v", err)
+	}
+}
+
+func TestLoad_JWT(t *testing.T) {
+	t.Setenv("ALLOWED_ORIGINS", "https://example.com")
+	t.Setenv("ENV", "prod")
+	t.Setenv("AUTH_MODE", "jwt")
+	t.Setenv("JWKS_URL", "")
+	t.Setenv("JWKS_FILE", "")
+	t.Setenv("JWT_ISSUER", "https://issuer.example")
+	t.Setenv("JWT_AUDIENCE", "todo-api")
+	if _, err := Load(); err == nil {
+		t.Fatalf("expected error without a key set")
+	}
+	t.Setenv("JWKS_URL", "http://issuer.example/jwks.json")
+	if _, err := Load(); err == nil {
+		t.Fatalf("expected error for a plain http key set in prod")
+	}
+	t.Setenv("JWKS_URL", "https://issuer.example/jwks.json")
+	cfg, err := Load()
+	if err != nil || cfg.AuthMode != AuthJWT || cfg.JWTClockSkew != time.Minute || cfg.JWKSRefresh != time.Hour {
+		t.Fatalf("unexpected config: %+v %v", cfg, err)
+	}
+	t.Setenv("JWKS_FILE", "jwks.json")
+	if _, err := Load(); err == nil {
+		t.Fatalf("expected error for both JWKS_URL and JWKS_FILE")
+	}
+	t.Setenv("JWKS_FILE", "")
+	t.Setenv("JWT_CLOCK_SKEW", "1h")
+	if _, err := Load(); err == nil {
+		t.Fatalf("expected error for a large clock skew")
+	}
+	t.Setenv("JWT_CLOCK_SKEW", "10s")
+	t.Setenv("JWT_AUDIENCE", "")
+	if _, err := Load(); err == nil {
+		t.Fatalf("expected error without an audience")
+	}
+}
+
+func TestLoad_RateLimit(t *testing.T) {
+	t.Setenv("ALLOWED_ORIGINS", "*")
+	for _, k := range []string{"RATE_LIMIT_READ", "RATE_LIMIT_READ_BURST", "RATE_LIMIT_WRITE", "RATE_LIMIT_WRITE_BURST", "TRUSTED_PROXIES"} {
+		t.Setenv(k, "")
+	}
+	cfg, err := Load()
+	if err != nil || cfg.RateLimitRead != 600 || cfg.RateLimitReadBurst != 100 || cfg.RateLimitWrite != 120 || cfg.RateLimitWriteBurst != 20 || cfg.TrustedProxies != nil {
+		t.Fatalf("unexpected defaults: %+v %v", cfg, err)
+	}
+	for _, v := range []string{"lots", "-1"} {
+		t.Setenv("RATE_LIMIT_WRITE", v)
+		if _, err := Load(); err == nil {
+			t.Fatalf("expected error for %q", v)
+		}
+	}
+	t.Setenv("RATE_LIMIT_WRITE", "60")
+	t.Setenv("RATE_LIMIT_WRITE_BURST", "0")
+	if _, err := Load(); err == nil {
+		t.Fatalf("expected error for a zero burst")
+	}
+	t.Setenv("RATE_LIMIT_WRITE", "0")
+	if _, err := Load(); err != nil {
+		t.Fatalf("expected a zero burst to be fine when the limit is off: %v", err)
+	}
+
+	t.Setenv("TRUSTED_PROXIES", "10.1.2.3/8, 192.0.2.1,2001:db8::/32")
+	cfg, err = Load()
+	if err != nil || len(cfg.TrustedProxies) != 3 || cfg.TrustedProxies[0].String() != "10.0.0.0/8" || cfg.TrustedProxies[1].String() != "192.0.2.1/32" {
+		t.Fatalf("unexpected trusted proxies: %v %v", cfg.TrustedProxies, err)
+	}
+	t.Setenv("TRUSTED_PROXIES", "10.0.0.0/8,proxy.internal")
+	if _, err := Load(); err == nil {
+		t.Fatalf("expected error for a hostname")
+	}
+}
@@ -0,0 +1,78 @@
+package idempotency
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps records in process memory, for development and tests.
+type MemoryStore struct {
+	mu      sync.Mutex
+	records map[string]Record
+	now     func() time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{records: make(map[string]Record), now: time.Now}
+}
+
+func (s *MemoryStore) Begin(ctx context.Context, key, fingerprint string, lease time.Duration) (Record, bool, error) {
+	_ = ctx
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now().UTC()
+	if rec, ok := s.records[key]; ok && now.Before(rec.ExpiresAt) {
+		return rec, false, nil
+	}
+	rec := Record{Key: key, Fingerprint: fingerprint, CreatedAt: now, ExpiresAt: now.Add(lease)}
+	s.records[key] = rec
+	return rec, true, nil
+}
+
+// claimed returns the record of claim if claim still holds it. s.mu must
+// be held.
+func (s *MemoryStore) claimed(claim Record) (Record, bool) {
+	rec, ok := s.records[claim.Key]
+	return rec, ok && rec.Pending() && rec.CreatedAt.Equal(claim.CreatedAt)
+}
+
+func (s *MemoryStore) Complete(ctx context.Context, claim Record, ttl time.Duration, status int, header map[string]string, body []byte) error {
+	_ = ctx
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec, ok := s.claimed(claim)
+	if !ok {
+		return ErrNotStarted
+	}
+	rec.Status, rec.Header, rec.Body = status, header, append([]byte(nil), body...)
+	rec.ExpiresAt = s.now().UTC().Add(ttl)
+	s.records[claim.Key] = rec
+	return nil
+}
+
+func (s *MemoryStore) Abort(ctx context.Context, claim Record) error {
+	_ = ctx
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.claimed(claim); !ok {
+		return ErrNotStarted
+	}
+	delete(s.records, claim.Key)
+	return nil
+}
+
+func (s *MemoryStore) DeleteExpired(ctx context.Context) (int, error) {
+	_ = ctx
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	n := 0
+	for key, rec := range s.records {
+		if !now.Before(rec.ExpiresAt) {
+			delete(s.records, key)
+			n++
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,97 @@
+package idempotency
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryStore_Lifecycle(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore()
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+
+	claim, started, err := s.Begin(ctx, "k", "fp", time.Minute)
+	if err != nil || !started {
+		t.Fatalf("first begin: %v %v", started, err)
+	}
+	rec, started, err := s.Begin(ctx, "k", "fp", time.Minute)
+	if err != nil || started || !rec.Pending() {
+		t.Fatalf("expected pending record, got %+v %v %v", rec, started, err)
+	}
+	if err := s.Complete(ctx, claim, time.Hour, 201, map[string]string{"ETag": `"1"`}, []byte("{}")); err != nil {
+		t.Fatalf("complete: %v", err)
+	}
+	if err := s.Complete(ctx, claim, time.Hour, 201, nil, nil); err != ErrNotStarted {
+		t.Fatalf("expected ErrNotStarted completing twice, got %v", err)
+	}
+	// The response is kept for the ttl, not the lease.
+	now = now.Add(30 * time.Minute)
+	rec, started, _ = s.Begin(ctx, "k", "other", time.Minute)
+	if started || rec.Status != 201 || rec.Fingerprint != "fp" || string(rec.Body) != "{}" || rec.Header["ETag"] != `"1"` {
+		t.Fatalf("unexpected record: %+v", rec)
+	}
+	// Once expired the key can be claimed again.
+	now = now.Add(time.Hour)
+	again, started, _ := s.Begin(ctx, "k", "other", time.Minute)
+	if !started {
+		t.Fatalf("expected expired key to be claimable")
+	}
+	if err := s.Abort(ctx, again); err != nil {
+		t.Fatalf("abort: %v", err)
+	}
+	if _, started, _ := s.Begin(ctx, "k", "fp", time.Minute); !started {
+		t.Fatalf("expected aborted key to be claimable")
+	}
+}
+
+func TestMemoryStore_Lease(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore()
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+
+	// A claim that is never completed, as when its server crashed, blocks
+	// retries only until its lease runs out.
+	stale, _, _ := s.Begin(ctx, "k", "fp", time.Minute)
+	now = now.Add(59 * time.Second)
+	if rec, started, _ := s.Begin(ctx, "k", "fp", time.Minute); started || !rec.Pending() {
+		t.Fatalf("expected the claim to hold within its lease, got %+v", rec)
+	}
+	now = now.Add(time.Second)
+	claim, started, _ := s.Begin(ctx, "k", "fp", time.Minute)
+	if !started {
+		t.Fatalf("expected a retry to take over after the lease")
+	}
+
+	// The stale claim can no longer finish or release the key.
+	if err := s.Complete(ctx, stale, time.Hour, 201, nil, []byte("stale")); err != ErrNotStarted {
+		t.Fatalf("expected ErrNotStarted for a stale claim, got %v", err)
+	}
+	if err := s.Abort(ctx, stale); err != ErrNotStarted {
+		t.Fatalf("expected ErrNotStarted releasing a stale claim, got %v", err)
+	}
+	if err := s.Complete(ctx, claim, time.Hour, 201, nil, []byte("new")); err != nil {
+		t.Fatalf("complete: %v", err)
+	}
+	if rec, _, _ := s.Begin(ctx, "k", "fp", time.Minute); string(rec.Body) != "new" {
+		t.Fatalf("expected the new claim's response, got %q", rec.Body)
+	}
+}
+
+func TestMemoryStore_DeleteExpired(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore()
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+	_, _, _ = s.Begin(ctx, "short", "fp", time.Minute)
+	_, _, _ = s.Begin(ctx, "long", "fp", time.Hour)
+	now = now.Add(10 * time.Minute)
+	if n, err := s.DeleteExpired(ctx); err != nil || n != 1 {
+		t.Fatalf("expected 1 deleted, got %d %v", n, err)
+	}
+	if _, ok := s.records["long"]; !ok {
+		t.Fatalf("unexpired record was deleted")
+	}
+}
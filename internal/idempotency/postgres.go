@@ -0,0 +1,99 @@
+package idempotency
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// PostgresStore keeps records in the idempotency_keys table so that
+// retries are recognised by every server instance.
+type PostgresStore struct {
+	db *sql.DB
+}
+
+func NewPostgresStore(db *sql.DB) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+func (s *PostgresStore) Begin(ctx context.Context, key, fingerprint string, lease time.Duration) (Record, bool, error) {
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	rec := Record{Key: key, Fingerprint: fingerprint, CreatedAt: now, ExpiresAt: now.Add(lease)}
+	// An expired record, including a pending one whose lease ran out, is
+	// replaced in place; the conflict clause makes claiming the key atomic.
+	res, err := s.db.ExecContext(ctx,
+		`INSERT INTO idempotency_keys (key, fingerprint, created_at, expires_at) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (key) DO UPDATE SET fingerprint=EXCLUDED.fingerprint, status=NULL, header=NULL, body=NULL,
+			created_at=EXCLUDED.created_at, expires_at=EXCLUDED.expires_at
+		WHERE idempotency_keys.expires_at <= EXCLUDED.created_at`,
+		key, fingerprint, rec.CreatedAt, rec.ExpiresAt,
+	)
+	if err != nil {
+		return Record{}, false, err
+	}
+	if n, _ := res.RowsAffected(); n == 1 {
+		return rec, true, nil
+	}
+
+	var (
+		status sql.NullInt64
+		header []byte
+	)
+	err = s.db.QueryRowContext(ctx,
+		`SELECT fingerprint, status, header, body, created_at, expires_at FROM idempotency_keys WHERE key=$1`, key,
+	).Scan(&rec.Fingerprint, &status, &header, &rec.Body, &rec.CreatedAt, &rec.ExpiresAt)
+	if err != nil {
+		return Record{}, false, err
+	}
+	rec.Status = int(status.Int64)
+	if header != nil {
+		if err := json.Unmarshal(header, &rec.Header); err != nil {
+			return Record{}, false, err
+		}
+	}
+	return rec, false, nil
+}
+
+// Complete and Abort only touch the record while it is the pending one
+// claim created, identified by its creation time.
+func (s *PostgresStore) Complete(ctx context.Context, claim Record, ttl time.Duration, status int, header map[string]string, body []byte) error {
+	encoded, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+	res, err := s.db.ExecContext(ctx,
+		`UPDATE idempotency_keys SET status=$1, header=$2, body=$3, expires_at=$4
+		WHERE key=$5 AND created_at=$6 AND status IS NULL`,
+		status, encoded, body, time.Now().UTC().Add(ttl), claim.Key, claim.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotStarted
+	}
+	return nil
+}
+
+func (s *PostgresStore) Abort(ctx context.Context, claim Record) error {
+	res, err := s.db.ExecContext(ctx,
+		`DELETE FROM idempotency_keys WHERE key=$1 AND created_at=$2 AND status IS NULL`, claim.Key, claim.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotStarted
+	}
+	return nil
+}
+
+func (s *PostgresStore) DeleteExpired(ctx context.Context) (int, error) {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE expires_at <= NOW()`)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
@@ -0,0 +1,73 @@
+// Package idempotency stores the outcome of requests made with an
+// Idempotency-Key header so that retries can be answered with the original
+// response instead of being executed again.
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+// ErrNotStarted is returned by Complete and Abort for a claim that is no
+// longer pending, because it was completed, released, or taken over after
+// its lease ran out.
+var ErrNotStarted = errors.New("idempotency key not started")
+
+// Record is what is kept for a key. A record with Status zero is pending:
+// the first request is still running.
+type Record struct {
+	Key string
+	// Fingerprint identifies the request the key was first used with.
+	Fingerprint string
+	Status      int
+	Header      map[string]string
+	Body        []byte
+	CreatedAt   time.Time
+	ExpiresAt   time.Time
+}
+
+// Pending reports whether the original request has not finished yet.
+func (r Record) Pending() bool {
+	return r.Status == 0
+}
+
+// Store persists records until they expire. Implementations must make
+// Begin atomic so that only one request can claim a key.
+type Store interface {
+	// Begin claims key for a request with the given fingerprint, creating
+	// a pending record leased for lease. If a live record already exists
+	// it is returned with started set to false. A pending record whose
+	// lease ran out, because the server running it stopped, can be
+	// claimed again.
+	Begin(ctx context.Context, key, fingerprint string, lease time.Duration) (rec Record, started bool, err error)
+	// Complete saves the response for a claim returned by Begin and keeps
+	// it for ttl.
+	Complete(ctx context.Context, claim Record, ttl time.Duration, status int, header map[string]string, body []byte) error
+	// Abort releases a claim returned by Begin so the request can be
+	// retried.
+	Abort(ctx context.Context, claim Record) error
+	// DeleteExpired removes expired records and returns how many.
+	DeleteExpired(ctx context.Context) (int, error)
+}
+
+// Sweep deletes expired records every interval until ctx is cancelled.
+func Sweep(ctx context.Context, store Store, interval time.Duration, logger *slog.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		n, err := store.DeleteExpired(ctx)
+		switch {
+		case err != nil && ctx.Err() == nil:
+			logger.Error("could not delete expired idempotency keys", "error", err)
+		case n > 0:
+			logger.Info("deleted expired idempotency keys", "count", n)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+// sameJSON compares two JSON texts ignoring formatting and member order.
+func sameJSON(t *testing.T, got []byte, want string) {
+	t.Helper()
+	a, err := decodeValue(got)
+	if err != nil {
+		t.Fatalf("decode result %s: %v", got, err)
+	}
+	b, err := decodeValue([]byte(want))
+	if err != nil {
+		t.Fatalf("decode want %s: %v", want, err)
+	}
+	if !equal(a, b) {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
+
+func TestPatch_Apply(t *testing.T) {
+	cases := []struct {
+		name, doc, patch, want string
+	}{
+		{"add member", `{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux"}]`, `{"baz":"qux","foo":"bar"}`},
+		{"add array element", `{"foo":["bar","baz"]}`, `[{"op":"add","path":"/foo/1","value":"qux"}]`, `{"foo":["bar","qux","baz"]}`},
+		{"append", `{"foo":[1]}`, `[{"op":"add","path":"/foo/-","value":2}]`, `{"foo":[1,2]}`},
+		{"add null", `{}`, `[{"op":"add","path":"/a","value":null}]`, `{"a":null}`},
+		{"remove member", `{"baz":"qux","foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`, `{"foo":"bar"}`},
+		{"remove element", `{"foo":["bar","qux","baz"]}`, `[{"op":"remove","path":"/foo/1"}]`, `{"foo":["bar","baz"]}`},
+		{"replace", `{"baz":"qux","foo":"bar"}`, `[{"op":"replace","path":"/baz","value":"boo"}]`, `{"baz":"boo","foo":"bar"}`},
+		{"move member", `{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`,
+			`[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`,
+			`{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`},
+		{"move element", `{"foo":["all","grass","cows","eat"]}`, `[{"op":"move","from":"/foo/1","path":"/foo/3"}]`, `{"foo":["all","cows","eat","grass"]}`},
+		{"copy is deep", `{"a":{"b":1}}`, `[{"op":"copy","from":"/a","path":"/c"},{"op":"replace","path":"/c/b","value":2}]`, `{"a":{"b":1},"c":{"b":2}}`},
+		{"test then replace", `{"n":1.0,"s":"x"}`, `[{"op":"test","path":"/n","value":1},{"op":"replace","path":"/s","value":"y"}]`, `{"n":1.0,"s":"y"}`},
+		{"escaped pointer", `{"a/b":1,"m~n":2}`, `[{"op":"remove","path":"/a~1b"},{"op":"replace","path":"/m~0n","value":3}]`, `{"m~n":3}`},
+		{"replace root", `{"a":1}`, `[{"op":"replace","path":"","value":{"b":2}}]`, `{"b":2}`},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := Decode([]byte(tc.patch))
+			if err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+			got, err := p.Apply([]byte(tc.doc))
+			if err != nil {
+				t.Fatalf("Apply: %v", err)
+			}
+			sameJSON(t, got, tc.want)
+		})
+	}
+}
+
+func TestPatch_Errors(t *testing.T) {
+	invalid := []string{
+		`{"op":"add"}`,
+		`[{"op":"add","path":"/a"}]`,
+		`[{"op":"frobnicate","path":"/a"}]`,
+		`[{"op":"remove","path":"a"}]`,
+		`[{"op":"remove","path":"/a~2"}]`,
+		`[{"op":"move","from":"/a","path":"/a/b"}]`,
+	}
+	for _, patch := range invalid {
+		if _, err := Decode([]byte(patch)); !errors.Is(err, ErrInvalid) {
+			t.Fatalf("expected ErrInvalid for %s, got %v", patch, err)
+		}
+	}
+
+	doc := []byte(`{"a":[1,2],"b":{"c":"d"}}`)
+	failing := map[string]error{
+		`[{"op":"remove","path":"/missing"}]`:                                      ErrPathNotFound,
+		`[{"op":"replace","path":"/missing","value":1}]`:                           ErrPathNotFound,
+		`[{"op":"add","path":"/a/5","value":1}]`:                                   ErrPathNotFound,
+		`[{"op":"add","path":"/a/01","value":1}]`:                                  ErrPathNotFound,
+		`[{"op":"add","path":"/x/y","value":1}]`:                                   ErrPathNotFound,
+		`[{"op":"test","path":"/b/c","value":"e"}]`:                                ErrTestFailed,
+		`[{"op":"replace","path":"/b/c","value":"e"},{"op":"remove","path":"/z"}]`: ErrPathNotFound,
+	}
+	for patch, want := range failing {
+		p, err := Decode([]byte(patch))
+		if err != nil {
+			t.Fatalf("Decode %s: %v", patch, err)
+		}
+		if _, err := p.Apply(doc); !errors.Is(err, want) {
+			t.Fatalf("expected %v for %s, got %v", want, patch, err)
+		}
+	}
+}
+
+func TestMergePatch(t *testing.T) {
+	// Examples from RFC 7396, appendix A.
+	cases := []struct{ doc, patch, want string }{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"e":null}`, `{"a":1}`, `{"e":null,"a":1}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+	for _, tc := range cases {
+		got, err := MergePatch([]byte(tc.doc), []byte(tc.patch))
+		if err != nil {
+			t.Fatalf("MergePatch(%s, %s): %v", tc.doc, tc.patch, err)
+		}
+		sameJSON(t, got, tc.want)
+	}
+	if _, err := MergePatch([]byte(`{}`), []byte(`{"a":`)); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("expected ErrInvalid for malformed patch, got %v", err)
+	}
+}
+
+func TestOperation_NullValue(t *testing.T) {
+	var op Operation
+	if err := json.Unmarshal([]byte(`{"op":"add","path":"/a","value":null}`), &op); err != nil {
+		t.Fatal(err)
+	}
+	if op.Value == nil {
+		t.Fatalf("a null value must be distinguishable from a missing one")
+	}
+}
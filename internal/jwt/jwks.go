@@ -0,0 +1,277 @@
+package jwt
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// JWK is a JSON Web Key as described by RFC 7517. Only the members needed
+// for RSA, P-256 and symmetric keys are decoded.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Use string `json:"use,omitempty"`
+	// RSA public keys.
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Elliptic curve public keys.
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+	// Symmetric keys.
+	K string `json:"k,omitempty"`
+}
+
+// key is a parsed JWK.
+type key struct {
+	kid string
+	alg string // the only algorithm the key may be used with
+	pub any    // *rsa.PublicKey, *ecdsa.PublicKey or []byte
+}
+
+// KeySet is a parsed JSON Web Key Set.
+type KeySet struct {
+	keys []key
+}
+
+// ParseKeySet parses a JWK Set document. Keys that are not for signatures,
+// or of a type this package cannot verify with, are skipped; an invalid
+// key of a supported type is an error.
+func ParseKeySet(data []byte) (*KeySet, error) {
+	var doc struct {
+		Keys []JWK `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid JWK set: %v", err)
+	}
+	set := &KeySet{}
+	for i, jwk := range doc.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		k, ok, err := parseKey(jwk)
+		if err != nil {
+			return nil, fmt.Errorf("JWK %d: %v", i, err)
+		}
+		if ok {
+			set.keys = append(set.keys, k)
+		}
+	}
+	return set, nil
+}
+
+func parseKey(jwk JWK) (key, bool, error) {
+	k := key{kid: jwk.Kid}
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decodeBigInt(jwk.N)
+		if err != nil {
+			return key{}, false, fmt.Errorf("n: %v", err)
+		}
+		e, err := decodeBigInt(jwk.E)
+		if err != nil || !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return key{}, false, errors.New("invalid exponent")
+		}
+		if n.BitLen() < 2048 {
+			return key{}, false, errors.New("RSA keys must have at least 2048 bits")
+		}
+		k.alg, k.pub = RS256, &rsa.PublicKey{N: n, E: int(e.Int64())}
+	case "EC":
+		if jwk.Crv != "P-256" {
+			return key{}, false, nil
+		}
+		x, errX := decodeBigInt(jwk.X)
+		y, errY := decodeBigInt(jwk.Y)
+		if errX != nil || errY != nil || !elliptic.P256().IsOnCurve(x, y) {
+			return key{}, false, errors.New("invalid P-256 point")
+		}
+		k.alg, k.pub = ES256, &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+	case "oct":
+		secret, err := base64.RawURLEncoding.DecodeString(jwk.K)
+		if err != nil || len(secret) < 32 {
+			return key{}, false, errors.New("HS256 keys must have at least 256 bits")
+		}
+		k.alg, k.pub = HS256, secret
+	default:
+		return key{}, false, nil
+	}
+	if jwk.Alg != "" && jwk.Alg != k.alg {
+		return key{}, false, nil
+	}
+	return k, true, nil
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, errors.New("must be non-empty base64url")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// candidates returns the keys that may have signed a token with the given
+// header.
+func (s *KeySet) candidates(alg, kid string) []key {
+	var out []key
+	for _, k := range s.keys {
+		if k.alg == alg && (kid == "" || k.kid == kid) {
+			out = append(out, k)
+		}
+	}
+	return out
+}
+
+// Keys provides the key set tokens are verified against.
+type Keys interface {
+	// KeySet returns the current keys. With refresh set, a provider that
+	// can reload its keys should do so, because a token named a key it
+	// does not know.
+	KeySet(ctx context.Context, refresh bool) (*KeySet, error)
+}
+
+// StaticKeys is a key set that never changes.
+type StaticKeys struct {
+	set *KeySet
+}
+
+// LoadKeysFile reads a JWK set from a file.
+func LoadKeysFile(path string) (*StaticKeys, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	set, err := ParseKeySet(data)
+	if err != nil {
+		return nil, err
+	}
+	return &StaticKeys{set: set}, nil
+}
+
+func (k *StaticKeys) KeySet(ctx context.Context, refresh bool) (*KeySet, error) {
+	return k.set, nil
+}
+
+// minRefreshInterval stops tokens with unknown key IDs from making every
+// request fetch the key set.
+const minRefreshInterval = time.Minute
+
+// retryInterval is how long a failed fetch is remembered before the key
+// set is fetched again, so that an unreachable provider is not asked on
+// every request.
+const retryInterval = 10 * time.Second
+
+// maxKeySetSize bounds a fetched key set document.
+const maxKeySetSize = 1 << 20
+
+// RemoteKeys fetches a JWK set from a URL, caches it for a while and
+// fetches it again early when a token names an unknown key, so that the
+// identity provider can rotate keys. If a fetch fails, the last good set
+// is used. Only one fetch runs at a time, and callers with a set to use
+// do not wait for it.
+type RemoteKeys struct {
+	url    string
+	ttl    time.Duration
+	client *http.Client
+
+	mu      sync.Mutex
+	set     *KeySet
+	fetched time.Time
+	// err is the error of the last fetch, at failed, if it did not succeed.
+	err    error
+	failed time.Time
+	// fetching is closed when the fetch under way ends; nil if none is.
+	fetching chan struct{}
+	now      func() time.Time
+}
+
+// NewRemoteKeys returns keys fetched from url and kept for ttl.
+func NewRemoteKeys(url string, ttl time.Duration) *RemoteKeys {
+	return &RemoteKeys{url: url, ttl: ttl, client: &http.Client{Timeout: 10 * time.Second}, now: time.Now}
+}
+
+func (k *RemoteKeys) KeySet(ctx context.Context, refresh bool) (*KeySet, error) {
+	k.mu.Lock()
+	done := k.fetching
+	switch {
+	case done == nil && k.due(refresh):
+		done = make(chan struct{})
+		k.fetching = done
+		k.mu.Unlock()
+		k.update(ctx, done)
+		k.mu.Lock()
+	case done != nil && k.set == nil:
+		// There is nothing to fall back on, so wait for the fetch.
+		k.mu.Unlock()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		k.mu.Lock()
+	}
+	defer k.mu.Unlock()
+	if k.set != nil {
+		return k.set, nil
+	}
+	return nil, k.err
+}
+
+// due reports whether the set should be fetched. k.mu must be held.
+func (k *RemoteKeys) due(refresh bool) bool {
+	now := k.now()
+	if k.err != nil && now.Sub(k.failed) < retryInterval {
+		return false
+	}
+	age := now.Sub(k.fetched)
+	return k.set == nil || age >= k.ttl || refresh && age >= minRefreshInterval
+}
+
+// update fetches the set without holding k.mu, records the outcome and
+// closes done. The fetch outlives ctx, since other callers may be waiting
+// for it; the client's timeout bounds it.
+func (k *RemoteKeys) update(ctx context.Context, done chan struct{}) {
+	set, err := k.fetch(context.WithoutCancel(ctx))
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err != nil {
+		k.err, k.failed = err, k.now()
+	} else {
+		k.set, k.fetched, k.err = set, k.now(), nil
+	}
+	k.fetching = nil
+	close(done)
+}
+
+func (k *RemoteKeys) fetch(ctx context.Context) (*KeySet, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, k.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetch JWK set: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch JWK set: %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxKeySetSize))
+	if err != nil {
+		return nil, fmt.Errorf("fetch JWK set: %w", err)
+	}
+	return ParseKeySet(data)
+}
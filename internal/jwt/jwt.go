@@ -0,0 +1,218 @@
+// Package jwt verifies JSON Web Tokens signed with RS256, ES256 or HS256
+// against a JSON Web Key Set.
+package jwt
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Supported signature algorithms.
+const (
+	RS256 = "RS256"
+	ES256 = "ES256"
+	HS256 = "HS256"
+)
+
+// ErrInvalid is wrapped by every error about the token itself, as opposed
+// to a failure to obtain the keys.
+var ErrInvalid = errors.New("invalid token")
+
+// Claims is the decoded payload of a token. Numbers are json.Number.
+type Claims map[string]any
+
+// Subject returns the sub claim.
+func (c Claims) Subject() string {
+	s, _ := c["sub"].(string)
+	return s
+}
+
+// Strings returns a claim that is a string or an array of strings.
+func (c Claims) Strings(name string) []string {
+	switch v := c[name].(type) {
+	case string:
+		return []string{v}
+	case []any:
+		out := make([]string, 0, len(v))
+		for _, elem := range v {
+			if s, ok := elem.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+// time returns a NumericDate claim.
+func (c Claims) time(name string) (time.Time, bool, error) {
+	v, ok := c[name]
+	if !ok {
+		return time.Time{}, false, nil
+	}
+	n, ok := v.(json.Number)
+	if !ok {
+		return time.Time{}, false, fmt.Errorf("%w: %s must be a number", ErrInvalid, name)
+	}
+	f, err := n.Float64()
+	if err != nil || math.IsInf(f, 0) || math.Abs(f) > 1<<53 {
+		return time.Time{}, false, fmt.Errorf("%w: %s is out of range", ErrInvalid, name)
+	}
+	sec, frac := math.Modf(f)
+	return time.Unix(int64(sec), int64(frac*1e9)).UTC(), true, nil
+}
+
+// Options are the checks a Verifier makes besides the signature.
+type Options struct {
+	// Issuer must equal the iss claim, if set.
+	Issuer string
+	// Audience must be one of the aud claim's values, if set.
+	Audience string
+	// Leeway is the clock skew tolerated when checking exp and nbf.
+	Leeway time.Duration
+}
+
+// Verifier checks tokens against a key set.
+type Verifier struct {
+	keys Keys
+	opts Options
+	now  func() time.Time
+}
+
+func NewVerifier(keys Keys, opts Options) *Verifier {
+	return &Verifier{keys: keys, opts: opts, now: time.Now}
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	Typ string `json:"typ"`
+	// Crit lists extensions the token requires to be understood; none are.
+	Crit []string `json:"crit"`
+}
+
+// Verify checks a compact-serialized token's signature, its exp and nbf
+// claims and the issuer and audience, and returns its claims. Tokens
+// without exp are rejected.
+func (v *Verifier) Verify(ctx context.Context, token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: not a compact JWS", ErrInvalid)
+	}
+	var h header
+	if err := decodeSegment(parts[0], &h, false); err != nil {
+		return nil, fmt.Errorf("%w: header: %v", ErrInvalid, err)
+	}
+	if h.Alg != RS256 && h.Alg != ES256 && h.Alg != HS256 {
+		return nil, fmt.Errorf("%w: unsupported alg %q", ErrInvalid, h.Alg)
+	}
+	if len(h.Crit) > 0 {
+		return nil, fmt.Errorf("%w: unsupported critical header %q", ErrInvalid, h.Crit[0])
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature is not base64url", ErrInvalid)
+	}
+
+	signed := []byte(parts[0] + "." + parts[1])
+	set, err := v.keys.KeySet(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	candidates := set.candidates(h.Alg, h.Kid)
+	if len(candidates) == 0 {
+		// The provider may have rotated keys since the set was loaded.
+		if set, err = v.keys.KeySet(ctx, true); err != nil {
+			return nil, err
+		}
+		candidates = set.candidates(h.Alg, h.Kid)
+	}
+	if !slices.ContainsFunc(candidates, func(k key) bool { return verifySignature(k, signed, sig) }) {
+		return nil, fmt.Errorf("%w: signature does not match any key", ErrInvalid)
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims, true); err != nil || claims == nil {
+		return nil, fmt.Errorf("%w: claims must be a JSON object", ErrInvalid)
+	}
+	if err := v.check(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (v *Verifier) check(claims Claims) error {
+	now := v.now()
+	exp, ok, err := claims.time("exp")
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%w: exp is required", ErrInvalid)
+	}
+	if !now.Before(exp.Add(v.opts.Leeway)) {
+		return fmt.Errorf("%w: token has expired", ErrInvalid)
+	}
+	nbf, ok, err := claims.time("nbf")
+	if err != nil {
+		return err
+	}
+	if ok && now.Add(v.opts.Leeway).Before(nbf) {
+		return fmt.Errorf("%w: token is not valid yet", ErrInvalid)
+	}
+	if v.opts.Issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != v.opts.Issuer {
+			return fmt.Errorf("%w: unexpected issuer", ErrInvalid)
+		}
+	}
+	if v.opts.Audience != "" && !slices.Contains(claims.Strings("aud"), v.opts.Audience) {
+		return fmt.Errorf("%w: unexpected audience", ErrInvalid)
+	}
+	return nil
+}
+
+func decodeSegment(seg string, v any, useNumber bool) error {
+	raw, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return errors.New("not base64url")
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	if useNumber {
+		dec.UseNumber()
+	}
+	return dec.Decode(v)
+}
+
+func verifySignature(k key, signed, sig []byte) bool {
+	digest := sha256.Sum256(signed)
+	switch pub := k.pub.(type) {
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig) == nil
+	case *ecdsa.PublicKey:
+		// JWS encodes ES256 signatures as r and s, 32 bytes each.
+		if len(sig) != 64 {
+			return false
+		}
+		r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+		return ecdsa.Verify(pub, digest[:], r, s)
+	case []byte:
+		mac := hmac.New(sha256.New, pub)
+		mac.Write(signed)
+		return hmac.Equal(mac.Sum(nil), sig)
+	}
+	return false
+}
@@ -0,0 +1,292 @@
+package jwt
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var testNow = time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+
+// hsSecret is the oct key in testdata/jwks.json.
+var hsSecret = []byte("test-only-hs256-secret-of-32-bytes!")
+
+func loadPrivateKey(t *testing.T, path string) crypto.Signer {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(data)
+	k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k.(crypto.Signer)
+}
+
+// sign returns a compact JWS of claims, signed with the testdata key for alg.
+func sign(t *testing.T, alg, kid string, claims map[string]any) string {
+	t.Helper()
+	h, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	c, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+	digest := sha256.Sum256([]byte(signed))
+	var sig []byte
+	switch alg {
+	case RS256:
+		k := loadPrivateKey(t, "testdata/rsa.pem").(*rsa.PrivateKey)
+		sig, _ = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+	case ES256:
+		k := loadPrivateKey(t, "testdata/ec.pem").(*ecdsa.PrivateKey)
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	case HS256:
+		mac := hmac.New(sha256.New, hsSecret)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func claims(overrides map[string]any) map[string]any {
+	c := map[string]any{
+		"sub": "user-1",
+		"iss": "https://issuer.example",
+		"aud": []string{"other", "todo-api"},
+		"exp": testNow.Add(time.Hour).Unix(),
+		"nbf": testNow.Add(-time.Minute).Unix(),
+	}
+	for k, v := range overrides {
+		if v == nil {
+			delete(c, k)
+		} else {
+			c[k] = v
+		}
+	}
+	return c
+}
+
+func newTestVerifier(t *testing.T) *Verifier {
+	t.Helper()
+	keys, err := LoadKeysFile("testdata/jwks.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := NewVerifier(keys, Options{Issuer: "https://issuer.example", Audience: "todo-api", Leeway: time.Minute})
+	v.now = func() time.Time { return testNow }
+	return v
+}
+
+func TestVerify(t *testing.T) {
+	v := newTestVerifier(t)
+	for _, tc := range []struct{ alg, kid string }{{RS256, "rsa-1"}, {ES256, "ec-1"}, {HS256, "hs-1"}, {RS256, ""}} {
+		c, err := v.Verify(context.Background(), sign(t, tc.alg, tc.kid, claims(map[string]any{"role": "tester"})))
+		if err != nil {
+			t.Fatalf("%s %q: %v", tc.alg, tc.kid, err)
+		}
+		if c.Subject() != "user-1" || c["role"] != "tester" {
+			t.Fatalf("unexpected claims: %v", c)
+		}
+	}
+
+	skew := 30 * time.Second
+	cases := map[string]string{
+		"unknown kid":       sign(t, RS256, "rsa-2", claims(nil)),
+		"alg for other key": sign(t, HS256, "rsa-1", claims(nil)),
+		"expired":           sign(t, ES256, "ec-1", claims(map[string]any{"exp": testNow.Add(-time.Minute - skew).Unix()})),
+		"not yet valid":     sign(t, ES256, "ec-1", claims(map[string]any{"nbf": testNow.Add(time.Minute + skew).Unix()})),
+		"no exp":            sign(t, RS256, "rsa-1", claims(map[string]any{"exp": nil})),
+		"string exp":        sign(t, RS256, "rsa-1", claims(map[string]any{"exp": "tomorrow"})),
+		"wrong issuer":      sign(t, RS256, "rsa-1", claims(map[string]any{"iss": "https://evil.example"})),
+		"wrong audience":    sign(t, RS256, "rsa-1", claims(map[string]any{"aud": "other"})),
+		"no audience":       sign(t, RS256, "rsa-1", claims(map[string]any{"aud": nil})),
+		"not a JWS":         "a.b",
+	}
+	none, _ := json.Marshal(map[string]string{"alg": "none"})
+	payload, _ := json.Marshal(claims(nil))
+	cases["alg none"] = base64.RawURLEncoding.EncodeToString(none) + "." + base64.RawURLEncoding.EncodeToString(payload) + "."
+	good := sign(t, RS256, "rsa-1", claims(nil))
+	parts := strings.Split(good, ".")
+	cases["tampered"] = parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"admin","exp":9999999999}`)) + "." + parts[2]
+	for name, token := range cases {
+		if _, err := v.Verify(context.Background(), token); !errors.Is(err, ErrInvalid) {
+			t.Fatalf("%s: expected ErrInvalid, got %v", name, err)
+		}
+	}
+
+	// Clock skew is tolerated.
+	for _, c := range []map[string]any{
+		{"exp": testNow.Add(-skew).Unix()},
+		{"nbf": testNow.Add(skew).Unix()},
+		{"aud": "todo-api"},
+	} {
+		if _, err := v.Verify(context.Background(), sign(t, RS256, "rsa-1", claims(c))); err != nil {
+			t.Fatalf("%v: %v", c, err)
+		}
+	}
+}
+
+func TestParseKeySet(t *testing.T) {
+	keys, err := LoadKeysFile("testdata/jwks.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys.set.keys) != 3 {
+		t.Fatalf("expected the encryption key to be skipped, got %d keys", len(keys.set.keys))
+	}
+	for _, doc := range []string{
+		`{"keys":[{"kty":"oct","k":"c2hvcnQ"}]}`,
+		`{"keys":[{"kty":"EC","crv":"P-256","x":"AQ","y":"AQ"}]}`,
+		`{"keys":[{"kty":"RSA","n":"AQAB","e":"AQAB"}]}`,
+		`{"keys":`,
+	} {
+		if _, err := ParseKeySet([]byte(doc)); err == nil {
+			t.Fatalf("expected %s to be rejected", doc)
+		}
+	}
+}
+
+func TestRemoteKeys(t *testing.T) {
+	jwks, err := os.ReadFile("testdata/jwks.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fetches atomic.Int32
+	var failing atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		if failing.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write(jwks)
+	}))
+	defer srv.Close()
+
+	now := testNow
+	keys := NewRemoteKeys(srv.URL, time.Hour)
+	keys.now = func() time.Time { return now }
+	v := NewVerifier(keys, Options{Leeway: time.Minute})
+	v.now = func() time.Time { return testNow }
+
+	if _, err := v.Verify(context.Background(), sign(t, ES256, "ec-1", claims(nil))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.Verify(context.Background(), sign(t, HS256, "hs-1", claims(nil))); err != nil {
+		t.Fatal(err)
+	}
+	if n := fetches.Load(); n != 1 {
+		t.Fatalf("expected the key set to be cached, got %d fetches", n)
+	}
+
+	// An unknown kid refetches the set, but not more than once a minute.
+	unknown := sign(t, RS256, "rotated", claims(nil))
+	for i := 0; i < 2; i++ {
+		if _, err := v.Verify(context.Background(), unknown); !errors.Is(err, ErrInvalid) {
+			t.Fatalf("expected ErrInvalid, got %v", err)
+		}
+	}
+	if n := fetches.Load(); n != 1 {
+		t.Fatalf("expected no refetch within a minute, got %d fetches", n)
+	}
+	now = now.Add(2 * time.Minute)
+	_, _ = v.Verify(context.Background(), unknown)
+	if n := fetches.Load(); n != 2 {
+		t.Fatalf("expected a refetch for an unknown kid, got %d fetches", n)
+	}
+
+	// A failed fetch keeps the last good set, and is not retried on every
+	// request.
+	failing.Store(true)
+	now = now.Add(2 * time.Hour)
+	for i := 0; i < 3; i++ {
+		if _, err := v.Verify(context.Background(), sign(t, RS256, "rsa-1", claims(nil))); err != nil {
+			t.Fatalf("expected the cached keys to be used: %v", err)
+		}
+	}
+	if n := fetches.Load(); n != 3 {
+		t.Fatalf("expected one attempt after the set expired, got %d fetches", n-2)
+	}
+	now = now.Add(retryInterval)
+	_, _ = v.Verify(context.Background(), sign(t, RS256, "rsa-1", claims(nil)))
+	if n := fetches.Load(); n != 4 {
+		t.Fatalf("expected a retry after %v, got %d fetches", retryInterval, n)
+	}
+
+	// Without a set to fall back on, the error is returned until a retry.
+	empty := NewRemoteKeys(srv.URL, time.Hour)
+	for i := 0; i < 2; i++ {
+		if _, err := NewVerifier(empty, Options{}).Verify(context.Background(), sign(t, RS256, "rsa-1", claims(nil))); err == nil || errors.Is(err, ErrInvalid) {
+			t.Fatalf("expected a key fetch error, got %v", err)
+		}
+	}
+	if n := fetches.Load(); n != 5 {
+		t.Fatalf("expected the failure to be remembered, got %d fetches", n)
+	}
+}
+
+func TestRemoteKeys_SlowFetch(t *testing.T) {
+	jwks, err := os.ReadFile("testdata/jwks.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started, release := make(chan struct{}, 1), make(chan struct{})
+	var slow atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if slow.Load() {
+			started <- struct{}{}
+			<-release
+		}
+		_, _ = w.Write(jwks)
+	}))
+	defer srv.Close()
+
+	now := testNow
+	keys := NewRemoteKeys(srv.URL, time.Hour)
+	keys.now = func() time.Time { return now }
+	if _, err := keys.KeySet(context.Background(), false); err != nil {
+		t.Fatal(err)
+	}
+
+	// While one caller refetches an expired set, others keep using it.
+	slow.Store(true)
+	now = now.Add(2 * time.Hour)
+	refetched := make(chan error)
+	go func() {
+		_, err := keys.KeySet(context.Background(), false)
+		refetched <- err
+	}()
+	<-started
+	if set, err := keys.KeySet(context.Background(), true); set == nil || err != nil {
+		t.Fatalf("expected the cached set during a fetch, got %v", err)
+	}
+	close(release)
+	if err := <-refetched; err != nil {
+		t.Fatal(err)
+	}
+	if keys.fetched != now {
+		t.Fatalf("expected the refetch to be recorded")
+	}
+}
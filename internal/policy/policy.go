@@ -0,0 +1,141 @@
+// Package policy decides what a caller may do with a todo or list, given
+// whether they own it and the roles it has been shared with them in.
+package policy
+
+import "errors"
+
+// Role is what a caller is to a resource. Viewer, editor and admin can be
+// granted; owner belongs to whoever created the resource.
+type Role string
+
+const (
+	Viewer Role = "viewer"
+	Editor Role = "editor"
+	Admin  Role = "admin"
+	Owner  Role = "owner"
+)
+
+// rank orders roles so that each includes everything below it. A caller
+// with no role ranks lowest.
+func (r Role) rank() int {
+	switch r {
+	case Viewer:
+		return 1
+	case Editor:
+		return 2
+	case Admin:
+		return 3
+	case Owner:
+		return 4
+	}
+	return 0
+}
+
+// Grantable reports whether r can be granted to another caller.
+func (r Role) Grantable() bool {
+	return r == Viewer || r == Editor || r == Admin
+}
+
+// Action is something done to a todo or list.
+type Action string
+
+const (
+	// Read covers viewing the resource, its tags and checklist and, for a
+	// list, its todos.
+	Read Action = "read"
+	// Edit covers changing it, its tags and its checklist.
+	Edit Action = "edit"
+	// Delete covers moving it to the trash, restoring or purging it.
+	Delete Action = "delete"
+	// Share covers seeing and managing its grants.
+	Share Action = "share"
+)
+
+// minRole is the lowest role that may perform each action.
+var minRole = map[Action]Role{
+	Read:   Viewer,
+	Edit:   Editor,
+	Delete: Admin,
+	Share:  Admin,
+}
+
+var (
+	// ErrNoAccess means the caller has no role on the resource. Callers
+	// should report the resource as not found rather than reveal it.
+	ErrNoAccess = errors.New("no access")
+	// ErrDenied means the caller has a role on the resource that does not
+	// allow the action.
+	ErrDenied = errors.New("permission denied")
+)
+
+// Subject is a caller, or the owner of a resource.
+type Subject struct {
+	ID       string
+	TenantID string
+}
+
+// RoleOf returns the role of caller on a resource of owner that has been
+// granted to the caller in the given roles, which may include grants on
+// the list holding the resource. The strongest role wins. Grants never
+// reach across tenants.
+func RoleOf(caller, owner Subject, granted []Role) Role {
+	if caller == owner {
+		return Owner
+	}
+	if caller.TenantID != owner.TenantID {
+		return ""
+	}
+	var best Role
+	for _, r := range granted {
+		if r.Grantable() && r.rank() > best.rank() {
+			best = r
+		}
+	}
+	return best
+}
+
+// Authorize returns nil if role allows action, ErrNoAccess if there is no
+// role and ErrDenied otherwise.
+func Authorize(role Role, action Action) error {
+	if role.rank() == 0 {
+		return ErrNoAccess
+	}
+	need, ok := minRole[action]
+	if !ok || role.rank() < need.rank() {
+		return ErrDenied
+	}
+	return nil
+}
+
+// CanGrant reports whether a caller with role actor may give someone
+// role, or take it away: they must be allowed to share and may not hand
+// out more than they hold.
+func CanGrant(actor, role Role) error {
+	if err := Authorize(actor, Share); err != nil {
+		return err
+	}
+	if !role.Grantable() || role.rank() > actor.rank() {
+		return ErrDenied
+	}
+	return nil
+}
+
+// CanChange reports whether a caller with role actor may change a grant
+// from one role to another.
+func CanChange(actor, from, to Role) error {
+	for _, r := range []Role{from, to} {
+		if err := CanGrant(actor, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CanRevoke reports whether a caller with role actor may revoke a grant of
+// role. Anyone may give up a grant of their own.
+func CanRevoke(actor, role Role, own bool) error {
+	if own && actor.rank() > 0 {
+		return nil
+	}
+	return CanGrant(actor, role)
+}
@@ -0,0 +1,96 @@
+package policy
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRoleOf(t *testing.T) {
+	alice := Subject{ID: "alice", TenantID: "acme"}
+	bob := Subject{ID: "bob", TenantID: "acme"}
+	outsider := Subject{ID: "bob", TenantID: "globex"}
+	cases := []struct {
+		caller  Subject
+		granted []Role
+		want    Role
+	}{
+		{alice, nil, Owner},
+		{alice, []Role{Viewer}, Owner},
+		{bob, nil, ""},
+		{bob, []Role{Viewer}, Viewer},
+		{bob, []Role{Editor, Viewer}, Editor},
+		{bob, []Role{Viewer, Admin}, Admin},
+		{bob, []Role{Owner}, ""},
+		{outsider, []Role{Admin}, ""},
+	}
+	for _, tc := range cases {
+		if got := RoleOf(tc.caller, alice, tc.granted); got != tc.want {
+			t.Fatalf("RoleOf(%v, %v) = %q, want %q", tc.caller, tc.granted, got, tc.want)
+		}
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	allowed := map[Role][]Action{
+		Viewer: {Read},
+		Editor: {Read, Edit},
+		Admin:  {Read, Edit, Delete, Share},
+		Owner:  {Read, Edit, Delete, Share},
+	}
+	for role, actions := range allowed {
+		for _, a := range []Action{Read, Edit, Delete, Share} {
+			want := ErrDenied
+			for _, ok := range actions {
+				if a == ok {
+					want = nil
+				}
+			}
+			if err := Authorize(role, a); !errors.Is(err, want) {
+				t.Fatalf("Authorize(%s, %s) = %v, want %v", role, a, err, want)
+			}
+		}
+	}
+	if err := Authorize("", Read); !errors.Is(err, ErrNoAccess) {
+		t.Fatalf("expected ErrNoAccess without a role, got %v", err)
+	}
+	if err := Authorize(Owner, "transfer"); !errors.Is(err, ErrDenied) {
+		t.Fatalf("expected unknown actions to be denied, got %v", err)
+	}
+}
+
+func TestGrants(t *testing.T) {
+	cases := []struct {
+		actor, role Role
+		want        error
+	}{
+		{Owner, Admin, nil},
+		{Admin, Admin, nil},
+		{Admin, Viewer, nil},
+		{Admin, Owner, ErrDenied},
+		{Editor, Viewer, ErrDenied},
+		{Viewer, Viewer, ErrDenied},
+		{"", Viewer, ErrNoAccess},
+	}
+	for _, tc := range cases {
+		if err := CanGrant(tc.actor, tc.role); !errors.Is(err, tc.want) {
+			t.Fatalf("CanGrant(%q, %q) = %v, want %v", tc.actor, tc.role, err, tc.want)
+		}
+	}
+
+	if err := CanChange(Admin, Viewer, Admin); err != nil {
+		t.Fatalf("admin promoting to admin: %v", err)
+	}
+	if err := CanChange(Admin, Viewer, Owner); !errors.Is(err, ErrDenied) {
+		t.Fatalf("expected promotion to owner to be denied, got %v", err)
+	}
+
+	if err := CanRevoke(Viewer, Viewer, true); err != nil {
+		t.Fatalf("viewer leaving: %v", err)
+	}
+	if err := CanRevoke(Viewer, Editor, false); !errors.Is(err, ErrDenied) {
+		t.Fatalf("expected a viewer revoking another grant to be denied, got %v", err)
+	}
+	if err := CanRevoke("", Viewer, true); !errors.Is(err, ErrNoAccess) {
+		t.Fatalf("expected a revoke without access to fail, got %v", err)
+	}
+}
@@ -0,0 +1,110 @@
+// Package ratelimit limits how fast each client can make requests, using
+// a token bucket per client.
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Limit is a token bucket budget: up to Burst requests at once, refilled
+// at PerMinute requests a minute. A zero PerMinute means no limit.
+type Limit struct {
+	PerMinute int
+	Burst     int
+}
+
+func (l Limit) enabled() bool {
+	return l.PerMinute > 0 && l.Burst > 0
+}
+
+// rate is the refill rate in tokens per second.
+func (l Limit) rate() float64 {
+	return float64(l.PerMinute) / 60
+}
+
+// Result is the outcome of taking a token.
+type Result struct {
+	Allowed bool
+	// Limit is the bucket's capacity and Remaining the whole tokens left.
+	Limit     int
+	Remaining int
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+	// RetryAfter is how long until a request would be allowed, if this
+	// one was not.
+	RetryAfter time.Duration
+}
+
+// sweepInterval is how often buckets that have refilled completely are
+// dropped, which keeps memory bounded by the clients seen recently.
+const sweepInterval = time.Minute
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// Limiter keeps a token bucket for each key, in memory, so every instance
+// of the server limits on its own.
+type Limiter struct {
+	limit Limit
+	now   func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+func NewLimiter(limit Limit) *Limiter {
+	return &Limiter{limit: limit, now: time.Now, buckets: make(map[string]*bucket)}
+}
+
+// Take takes a token from the bucket of key, if one is left.
+func (l *Limiter) Take(key string) Result {
+	now := l.now()
+	burst := float64(l.limit.Burst)
+	rate := l.limit.rate()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.lastSweep) >= sweepInterval {
+		l.sweep(now)
+	}
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: burst, updated: now}
+		l.buckets[key] = b
+	}
+	if elapsed := now.Sub(b.updated); elapsed > 0 {
+		b.tokens = min(burst, b.tokens+elapsed.Seconds()*rate)
+		b.updated = now
+	}
+
+	res := Result{Limit: l.limit.Burst}
+	if b.tokens >= 1 {
+		b.tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = seconds((1 - b.tokens) / rate)
+	}
+	res.Remaining = int(b.tokens)
+	res.Reset = seconds((burst - b.tokens) / rate)
+	return res
+}
+
+// sweep drops the buckets that would be full by now, since a new bucket
+// is the same as a full one. l.mu must be held.
+func (l *Limiter) sweep(now time.Time) {
+	burst, rate := float64(l.limit.Burst), l.limit.rate()
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.updated).Seconds()*rate >= burst {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
@@ -0,0 +1,251 @@
+package ratelimit
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jplaulau14/go-todo-api/internal/reqctx"
+)
+
+// Options configure Middleware.
+type Options struct {
+	// Read limits GET, HEAD and OPTIONS requests and Write every other
+	// method, so that a client reading heavily can still write.
+	Read  Limit
+	Write Limit
+	// TrustedProxies are the reverse proxies whose X-Forwarded-For header
+	// is believed when finding a client's address.
+	TrustedProxies []netip.Prefix
+	// Public paths are not limited.
+	Public []string
+}
+
+// Middleware gives each client a token bucket for reads and another for
+// writes. Clients are told apart by the API key they use, else the
+// subject of their token, else their IP address, so it runs inside the
+// authentication middleware, with Guard outside it. Limited responses
+// carry RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers,
+// and requests over the budget get 429 with Retry-After.
+func Middleware(opts Options) func(http.Handler) http.Handler {
+	buckets := newBuckets(opts)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			limiter, ok := buckets.of(r)
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if a, ok := r.Context().Value(attemptKey{}).(*attempt); ok {
+				a.counted = true
+			}
+			if take(w, r, limiter, clientKey(r, opts.TrustedProxies)) {
+				next.ServeHTTP(w, r)
+			}
+		})
+	}
+}
+
+// Guard limits, by IP address, the requests that never reach Middleware,
+// such as those authentication rejects, so that guessing keys or sending
+// junk tokens is limited too. It goes outside authentication and charges
+// the address only when a response is written without Middleware having
+// counted the request, replacing that response with 429 once the address
+// has run out. Authenticated clients are never held back by failures from
+// the address they share.
+func Guard(opts Options) func(http.Handler) http.Handler {
+	buckets := newBuckets(opts)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			limiter, ok := buckets.of(r)
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+			a := &attempt{}
+			gw := &guardWriter{ResponseWriter: w, attempt: a, charge: func() bool {
+				return take(w, r, limiter, ipKey(clientIP(r, opts.TrustedProxies)))
+			}}
+			next.ServeHTTP(gw, r.WithContext(context.WithValue(r.Context(), attemptKey{}, a)))
+		})
+	}
+}
+
+type attemptKey struct{}
+
+// attempt tells Guard whether Middleware counted a request.
+type attempt struct {
+	counted bool
+}
+
+// guardWriter charges the client's address, through charge, when the first
+// byte or status of a response is written for a request Middleware has not
+// counted. If charge refuses, it has written 429 and the response is
+// dropped.
+type guardWriter struct {
+	http.ResponseWriter
+	attempt *attempt
+	charge  func() bool
+
+	decided, blocked bool
+}
+
+func (g *guardWriter) allowed() bool {
+	if !g.decided {
+		g.decided = true
+		g.blocked = !g.attempt.counted && !g.charge()
+	}
+	return !g.blocked
+}
+
+func (g *guardWriter) WriteHeader(status int) {
+	if g.allowed() {
+		g.ResponseWriter.WriteHeader(status)
+	}
+}
+
+func (g *guardWriter) Write(b []byte) (int, error) {
+	if !g.allowed() {
+		return len(b), nil
+	}
+	return g.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, for
+// handlers that flush or extend deadlines.
+func (g *guardWriter) Unwrap() http.ResponseWriter {
+	return g.ResponseWriter
+}
+
+// buckets are the limiters for reads and writes.
+type buckets struct {
+	opts          Options
+	reads, writes *Limiter
+}
+
+func newBuckets(opts Options) buckets {
+	return buckets{opts: opts, reads: NewLimiter(opts.Read), writes: NewLimiter(opts.Write)}
+}
+
+// of returns the limiter for r, or false if r is not limited.
+func (b buckets) of(r *http.Request) (*Limiter, bool) {
+	limiter, limit := b.writes, b.opts.Write
+	if isRead(r.Method) {
+		limiter, limit = b.reads, b.opts.Read
+	}
+	if !limit.enabled() || slices.Contains(b.opts.Public, r.URL.Path) {
+		return nil, false
+	}
+	return limiter, true
+}
+
+// take takes a token for key and sets the RateLimit headers. If none is
+// left it writes 429 and returns false.
+func take(w http.ResponseWriter, r *http.Request, limiter *Limiter, key string) bool {
+	res := limiter.Take(key)
+	h := w.Header()
+	h.Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+	h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	h.Set("RateLimit-Reset", ceilSeconds(res.Reset))
+	if !res.Allowed {
+		retry := ceilSeconds(res.RetryAfter)
+		h.Set("Retry-After", retry)
+		writeTooManyRequests(w, r, "rate limit exceeded; retry in "+retry+" seconds")
+		return false
+	}
+	return true
+}
+
+func isRead(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// ceilSeconds formats d as whole seconds, rounding up so that clients do
+// not retry too early.
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+// clientKey names the bucket a request counts against.
+func clientKey(r *http.Request, trusted []netip.Prefix) string {
+	if p, ok := reqctx.GetPrincipal(r.Context()); ok {
+		if p.KeyID != "" {
+			return "key:" + p.KeyID
+		}
+		if p.Subject != "" {
+			return "sub:" + p.TenantID + "\x00" + p.Subject
+		}
+	}
+	return ipKey(clientIP(r, trusted))
+}
+
+// ipKey names the bucket of a client address. An IPv6 client usually
+// controls a whole /64.
+func ipKey(ip netip.Addr) string {
+	if ip.Is6() {
+		return "ip:" + netip.PrefixFrom(ip, 64).Masked().String()
+	}
+	return "ip:" + ip.String()
+}
+
+// clientIP returns the address of the client that made r. Only when the
+// peer is a trusted proxy is X-Forwarded-For consulted, from the right,
+// skipping further trusted proxies, so that clients cannot choose their
+// address by sending the header themselves.
+func clientIP(r *http.Request, trusted []netip.Prefix) netip.Addr {
+	peer, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return netip.Addr{}
+	}
+	ip := peer.Addr().Unmap()
+	if !isTrusted(ip, trusted) {
+		return ip
+	}
+	var hops []string
+	for _, v := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(v, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		ip = hop.Unmap()
+		if !isTrusted(ip, trusted) {
+			break
+		}
+	}
+	return ip
+}
+
+func isTrusted(ip netip.Addr, trusted []netip.Prefix) bool {
+	return slices.ContainsFunc(trusted, func(p netip.Prefix) bool { return p.Contains(ip) })
+}
+
+// errorResponse has the shape of every error the API returns.
+type errorResponse struct {
+	Code      string `json:"code"`
+	String    string `json:"string"`
+	Message   string `json:"message"`
+	Status    int    `json:"status"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+func writeTooManyRequests(w http.ResponseWriter, r *http.Request, message string) {
+	status := http.StatusTooManyRequests
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(errorResponse{
+		Code:      "too_many_requests",
+		String:    http.StatusText(status),
+		Message:   message,
+		Status:    status,
+		RequestID: reqctx.GetRequestID(r.Context()),
+	})
+}
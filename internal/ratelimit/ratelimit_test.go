@@ -0,0 +1,198 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/jplaulau14/go-todo-api/internal/reqctx"
+)
+
+func TestLimiter(t *testing.T) {
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	l := NewLimiter(Limit{PerMinute: 60, Burst: 3})
+	l.now = func() time.Time { return now }
+
+	for i := 2; i >= 0; i-- {
+		res := l.Take("a")
+		if !res.Allowed || res.Remaining != i || res.Limit != 3 {
+			t.Fatalf("take %d: %+v", 3-i, res)
+		}
+	}
+	res := l.Take("a")
+	if res.Allowed || res.RetryAfter != time.Second || res.Reset != 3*time.Second {
+		t.Fatalf("expected the bucket to be empty: %+v", res)
+	}
+	if res := l.Take("b"); !res.Allowed {
+		t.Fatalf("expected another key to have its own bucket: %+v", res)
+	}
+
+	// Tokens come back at the refill rate, up to the burst.
+	now = now.Add(1500 * time.Millisecond)
+	if res := l.Take("a"); !res.Allowed || res.Remaining != 0 {
+		t.Fatalf("expected one token after 1.5s: %+v", res)
+	}
+	now = now.Add(time.Hour)
+	if res := l.Take("a"); !res.Allowed || res.Remaining != 2 {
+		t.Fatalf("expected a full bucket: %+v", res)
+	}
+
+	// Buckets that have refilled are dropped.
+	now = now.Add(time.Hour)
+	l.Take("c")
+	if _, ok := l.buckets["a"]; ok || len(l.buckets) != 1 {
+		t.Fatalf("expected full buckets to be swept, have %d", len(l.buckets))
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) })
+	srv := Middleware(Options{
+		Read:   Limit{PerMinute: 60, Burst: 2},
+		Write:  Limit{PerMinute: 60, Burst: 1},
+		Public: []string{"/healthz"},
+	})(ok)
+	send := func(method, path string, p *reqctx.Principal) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		ctx := reqctx.WithRequestID(req.Context(), "req-1")
+		if p != nil {
+			ctx = reqctx.WithPrincipal(ctx, *p)
+		}
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req.WithContext(ctx))
+		return w
+	}
+
+	w := send(http.MethodPost, "/todos", nil)
+	if w.Code != http.StatusNoContent || w.Header().Get("RateLimit-Limit") != "1" || w.Header().Get("RateLimit-Remaining") != "0" || w.Header().Get("RateLimit-Reset") != "1" {
+		t.Fatalf("unexpected first write: %d %v", w.Code, w.Header())
+	}
+	w = send(http.MethodPatch, "/todos/1", nil)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "1" {
+		t.Fatalf("expected 429 with Retry-After, got %d %v", w.Code, w.Header())
+	}
+	var e errorResponse
+	if err := json.NewDecoder(w.Body).Decode(&e); err != nil || e.Code != "too_many_requests" || e.Status != http.StatusTooManyRequests || e.RequestID != "req-1" {
+		t.Fatalf("unexpected error body: %+v %v", e, err)
+	}
+
+	// Reads have their own budget, and public paths none.
+	if w := send(http.MethodGet, "/todos", nil); w.Code != http.StatusNoContent || w.Header().Get("RateLimit-Limit") != "2" {
+		t.Fatalf("expected reads to be allowed after writes ran out: %d %v", w.Code, w.Header())
+	}
+	for i := 0; i < 5; i++ {
+		if w := send(http.MethodGet, "/healthz", nil); w.Code != http.StatusNoContent || w.Header().Get("RateLimit-Limit") != "" {
+			t.Fatalf("expected /healthz to be unlimited: %d %v", w.Code, w.Header())
+		}
+	}
+
+	// Authenticated callers are limited by key or subject, not address.
+	for _, p := range []reqctx.Principal{{Subject: "alice", KeyID: "key-1"}, {Subject: "alice", KeyID: "key-2"}, {Subject: "alice", TenantID: "acme"}} {
+		if w := send(http.MethodPost, "/todos", &p); w.Code != http.StatusNoContent {
+			t.Fatalf("%+v: expected its own bucket, got %d", p, w.Code)
+		}
+	}
+	if w := send(http.MethodPost, "/todos", &reqctx.Principal{Subject: "bob", KeyID: "key-1"}); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected the key's bucket to be shared, got %d", w.Code)
+	}
+}
+
+func TestGuard(t *testing.T) {
+	opts := Options{Read: Limit{PerMinute: 60, Burst: 3}, Write: Limit{PerMinute: 60, Burst: 3}}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) })
+	// authenticate stands in for the auth middleware: requests without a
+	// valid key get 401 and never reach the inner limiter.
+	authenticate := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get("Authorization")
+			if key == "" || key == "junk" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(reqctx.WithPrincipal(r.Context(), reqctx.Principal{Subject: key, KeyID: key})))
+		})
+	}
+	srv := Guard(opts)(authenticate(Middleware(opts)(ok)))
+	send := func(addr, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/todos", nil)
+		req.RemoteAddr = addr
+		if key != "" {
+			req.Header.Set("Authorization", key)
+		}
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		return w
+	}
+
+	// Repeated failures use up the address's budget.
+	for i := 0; i < 3; i++ {
+		if w := send("192.0.2.1:1234", "junk"); w.Code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: expected 401, got %d", i, w.Code)
+		}
+	}
+	w := send("192.0.2.1:1234", "junk")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "1" {
+		t.Fatalf("expected repeated 401s to end in 429, got %d %v", w.Code, w.Header())
+	}
+	if w := send("198.51.100.1:1234", ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected another address to have its own budget, got %d", w.Code)
+	}
+
+	// Authenticated requests are counted by key instead, so many keys
+	// behind one address are not limited by it.
+	for i := 0; i < 10; i++ {
+		w := send("203.0.113.1:1234", "key-"+strconv.Itoa(i))
+		if w.Code != http.StatusNoContent || w.Header().Get("RateLimit-Remaining") != "2" {
+			t.Fatalf("key %d: expected its own bucket, got %d %v", i, w.Code, w.Header())
+		}
+	}
+	for i := 0; i < 3; i++ {
+		send("203.0.113.1:1234", "key-0")
+	}
+	if w := send("203.0.113.1:1234", "key-0"); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected the key's own budget to still apply, got %d", w.Code)
+	}
+
+	// An address out of budget from failures still lets valid keys through.
+	if w := send("192.0.2.1:1234", "key-9"); w.Code != http.StatusNoContent {
+		t.Fatalf("expected a valid key from an exhausted address to pass, got %d", w.Code)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("2001:db8::1/128")}
+	cases := []struct {
+		remote, forwarded, want string
+	}{
+		// Untrusted peers cannot choose their address.
+		{"192.0.2.1:1234", "203.0.113.7", "192.0.2.1"},
+		{"10.0.0.1:1234", "", "10.0.0.1"},
+		{"10.0.0.1:1234", "203.0.113.7", "203.0.113.7"},
+		// Only the hops appended by trusted proxies count.
+		{"10.0.0.1:1234", "198.51.100.1, 203.0.113.7, 10.0.0.2", "203.0.113.7"},
+		{"[2001:db8::1]:443", "203.0.113.7", "203.0.113.7"},
+		{"10.0.0.1:1234", "garbage, 10.0.0.2", "10.0.0.2"},
+		{"[::ffff:192.0.2.1]:1234", "", "192.0.2.1"},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = tc.remote
+		if tc.forwarded != "" {
+			req.Header.Set("X-Forwarded-For", tc.forwarded)
+		}
+		if got := clientIP(req, trusted); got.String() != tc.want {
+			t.Fatalf("%s via %q: got %s, want %s", tc.remote, tc.forwarded, got, tc.want)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "[2001:db8:1:2:3:4:5:6]:443"
+	if key := clientKey(req, nil); key != "ip:2001:db8:1:2::/64" {
+		t.Fatalf("expected IPv6 clients to be keyed by /64, got %q", key)
+	}
+}
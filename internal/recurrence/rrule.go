@@ -0,0 +1,316 @@
+// Package recurrence implements the subset of RFC 5545 recurrence rules
+// used by repeating todos: FREQ, INTERVAL, BYDAY, COUNT and UNTIL.
+package recurrence
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Frequency string
+
+const (
+	Daily   Frequency = "DAILY"
+	Weekly  Frequency = "WEEKLY"
+	Monthly Frequency = "MONTHLY"
+	Yearly  Frequency = "YEARLY"
+)
+
+// WeekdayNum is a BYDAY entry. N selects the Nth (or, when negative, the
+// Nth from last) such weekday of the month and is only valid with MONTHLY
+// rules; zero means every such weekday.
+type WeekdayNum struct {
+	N       int
+	Weekday time.Weekday
+}
+
+// Rule is a parsed recurrence rule. Count and Until are mutually
+// exclusive; when both are zero the rule repeats forever.
+type Rule struct {
+	Freq     Frequency
+	Interval int
+	ByDay    []WeekdayNum
+	Count    int
+	Until    time.Time
+}
+
+var dayCodes = map[string]time.Weekday{
+	"SU": time.Sunday,
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+}
+
+// untilLayouts are the UNTIL forms accepted: a UTC date-time or a date.
+var untilLayouts = []string{"20060102T150405Z", "20060102"}
+
+// maxPeriods bounds how far Next searches so that rules which can never
+// match (e.g. the 31st of every February) terminate.
+const maxPeriods = 100000
+
+// Parse parses a rule such as "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,WE". An
+// optional "RRULE:" prefix is accepted.
+func Parse(s string) (Rule, error) {
+	s = strings.TrimSpace(s)
+	if len(s) >= 6 && strings.EqualFold(s[:6], "RRULE:") {
+		s = s[6:]
+	}
+	if s == "" {
+		return Rule{}, errors.New("empty rule")
+	}
+	r := Rule{Interval: 1}
+	seen := map[string]bool{}
+	for _, part := range strings.Split(s, ";") {
+		key, value, ok := strings.Cut(part, "=")
+		key = strings.ToUpper(strings.TrimSpace(key))
+		value = strings.ToUpper(strings.TrimSpace(value))
+		if !ok || value == "" {
+			return Rule{}, fmt.Errorf("malformed rule part %q", part)
+		}
+		if seen[key] {
+			return Rule{}, fmt.Errorf("duplicate %s", key)
+		}
+		seen[key] = true
+		switch key {
+		case "FREQ":
+			switch f := Frequency(value); f {
+			case Daily, Weekly, Monthly, Yearly:
+				r.Freq = f
+			default:
+				return Rule{}, fmt.Errorf("unsupported FREQ %q", value)
+			}
+		case "INTERVAL":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return Rule{}, errors.New("INTERVAL must be a positive integer")
+			}
+			r.Interval = n
+		case "COUNT":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return Rule{}, errors.New("COUNT must be a positive integer")
+			}
+			r.Count = n
+		case "UNTIL":
+			var err error
+			for _, layout := range untilLayouts {
+				if r.Until, err = time.Parse(layout, value); err == nil {
+					break
+				}
+			}
+			if err != nil {
+				return Rule{}, errors.New("UNTIL must look like 20250131T000000Z or 20250131")
+			}
+		case "BYDAY":
+			for _, d := range strings.Split(value, ",") {
+				wd, err := parseWeekdayNum(d)
+				if err != nil {
+					return Rule{}, err
+				}
+				r.ByDay = append(r.ByDay, wd)
+			}
+		default:
+			return Rule{}, fmt.Errorf("unsupported rule part %s", key)
+		}
+	}
+	if r.Freq == "" {
+		return Rule{}, errors.New("FREQ is required")
+	}
+	if r.Count > 0 && !r.Until.IsZero() {
+		return Rule{}, errors.New("COUNT and UNTIL cannot both be set")
+	}
+	for _, wd := range r.ByDay {
+		if wd.N != 0 && r.Freq != Monthly {
+			return Rule{}, errors.New("numbered BYDAY entries require FREQ=MONTHLY")
+		}
+	}
+	if len(r.ByDay) > 0 && r.Freq == Yearly {
+		return Rule{}, errors.New("BYDAY is not supported with FREQ=YEARLY")
+	}
+	return r, nil
+}
+
+func parseWeekdayNum(s string) (WeekdayNum, error) {
+	s = strings.TrimSpace(s)
+	if len(s) < 2 {
+		return WeekdayNum{}, fmt.Errorf("invalid BYDAY %q", s)
+	}
+	wd, ok := dayCodes[s[len(s)-2:]]
+	if !ok {
+		return WeekdayNum{}, fmt.Errorf("invalid BYDAY %q", s)
+	}
+	out := WeekdayNum{Weekday: wd}
+	if prefix := s[:len(s)-2]; prefix != "" {
+		n, err := strconv.Atoi(prefix)
+		if err != nil || n == 0 || n < -5 || n > 5 {
+			return WeekdayNum{}, fmt.Errorf("invalid BYDAY %q", s)
+		}
+		out.N = n
+	}
+	return out, nil
+}
+
+// String formats the rule in canonical form, suitable for storage.
+func (r Rule) String() string {
+	parts := []string{"FREQ=" + string(r.Freq)}
+	if r.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.Interval))
+	}
+	if len(r.ByDay) > 0 {
+		days := make([]string, len(r.ByDay))
+		for i, wd := range r.ByDay {
+			days[i] = wd.String()
+		}
+		parts = append(parts, "BYDAY="+strings.Join(days, ","))
+	}
+	if r.Count > 0 {
+		parts = append(parts, "COUNT="+strconv.Itoa(r.Count))
+	}
+	if !r.Until.IsZero() {
+		parts = append(parts, "UNTIL="+r.Until.UTC().Format(untilLayouts[0]))
+	}
+	return strings.Join(parts, ";")
+}
+
+func (wd WeekdayNum) String() string {
+	code := strings.ToUpper(wd.Weekday.String()[:2])
+	if wd.N != 0 {
+		return strconv.Itoa(wd.N) + code
+	}
+	return code
+}
+
+// Next returns the first occurrence strictly after after, for a series
+// whose first occurrence is dtstart. The second result is false once the
+// series is exhausted by COUNT or UNTIL. Occurrences keep dtstart's time
+// of day and location.
+func (r Rule) Next(dtstart, after time.Time) (time.Time, bool) {
+	var found time.Time
+	ok := false
+	r.each(dtstart, func(occ time.Time) bool {
+		if occ.After(after) {
+			found, ok = occ, true
+			return false
+		}
+		return true
+	})
+	return found, ok
+}
+
+// each calls fn for every occurrence in order, starting with dtstart,
+// until fn returns false or the series ends.
+func (r Rule) each(dtstart time.Time, fn func(time.Time) bool) {
+	interval := max(r.Interval, 1)
+	n := 0
+	emit := func(occ time.Time) bool {
+		if !r.Until.IsZero() && occ.After(r.Until) {
+			return false
+		}
+		n++
+		if !fn(occ) {
+			return false
+		}
+		return r.Count == 0 || n < r.Count
+	}
+	if !emit(dtstart) {
+		return
+	}
+	for k := 0; k < maxPeriods; k++ {
+		candidates := r.period(dtstart, k*interval)
+		for _, occ := range candidates {
+			if !occ.After(dtstart) {
+				continue
+			}
+			if !emit(occ) {
+				return
+			}
+		}
+	}
+}
+
+// period returns the sorted candidate occurrences in the period that is
+// offset periods after the one containing dtstart.
+func (r Rule) period(dtstart time.Time, offset int) []time.Time {
+	y, m, d := dtstart.Date()
+	hh, mm, ss := dtstart.Clock()
+	ns, loc := dtstart.Nanosecond(), dtstart.Location()
+	at := func(y int, m time.Month, d int) time.Time { return time.Date(y, m, d, hh, mm, ss, ns, loc) }
+
+	var out []time.Time
+	switch r.Freq {
+	case Daily:
+		day := at(y, m, d+offset)
+		if len(r.ByDay) == 0 || r.hasWeekday(day.Weekday()) {
+			out = append(out, day)
+		}
+	case Weekly:
+		// Weeks start on Monday (the RFC 5545 default WKST).
+		monday := d - (int(dtstart.Weekday())+6)%7 + 7*offset
+		days := []time.Weekday{dtstart.Weekday()}
+		if len(r.ByDay) > 0 {
+			days = days[:0]
+			for _, wd := range r.ByDay {
+				days = append(days, wd.Weekday)
+			}
+		}
+		for _, wd := range days {
+			out = append(out, at(y, m, monday+(int(wd)+6)%7))
+		}
+	case Monthly:
+		first := at(y, m+time.Month(offset), 1)
+		if len(r.ByDay) == 0 {
+			if occ := at(first.Year(), first.Month(), d); occ.Month() == first.Month() {
+				out = append(out, occ)
+			}
+			break
+		}
+		out = r.monthDays(first)
+	case Yearly:
+		if occ := at(y+offset, m, d); occ.Month() == m {
+			out = append(out, occ)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Before(out[j]) })
+	return slices.CompactFunc(out, func(a, b time.Time) bool { return a.Equal(b) })
+}
+
+func (r Rule) hasWeekday(wd time.Weekday) bool {
+	for _, bd := range r.ByDay {
+		if bd.Weekday == wd {
+			return true
+		}
+	}
+	return false
+}
+
+// monthDays expands BYDAY within the month starting at first.
+func (r Rule) monthDays(first time.Time) []time.Time {
+	var out []time.Time
+	daysIn := first.AddDate(0, 1, -1).Day()
+	for _, bd := range r.ByDay {
+		var matches []time.Time
+		for day := 1; day <= daysIn; day++ {
+			t := first.AddDate(0, 0, day-1)
+			if t.Weekday() == bd.Weekday {
+				matches = append(matches, t)
+			}
+		}
+		switch {
+		case bd.N == 0:
+			out = append(out, matches...)
+		case bd.N > 0 && bd.N <= len(matches):
+			out = append(out, matches[bd.N-1])
+		case bd.N < 0 && -bd.N <= len(matches):
+			out = append(out, matches[len(matches)+bd.N])
+		}
+	}
+	return out
+}
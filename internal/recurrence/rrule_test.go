@@ -0,0 +1,140 @@
+package recurrence
+
+import (
+	"testing"
+	"time"
+)
+
+func date(y int, m time.Month, d int) time.Time {
+	return time.Date(y, m, d, 9, 0, 0, 0, time.UTC)
+}
+
+// occurrences walks the series with Next, returning at most n entries.
+func occurrences(t *testing.T, rule string, dtstart time.Time, n int) []time.Time {
+	t.Helper()
+	r, err := Parse(rule)
+	if err != nil {
+		t.Fatalf("Parse(%q): %v", rule, err)
+	}
+	out := []time.Time{dtstart}
+	for len(out) < n {
+		next, ok := r.Next(dtstart, out[len(out)-1])
+		if !ok {
+			break
+		}
+		out = append(out, next)
+	}
+	return out
+}
+
+func assertDates(t *testing.T, got []time.Time, want ...time.Time) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d occurrences %v, want %d %v", len(got), got, len(want), want)
+	}
+	for i := range want {
+		if !got[i].Equal(want[i]) {
+			t.Fatalf("occurrence %d: got %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestParse_RoundTrip(t *testing.T) {
+	r, err := Parse("RRULE:freq=weekly;interval=2;byday=MO,FR;count=4")
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if got := r.String(); got != "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,FR;COUNT=4" {
+		t.Fatalf("String: %q", got)
+	}
+	r, err = Parse("FREQ=MONTHLY;BYDAY=-1FR;UNTIL=20251231")
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if got := r.String(); got != "FREQ=MONTHLY;BYDAY=-1FR;UNTIL=20251231T000000Z" {
+		t.Fatalf("String: %q", got)
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	for _, s := range []string{
+		"",
+		"INTERVAL=2",
+		"FREQ=HOURLY",
+		"FREQ=DAILY;INTERVAL=0",
+		"FREQ=DAILY;COUNT=-1",
+		"FREQ=DAILY;COUNT=2;UNTIL=20250101",
+		"FREQ=DAILY;UNTIL=tomorrow",
+		"FREQ=WEEKLY;BYDAY=XX",
+		"FREQ=WEEKLY;BYDAY=2MO",
+		"FREQ=YEARLY;BYDAY=MO",
+		"FREQ=DAILY;BYMONTH=1",
+		"FREQ=DAILY;FREQ=WEEKLY",
+		"FREQ",
+	} {
+		if _, err := Parse(s); err == nil {
+			t.Errorf("Parse(%q): expected error", s)
+		}
+	}
+}
+
+func TestNext_Daily(t *testing.T) {
+	got := occurrences(t, "FREQ=DAILY;INTERVAL=3;COUNT=3", date(2025, 1, 30), 10)
+	assertDates(t, got, date(2025, 1, 30), date(2025, 2, 2), date(2025, 2, 5))
+}
+
+func TestNext_DailyByDay(t *testing.T) {
+	// Weekdays only, starting on a Friday.
+	got := occurrences(t, "FREQ=DAILY;BYDAY=MO,TU,WE,TH,FR", date(2025, 8, 8), 3)
+	assertDates(t, got, date(2025, 8, 8), date(2025, 8, 11), date(2025, 8, 12))
+}
+
+func TestNext_WeeklyByDay(t *testing.T) {
+	// Every other week on Monday and Wednesday, starting Wednesday 2025-08-06.
+	got := occurrences(t, "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,WE", date(2025, 8, 6), 5)
+	assertDates(t, got, date(2025, 8, 6), date(2025, 8, 18), date(2025, 8, 20), date(2025, 9, 1), date(2025, 9, 3))
+}
+
+func TestNext_WeeklyDefaultsToStartWeekday(t *testing.T) {
+	got := occurrences(t, "FREQ=WEEKLY", date(2025, 8, 9), 3)
+	assertDates(t, got, date(2025, 8, 9), date(2025, 8, 16), date(2025, 8, 23))
+}
+
+func TestNext_MonthlySkipsShortMonths(t *testing.T) {
+	got := occurrences(t, "FREQ=MONTHLY", date(2025, 1, 31), 4)
+	assertDates(t, got, date(2025, 1, 31), date(2025, 3, 31), date(2025, 5, 31), date(2025, 7, 31))
+}
+
+func TestNext_MonthlyByNumberedDay(t *testing.T) {
+	// Last Friday of each month.
+	got := occurrences(t, "FREQ=MONTHLY;BYDAY=-1FR", date(2025, 8, 29), 3)
+	assertDates(t, got, date(2025, 8, 29), date(2025, 9, 26), date(2025, 10, 31))
+
+	// Second Tuesday.
+	got = occurrences(t, "FREQ=MONTHLY;BYDAY=2TU", date(2025, 8, 12), 3)
+	assertDates(t, got, date(2025, 8, 12), date(2025, 9, 9), date(2025, 10, 14))
+}
+
+func TestNext_YearlyLeapDay(t *testing.T) {
+	got := occurrences(t, "FREQ=YEARLY", date(2024, 2, 29), 3)
+	assertDates(t, got, date(2024, 2, 29), date(2028, 2, 29), date(2032, 2, 29))
+}
+
+func TestNext_Until(t *testing.T) {
+	got := occurrences(t, "FREQ=WEEKLY;UNTIL=20250820T090000Z", date(2025, 8, 6), 10)
+	assertDates(t, got, date(2025, 8, 6), date(2025, 8, 13), date(2025, 8, 20))
+}
+
+func TestNext_AfterArbitraryTime(t *testing.T) {
+	r, _ := Parse("FREQ=WEEKLY;BYDAY=MO")
+	// Asking from the middle of a week lands on the following Monday.
+	next, ok := r.Next(date(2025, 8, 4), date(2025, 8, 27))
+	if !ok || !next.Equal(date(2025, 9, 1)) {
+		t.Fatalf("got %v %v", next, ok)
+	}
+
+	r, _ = Parse("FREQ=DAILY;COUNT=2")
+	if _, ok := r.Next(date(2025, 8, 4), date(2025, 8, 5)); ok {
+		t.Fatalf("expected exhausted series")
+	}
+}
@@ -0,0 +1,30 @@
+package reqctx
+
+import "context"
+
+const principalKey ctxKey = 2
+
+// Principal is the authenticated caller of a request.
+type Principal struct {
+	// Subject identifies the caller: the owner an API key acts for or the
+	// sub claim of a JSON Web Token.
+	Subject string
+	// TenantID is the tenant the caller belongs to, if any.
+	TenantID string
+	// KeyID is the API key the request was made with; empty for JSON Web
+	// Tokens.
+	KeyID  string
+	Scopes []string
+	// Claims are the verified claims of a JSON Web Token; nil for API keys.
+	Claims map[string]any
+}
+
+func WithPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalKey, p)
+}
+
+// GetPrincipal returns the caller of the request, if it was authenticated.
+func GetPrincipal(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey).(Principal)
+	return p, ok
+}
@@ -0,0 +1,238 @@
+package todo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// ErrInvalidOperation wraps validation failures of a bulk operation.
+	ErrInvalidOperation = errors.New("invalid operation")
+	// ErrBulkAborted is reported for every operation of an atomic batch
+	// that was rolled back or skipped because another operation failed.
+	ErrBulkAborted = errors.New("not applied because another operation in the batch failed")
+)
+
+// maxBulkOperations bounds the size of a single batch.
+const maxBulkOperations = 500
+
+type BulkMode string
+
+const (
+	// BulkAtomic applies every operation or none of them.
+	BulkAtomic BulkMode = "atomic"
+	// BulkBestEffort applies each operation independently.
+	BulkBestEffort BulkMode = "best_effort"
+)
+
+type BulkAction string
+
+const (
+	BulkCreate BulkAction = "create"
+	BulkUpdate BulkAction = "update"
+	BulkDelete BulkAction = "delete"
+)
+
+// BulkOperation is one entry of a batch. Todo holds a CreateTodoRequest or
+// an UpdateTodoRequest depending on Op; Validate decodes it into Create or
+// Update.
+type BulkOperation struct {
+	Op BulkAction `json:"op"`
+	ID string     `json:"id,omitempty"`
+	// IfVersion makes an update or delete conditional, like If-Match.
+	IfVersion int64           `json:"if_version,omitempty"`
+	Todo      json.RawMessage `json:"todo,omitempty"`
+
+	Create *CreateTodoRequest `json:"-"`
+	Update *UpdateTodoRequest `json:"-"`
+}
+
+// Validate checks the operation and decodes its payload. Errors wrap
+// ErrInvalidOperation.
+func (op *BulkOperation) Validate() error {
+	if err := op.validate(); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidOperation, err)
+	}
+	return nil
+}
+
+func (op *BulkOperation) validate() error {
+	if op.IfVersion < 0 {
+		return errors.New("if_version must be positive")
+	}
+	switch op.Op {
+	case BulkCreate:
+		if op.ID != "" || op.IfVersion != 0 {
+			return errors.New("create takes neither id nor if_version")
+		}
+		op.Create = &CreateTodoRequest{}
+		if err := decodeStrict(op.Todo, op.Create); err != nil {
+			return err
+		}
+		return op.Create.Validate()
+	case BulkUpdate:
+		if op.ID == "" {
+			return errors.New("id is required")
+		}
+		op.Update = &UpdateTodoRequest{}
+		if err := decodeStrict(op.Todo, op.Update); err != nil {
+			return err
+		}
+		return op.Update.Validate()
+	case BulkDelete:
+		if op.ID == "" {
+			return errors.New("id is required")
+		}
+		if len(op.Todo) > 0 {
+			return errors.New("delete takes no todo")
+		}
+		return nil
+	default:
+		return errors.New("op must be one of create, update, delete")
+	}
+}
+
+// decodeStrict decodes a required JSON object, rejecting unknown fields.
+func decodeStrict(raw json.RawMessage, v any) error {
+	if len(raw) == 0 || bytes.Equal(raw, []byte("null")) {
+		return errors.New("todo is required")
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("invalid todo: %v", err)
+	}
+	return nil
+}
+
+type BulkRequest struct {
+	// Mode defaults to atomic.
+	Mode       BulkMode        `json:"mode"`
+	Operations []BulkOperation `json:"operations"`
+}
+
+// Validate checks the batch as a whole; operations are validated one by
+// one so that each can report its own error.
+func (req *BulkRequest) Validate() error {
+	if req.Mode == "" {
+		req.Mode = BulkAtomic
+	}
+	if req.Mode != BulkAtomic && req.Mode != BulkBestEffort {
+		return errors.New("mode must be atomic or best_effort")
+	}
+	if len(req.Operations) == 0 {
+		return errors.New("operations is required")
+	}
+	if len(req.Operations) > maxBulkOperations {
+		return fmt.Errorf("at most %d operations are allowed", maxBulkOperations)
+	}
+	return nil
+}
+
+// BulkResult is the outcome of one operation: the todo it created or
+// updated, or the error it failed with. Deletes succeed with a nil Todo.
+type BulkResult struct {
+	Todo *Todo
+	Err  error
+}
+
+// abortBulk marks every result except the failed one as aborted, after an
+// atomic batch was rolled back.
+func abortBulk(results []BulkResult, failed int) {
+	for i := range results {
+		if i != failed {
+			results[i] = BulkResult{Err: ErrBulkAborted}
+		}
+	}
+}
+
+// Bulk applies validated operations in order under one lock. Changes are
+// journaled so that an atomic batch, or a single failed operation of a
+// best-effort one, can be undone.
+func (r *InMemoryRepository) Bulk(ctx context.Context, ops []BulkOperation, atomic bool) ([]BulkResult, error) {
+	o := OwnerOf(ctx)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now().UTC()
+	results := make([]BulkResult, len(ops))
+	var journal []Todo
+	for i, op := range ops {
+		mark := len(journal)
+		t, err := r.applyBulk(o, op, now, &journal)
+		if err == nil {
+			results[i].Todo = t
+			continue
+		}
+		results[i].Err = err
+		if atomic {
+			mark = 0
+		}
+		r.undo(journal[mark:])
+		journal = journal[:mark]
+		if atomic {
+			abortBulk(results, i)
+			break
+		}
+	}
+	return results, nil
+}
+
+// applyBulk runs one operation, appending the prior state of every todo it
+// touches to journal. A todo that did not exist is journaled with only its
+// ID set. Operations act for o. r.mu must be held for writing.
+func (r *InMemoryRepository) applyBulk(o Owner, op BulkOperation, now time.Time, journal *[]Todo) (*Todo, error) {
+	switch op.Op {
+	case BulkCreate:
+		t, err := r.create(o, *op.Create, now)
+		if err != nil {
+			return nil, err
+		}
+		*journal = append(*journal, Todo{ID: t.ID})
+		return &t, nil
+	case BulkUpdate:
+		current, ok := r.live(o, op.ID)
+		if !ok {
+			return nil, ErrNotFound
+		}
+		if err := op.Update.ValidateFor(current); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidOperation, err)
+		}
+		t, err := r.update(o, op.ID, *op.Update, op.IfVersion, now)
+		if err != nil {
+			return nil, err
+		}
+		*journal = append(*journal, current)
+		next, err := r.createNext(o, current, t, now)
+		if err != nil {
+			return nil, err
+		}
+		if next != nil {
+			*journal = append(*journal, Todo{ID: next.ID})
+		}
+		return &t, nil
+	case BulkDelete:
+		current, _ := r.live(o, op.ID)
+		if err := r.delete(o, op.ID, op.IfVersion, now); err != nil {
+			return nil, err
+		}
+		*journal = append(*journal, current)
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("%w: unknown op %q", ErrInvalidOperation, op.Op)
+	}
+}
+
+// undo restores the journaled todos, newest change first.
+func (r *InMemoryRepository) undo(journal []Todo) {
+	for i := len(journal) - 1; i >= 0; i-- {
+		if prev := journal[i]; prev.Version == 0 {
+			delete(r.store, prev.ID)
+		} else {
+			r.store[prev.ID] = prev
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package todo
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/jplaulau14/go-todo-api/internal/reqctx"
+)
+
+// bulkResult reports one operation of a batch. Status is the status the
+// equivalent single request would have answered with.
+type bulkResult struct {
+	Index  int            `json:"index"`
+	Op     BulkAction     `json:"op"`
+	ID     string         `json:"id,omitempty"`
+	Status int            `json:"status"`
+	Todo   *Todo          `json:"todo,omitempty"`
+	Error  *errorResponse `json:"error,omitempty"`
+}
+
+type bulkResponse struct {
+	Mode      BulkMode     `json:"mode"`
+	Succeeded int          `json:"succeeded"`
+	Failed    int          `json:"failed"`
+	Results   []bulkResult `json:"results"`
+}
+
+// bulk applies a batch of creates, updates and deletes. The response is
+// 200 with a result per operation whether or not they succeeded; in atomic
+// mode a single failure means nothing was applied.
+func (h *HTTPHandler) bulk(w http.ResponseWriter, r *http.Request) {
+	var req BulkRequest
+	if !h.decodeJSON(w, r, &req) {
+		return
+	}
+	if err := req.Validate(); err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	results := make([]BulkResult, len(req.Operations))
+	var (
+		valid   []BulkOperation
+		indexes []int
+	)
+	for i := range req.Operations {
+		if err := req.Operations[i].Validate(); err != nil {
+			results[i].Err = err
+			continue
+		}
+		valid = append(valid, req.Operations[i])
+		indexes = append(indexes, i)
+	}
+
+	atomic := req.Mode == BulkAtomic
+	if atomic && len(valid) < len(req.Operations) {
+		// Reject the batch without touching the repository.
+		for i := range results {
+			if results[i].Err == nil {
+				results[i].Err = ErrBulkAborted
+			}
+		}
+	} else if len(valid) > 0 {
+		applied, err := h.repo.Bulk(r.Context(), valid, atomic)
+		if err != nil {
+			h.logger.Error("could not apply bulk operations", "error", err, "request_id", reqctx.GetRequestID(r.Context()))
+			writeError(w, r, http.StatusInternalServerError, "could not apply operations")
+			return
+		}
+		for j, res := range applied {
+			results[indexes[j]] = res
+		}
+	}
+
+	resp := bulkResponse{Mode: req.Mode, Results: make([]bulkResult, len(results))}
+	for i, res := range results {
+		op := req.Operations[i]
+		out := bulkResult{Index: i, Op: op.Op, ID: op.ID, Todo: res.Todo}
+		if res.Todo != nil {
+			out.ID = res.Todo.ID
+		}
+		if res.Err == nil {
+			resp.Succeeded++
+			out.Status = bulkSuccessStatus(op.Op)
+		} else {
+			resp.Failed++
+			status, message := h.bulkErrorStatus(r, op, res.Err)
+			code, str := statusToCode(status)
+			out.Status = status
+			out.Error = &errorResponse{Code: code, String: str, Message: message, Status: status}
+		}
+		resp.Results[i] = out
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func bulkSuccessStatus(op BulkAction) int {
+	switch op {
+	case BulkCreate:
+		return http.StatusCreated
+	case BulkDelete:
+		return http.StatusNoContent
+	default:
+		return http.StatusOK
+	}
+}
+
+// bulkErrorStatus maps an operation's error onto the status and message
+// the equivalent single request would have answered with.
+func (h *HTTPHandler) bulkErrorStatus(r *http.Request, op BulkOperation, err error) (int, string) {
+	switch {
+	case errors.Is(err, ErrInvalidOperation):
+		return http.StatusBadRequest, err.Error()
+	case errors.Is(err, ErrBulkAborted):
+		return http.StatusFailedDependency, err.Error()
+	case errors.Is(err, ErrNotFound):
+		return http.StatusNotFound, "todo not found"
+	case errors.Is(err, ErrListNotFound):
+		return http.StatusBadRequest, "list not found"
+	case errors.Is(err, ErrInvalidTransition):
+		return http.StatusConflict, err.Error()
+	case errors.Is(err, ErrVersionMismatch):
+		return http.StatusPreconditionFailed, "todo has been modified; fetch it again and retry"
+	default:
+		h.logger.Error("could not apply bulk operation", "op", op.Op, "id", op.ID, "error", err, "request_id", reqctx.GetRequestID(r.Context()))
+		return http.StatusInternalServerError, "could not " + string(op.Op)
+	}
+}
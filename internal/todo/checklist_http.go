@@ -0,0 +1,89 @@
+package todo
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/jplaulau14/go-todo-api/internal/policy"
+	"github.com/jplaulau14/go-todo-api/internal/reqctx"
+)
+
+// writeItemError maps checklist repository errors onto responses.
+func (h *HTTPHandler) writeItemError(w http.ResponseWriter, r *http.Request, err error, action string) {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		writeError(w, r, http.StatusNotFound, "todo not found")
+	case errors.Is(err, ErrItemNotFound):
+		writeError(w, r, http.StatusNotFound, "checklist item not found")
+	default:
+		h.logger.Error("could not "+action+" checklist item", "error", err, "request_id", reqctx.GetRequestID(r.Context()))
+		writeError(w, r, http.StatusInternalServerError, "could not "+action+" checklist item")
+	}
+}
+
+func (h *HTTPHandler) listItems(w http.ResponseWriter, r *http.Request, todoID string) {
+	r, ok := h.authorize(w, r, ResourceTodo, todoID, policy.Read)
+	if !ok {
+		return
+	}
+	items, err := h.items.Items(r.Context(), todoID)
+	if err != nil {
+		h.writeItemError(w, r, err, "list")
+		return
+	}
+	writeJSON(w, http.StatusOK, items)
+}
+
+func (h *HTTPHandler) addItem(w http.ResponseWriter, r *http.Request, todoID string) {
+	r, ok := h.authorize(w, r, ResourceTodo, todoID, policy.Edit)
+	if !ok {
+		return
+	}
+	var req CreateItemRequest
+	if !h.decodeJSON(w, r, &req) {
+		return
+	}
+	if err := req.Validate(); err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	it, err := h.items.AddItem(r.Context(), todoID, req)
+	if err != nil {
+		h.writeItemError(w, r, err, "create")
+		return
+	}
+	writeJSON(w, http.StatusCreated, it)
+}
+
+func (h *HTTPHandler) updateItem(w http.ResponseWriter, r *http.Request, todoID, itemID string) {
+	r, ok := h.authorize(w, r, ResourceTodo, todoID, policy.Edit)
+	if !ok {
+		return
+	}
+	var req UpdateItemRequest
+	if !h.decodeJSON(w, r, &req) {
+		return
+	}
+	if err := req.Validate(); err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	it, err := h.items.UpdateItem(r.Context(), todoID, itemID, req)
+	if err != nil {
+		h.writeItemError(w, r, err, "update")
+		return
+	}
+	writeJSON(w, http.StatusOK, it)
+}
+
+func (h *HTTPHandler) deleteItem(w http.ResponseWriter, r *http.Request, todoID, itemID string) {
+	r, ok := h.authorize(w, r, ResourceTodo, todoID, policy.Edit)
+	if !ok {
+		return
+	}
+	if err := h.items.DeleteItem(r.Context(), todoID, itemID); err != nil {
+		h.writeItemError(w, r, err, "delete")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,143 @@
+package todo
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var ErrItemNotFound = errors.New("checklist item not found")
+
+// ChecklistRepository stores the checklist items of a todo. Operations on
+// a missing todo fail with ErrNotFound. Whenever a change leaves every item
+// of a todo done, the todo itself is marked completed, and the next
+// occurrence of a recurring todo is created in the same write.
+type ChecklistRepository interface {
+	AddItem(ctx context.Context, todoID string, req CreateItemRequest) (ChecklistItem, error)
+	// Items returns a todo's checklist ordered by position.
+	Items(ctx context.Context, todoID string) ([]ChecklistItem, error)
+	UpdateItem(ctx context.Context, todoID, itemID string, update UpdateItemRequest) (ChecklistItem, error)
+	DeleteItem(ctx context.Context, todoID, itemID string) error
+}
+
+func (r *InMemoryRepository) AddItem(ctx context.Context, todoID string, req CreateItemRequest) (ChecklistItem, error) {
+	o := OwnerOf(ctx)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.live(o, todoID); !ok {
+		return ChecklistItem{}, ErrNotFound
+	}
+	items := r.items[todoID]
+	now := time.Now().UTC()
+	it := ChecklistItem{
+		ID:        uuid.NewString(),
+		TodoID:    todoID,
+		Title:     req.Title,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if req.Position != nil {
+		it.Position = *req.Position
+	} else {
+		for _, existing := range items {
+			it.Position = max(it.Position, existing.Position+1)
+		}
+	}
+	r.items[todoID] = append(slices.Clone(items), it)
+	if err := r.checklistChanged(o, todoID, items, now); err != nil {
+		return ChecklistItem{}, err
+	}
+	return it, nil
+}
+
+func (r *InMemoryRepository) Items(ctx context.Context, todoID string) ([]ChecklistItem, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if _, ok := r.live(OwnerOf(ctx), todoID); !ok {
+		return nil, ErrNotFound
+	}
+	items := slices.Clone(r.items[todoID])
+	sortItems(items)
+	if items == nil {
+		items = []ChecklistItem{}
+	}
+	return items, nil
+}
+
+func (r *InMemoryRepository) UpdateItem(ctx context.Context, todoID, itemID string, update UpdateItemRequest) (ChecklistItem, error) {
+	o := OwnerOf(ctx)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.live(o, todoID); !ok {
+		return ChecklistItem{}, ErrNotFound
+	}
+	previous := r.items[todoID]
+	items := slices.Clone(previous)
+	i := slices.IndexFunc(items, func(it ChecklistItem) bool { return it.ID == itemID })
+	if i < 0 {
+		return ChecklistItem{}, ErrItemNotFound
+	}
+	now := time.Now().UTC()
+	update.apply(&items[i])
+	items[i].UpdatedAt = now
+	r.items[todoID] = items
+	if err := r.checklistChanged(o, todoID, previous, now); err != nil {
+		return ChecklistItem{}, err
+	}
+	return items[i], nil
+}
+
+func (r *InMemoryRepository) DeleteItem(ctx context.Context, todoID, itemID string) error {
+	o := OwnerOf(ctx)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.live(o, todoID); !ok {
+		return ErrNotFound
+	}
+	items := r.items[todoID]
+	i := slices.IndexFunc(items, func(it ChecklistItem) bool { return it.ID == itemID })
+	if i < 0 {
+		return ErrItemNotFound
+	}
+	r.items[todoID] = slices.Delete(slices.Clone(items), i, i+1)
+	return r.checklistChanged(o, todoID, items, time.Now().UTC())
+}
+
+// checklistChanged refreshes the stored progress of a todo of o after its
+// checklist changed from previous, completing it once every item is done
+// and creating the next occurrence if that completes a recurring todo. If
+// that fails, the checklist and todo are left as they were. r.mu must be
+// held for writing.
+func (r *InMemoryRepository) checklistChanged(o Owner, todoID string, previous []ChecklistItem, now time.Time) error {
+	current := r.store[todoID]
+	t := current
+	t.Progress = Progress{Total: len(r.items[todoID])}
+	for _, it := range r.items[todoID] {
+		if it.Done {
+			t.Progress.Done++
+		}
+	}
+	if t.Progress.Total > 0 && t.Progress.Done == t.Progress.Total && !t.Completed {
+		t.setStatus(StatusDone, now)
+	}
+	t.touch(now)
+	r.store[todoID] = t
+	if _, err := r.createNext(o, current, t, now); err != nil {
+		r.store[todoID], r.items[todoID] = current, previous
+		return err
+	}
+	return nil
+}
+
+func sortItems(items []ChecklistItem) {
+	sort.SliceStable(items, func(i, j int) bool {
+		if items[i].Position != items[j].Position {
+			return items[i].Position < items[j].Position
+		}
+		return items[i].CreatedAt.Before(items[j].CreatedAt)
+	})
+}
@@ -0,0 +1,55 @@
+package todo
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Cursor marks a position in a listing ordered by (created_at, id). It is
+// handed to clients as an opaque string so the encoding can change later.
+type Cursor struct {
+	CreatedAt time.Time
+	ID        string
+}
+
+func CursorFor(t Todo) Cursor {
+	return Cursor{CreatedAt: t.CreatedAt, ID: t.ID}
+}
+
+func (c Cursor) Encode() string {
+	raw := c.CreatedAt.UTC().Format(time.RFC3339Nano) + "," + c.ID
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func DecodeCursor(s string) (Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+	ts, id, ok := strings.Cut(string(raw), ",")
+	if !ok || id == "" {
+		return Cursor{}, ErrInvalidCursor
+	}
+	created, err := time.Parse(time.RFC3339Nano, ts)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+	return Cursor{CreatedAt: created, ID: id}, nil
+}
+
+// after reports whether t comes strictly after the cursor when walking in
+// the given direction.
+func (c Cursor) after(t Todo, desc bool) bool {
+	cmp := t.CreatedAt.Compare(c.CreatedAt)
+	if cmp == 0 {
+		cmp = strings.Compare(t.ID, c.ID)
+	}
+	if desc {
+		return cmp < 0
+	}
+	return cmp > 0
+}
@@ -0,0 +1,156 @@
+package todo
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// etag is the strong validator for a todo: its version, quoted.
+func etag(t Todo) string {
+	return `"` + strconv.FormatInt(t.Version, 10) + `"`
+}
+
+// setETag sets the ETag header for t.
+func setETag(w http.ResponseWriter, t Todo) {
+	w.Header().Set("ETag", etag(t))
+}
+
+// ifMatch is a parsed If-Match header.
+type ifMatch struct {
+	present  bool
+	any      bool
+	versions []int64
+}
+
+// parseIfMatch reads every If-Match header. Weak and malformed entity tags
+// can never match under the strong comparison If-Match requires, so they
+// are dropped.
+func parseIfMatch(r *http.Request) ifMatch {
+	var m ifMatch
+	for _, line := range r.Header.Values("If-Match") {
+		for _, tag := range strings.Split(line, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "" {
+				continue
+			}
+			m.present = true
+			if tag == "*" {
+				m.any = true
+				continue
+			}
+			if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+				continue
+			}
+			if v, err := strconv.ParseInt(tag[1:len(tag)-1], 10, 64); err == nil && v > 0 {
+				m.versions = append(m.versions, v)
+			}
+		}
+	}
+	return m
+}
+
+// ifMatchVersion resolves If-Match into the version a conditional write
+// must compare against; zero means the write is unconditional. If the
+// header cannot match it writes 412 and returns false.
+func (h *HTTPHandler) ifMatchVersion(w http.ResponseWriter, r *http.Request, id string) (int64, bool) {
+	m := parseIfMatch(r)
+	switch {
+	case !m.present || m.any:
+		return 0, true
+	case len(m.versions) == 1:
+		return m.versions[0], true
+	case len(m.versions) == 0:
+		writePreconditionFailed(w, r)
+		return 0, false
+	}
+	// Several tags: find the one that is current, and still compare-and-swap
+	// against it in case the todo changes in between.
+	t, err := h.repo.Get(r.Context(), id)
+	if err != nil {
+		// Let the write itself report the error.
+		return 0, true
+	}
+	for _, v := range m.versions {
+		if v == t.Version {
+			return v, true
+		}
+	}
+	writePreconditionFailed(w, r)
+	return 0, false
+}
+
+// pageETag derives a strong validator for a listing from everything in the
+// response that can change: the body and the Link header.
+func pageETag(body []byte, header http.Header) string {
+	h := sha256.New()
+	h.Write(body)
+	for _, link := range header.Values("Link") {
+		h.Write([]byte(link))
+	}
+	return `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+}
+
+// notModified evaluates If-None-Match, or failing that If-Modified-Since,
+// against the current validators as RFC 9110 describes for GET.
+func notModified(r *http.Request, tag string, modified time.Time) bool {
+	if values := r.Header.Values("If-None-Match"); len(values) > 0 {
+		for _, line := range values {
+			for _, candidate := range strings.Split(line, ",") {
+				// If-None-Match uses the weak comparison.
+				candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+				if candidate == "*" || candidate == tag {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	if v := r.Header.Get("If-Modified-Since"); v != "" && !modified.IsZero() {
+		since, err := http.ParseTime(v)
+		return err == nil && !modified.Truncate(time.Second).After(since)
+	}
+	return false
+}
+
+// writeConditional writes v as JSON with ETag and Last-Modified headers,
+// or just the headers and 304 Not Modified if the client's copy is current.
+// A zero tag is derived from the encoded response with pageETag. A zero
+// modified time leaves out Last-Modified, as for listings: todos leaving a
+// page do not advance the newest updated_at on it, so only the content hash
+// can tell that the page changed.
+func writeConditional(w http.ResponseWriter, r *http.Request, v any, tag string, modified time.Time) {
+	var body bytes.Buffer
+	if err := json.NewEncoder(&body).Encode(v); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "could not encode response")
+		return
+	}
+	writeRepresentation(w, r, jsonType, body.Bytes(), tag, modified)
+}
+
+// writeRepresentation is writeConditional for an already encoded body.
+func writeRepresentation(w http.ResponseWriter, r *http.Request, contentType string, body []byte, tag string, modified time.Time) {
+	if tag == "" {
+		tag = pageETag(body, w.Header())
+	}
+	w.Header().Set("ETag", tag)
+	if !modified.IsZero() {
+		w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	}
+	if notModified(r, tag, modified) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(body)
+}
+
+func writePreconditionFailed(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, http.StatusPreconditionFailed, "todo has been modified; fetch it again and retry")
+}
@@ -0,0 +1,324 @@
+package todo
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jplaulau14/go-todo-api/internal/reqctx"
+)
+
+const (
+	jsonType   = "application/json"
+	csvType    = "text/csv"
+	ndjsonType = "application/x-ndjson"
+)
+
+// listTypes are the representations of a listing, in order of preference.
+var listTypes = []string{jsonType, csvType, ndjsonType}
+
+// formats maps ?format= values to media types, for clients such as
+// browsers that cannot set Accept.
+var formats = map[string]string{"json": jsonType, "csv": csvType, "ndjson": ndjsonType}
+
+const (
+	// exportPageSize is how many todos an export reads at a time.
+	exportPageSize = 500
+	// exportWriteTimeout is the write deadline granted for each page of an
+	// export, which may take longer than the server's WriteTimeout overall.
+	exportWriteTimeout = 30 * time.Second
+)
+
+var errNotAcceptable = errors.New("acceptable types are " + strings.Join(listTypes, ", "))
+
+// negotiate picks the media type of a listing: ?format= if given,
+// otherwise the type in Accept with the highest quality, preferring
+// earlier listTypes on ties. No Accept header means JSON.
+func negotiate(r *http.Request) (string, error) {
+	if f := r.URL.Query().Get("format"); f != "" {
+		t, ok := formats[f]
+		if !ok {
+			return "", errors.New("format must be one of json, csv, ndjson")
+		}
+		return t, nil
+	}
+	accept := strings.Join(r.Header.Values("Accept"), ",")
+	if strings.TrimSpace(accept) == "" {
+		return jsonType, nil
+	}
+	best, bestQ := "", 0.0
+	for _, t := range listTypes {
+		if q := quality(accept, t); q > bestQ {
+			best, bestQ = t, q
+		}
+	}
+	if best == "" {
+		return "", errNotAcceptable
+	}
+	return best, nil
+}
+
+// quality returns the q-value Accept gives to mediaType, taken from the
+// most specific matching range. Malformed ranges are ignored.
+func quality(accept, mediaType string) float64 {
+	typ, _, _ := strings.Cut(mediaType, "/")
+	q, specificity := 0.0, -1
+	for _, part := range strings.Split(accept, ",") {
+		rng, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		s := -1
+		switch rng {
+		case mediaType:
+			s = 2
+		case typ + "/*":
+			s = 1
+		case "*/*":
+			s = 0
+		}
+		if s <= specificity {
+			continue
+		}
+		specificity, q = s, 1
+		if v, ok := params["q"]; ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil && f >= 0 && f <= 1 {
+				q = f
+			}
+		}
+	}
+	return q
+}
+
+// todoWriter encodes a sequence of todos as a JSON array, NDJSON or CSV.
+// CSV has one column per selected field, so it always goes through a
+// projection; the other types only do when one was asked for.
+type todoWriter struct {
+	h         *HTTPHandler
+	mediaType string
+	p         projection
+	w         io.Writer
+	csv       *csv.Writer
+	n         int
+}
+
+func (h *HTTPHandler) newTodoWriter(w io.Writer, mediaType string, p projection) *todoWriter {
+	tw := &todoWriter{h: h, mediaType: mediaType, p: p, w: w}
+	if mediaType == csvType {
+		if tw.p.fields == nil {
+			tw.p.fields = todoFields
+		}
+		tw.csv = csv.NewWriter(w)
+	}
+	return tw
+}
+
+// begin writes what precedes the first todo.
+func (tw *todoWriter) begin() error {
+	switch tw.mediaType {
+	case csvType:
+		return tw.csv.Write(tw.p.fields)
+	case jsonType:
+		_, err := io.WriteString(tw.w, "[")
+		return err
+	}
+	return nil
+}
+
+// write encodes a page of todos.
+func (tw *todoWriter) write(r *http.Request, todos []Todo) error {
+	docs := make([]any, len(todos))
+	if tw.p.empty() {
+		for i, t := range todos {
+			docs[i] = t
+		}
+	} else {
+		projected, err := tw.h.project(r.Context(), todos, tw.p)
+		if err != nil {
+			return err
+		}
+		for i, doc := range projected {
+			docs[i] = doc
+		}
+	}
+	for _, doc := range docs {
+		if err := tw.encode(doc); err != nil {
+			return err
+		}
+		tw.n++
+	}
+	if tw.csv != nil {
+		tw.csv.Flush()
+		return tw.csv.Error()
+	}
+	return nil
+}
+
+func (tw *todoWriter) encode(doc any) error {
+	if tw.csv != nil {
+		record := make([]string, len(tw.p.fields))
+		for i, f := range tw.p.fields {
+			record[i] = csvCell(doc.(projected)[f])
+		}
+		return tw.csv.Write(record)
+	}
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	switch {
+	case tw.mediaType == ndjsonType:
+		b = append(b, '\n')
+	case tw.n > 0:
+		b = append([]byte(","), b...)
+	}
+	_, err = tw.w.Write(b)
+	return err
+}
+
+// end writes what follows the last todo.
+func (tw *todoWriter) end() error {
+	if tw.mediaType == jsonType {
+		_, err := io.WriteString(tw.w, "]\n")
+		return err
+	}
+	return nil
+}
+
+// csvCell renders a JSON value as a spreadsheet cell: null is empty,
+// strings are unquoted, arrays such as tags are joined with ";" and
+// objects are left as JSON. Text that a spreadsheet would evaluate as a
+// formula is prefixed with a quote.
+func csvCell(raw json.RawMessage) string {
+	var v any
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return string(raw)
+	}
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		if v != "" && strings.ContainsRune("=+-@\t\r", rune(v[0])) {
+			return "'" + v
+		}
+		return v
+	case []any:
+		parts := make([]string, len(v))
+		for i, elem := range v {
+			b, _ := json.Marshal(elem)
+			parts[i] = csvCell(b)
+		}
+		return strings.Join(parts, ";")
+	default:
+		return string(raw)
+	}
+}
+
+// writeListAs writes a page of a listing as CSV or NDJSON, with the same
+// validators as the JSON form.
+func (h *HTTPHandler) writeListAs(w http.ResponseWriter, r *http.Request, mediaType string, p projection, items []Todo) {
+	var body bytes.Buffer
+	tw := h.newTodoWriter(&body, mediaType, p)
+	err := tw.begin()
+	if err == nil {
+		err = tw.write(r, items)
+	}
+	if err == nil {
+		err = tw.end()
+	}
+	if err != nil {
+		h.logger.Error("could not encode todos", "error", err, "request_id", reqctx.GetRequestID(r.Context()))
+		writeError(w, r, http.StatusInternalServerError, "could not list")
+		return
+	}
+	writeRepresentation(w, r, mediaType, body.Bytes(), "", time.Time{})
+}
+
+// export streams every todo matching the listing filters, reading the
+// repository a page at a time. limit is ignored; offset or cursor set
+// where the export starts. Keyset pages are used when sorting by
+// created_at, so concurrent writes cannot make rows repeat or go missing.
+func (h *HTTPHandler) export(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Vary", "Accept")
+	mediaType, err := negotiate(r)
+	if err != nil {
+		writeNegotiationError(w, r, err)
+		return
+	}
+	opts, err := parseListOptions(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	p, err := h.parseProjection(r.URL.Query())
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if mediaType == csvType && len(p.expand) > 0 {
+		writeError(w, r, http.StatusBadRequest, "expand is not available for CSV")
+		return
+	}
+	opts.Limit = exportPageSize
+
+	// The first page is read before anything is written, so that a failure
+	// can still be reported with a status code.
+	page, err := h.repo.List(r.Context(), opts)
+	if err != nil {
+		h.logger.Error("could not export todos", "error", err, "request_id", reqctx.GetRequestID(r.Context()))
+		writeError(w, r, http.StatusInternalServerError, "could not export")
+		return
+	}
+	ext := map[string]string{jsonType: "json", csvType: "csv", ndjsonType: "ndjson"}[mediaType]
+	w.Header().Set("Content-Type", mediaType)
+	w.Header().Set("Content-Disposition", `attachment; filename="todos.`+ext+`"`)
+	w.WriteHeader(http.StatusOK)
+
+	rc := http.NewResponseController(w)
+	tw := h.newTodoWriter(w, mediaType, p)
+	err = tw.begin()
+	for err == nil {
+		_ = rc.SetWriteDeadline(time.Now().Add(exportWriteTimeout))
+		if err = tw.write(r, page); err != nil {
+			break
+		}
+		_ = rc.Flush()
+		if len(page) < opts.Limit {
+			err = tw.end()
+			break
+		}
+		if opts.sort().Keyset() {
+			// The cursor already marks where the last page ended, so an
+			// offset from the request must not skip rows a second time.
+			cur := CursorFor(page[len(page)-1])
+			opts.After = &cur
+			opts.Offset = 0
+		} else {
+			opts.Offset += len(page)
+		}
+		page, err = h.repo.List(r.Context(), opts)
+	}
+	if err != nil {
+		// The status has been sent; abort so the client sees a truncated
+		// response rather than a complete-looking one.
+		h.logger.Error("export interrupted", "error", err, "written", tw.n, "request_id", reqctx.GetRequestID(r.Context()))
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// writeNegotiationError reports an unknown ?format= as a bad request and
+// an Accept header nothing matches as 406.
+func writeNegotiationError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, errNotAcceptable) {
+		writeError(w, r, http.StatusNotAcceptable, err.Error())
+		return
+	}
+	writeError(w, r, http.StatusBadRequest, err.Error())
+}
@@ -0,0 +1,159 @@
+package todo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// todoFields are the members of a todo's JSON document that ?fields= can
+// select.
+var todoFields = []string{
+	"id", "title", "description", "completed", "status", "completed_at", "priority", "due_at", "tags",
+	"list_id", "progress", "recurrence", "recurrence_start", "deleted_at", "created_at", "updated_at", "version",
+}
+
+// Expansions embed related resources in a todo: its checklist items, or
+// the list it belongs to.
+const (
+	expandItems = "items"
+	expandList  = "list"
+)
+
+// projection describes a sparse or expanded rendering of todos. A nil
+// fields slice selects every member.
+type projection struct {
+	fields []string
+	expand []string
+}
+
+func (p projection) empty() bool {
+	return p.fields == nil && len(p.expand) == 0
+}
+
+// projected is a todo rendered through a projection.
+type projected map[string]json.RawMessage
+
+// projectedPage is a Page whose items have been projected; its Items
+// field hides the embedded one when encoded.
+type projectedPage struct {
+	Page
+	Items []projected `json:"items"`
+}
+
+// parseProjection reads ?fields= and ?expand=, both comma-separated or
+// repeated, rejecting names that are not known.
+func (h *HTTPHandler) parseProjection(query map[string][]string) (projection, error) {
+	var (
+		p   projection
+		err error
+	)
+	if values, ok := query["fields"]; ok {
+		if p.fields, err = parseNames(values, todoFields, "field"); err != nil {
+			return projection{}, err
+		}
+		if len(p.fields) == 0 {
+			return projection{}, errors.New("fields cannot be empty")
+		}
+	}
+	var expansions []string
+	if h.items != nil {
+		expansions = append(expansions, expandItems)
+	}
+	if h.lists != nil {
+		expansions = append(expansions, expandList)
+	}
+	if p.expand, err = parseNames(query["expand"], expansions, "expansion"); err != nil {
+		return projection{}, err
+	}
+	return p, nil
+}
+
+// parseNames splits comma-separated values into distinct names, checking
+// each against known.
+func parseNames(values, known []string, kind string) ([]string, error) {
+	names := []string{}
+	for _, v := range values {
+		for _, name := range strings.Split(v, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" || slices.Contains(names, name) {
+				continue
+			}
+			if !slices.Contains(known, name) {
+				return nil, fmt.Errorf("unknown %s %q; must be one of %s", kind, name, strings.Join(known, ", "))
+			}
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// project renders todos through p. Selected members a todo does not have
+// are written as null; expansions are added whatever the field selection.
+func (h *HTTPHandler) project(ctx context.Context, todos []Todo, p projection) ([]projected, error) {
+	lists := map[string]json.RawMessage{}
+	out := make([]projected, len(todos))
+	for i, t := range todos {
+		raw, err := json.Marshal(t)
+		if err != nil {
+			return nil, err
+		}
+		var doc projected
+		if err := json.Unmarshal(raw, &doc); err != nil {
+			return nil, err
+		}
+		if p.fields != nil {
+			sparse := make(projected, len(p.fields)+len(p.expand))
+			for _, f := range p.fields {
+				if v, ok := doc[f]; ok {
+					sparse[f] = v
+				} else {
+					sparse[f] = json.RawMessage("null")
+				}
+			}
+			doc = sparse
+		}
+		for _, e := range p.expand {
+			if doc[e], err = h.expansion(ctx, t, e, lists); err != nil {
+				return nil, err
+			}
+		}
+		out[i] = doc
+	}
+	return out, nil
+}
+
+// expansion encodes one related resource of t. Lists are cached across
+// todos since many usually share one.
+func (h *HTTPHandler) expansion(ctx context.Context, t Todo, name string, lists map[string]json.RawMessage) (json.RawMessage, error) {
+	switch name {
+	case expandItems:
+		items, err := h.items.Items(ctx, t.ID)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(items)
+	case expandList:
+		if t.ListID == nil {
+			return json.RawMessage("null"), nil
+		}
+		if cached, ok := lists[*t.ListID]; ok {
+			return cached, nil
+		}
+		l, err := h.lists.GetList(ctx, *t.ListID)
+		if err != nil {
+			return nil, err
+		}
+		encoded, err := json.Marshal(l)
+		if err != nil {
+			return nil, err
+		}
+		lists[*t.ListID] = encoded
+		return encoded, nil
+	default:
+		return nil, fmt.Errorf("unknown expansion %q", name)
+	}
+}
@@ -0,0 +1,250 @@
+package todo
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/jplaulau14/go-todo-api/internal/policy"
+	"github.com/jplaulau14/go-todo-api/internal/reqctx"
+)
+
+// WithGrants enables sharing: the /todos/{id}/grants, /lists/{id}/grants
+// and /shared routes, and access to todos and lists through grants.
+func (h *HTTPHandler) WithGrants(grants GrantRepository) *HTTPHandler {
+	h.grants = grants
+	return h
+}
+
+// access returns the caller's role on a todo or list and the request to
+// make repository calls for it with. If the todo or list is shared with
+// the caller, that request acts for its owner. Otherwise the caller is
+// taken to own it, and the repository, scoped to the caller, reports
+// whether it exists.
+func (h *HTTPHandler) access(r *http.Request, kind ResourceType, id string) (policy.Role, *http.Request, error) {
+	if h.grants == nil {
+		return policy.Owner, r, nil
+	}
+	grants, err := h.grants.Access(r.Context(), kind, id)
+	if err != nil || len(grants) == 0 {
+		return policy.Owner, r, err
+	}
+	owner := grants[0].owner
+	roles := make([]policy.Role, len(grants))
+	for i, g := range grants {
+		roles[i] = g.Role
+	}
+	role := policy.RoleOf(policy.Subject(callerOf(r.Context())), policy.Subject(owner), roles)
+	return role, r.WithContext(actingFor(r.Context(), owner)), nil
+}
+
+// authorize checks that the caller may perform action on a todo or list,
+// before any repository call is made for it, and returns the request to
+// make those calls with. On failure it writes the error response and
+// returns false.
+func (h *HTTPHandler) authorize(w http.ResponseWriter, r *http.Request, kind ResourceType, id string, action policy.Action) (*http.Request, bool) {
+	role, r, err := h.access(r, kind, id)
+	if err == nil {
+		err = policy.Authorize(role, action)
+	}
+	if err != nil {
+		h.writeAccessError(w, r, kind, err)
+		return r, false
+	}
+	return r, true
+}
+
+// writeAccessError maps a failed access check onto a response. Callers
+// without any role get the same 404 as for a missing todo or list.
+func (h *HTTPHandler) writeAccessError(w http.ResponseWriter, r *http.Request, kind ResourceType, err error) {
+	switch {
+	case errors.Is(err, policy.ErrNoAccess):
+		writeError(w, r, http.StatusNotFound, string(kind)+" not found")
+	case errors.Is(err, policy.ErrDenied):
+		writeError(w, r, http.StatusForbidden, "your role on this "+string(kind)+" does not allow this")
+	default:
+		h.logger.Error("could not check access", "kind", kind, "error", err, "request_id", reqctx.GetRequestID(r.Context()))
+		writeError(w, r, http.StatusInternalServerError, "could not check access")
+	}
+}
+
+// writeGrantError maps grant repository and policy errors onto responses.
+func (h *HTTPHandler) writeGrantError(w http.ResponseWriter, r *http.Request, kind ResourceType, err error, action string) {
+	switch {
+	case errors.Is(err, ErrNotFound), errors.Is(err, ErrListNotFound):
+		writeError(w, r, http.StatusNotFound, string(kind)+" not found")
+	case errors.Is(err, ErrGrantNotFound):
+		writeError(w, r, http.StatusNotFound, "grant not found")
+	case errors.Is(err, ErrGrantExists):
+		writeError(w, r, http.StatusConflict, "subject already has a role; change it with PATCH")
+	case errors.Is(err, policy.ErrNoAccess), errors.Is(err, policy.ErrDenied):
+		h.writeAccessError(w, r, kind, err)
+	default:
+		h.logger.Error("could not "+action+" grant", "kind", kind, "error", err, "request_id", reqctx.GetRequestID(r.Context()))
+		writeError(w, r, http.StatusInternalServerError, "could not "+action+" grant")
+	}
+}
+
+// routeGrants dispatches /todos/{id}/grants and /lists/{id}/grants, with
+// rest holding what follows grants/.
+func (h *HTTPHandler) routeGrants(w http.ResponseWriter, r *http.Request, kind ResourceType, id, rest string) {
+	switch {
+	case rest == "":
+		switch r.Method {
+		case http.MethodGet:
+			h.listGrants(w, r, kind, id)
+		case http.MethodPost:
+			h.createGrant(w, r, kind, id)
+		default:
+			writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	case !strings.Contains(rest, "/"):
+		switch r.Method {
+		case http.MethodPatch:
+			h.updateGrant(w, r, kind, id, rest)
+		case http.MethodDelete:
+			h.deleteGrant(w, r, kind, id, rest)
+		default:
+			writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	default:
+		writeError(w, r, http.StatusNotFound, "route not found")
+	}
+}
+
+func (h *HTTPHandler) listGrants(w http.ResponseWriter, r *http.Request, kind ResourceType, id string) {
+	r, ok := h.authorize(w, r, kind, id, policy.Share)
+	if !ok {
+		return
+	}
+	grants, err := h.grants.Grants(r.Context(), kind, id)
+	if err != nil {
+		h.writeGrantError(w, r, kind, err, "list")
+		return
+	}
+	writeJSON(w, http.StatusOK, grants)
+}
+
+// createGrant shares a todo or list with a subject in the owner's tenant.
+// Callers may not hand out a stronger role than their own.
+func (h *HTTPHandler) createGrant(w http.ResponseWriter, r *http.Request, kind ResourceType, id string) {
+	role, r, err := h.access(r, kind, id)
+	if err != nil {
+		h.writeAccessError(w, r, kind, err)
+		return
+	}
+	var req CreateGrantRequest
+	if !h.decodeJSON(w, r, &req) {
+		return
+	}
+	if err := req.Validate(); err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := policy.CanGrant(role, req.Role); err != nil {
+		h.writeAccessError(w, r, kind, err)
+		return
+	}
+	if req.Subject == OwnerOf(r.Context()).ID {
+		writeError(w, r, http.StatusBadRequest, "cannot share a "+string(kind)+" with its owner")
+		return
+	}
+	g, err := h.grants.CreateGrant(r.Context(), kind, id, req, callerOf(r.Context()).ID)
+	if err != nil {
+		h.writeGrantError(w, r, kind, err, "create")
+		return
+	}
+	w.Header().Set("Location", "/"+string(kind)+"s/"+id+"/grants/"+url.PathEscape(g.Subject))
+	writeJSON(w, http.StatusCreated, g)
+}
+
+// grant returns the grant of subject on a todo or list.
+func (h *HTTPHandler) grant(r *http.Request, kind ResourceType, id, subject string) (Grant, error) {
+	grants, err := h.grants.Grants(r.Context(), kind, id)
+	if err != nil {
+		return Grant{}, err
+	}
+	for _, g := range grants {
+		if g.Subject == subject {
+			return g, nil
+		}
+	}
+	return Grant{}, ErrGrantNotFound
+}
+
+func (h *HTTPHandler) updateGrant(w http.ResponseWriter, r *http.Request, kind ResourceType, id, subject string) {
+	role, r, err := h.access(r, kind, id)
+	if err == nil {
+		err = policy.Authorize(role, policy.Share)
+	}
+	if err != nil {
+		h.writeAccessError(w, r, kind, err)
+		return
+	}
+	var req UpdateGrantRequest
+	if !h.decodeJSON(w, r, &req) {
+		return
+	}
+	if err := req.Validate(); err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	current, err := h.grant(r, kind, id, subject)
+	if err == nil {
+		err = policy.CanChange(role, current.Role, req.Role)
+	}
+	if err != nil {
+		h.writeGrantError(w, r, kind, err, "update")
+		return
+	}
+	g, err := h.grants.UpdateGrant(r.Context(), kind, id, subject, req.Role)
+	if err != nil {
+		h.writeGrantError(w, r, kind, err, "update")
+		return
+	}
+	writeJSON(w, http.StatusOK, g)
+}
+
+// deleteGrant revokes a grant. Subjects may revoke their own grant to stop
+// seeing a todo or list; anyone else needs to be allowed to share it.
+func (h *HTTPHandler) deleteGrant(w http.ResponseWriter, r *http.Request, kind ResourceType, id, subject string) {
+	role, r, err := h.access(r, kind, id)
+	own := subject == callerOf(r.Context()).ID
+	if err == nil && !own {
+		err = policy.Authorize(role, policy.Share)
+	}
+	if err != nil {
+		h.writeAccessError(w, r, kind, err)
+		return
+	}
+	current, err := h.grant(r, kind, id, subject)
+	if err == nil {
+		err = policy.CanRevoke(role, current.Role, own)
+	}
+	if err == nil {
+		err = h.grants.DeleteGrant(r.Context(), kind, id, subject)
+	}
+	if err != nil {
+		h.writeGrantError(w, r, kind, err, "delete")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// listShared serves GET /shared: the grants the caller holds on other
+// owners' todos and lists, newest first.
+func (h *HTTPHandler) listShared(w http.ResponseWriter, r *http.Request) {
+	opts, err := parseListOptions(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	grants, err := h.grants.Shared(r.Context(), opts.Limit, opts.Offset)
+	if err != nil {
+		h.logger.Error("could not list shared", "error", err, "request_id", reqctx.GetRequestID(r.Context()))
+		writeError(w, r, http.StatusInternalServerError, "could not list shared")
+		return
+	}
+	writeJSON(w, http.StatusOK, grants)
+}
@@ -0,0 +1,182 @@
+package todo
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/jplaulau14/go-todo-api/internal/policy"
+)
+
+var (
+	ErrGrantNotFound = errors.New("grant not found")
+	ErrGrantExists   = errors.New("grant already exists")
+)
+
+// GrantRepository stores who todos and lists are shared with. Like
+// ListRepository, both implementations live on the same types as
+// Repository, so that grants go away with what they share.
+type GrantRepository interface {
+	// Grants returns the grants on a todo or list of the owner of ctx,
+	// oldest first. It fails with ErrNotFound or ErrListNotFound if there
+	// is no such todo or list.
+	Grants(ctx context.Context, kind ResourceType, id string) ([]Grant, error)
+	// CreateGrant shares a todo or list of the owner of ctx. It fails with
+	// ErrGrantExists if the subject already has a role on it.
+	CreateGrant(ctx context.Context, kind ResourceType, id string, req CreateGrantRequest, grantedBy string) (Grant, error)
+	UpdateGrant(ctx context.Context, kind ResourceType, id, subject string, role policy.Role) (Grant, error)
+	DeleteGrant(ctx context.Context, kind ResourceType, id, subject string) error
+
+	// Access returns the grants the caller of ctx holds on a todo or list,
+	// whoever ctx acts for: those on it and, for a todo, those on its list.
+	// It returns none if the todo or list does not exist.
+	Access(ctx context.Context, kind ResourceType, id string) ([]Grant, error)
+	// Shared returns the grants the caller of ctx holds, newest first.
+	Shared(ctx context.Context, limit, offset int) ([]Grant, error)
+}
+
+type grantKey struct {
+	kind    ResourceType
+	id      string
+	subject string
+}
+
+// shared checks that o owns the todo or list a grant is about. The caller
+// must hold r.mu.
+func (r *InMemoryRepository) shared(o Owner, kind ResourceType, id string) error {
+	if kind == ResourceList {
+		if _, ok := r.list(o, id); !ok {
+			return ErrListNotFound
+		}
+		return nil
+	}
+	if _, ok := r.live(o, id); !ok {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *InMemoryRepository) Grants(ctx context.Context, kind ResourceType, id string) ([]Grant, error) {
+	o := OwnerOf(ctx)
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if err := r.shared(o, kind, id); err != nil {
+		return nil, err
+	}
+	out := []Grant{}
+	for k, g := range r.grants {
+		if k.kind == kind && k.id == id && g.owner == o {
+			out = append(out, g)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].CreatedAt.Equal(out[j].CreatedAt) {
+			return out[i].CreatedAt.Before(out[j].CreatedAt)
+		}
+		return out[i].Subject < out[j].Subject
+	})
+	return out, nil
+}
+
+func (r *InMemoryRepository) CreateGrant(ctx context.Context, kind ResourceType, id string, req CreateGrantRequest, grantedBy string) (Grant, error) {
+	o := OwnerOf(ctx)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.shared(o, kind, id); err != nil {
+		return Grant{}, err
+	}
+	k := grantKey{kind: kind, id: id, subject: req.Subject}
+	if _, ok := r.grants[k]; ok {
+		return Grant{}, ErrGrantExists
+	}
+	now := time.Now().UTC()
+	g := Grant{
+		ResourceType: kind,
+		ResourceID:   id,
+		Subject:      req.Subject,
+		Role:         req.Role,
+		GrantedBy:    grantedBy,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+		owner:        o,
+	}
+	r.grants[k] = g
+	return g, nil
+}
+
+func (r *InMemoryRepository) UpdateGrant(ctx context.Context, kind ResourceType, id, subject string, role policy.Role) (Grant, error) {
+	o := OwnerOf(ctx)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	k := grantKey{kind: kind, id: id, subject: subject}
+	g, ok := r.grants[k]
+	if !ok || g.owner != o {
+		return Grant{}, ErrGrantNotFound
+	}
+	g.Role, g.UpdatedAt = role, time.Now().UTC()
+	r.grants[k] = g
+	return g, nil
+}
+
+func (r *InMemoryRepository) DeleteGrant(ctx context.Context, kind ResourceType, id, subject string) error {
+	o := OwnerOf(ctx)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	k := grantKey{kind: kind, id: id, subject: subject}
+	if g, ok := r.grants[k]; !ok || g.owner != o {
+		return ErrGrantNotFound
+	}
+	delete(r.grants, k)
+	return nil
+}
+
+func (r *InMemoryRepository) Access(ctx context.Context, kind ResourceType, id string) ([]Grant, error) {
+	c := callerOf(ctx)
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var out []Grant
+	if g, ok := r.grants[grantKey{kind: kind, id: id, subject: c.ID}]; ok && g.owner.TenantID == c.TenantID {
+		out = append(out, g)
+	}
+	if t, ok := r.store[id]; ok && kind == ResourceTodo && t.ListID != nil {
+		if g, ok := r.grants[grantKey{kind: ResourceList, id: *t.ListID, subject: c.ID}]; ok && g.owner == t.owner && g.owner.TenantID == c.TenantID {
+			out = append(out, g)
+		}
+	}
+	return out, nil
+}
+
+func (r *InMemoryRepository) Shared(ctx context.Context, limit, offset int) ([]Grant, error) {
+	c := callerOf(ctx)
+	r.mu.RLock()
+	all := []Grant{}
+	for _, g := range r.grants {
+		if g.Subject == c.ID && g.owner.TenantID == c.TenantID {
+			all = append(all, g)
+		}
+	}
+	r.mu.RUnlock()
+
+	sort.Slice(all, func(i, j int) bool {
+		if !all[i].CreatedAt.Equal(all[j].CreatedAt) {
+			return all[i].CreatedAt.After(all[j].CreatedAt)
+		}
+		if all[i].ResourceType != all[j].ResourceType {
+			return all[i].ResourceType < all[j].ResourceType
+		}
+		return all[i].ResourceID < all[j].ResourceID
+	})
+	return paginate(all, limit, offset), nil
+}
+
+// dropGrants revokes every grant on a todo or list that is going away, so
+// that a todo later created under the same id does not inherit them. The
+// caller must hold r.mu for writing.
+func (r *InMemoryRepository) dropGrants(kind ResourceType, id string) {
+	for k := range r.grants {
+		if k.kind == kind && k.id == id {
+			delete(r.grants, k)
+		}
+	}
+}
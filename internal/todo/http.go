@@ -4,20 +4,33 @@ import (
 	"encoding/json"
 	"errors"
 	"log/slog"
+	"mime"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/jplaulau14/go-todo-api/internal/idempotency"
+	"github.com/jplaulau14/go-todo-api/internal/policy"
 	"github.com/jplaulau14/go-todo-api/internal/reqctx"
 )
 
 type HTTPHandler struct {
 	repo   Repository
+	lists  ListRepository
+	items  ChecklistRepository
+	grants GrantRepository
 	logger *slog.Logger
+
+	idem    idempotency.Store
+	idemTTL time.Duration
+
+	importMaxBytes int64
 }
 
 func NewHTTPHandler(repo Repository) *HTTPHandler {
-	return &HTTPHandler{repo: repo, logger: slog.Default()}
+	return &HTTPHandler{repo: repo, logger: slog.Default(), importMaxBytes: DefaultImportMaxBytes}
 }
 
 func (h *HTTPHandler) WithLogger(logger *slog.Logger) *HTTPHandler {
@@ -28,6 +41,26 @@ func (h *HTTPHandler) WithLogger(logger *slog.Logger) *HTTPHandler {
 	return h
 }
 
+// WithImportLimit sets the largest body POST /todos/import accepts.
+func (h *HTTPHandler) WithImportLimit(maxBytes int64) *HTTPHandler {
+	if maxBytes > 0 {
+		h.importMaxBytes = maxBytes
+	}
+	return h
+}
+
+// WithLists enables the /lists routes backed by lists.
+func (h *HTTPHandler) WithLists(lists ListRepository) *HTTPHandler {
+	h.lists = lists
+	return h
+}
+
+// WithChecklists enables the /todos/{id}/items routes backed by items.
+func (h *HTTPHandler) WithChecklists(items ChecklistRepository) *HTTPHandler {
+	h.items = items
+	return h
+}
+
 func (h *HTTPHandler) RegisterRoutes(mux *http.ServeMux) {
 	mux.HandleFunc("/todos", func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.Path != "/todos" {
@@ -39,7 +72,7 @@ func (h *HTTPHandler) RegisterRoutes(mux *http.ServeMux) {
 			h.list(w, r)
 			return
 		case http.MethodPost:
-			h.create(w, r)
+			h.idempotent(w, r, h.create)
 			return
 		default:
 			writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
@@ -54,7 +87,7 @@ func (h *HTTPHandler) RegisterRoutes(mux *http.ServeMux) {
 				h.list(w, r)
 				return
 			case http.MethodPost:
-				h.create(w, r)
+				h.idempotent(w, r, h.create)
 				return
 			default:
 				writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
@@ -62,11 +95,35 @@ func (h *HTTPHandler) RegisterRoutes(mux *http.ServeMux) {
 			}
 		}
 
-		id := strings.TrimSuffix(path, "/")
+		if strings.TrimSuffix(path, "/") == "archive" && r.Method == http.MethodPost {
+			h.archiveCompleted(w, r)
+			return
+		}
+		if strings.TrimSuffix(path, "/") == "bulk" && r.Method == http.MethodPost {
+			h.idempotent(w, r, h.bulk)
+			return
+		}
+		if strings.TrimSuffix(path, "/") == "import" && r.Method == http.MethodPost {
+			h.importTodos(w, r)
+			return
+		}
+		if strings.TrimSuffix(path, "/") == "export" && r.Method == http.MethodGet {
+			h.export(w, r)
+			return
+		}
+
+		id, sub, _ := strings.Cut(strings.TrimSuffix(path, "/"), "/")
+		if sub != "" {
+			h.routeSubresource(w, r, id, sub)
+			return
+		}
 		switch r.Method {
 		case http.MethodGet:
 			h.get(w, r, id)
 			return
+		case http.MethodPut:
+			h.put(w, r, id)
+			return
 		case http.MethodPatch:
 			h.update(w, r, id)
 			return
@@ -78,6 +135,92 @@ func (h *HTTPHandler) RegisterRoutes(mux *http.ServeMux) {
 			return
 		}
 	})
+	mux.HandleFunc("/tags", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		h.listTags(w, r)
+	})
+	mux.HandleFunc("/trash", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		h.trash(w, r)
+	})
+	mux.HandleFunc("/search", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		h.search(w, r)
+	})
+	if h.lists != nil {
+		h.registerListRoutes(mux)
+	}
+	if h.grants != nil {
+		mux.HandleFunc("/shared", func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet {
+				writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+				return
+			}
+			h.listShared(w, r)
+		})
+	}
+}
+
+// routeSubresource dispatches /todos/{id}/{sub...}.
+func (h *HTTPHandler) routeSubresource(w http.ResponseWriter, r *http.Request, id, sub string) {
+	name, rest, _ := strings.Cut(sub, "/")
+	switch {
+	case name == "tags" && rest == "":
+		if r.Method != http.MethodPost {
+			writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		h.addTags(w, r, id)
+	case name == "tags" && !strings.Contains(rest, "/"):
+		if r.Method != http.MethodDelete {
+			writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		h.removeTag(w, r, id, rest)
+	case name == "archive" && rest == "":
+		if r.Method != http.MethodPost {
+			writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		h.archive(w, r, id)
+	case name == "restore" && rest == "":
+		if r.Method != http.MethodPost {
+			writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		h.restore(w, r, id)
+	case name == "items" && h.items != nil && rest == "":
+		switch r.Method {
+		case http.MethodGet:
+			h.listItems(w, r, id)
+		case http.MethodPost:
+			h.addItem(w, r, id)
+		default:
+			writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	case name == "items" && h.items != nil && !strings.Contains(rest, "/"):
+		switch r.Method {
+		case http.MethodPatch:
+			h.updateItem(w, r, id, rest)
+		case http.MethodDelete:
+			h.deleteItem(w, r, id, rest)
+		default:
+			writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	case name == "grants" && h.grants != nil:
+		h.routeGrants(w, r, ResourceTodo, id, rest)
+	default:
+		writeError(w, r, http.StatusNotFound, "route not found")
+	}
 }
 
 func writeJSON(w http.ResponseWriter, status int, v any) {
@@ -106,13 +249,18 @@ func writeError(w http.ResponseWriter, r *http.Request, status int, message stri
 }
 
 func isJSON(r *http.Request) bool {
-	ct := r.Header.Get("Content-Type")
-	if ct == "" {
-		return false
-	}
 	// Accept application/json and application/json; charset=UTF-8
-	ct = strings.ToLower(ct)
-	return strings.HasPrefix(ct, "application/json")
+	return mediaType(r) == "application/json"
+}
+
+// mediaType returns the request's Content-Type without parameters,
+// lowercased, or "" if it is missing or malformed.
+func mediaType(r *http.Request) string {
+	mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return ""
+	}
+	return mt
 }
 
 func statusToCode(status int) (string, string) {
@@ -127,10 +275,18 @@ func statusToCode(status int) (string, string) {
 		return "not_found", http.StatusText(status)
 	case http.StatusMethodNotAllowed:
 		return "method_not_allowed", http.StatusText(status)
+	case http.StatusConflict:
+		return "conflict", http.StatusText(status)
+	case http.StatusPreconditionFailed:
+		return "precondition_failed", http.StatusText(status)
+	case http.StatusUnprocessableEntity:
+		return "unprocessable_entity", http.StatusText(status)
 	case http.StatusUnsupportedMediaType:
 		return "unsupported_media_type", http.StatusText(status)
 	case http.StatusRequestEntityTooLarge:
 		return "request_entity_too_large", http.StatusText(status)
+	case http.StatusFailedDependency:
+		return "failed_dependency", http.StatusText(status)
 	case http.StatusInternalServerError:
 		return "internal", http.StatusText(status)
 	default:
@@ -138,71 +294,350 @@ func statusToCode(status int) (string, string) {
 	}
 }
 
-func (h *HTTPHandler) create(w http.ResponseWriter, r *http.Request) {
+// decodeJSON enforces a JSON content type and the 1MB body limit, then
+// decodes the body into v rejecting unknown fields. On failure it writes
+// the error response and returns false.
+func (h *HTTPHandler) decodeJSON(w http.ResponseWriter, r *http.Request, v any) bool {
 	if !isJSON(r) {
 		writeError(w, r, http.StatusUnsupportedMediaType, "content-type must be application/json")
-		return
+		return false
 	}
 	r.Body = http.MaxBytesReader(w, r.Body, 1<<20)
-	var req CreateTodoRequest
 	dec := json.NewDecoder(r.Body)
 	dec.DisallowUnknownFields()
-	if err := dec.Decode(&req); err != nil {
+	if err := dec.Decode(v); err != nil {
 		if strings.Contains(err.Error(), "request body too large") {
 			writeError(w, r, http.StatusRequestEntityTooLarge, "request body too large")
-			return
+			return false
 		}
 		h.logger.Warn("invalid json", "error", err, "request_id", reqctx.GetRequestID(r.Context()))
 		writeError(w, r, http.StatusBadRequest, "invalid json")
+		return false
+	}
+	return true
+}
+
+func (h *HTTPHandler) create(w http.ResponseWriter, r *http.Request) {
+	var req CreateTodoRequest
+	if !h.decodeJSON(w, r, &req) {
 		return
 	}
-	if req.Title == "" {
-		writeError(w, r, http.StatusBadRequest, "title is required")
+	if err := req.Validate(); err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
 		return
 	}
-	t, err := h.repo.Create(r.Context(), req.Title)
+	// Todos added to a shared list belong to the list's owner.
+	if req.ListID != nil {
+		var ok bool
+		if r, ok = h.authorize(w, r, ResourceList, *req.ListID, policy.Edit); !ok {
+			return
+		}
+	}
+	t, err := h.repo.Create(r.Context(), req)
 	if err != nil {
+		if errors.Is(err, ErrListNotFound) {
+			writeError(w, r, http.StatusBadRequest, "list not found")
+			return
+		}
 		h.logger.Error("could not create todo", "error", err, "request_id", reqctx.GetRequestID(r.Context()))
 		writeError(w, r, http.StatusInternalServerError, "could not create")
 		return
 	}
+	setETag(w, t)
 	writeJSON(w, http.StatusCreated, t)
 }
 
 func (h *HTTPHandler) list(w http.ResponseWriter, r *http.Request) {
-	// Parse pagination params with sane defaults and caps
+	opts, err := parseListOptions(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	h.writeList(w, r, opts)
+}
+
+// writeList runs a listing and writes it as a bare array or, with
+// ?envelope=true, as a Page. Items are projected by ?fields= and ?expand=.
+// CSV and NDJSON can be negotiated instead of JSON, without the envelope.
+func (h *HTTPHandler) writeList(w http.ResponseWriter, r *http.Request, opts ListOptions) {
+	w.Header().Add("Vary", "Accept")
+	mediaType, err := negotiate(r)
+	if err != nil {
+		writeNegotiationError(w, r, err)
+		return
+	}
+	envelope := false
+	if v := r.URL.Query().Get("envelope"); v != "" {
+		if envelope, err = strconv.ParseBool(v); err != nil {
+			writeError(w, r, http.StatusBadRequest, "envelope must be true or false")
+			return
+		}
+	}
+	if envelope && mediaType != jsonType {
+		writeError(w, r, http.StatusBadRequest, "envelope is only available for JSON")
+		return
+	}
+	p, err := h.parseProjection(r.URL.Query())
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if mediaType == csvType && len(p.expand) > 0 {
+		writeError(w, r, http.StatusBadRequest, "expand is not available for CSV")
+		return
+	}
+
+	// Fetch one extra row to learn whether another page exists.
+	limit := opts.Limit
+	opts.Limit++
+	items, err := h.repo.List(r.Context(), opts)
+	opts.Limit = limit
+	if err != nil {
+		h.logger.Error("could not list todos", "error", err, "request_id", reqctx.GetRequestID(r.Context()))
+		writeError(w, r, http.StatusInternalServerError, "could not list")
+		return
+	}
+	more := len(items) > limit
+	if more {
+		items = items[:limit]
+	}
+	if items == nil {
+		items = []Todo{}
+	}
+
+	next, prev := pageLinks(r, opts, items, more)
+	if next != nil {
+		w.Header().Add("Link", `<`+*next+`>; rel="next"`)
+	}
+	if prev != nil {
+		w.Header().Add("Link", `<`+*prev+`>; rel="prev"`)
+	}
+	if mediaType != jsonType {
+		h.writeListAs(w, r, mediaType, p, items)
+		return
+	}
+
+	var docs []projected
+	if !p.empty() {
+		if docs, err = h.project(r.Context(), items, p); err != nil {
+			h.logger.Error("could not expand todos", "error", err, "request_id", reqctx.GetRequestID(r.Context()))
+			writeError(w, r, http.StatusInternalServerError, "could not list")
+			return
+		}
+	}
+	if !envelope {
+		if docs != nil {
+			writeConditional(w, r, docs, "", time.Time{})
+			return
+		}
+		writeConditional(w, r, items, "", time.Time{})
+		return
+	}
+
+	total, err := h.repo.Count(r.Context(), opts)
+	if err != nil {
+		h.logger.Error("could not count todos", "error", err, "request_id", reqctx.GetRequestID(r.Context()))
+		writeError(w, r, http.StatusInternalServerError, "could not list")
+		return
+	}
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	page := Page{
+		Items:  items,
+		Total:  total,
+		Limit:  opts.Limit,
+		Offset: opts.Offset,
+		Next:   next,
+		Prev:   prev,
+	}
+	if docs != nil {
+		writeConditional(w, r, projectedPage{Page: page, Items: docs}, "", time.Time{})
+		return
+	}
+	writeConditional(w, r, page, "", time.Time{})
+}
+
+// pageLinks builds the next and previous page URLs. Orders that support
+// keyset pagination link forward by cursor; everything else uses offsets.
+// Cursor pages have no previous link since the walk is forward-only.
+func pageLinks(r *http.Request, opts ListOptions, items []Todo, more bool) (next, prev *string) {
+	if more {
+		var u string
+		if opts.sort().Keyset() {
+			cursor := CursorFor(items[len(items)-1]).Encode()
+			u = pageURL(r, func(q url.Values) {
+				q.Del("offset")
+				q.Set("cursor", cursor)
+			})
+		} else {
+			u = pageURL(r, func(q url.Values) {
+				q.Set("offset", strconv.Itoa(opts.Offset+opts.Limit))
+			})
+		}
+		next = &u
+	}
+	if opts.After == nil && opts.Offset > 0 {
+		u := pageURL(r, func(q url.Values) {
+			q.Set("offset", strconv.Itoa(max(opts.Offset-opts.Limit, 0)))
+		})
+		prev = &u
+	}
+	return next, prev
+}
+
+// pageURL returns the request path and query with edit applied, for use
+// as a relative target in a Link header.
+func pageURL(r *http.Request, edit func(url.Values)) string {
+	q := r.URL.Query()
+	edit(q)
+	return r.URL.Path + "?" + q.Encode()
+}
+
+// parseListOptions reads pagination, filter and sort query parameters.
+// Pagination params are clamped to sane defaults; malformed filters are
+// rejected so that a typo does not silently return unfiltered data.
+func parseListOptions(r *http.Request) (ListOptions, error) {
+	q := r.URL.Query()
+
 	// Defaults: limit=20, offset=0. Cap limit at 100, min 1.
-	limit := 20
-	offset := 0
-	if l := r.URL.Query().Get("limit"); l != "" {
+	opts := ListOptions{Limit: 20}
+	if l := q.Get("limit"); l != "" {
 		if n, err := strconv.Atoi(l); err == nil {
-			limit = n
+			opts.Limit = n
 		}
 	}
-	if o := r.URL.Query().Get("offset"); o != "" {
+	if o := q.Get("offset"); o != "" {
 		if n, err := strconv.Atoi(o); err == nil {
-			offset = n
+			opts.Offset = n
 		}
 	}
-	if limit < 1 {
-		limit = 1
-	} else if limit > 100 {
-		limit = 100
+	if opts.Limit < 1 {
+		opts.Limit = 1
+	} else if opts.Limit > 100 {
+		opts.Limit = 100
 	}
-	if offset < 0 {
-		offset = 0
+	if opts.Offset < 0 {
+		opts.Offset = 0
 	}
 
-	items, err := h.repo.List(r.Context(), limit, offset)
+	if v := q.Get("completed"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return ListOptions{}, errors.New("completed must be true or false")
+		}
+		opts.Completed = &b
+	}
+	if v := q.Get("status"); v != "" {
+		s := Status(v)
+		if !s.Valid() {
+			return ListOptions{}, errors.New("status must be one of open, in_progress, done, archived")
+		}
+		opts.Status = &s
+	}
+	if v := q.Get("include_archived"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return ListOptions{}, errors.New("include_archived must be true or false")
+		}
+		opts.IncludeArchived = b
+	}
+	var err error
+	if opts.CreatedAfter, err = parseTimeParam(q, "created_after"); err != nil {
+		return ListOptions{}, err
+	}
+	if opts.CreatedBefore, err = parseTimeParam(q, "created_before"); err != nil {
+		return ListOptions{}, err
+	}
+	if opts.UpdatedSince, err = parseTimeParam(q, "updated_since"); err != nil {
+		return ListOptions{}, err
+	}
+	if v := q.Get("list_id"); v != "" {
+		opts.ListID = &v
+	}
+	if tags := q["tag"]; len(tags) > 0 {
+		if opts.Tags, err = NormalizeTags(tags); err != nil {
+			return ListOptions{}, err
+		}
+		switch m := TagMatch(q.Get("tag_match")); m {
+		case "":
+			opts.TagMatch = TagMatchAll
+		case TagMatchAll, TagMatchAny:
+			opts.TagMatch = m
+		default:
+			return ListOptions{}, errors.New("tag_match must be any or all")
+		}
+	}
+	if v := q.Get("priority"); v != "" {
+		p := Priority(v)
+		if !p.Valid() {
+			return ListOptions{}, errors.New("priority must be one of low, normal, high, urgent")
+		}
+		opts.Priority = &p
+	}
+	if v := q.Get("overdue"); v != "" {
+		if opts.Overdue, err = strconv.ParseBool(v); err != nil {
+			return ListOptions{}, errors.New("overdue must be true or false")
+		}
+	}
+	if opts.DueAfter, err = parseTimeParam(q, "due_after"); err != nil {
+		return ListOptions{}, err
+	}
+	if opts.DueBefore, err = parseTimeParam(q, "due_before"); err != nil {
+		return ListOptions{}, err
+	}
+	// due_within=48h is shorthand for a window starting now.
+	if v := q.Get("due_within"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return ListOptions{}, errors.New("due_within must be a positive duration such as 48h")
+		}
+		if opts.DueAfter != nil || opts.DueBefore != nil {
+			return ListOptions{}, errors.New("due_within cannot be combined with due_after or due_before")
+		}
+		now := time.Now().UTC()
+		end := now.Add(d)
+		opts.DueAfter, opts.DueBefore = &now, &end
+	}
+	if opts.Sort, err = ParseSort(q.Get("sort")); err != nil {
+		return ListOptions{}, errors.New("sort must be one of created_at, updated_at, title, due_at, optionally prefixed with -")
+	}
+	if c := q.Get("cursor"); c != "" {
+		if q.Get("offset") != "" {
+			return ListOptions{}, errors.New("cursor cannot be combined with offset")
+		}
+		if !opts.Sort.Keyset() {
+			return ListOptions{}, errors.New("cursor requires sort=created_at or sort=-created_at")
+		}
+		cur, err := DecodeCursor(c)
+		if err != nil {
+			return ListOptions{}, errors.New("invalid cursor")
+		}
+		opts.After = &cur
+	}
+	return opts, nil
+}
+
+func parseTimeParam(q url.Values, name string) (*time.Time, error) {
+	v := q.Get(name)
+	if v == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
 	if err != nil {
-		h.logger.Error("could not list todos", "error", err, "request_id", reqctx.GetRequestID(r.Context()))
-		writeError(w, r, http.StatusInternalServerError, "could not list")
-		return
+		return nil, errors.New(name + " must be an RFC 3339 timestamp")
 	}
-	writeJSON(w, http.StatusOK, items)
+	return &t, nil
 }
 
 func (h *HTTPHandler) get(w http.ResponseWriter, r *http.Request, id string) {
+	r, ok := h.authorize(w, r, ResourceTodo, id, policy.Read)
+	if !ok {
+		return
+	}
+	p, err := h.parseProjection(r.URL.Query())
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
 	t, err := h.repo.Get(r.Context(), id)
 	if err != nil {
 		if errors.Is(err, ErrNotFound) {
@@ -213,49 +648,377 @@ func (h *HTTPHandler) get(w http.ResponseWriter, r *http.Request, id string) {
 		writeError(w, r, http.StatusInternalServerError, "could not get")
 		return
 	}
-	writeJSON(w, http.StatusOK, t)
+	if p.empty() {
+		writeConditional(w, r, t, etag(t), t.UpdatedAt)
+		return
+	}
+	// A projection is a different representation of the same version, so
+	// it gets its own entity tag. Expansions embed resources whose changes
+	// do not advance the todo's updated_at, so they go without
+	// Last-Modified and validate by the tag alone.
+	docs, err := h.project(r.Context(), []Todo{t}, p)
+	if err != nil {
+		h.logger.Error("could not expand todo", "id", id, "error", err, "request_id", reqctx.GetRequestID(r.Context()))
+		writeError(w, r, http.StatusInternalServerError, "could not get")
+		return
+	}
+	modified := t.UpdatedAt
+	if len(p.expand) > 0 {
+		modified = time.Time{}
+	}
+	writeConditional(w, r, docs[0], "", modified)
 }
 
+// update applies a partial update given as plain JSON, a JSON Merge Patch
+// or a JSON Patch.
 func (h *HTTPHandler) update(w http.ResponseWriter, r *http.Request, id string) {
-	if !isJSON(r) {
-		writeError(w, r, http.StatusUnsupportedMediaType, "content-type must be application/json")
+	r, ok := h.authorize(w, r, ResourceTodo, id, policy.Edit)
+	if !ok {
+		return
+	}
+	w.Header().Set("Accept-Patch", acceptPatch)
+	if mt := mediaType(r); mt == mergePatchType || mt == jsonPatchType {
+		h.patch(w, r, id)
 		return
 	}
-	r.Body = http.MaxBytesReader(w, r.Body, 1<<20)
 	var req UpdateTodoRequest
-	dec := json.NewDecoder(r.Body)
-	dec.DisallowUnknownFields()
-	if err := dec.Decode(&req); err != nil {
-		if strings.Contains(err.Error(), "request body too large") {
-			writeError(w, r, http.StatusRequestEntityTooLarge, "request body too large")
-			return
+	if !h.decodeJSON(w, r, &req) {
+		return
+	}
+	if err := req.Validate(); err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	ifVersion, ok := h.ifMatchVersion(w, r, id)
+	if !ok {
+		return
+	}
+	updated, err := h.repo.Update(r.Context(), id, req, ifVersion)
+	if err != nil {
+		h.writeUpdateError(w, r, id, err)
+		return
+	}
+	setETag(w, updated)
+	writeJSON(w, http.StatusOK, updated)
+}
+
+// reservedIDs are path segments under /todos/ that name routes rather than
+// todos, so clients cannot choose them as ids.
+var reservedIDs = []string{"archive", "bulk", "export", "import", "search"}
+
+const maxTodoIDLength = 128
+
+// validateTodoID checks a client-chosen id: up to 128 letters, digits,
+// hyphens and underscores, and not a reserved route name.
+func validateTodoID(id string) error {
+	if id == "" || len(id) > maxTodoIDLength {
+		return errors.New("id must be 1 to 128 characters")
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return errors.New("id may only contain letters, digits, hyphens and underscores")
 		}
-		h.logger.Warn("invalid json", "error", err, "request_id", reqctx.GetRequestID(r.Context()))
-		writeError(w, r, http.StatusBadRequest, "invalid json")
+	}
+	for _, reserved := range reservedIDs {
+		if strings.EqualFold(id, reserved) {
+			return errors.New("id " + reserved + " is reserved")
+		}
+	}
+	return nil
+}
+
+// idUnavailable explains why a client-chosen id cannot be used. The same
+// words cover an id in the caller's trash and one another owner uses, so
+// that callers cannot find out which ids other owners have.
+const idUnavailable = "id is not available; if a todo of yours in the trash has it, restore or purge that first"
+
+// put replaces a todo with the request body, or creates it under the
+// client's id if there is no such todo, answering 200 or 201. Fields left
+// out of the body are reset to their defaults. An id the caller cannot use
+// gets 409, whoever holds it.
+func (h *HTTPHandler) put(w http.ResponseWriter, r *http.Request, id string) {
+	if err := validateTodoID(id); err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	role, r, err := h.access(r, ResourceTodo, id)
+	if err == nil {
+		err = policy.Authorize(role, policy.Edit)
+	}
+	switch {
+	case errors.Is(err, policy.ErrNoAccess):
+		writeError(w, r, http.StatusConflict, idUnavailable)
+		return
+	case err != nil:
+		h.writeAccessError(w, r, ResourceTodo, err)
+		return
+	}
+	var req CreateTodoRequest
+	if !h.decodeJSON(w, r, &req) {
 		return
 	}
-	updated, err := h.repo.Update(r.Context(), id, req)
+	if err := req.Validate(); err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	ifVersion, ok := h.ifMatchVersion(w, r, id)
+	if !ok {
+		return
+	}
+	t, created, err := h.repo.Upsert(r.Context(), id, req, ifVersion)
 	if err != nil {
-		if errors.Is(err, ErrNotFound) {
-			writeError(w, r, http.StatusNotFound, "todo not found")
+		// Upsert reports another owner's id as not found.
+		if errors.Is(err, ErrTodoInTrash) || errors.Is(err, ErrNotFound) {
+			writeError(w, r, http.StatusConflict, idUnavailable)
 			return
 		}
-		h.logger.Error("could not update todo", "id", id, "error", err, "request_id", reqctx.GetRequestID(r.Context()))
-		writeError(w, r, http.StatusInternalServerError, "could not update")
+		h.writeUpdateError(w, r, id, err)
 		return
 	}
-	writeJSON(w, http.StatusOK, updated)
+	setETag(w, t)
+	if created {
+		w.Header().Set("Location", "/todos/"+t.ID)
+		writeJSON(w, http.StatusCreated, t)
+		return
+	}
+	writeJSON(w, http.StatusOK, t)
 }
 
+func (h *HTTPHandler) writeUpdateError(w http.ResponseWriter, r *http.Request, id string, err error) {
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, r, http.StatusNotFound, "todo not found")
+		return
+	}
+	if errors.Is(err, ErrListNotFound) {
+		writeError(w, r, http.StatusBadRequest, "list not found")
+		return
+	}
+	if errors.Is(err, ErrInvalidUpdate) {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if errors.Is(err, ErrInvalidTransition) {
+		writeError(w, r, http.StatusConflict, err.Error())
+		return
+	}
+	if errors.Is(err, ErrVersionMismatch) {
+		writePreconditionFailed(w, r)
+		return
+	}
+	h.logger.Error("could not update todo", "id", id, "error", err, "request_id", reqctx.GetRequestID(r.Context()))
+	writeError(w, r, http.StatusInternalServerError, "could not update")
+}
+
+// delete moves a todo to the trash, or with ?hard=true removes it for good
+// whether or not it is already in the trash.
 func (h *HTTPHandler) delete(w http.ResponseWriter, r *http.Request, id string) {
-	if err := h.repo.Delete(r.Context(), id); err != nil {
+	r, ok := h.authorize(w, r, ResourceTodo, id, policy.Delete)
+	if !ok {
+		return
+	}
+	hard := false
+	if v := r.URL.Query().Get("hard"); v != "" {
+		var err error
+		if hard, err = strconv.ParseBool(v); err != nil {
+			writeError(w, r, http.StatusBadRequest, "hard must be true or false")
+			return
+		}
+	}
+	ifVersion, ok := h.ifMatchVersion(w, r, id)
+	if !ok {
+		return
+	}
+	del := h.repo.Delete
+	if hard {
+		del = h.repo.Purge
+	}
+	if err := del(r.Context(), id, ifVersion); err != nil {
 		if errors.Is(err, ErrNotFound) {
 			writeError(w, r, http.StatusNotFound, "todo not found")
 			return
 		}
+		if errors.Is(err, ErrVersionMismatch) {
+			writePreconditionFailed(w, r)
+			return
+		}
 		h.logger.Error("could not delete todo", "id", id, "error", err, "request_id", reqctx.GetRequestID(r.Context()))
 		writeError(w, r, http.StatusInternalServerError, "could not delete")
 		return
 	}
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// archive serves POST /todos/{id}/archive. Only done todos can be archived.
+func (h *HTTPHandler) archive(w http.ResponseWriter, r *http.Request, id string) {
+	r, ok := h.authorize(w, r, ResourceTodo, id, policy.Edit)
+	if !ok {
+		return
+	}
+	archived := StatusArchived
+	ifVersion, ok := h.ifMatchVersion(w, r, id)
+	if !ok {
+		return
+	}
+	t, err := h.repo.Update(r.Context(), id, UpdateTodoRequest{Status: &archived}, ifVersion)
+	if err != nil {
+		h.writeUpdateError(w, r, id, err)
+		return
+	}
+	setETag(w, t)
+	writeJSON(w, http.StatusOK, t)
+}
+
+// archiveCompleted serves POST /todos/archive, archiving done todos that
+// were completed more than older_than_days ago.
+func (h *HTTPHandler) archiveCompleted(w http.ResponseWriter, r *http.Request) {
+	var req ArchiveCompletedRequest
+	if !h.decodeJSON(w, r, &req) {
+		return
+	}
+	if err := req.Validate(); err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	cutoff := time.Now().UTC().AddDate(0, 0, -*req.OlderThanDays)
+	n, err := h.repo.ArchiveCompleted(r.Context(), cutoff)
+	if err != nil {
+		h.logger.Error("could not archive todos", "error", err, "request_id", reqctx.GetRequestID(r.Context()))
+		writeError(w, r, http.StatusInternalServerError, "could not archive")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int{"archived": n})
+}
+
+// trash serves GET /trash, most recently deleted first.
+func (h *HTTPHandler) trash(w http.ResponseWriter, r *http.Request) {
+	opts, err := parseListOptions(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	items, err := h.repo.Trash(r.Context(), opts.Limit, opts.Offset)
+	if err != nil {
+		h.logger.Error("could not list trash", "error", err, "request_id", reqctx.GetRequestID(r.Context()))
+		writeError(w, r, http.StatusInternalServerError, "could not list trash")
+		return
+	}
+	if items == nil {
+		items = []Todo{}
+	}
+	writeJSON(w, http.StatusOK, items)
+}
+
+func (h *HTTPHandler) restore(w http.ResponseWriter, r *http.Request, id string) {
+	r, ok := h.authorize(w, r, ResourceTodo, id, policy.Delete)
+	if !ok {
+		return
+	}
+	t, err := h.repo.Restore(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			writeError(w, r, http.StatusNotFound, "todo not found in trash")
+			return
+		}
+		h.logger.Error("could not restore todo", "id", id, "error", err, "request_id", reqctx.GetRequestID(r.Context()))
+		writeError(w, r, http.StatusInternalServerError, "could not restore")
+		return
+	}
+	setETag(w, t)
+	writeJSON(w, http.StatusOK, t)
+}
+
+func (h *HTTPHandler) addTags(w http.ResponseWriter, r *http.Request, id string) {
+	r, ok := h.authorize(w, r, ResourceTodo, id, policy.Edit)
+	if !ok {
+		return
+	}
+	var req AddTagsRequest
+	if !h.decodeJSON(w, r, &req) {
+		return
+	}
+	tags, err := NormalizeTags(req.Tags)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(tags) == 0 {
+		writeError(w, r, http.StatusBadRequest, "tags is required")
+		return
+	}
+	t, err := h.repo.AddTags(r.Context(), id, tags)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			writeError(w, r, http.StatusNotFound, "todo not found")
+			return
+		}
+		h.logger.Error("could not add tags", "id", id, "error", err, "request_id", reqctx.GetRequestID(r.Context()))
+		writeError(w, r, http.StatusInternalServerError, "could not add tags")
+		return
+	}
+	setETag(w, t)
+	writeJSON(w, http.StatusOK, t)
+}
+
+func (h *HTTPHandler) removeTag(w http.ResponseWriter, r *http.Request, id, tag string) {
+	r, ok := h.authorize(w, r, ResourceTodo, id, policy.Edit)
+	if !ok {
+		return
+	}
+	if _, err := h.repo.RemoveTag(r.Context(), id, strings.ToLower(tag)); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			writeError(w, r, http.StatusNotFound, "todo not found")
+			return
+		}
+		h.logger.Error("could not remove tag", "id", id, "tag", tag, "error", err, "request_id", reqctx.GetRequestID(r.Context()))
+		writeError(w, r, http.StatusInternalServerError, "could not remove tag")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *HTTPHandler) listTags(w http.ResponseWriter, r *http.Request) {
+	tags, err := h.repo.ListTags(r.Context())
+	if err != nil {
+		h.logger.Error("could not list tags", "error", err, "request_id", reqctx.GetRequestID(r.Context()))
+		writeError(w, r, http.StatusInternalServerError, "could not list tags")
+		return
+	}
+	writeJSON(w, http.StatusOK, tags)
+}
+
+// search serves GET /search?q=... . It accepts the filters and offset
+// paging of GET /todos, but results are always ordered by relevance.
+func (h *HTTPHandler) search(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	query := strings.TrimSpace(q.Get("q"))
+	if query == "" {
+		writeError(w, r, http.StatusBadRequest, "q is required")
+		return
+	}
+	if q.Has("sort") || q.Has("cursor") {
+		writeError(w, r, http.StatusBadRequest, "search results are ordered by relevance and do not support sort or cursor")
+		return
+	}
+	opts, err := parseListOptions(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	search := SearchOptions{ListOptions: opts, Query: query}
+	if v := q.Get("highlight"); v != "" {
+		if search.Highlight, err = strconv.ParseBool(v); err != nil {
+			writeError(w, r, http.StatusBadRequest, "highlight must be true or false")
+			return
+		}
+	}
+	results, err := h.repo.Search(r.Context(), search)
+	if err != nil {
+		h.logger.Error("could not search todos", "error", err, "request_id", reqctx.GetRequestID(r.Context()))
+		writeError(w, r, http.StatusInternalServerError, "could not search")
+		return
+	}
+	if results == nil {
+		results = []SearchResult{}
+	}
+	writeJSON(w, http.StatusOK, results)
+}
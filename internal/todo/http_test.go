@@ -2,16 +2,25 @@ package todo
 
 import (
 	"bytes"
+	"context"
+	"encoding/csv"
 	"encoding/json"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/jplaulau14/go-todo-api/internal/idempotency"
+	"github.com/jplaulau14/go-todo-api/internal/reqctx"
 )
 
 func setupServer() http.Handler {
 	repo := NewInMemoryRepository()
-	h := NewHTTPHandler(repo)
+	h := NewHTTPHandler(repo).WithLists(repo).WithChecklists(repo).WithGrants(repo).
+		WithIdempotency(idempotency.NewMemoryStore(), time.Hour)
 	mux := http.NewServeMux()
 	h.RegisterRoutes(mux)
 	return mux
@@ -154,3 +163,1608 @@ func TestHTTP_Validation_UnknownFields(t *testing.T) {
 		t.Fatalf("expected 400, got %d", w.Code)
 	}
 }
+
+func TestHTTP_ListFiltersAndSort(t *testing.T) {
+	srv := setupServer()
+
+	var ids []string
+	for _, title := range []string{"b", "a", "c"} {
+		req := httptest.NewRequest(http.MethodPost, "/todos", bytes.NewBufferString(`{"title":"`+title+`"}`))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		var created Todo
+		_ = json.NewDecoder(w.Body).Decode(&created)
+		ids = append(ids, created.ID)
+	}
+	req := httptest.NewRequest(http.MethodPatch, "/todos/"+ids[0], bytes.NewBufferString(`{"completed":true}`))
+	req.Header.Set("Content-Type", "application/json")
+	srv.ServeHTTP(httptest.NewRecorder(), req)
+
+	req = httptest.NewRequest(http.MethodGet, "/todos?completed=false&sort=title", nil)
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("list status: %d", w.Code)
+	}
+	var items []Todo
+	if err := json.NewDecoder(w.Body).Decode(&items); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(items) != 2 || items[0].Title != "a" || items[1].Title != "c" {
+		t.Fatalf("unexpected items: %+v", items)
+	}
+
+	for _, q := range []string{"completed=maybe", "created_after=yesterday", "sort=priority"} {
+		req = httptest.NewRequest(http.MethodGet, "/todos?"+q, nil)
+		w = httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected 400, got %d", q, w.Code)
+		}
+	}
+}
+
+func TestHTTP_ListCursorWalk(t *testing.T) {
+	srv := setupServer()
+	for i := 0; i < 5; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/todos", bytes.NewBufferString(`{"title":"t"}`))
+		req.Header.Set("Content-Type", "application/json")
+		srv.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	seen := map[string]bool{}
+	next := "/todos?limit=2"
+	pages := 0
+	for next != "" {
+		req := httptest.NewRequest(http.MethodGet, next, nil)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("list status: %d", w.Code)
+		}
+		var items []Todo
+		if err := json.NewDecoder(w.Body).Decode(&items); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		for _, it := range items {
+			if seen[it.ID] {
+				t.Fatalf("duplicate item %s", it.ID)
+			}
+			seen[it.ID] = true
+		}
+		pages++
+		next = ""
+		if link := w.Header().Get("Link"); link != "" {
+			next = link[1:strings.Index(link, ">")]
+		}
+	}
+	if len(seen) != 5 || pages != 3 {
+		t.Fatalf("expected 5 items over 3 pages, got %d over %d", len(seen), pages)
+	}
+
+	for _, q := range []string{"cursor=bogus", "cursor=" + (Cursor{ID: "x"}).Encode() + "&offset=1", "cursor=" + (Cursor{ID: "x"}).Encode() + "&sort=title"} {
+		req := httptest.NewRequest(http.MethodGet, "/todos?"+q, nil)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected 400, got %d", q, w.Code)
+		}
+	}
+}
+
+func TestHTTP_ListEnvelope(t *testing.T) {
+	srv := setupServer()
+	for i := 0; i < 5; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/todos", bytes.NewBufferString(`{"title":"t"}`))
+		req.Header.Set("Content-Type", "application/json")
+		srv.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/todos?envelope=true&sort=title&limit=2&offset=2", nil)
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("list status: %d", w.Code)
+	}
+	if got := w.Header().Get("X-Total-Count"); got != "5" {
+		t.Fatalf("X-Total-Count: %q", got)
+	}
+	if links := w.Header().Values("Link"); len(links) != 2 {
+		t.Fatalf("expected next and prev links, got %v", links)
+	}
+	var page Page
+	if err := json.NewDecoder(w.Body).Decode(&page); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(page.Items) != 2 || page.Total != 5 || page.Limit != 2 || page.Offset != 2 {
+		t.Fatalf("unexpected page: %+v", page)
+	}
+	if page.Next == nil || !strings.Contains(*page.Next, "offset=4") {
+		t.Fatalf("unexpected next: %v", page.Next)
+	}
+	if page.Prev == nil || !strings.Contains(*page.Prev, "offset=0") {
+		t.Fatalf("unexpected prev: %v", page.Prev)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/todos?envelope=true&sort=title&offset=4", nil)
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	page = Page{}
+	_ = json.NewDecoder(w.Body).Decode(&page)
+	if page.Next != nil || len(page.Items) != 1 {
+		t.Fatalf("expected last page without next: %+v", page)
+	}
+}
+
+func TestHTTP_Validation_PriorityAndDueAt(t *testing.T) {
+	srv := setupServer()
+
+	req := httptest.NewRequest(http.MethodPost, "/todos", bytes.NewBufferString(`{"title":"t","priority":"someday"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for bad priority, got %d", w.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/todos", bytes.NewBufferString(`{"title":"t","due_at":"2000-01-01T00:00:00Z","priority":"high","description":"d"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create status: %d", w.Code)
+	}
+	var created Todo
+	_ = json.NewDecoder(w.Body).Decode(&created)
+	if created.DueAt == nil || created.Priority != PriorityHigh {
+		t.Fatalf("unexpected created: %+v", created)
+	}
+
+	req = httptest.NewRequest(http.MethodPatch, "/todos/"+created.ID, bytes.NewBufferString(`{"title":""}`))
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for empty title, got %d", w.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/todos?overdue=true", nil)
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	var items []Todo
+	_ = json.NewDecoder(w.Body).Decode(&items)
+	if len(items) != 1 || items[0].ID != created.ID {
+		t.Fatalf("overdue list: %+v", items)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/todos?due_within=soon", nil)
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for bad due_within, got %d", w.Code)
+	}
+}
+
+func TestHTTP_Tags(t *testing.T) {
+	srv := setupServer()
+
+	req := httptest.NewRequest(http.MethodPost, "/todos", bytes.NewBufferString(`{"title":"t","tags":["Work"]}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	var created Todo
+	_ = json.NewDecoder(w.Body).Decode(&created)
+	if len(created.Tags) != 1 || created.Tags[0] != "work" {
+		t.Fatalf("unexpected tags on create: %+v", created.Tags)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/todos/"+created.ID+"/tags", bytes.NewBufferString(`{"tags":["urgent"]}`))
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("add tags status: %d", w.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/todos?tag=work&tag=urgent", nil)
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	var items []Todo
+	_ = json.NewDecoder(w.Body).Decode(&items)
+	if len(items) != 1 {
+		t.Fatalf("tag filter: %+v", items)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/tags", nil)
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	var tags []TagCount
+	_ = json.NewDecoder(w.Body).Decode(&tags)
+	if w.Code != http.StatusOK || len(tags) != 2 {
+		t.Fatalf("list tags: %d %+v", w.Code, tags)
+	}
+
+	req = httptest.NewRequest(http.MethodDelete, "/todos/"+created.ID+"/tags/urgent", nil)
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("remove tag status: %d", w.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/todos?tag=work&tag_match=some", nil)
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for bad tag_match, got %d", w.Code)
+	}
+}
+
+func TestHTTP_Lists(t *testing.T) {
+	srv := setupServer()
+
+	req := httptest.NewRequest(http.MethodPost, "/lists", bytes.NewBufferString(`{"name":"groceries"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create list status: %d", w.Code)
+	}
+	var list TodoList
+	_ = json.NewDecoder(w.Body).Decode(&list)
+
+	req = httptest.NewRequest(http.MethodPost, "/todos", bytes.NewBufferString(`{"title":"milk","list_id":"`+list.ID+`"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create todo status: %d", w.Code)
+	}
+	req = httptest.NewRequest(http.MethodPost, "/todos", bytes.NewBufferString(`{"title":"loose"}`))
+	req.Header.Set("Content-Type", "application/json")
+	srv.ServeHTTP(httptest.NewRecorder(), req)
+
+	req = httptest.NewRequest(http.MethodPost, "/todos", bytes.NewBufferString(`{"title":"x","list_id":"missing"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for unknown list, got %d", w.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/lists/"+list.ID+"/todos", nil)
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	var items []Todo
+	_ = json.NewDecoder(w.Body).Decode(&items)
+	if w.Code != http.StatusOK || len(items) != 1 || items[0].Title != "milk" {
+		t.Fatalf("nested list: %d %+v", w.Code, items)
+	}
+
+	req = httptest.NewRequest(http.MethodDelete, "/lists/"+list.ID, nil)
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected 409 deleting non-empty list, got %d", w.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodDelete, "/lists/"+list.ID+"?cascade=true", nil)
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("cascade delete status: %d", w.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/todos", nil)
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	items = nil
+	_ = json.NewDecoder(w.Body).Decode(&items)
+	if len(items) != 1 || items[0].Title != "loose" {
+		t.Fatalf("cascade should remove list todos only: %+v", items)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/lists/"+list.ID, nil)
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 after delete, got %d", w.Code)
+	}
+}
+
+func TestHTTP_ChecklistItems(t *testing.T) {
+	srv := setupServer()
+
+	req := httptest.NewRequest(http.MethodPost, "/todos", bytes.NewBufferString(`{"title":"move house"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	var parent Todo
+	_ = json.NewDecoder(w.Body).Decode(&parent)
+
+	var items []ChecklistItem
+	for _, title := range []string{"pack", "drive"} {
+		req = httptest.NewRequest(http.MethodPost, "/todos/"+parent.ID+"/items", bytes.NewBufferString(`{"title":"`+title+`"}`))
+		req.Header.Set("Content-Type", "application/json")
+		w = httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("add item status: %d", w.Code)
+		}
+		var it ChecklistItem
+		_ = json.NewDecoder(w.Body).Decode(&it)
+		items = append(items, it)
+	}
+
+	getParent := func() Todo {
+		req := httptest.NewRequest(http.MethodGet, "/todos/"+parent.ID, nil)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		var got Todo
+		_ = json.NewDecoder(w.Body).Decode(&got)
+		return got
+	}
+	if got := getParent(); got.Progress != (Progress{Done: 0, Total: 2}) {
+		t.Fatalf("unexpected progress: %+v", got.Progress)
+	}
+
+	for i, it := range items {
+		req = httptest.NewRequest(http.MethodPatch, "/todos/"+parent.ID+"/items/"+it.ID, bytes.NewBufferString(`{"done":true}`))
+		req.Header.Set("Content-Type", "application/json")
+		w = httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("update item status: %d", w.Code)
+		}
+		got := getParent()
+		if got.Progress.Done != i+1 {
+			t.Fatalf("unexpected progress: %+v", got.Progress)
+		}
+		if got.Completed != (i == len(items)-1) {
+			t.Fatalf("parent completed=%v after %d of %d items", got.Completed, i+1, len(items))
+		}
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/todos/"+parent.ID+"/items", nil)
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	var listed []ChecklistItem
+	_ = json.NewDecoder(w.Body).Decode(&listed)
+	if len(listed) != 2 || listed[0].Title != "pack" || listed[1].Position != 1 {
+		t.Fatalf("unexpected items: %+v", listed)
+	}
+
+	req = httptest.NewRequest(http.MethodDelete, "/todos/"+parent.ID+"/items/"+items[0].ID, nil)
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("delete item status: %d", w.Code)
+	}
+	req = httptest.NewRequest(http.MethodDelete, "/todos/"+parent.ID+"/items/"+items[0].ID, nil)
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for deleted item, got %d", w.Code)
+	}
+	req = httptest.NewRequest(http.MethodGet, "/todos/missing/items", nil)
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for missing todo, got %d", w.Code)
+	}
+}
+
+func TestHTTP_RecurringTodo(t *testing.T) {
+	srv := setupServer()
+
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		var req *http.Request
+		if body == "" {
+			req = httptest.NewRequest(method, path, nil)
+		} else {
+			req = httptest.NewRequest(method, path, bytes.NewBufferString(body))
+			req.Header.Set("Content-Type", "application/json")
+		}
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := send(http.MethodPost, "/todos", `{"title":"bins","recurrence":"FREQ=WEEKLY"}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 without due_at, got %d", w.Code)
+	}
+	if w := send(http.MethodPost, "/todos", `{"title":"bins","due_at":"2026-01-05T08:00:00Z","recurrence":"FREQ=HOURLY"}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for unsupported rule, got %d", w.Code)
+	}
+
+	w := send(http.MethodPost, "/todos", `{"title":"bins","tags":["home"],"due_at":"2026-01-05T08:00:00Z","recurrence":"rrule:freq=weekly;byday=mo,th;count=3"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create status: %d %s", w.Code, w.Body.String())
+	}
+	var first Todo
+	_ = json.NewDecoder(w.Body).Decode(&first)
+	if first.Recurrence == nil || *first.Recurrence != "FREQ=WEEKLY;BYDAY=MO,TH;COUNT=3" {
+		t.Fatalf("recurrence not normalized: %v", first.Recurrence)
+	}
+
+	// Completing each occurrence spawns the next until COUNT is used up.
+	id := first.ID
+	for _, want := range []string{"2026-01-08T08:00:00Z", "2026-01-12T08:00:00Z"} {
+		if w := send(http.MethodPatch, "/todos/"+id, `{"completed":true}`); w.Code != http.StatusOK {
+			t.Fatalf("complete status: %d", w.Code)
+		}
+		w := send(http.MethodGet, "/todos?completed=false", "")
+		var open []Todo
+		_ = json.NewDecoder(w.Body).Decode(&open)
+		if len(open) != 1 {
+			t.Fatalf("expected one open occurrence, got %d", len(open))
+		}
+		next := open[0]
+		if next.DueAt == nil || next.DueAt.Format(time.RFC3339) != want {
+			t.Fatalf("expected next due %s, got %v", want, next.DueAt)
+		}
+		if next.Title != "bins" || len(next.Tags) != 1 || next.RecurrenceStart == nil || !next.RecurrenceStart.Equal(*first.DueAt) {
+			t.Fatalf("next occurrence not copied from previous: %+v", next)
+		}
+		id = next.ID
+	}
+	send(http.MethodPatch, "/todos/"+id, `{"completed":true}`)
+	w = send(http.MethodGet, "/todos?completed=false", "")
+	var open []Todo
+	_ = json.NewDecoder(w.Body).Decode(&open)
+	if len(open) != 0 {
+		t.Fatalf("expected series to end after COUNT, got %d open", len(open))
+	}
+
+	// A recurrence needs a due date, set now or already.
+	undated := send(http.MethodPost, "/todos", `{"title":"someday"}`)
+	var someday Todo
+	_ = json.NewDecoder(undated.Body).Decode(&someday)
+	if w := send(http.MethodPatch, "/todos/"+someday.ID, `{"recurrence":"FREQ=DAILY"}`); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "recurrence requires due_at") {
+		t.Fatalf("expected 400 for a recurrence without due_at, got %d %s", w.Code, w.Body)
+	}
+
+	// An empty recurrence stops the todo from repeating.
+	w = send(http.MethodPatch, "/todos/"+first.ID, `{"recurrence":""}`)
+	var stopped Todo
+	_ = json.NewDecoder(w.Body).Decode(&stopped)
+	if stopped.Recurrence != nil || stopped.RecurrenceStart != nil {
+		t.Fatalf("expected recurrence cleared: %+v", stopped)
+	}
+}
+
+func TestHTTP_Search(t *testing.T) {
+	srv := setupServer()
+	for _, body := range []string{
+		`{"title":"water the plants","priority":"high"}`,
+		`{"title":"buy plant food"}`,
+		`{"title":"file taxes"}`,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/todos", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		srv.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/search?q=plants&highlight=true", nil)
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("search status: %d", w.Code)
+	}
+	var results []SearchResult
+	_ = json.NewDecoder(w.Body).Decode(&results)
+	if len(results) != 2 || results[0].Highlight == nil || !strings.Contains(*results[0].Highlight, "<b>") {
+		t.Fatalf("unexpected results: %+v", results)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/search?q=plants&priority=high", nil)
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	results = nil
+	_ = json.NewDecoder(w.Body).Decode(&results)
+	if len(results) != 1 || results[0].Title != "water the plants" || results[0].Highlight != nil {
+		t.Fatalf("expected filters to apply: %+v", results)
+	}
+
+	for _, path := range []string{"/search", "/search?q=plants&sort=title", "/search?q=x&highlight=maybe"} {
+		req = httptest.NewRequest(http.MethodGet, path, nil)
+		w = httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected 400, got %d", path, w.Code)
+		}
+	}
+}
+
+func TestHTTP_TrashAndRestore(t *testing.T) {
+	srv := setupServer()
+
+	req := httptest.NewRequest(http.MethodPost, "/todos", bytes.NewBufferString(`{"title":"oops"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	var created Todo
+	_ = json.NewDecoder(w.Body).Decode(&created)
+
+	do := func(method, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w
+	}
+
+	if w := do(http.MethodDelete, "/todos/"+created.ID); w.Code != http.StatusNoContent {
+		t.Fatalf("delete status: %d", w.Code)
+	}
+	if w := do(http.MethodGet, "/todos/"+created.ID); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for trashed todo, got %d", w.Code)
+	}
+	w = do(http.MethodGet, "/trash")
+	var trash []Todo
+	_ = json.NewDecoder(w.Body).Decode(&trash)
+	if w.Code != http.StatusOK || len(trash) != 1 || trash[0].DeletedAt == nil {
+		t.Fatalf("unexpected trash: %d %+v", w.Code, trash)
+	}
+
+	if w := do(http.MethodPost, "/todos/"+created.ID+"/restore"); w.Code != http.StatusOK {
+		t.Fatalf("restore status: %d", w.Code)
+	}
+	if w := do(http.MethodPost, "/todos/"+created.ID+"/restore"); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 restoring a live todo, got %d", w.Code)
+	}
+	if w := do(http.MethodGet, "/todos/"+created.ID); w.Code != http.StatusOK {
+		t.Fatalf("expected restored todo, got %d", w.Code)
+	}
+
+	if w := do(http.MethodDelete, "/todos/"+created.ID+"?hard=maybe"); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for bad hard, got %d", w.Code)
+	}
+	if w := do(http.MethodDelete, "/todos/"+created.ID+"?hard=true"); w.Code != http.StatusNoContent {
+		t.Fatalf("hard delete status: %d", w.Code)
+	}
+	w = do(http.MethodGet, "/trash")
+	trash = nil
+	_ = json.NewDecoder(w.Body).Decode(&trash)
+	if trash == nil || len(trash) != 0 {
+		t.Fatalf("expected empty trash array after purge, got %s", w.Body.String())
+	}
+}
+
+func TestHTTP_Archive(t *testing.T) {
+	srv := setupServer()
+
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		if body != "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		return w
+	}
+	create := func(body string) Todo {
+		var td Todo
+		_ = json.NewDecoder(send(http.MethodPost, "/todos", body).Body).Decode(&td)
+		return td
+	}
+	count := func(query string) int {
+		var list []Todo
+		_ = json.NewDecoder(send(http.MethodGet, "/todos"+query, "").Body).Decode(&list)
+		return len(list)
+	}
+
+	open := create(`{"title":"open"}`)
+	done := create(`{"title":"done","status":"done"}`)
+	_ = create(`{"title":"also done","status":"done"}`)
+	if w := send(http.MethodPost, "/todos", `{"title":"x","status":"archived"}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 creating archived todo, got %d", w.Code)
+	}
+	if w := send(http.MethodPatch, "/todos/"+open.ID, `{"status":"done","completed":false}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for contradictory update, got %d", w.Code)
+	}
+
+	if w := send(http.MethodPost, "/todos/"+open.ID+"/archive", ""); w.Code != http.StatusConflict {
+		t.Fatalf("expected 409 archiving open todo, got %d", w.Code)
+	}
+	w := send(http.MethodPost, "/todos/"+done.ID+"/archive", "")
+	var archived Todo
+	_ = json.NewDecoder(w.Body).Decode(&archived)
+	if w.Code != http.StatusOK || archived.Status != StatusArchived {
+		t.Fatalf("archive: %d %+v", w.Code, archived)
+	}
+	if w := send(http.MethodPatch, "/todos/"+done.ID, `{"status":"open"}`); w.Code != http.StatusConflict {
+		t.Fatalf("expected 409 reopening archived todo, got %d", w.Code)
+	}
+
+	if n := count(""); n != 2 {
+		t.Fatalf("expected archived todo hidden by default, got %d", n)
+	}
+	if n := count("?include_archived=true"); n != 3 {
+		t.Fatalf("expected include_archived to show all, got %d", n)
+	}
+	if n := count("?status=archived"); n != 1 {
+		t.Fatalf("expected status filter, got %d", n)
+	}
+
+	if w := send(http.MethodPost, "/todos/archive", `{}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 without older_than_days, got %d", w.Code)
+	}
+	w = send(http.MethodPost, "/todos/archive", `{"older_than_days":0}`)
+	var res struct {
+		Archived int `json:"archived"`
+	}
+	_ = json.NewDecoder(w.Body).Decode(&res)
+	if w.Code != http.StatusOK || res.Archived != 1 {
+		t.Fatalf("bulk archive: %d %+v", w.Code, res)
+	}
+	if n := count(""); n != 1 {
+		t.Fatalf("expected only the open todo listed, got %d", n)
+	}
+}
+
+func TestHTTP_IfMatch(t *testing.T) {
+	srv := setupServer()
+
+	send := func(method, path, body string, header ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		if body != "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Add(header[i], header[i+1])
+		}
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		return w
+	}
+
+	w := send(http.MethodPost, "/todos", `{"title":"race"}`)
+	var created Todo
+	_ = json.NewDecoder(w.Body).Decode(&created)
+	if w.Header().Get("ETag") != `"1"` {
+		t.Fatalf("expected ETag on create, got %q", w.Header().Get("ETag"))
+	}
+	if w := send(http.MethodGet, "/todos/"+created.ID, ""); w.Header().Get("ETag") != `"1"` {
+		t.Fatalf("expected ETag on get, got %q", w.Header().Get("ETag"))
+	}
+
+	w = send(http.MethodPatch, "/todos/"+created.ID, `{"title":"first"}`, "If-Match", `"1"`)
+	if w.Code != http.StatusOK || w.Header().Get("ETag") != `"2"` {
+		t.Fatalf("conditional patch: %d %q", w.Code, w.Header().Get("ETag"))
+	}
+	// A second writer still holding version 1 must not overwrite the first.
+	w = send(http.MethodPatch, "/todos/"+created.ID, `{"title":"second"}`, "If-Match", `"1"`)
+	if w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("expected 412 for stale patch, got %d", w.Code)
+	}
+	var e errorResponse
+	_ = json.NewDecoder(w.Body).Decode(&e)
+	if e.Code != "precondition_failed" {
+		t.Fatalf("unexpected error body: %+v", e)
+	}
+	for _, tag := range []string{`W/"2"`, `"abc"`} {
+		if w := send(http.MethodPatch, "/todos/"+created.ID, `{"title":"x"}`, "If-Match", tag); w.Code != http.StatusPreconditionFailed {
+			t.Fatalf("expected 412 for If-Match %s, got %d", tag, w.Code)
+		}
+	}
+	if w := send(http.MethodPatch, "/todos/"+created.ID, `{"title":"third"}`, "If-Match", `"1", "2"`); w.Code != http.StatusOK {
+		t.Fatalf("expected a matching tag in a list to pass, got %d", w.Code)
+	}
+
+	if w := send(http.MethodDelete, "/todos/"+created.ID, "", "If-Match", `"2"`); w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("expected 412 for stale delete, got %d", w.Code)
+	}
+	if w := send(http.MethodDelete, "/todos/"+created.ID, "", "If-Match", "*"); w.Code != http.StatusNoContent {
+		t.Fatalf("expected If-Match * to delete, got %d", w.Code)
+	}
+}
+
+func TestHTTP_ConditionalGet(t *testing.T) {
+	srv := setupServer()
+
+	get := func(path string, header ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		return w
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/todos", bytes.NewBufferString(`{"title":"poll me"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	var created Todo
+	_ = json.NewDecoder(w.Body).Decode(&created)
+
+	w = get("/todos/" + created.ID)
+	tag, modified := w.Header().Get("ETag"), w.Header().Get("Last-Modified")
+	if tag == "" || modified == "" {
+		t.Fatalf("expected validators, got ETag=%q Last-Modified=%q", tag, modified)
+	}
+	if w := get("/todos/"+created.ID, "If-None-Match", tag); w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Fatalf("expected 304 for matching ETag, got %d", w.Code)
+	}
+	if w := get("/todos/"+created.ID, "If-None-Match", "W/"+tag); w.Code != http.StatusNotModified {
+		t.Fatalf("expected weak comparison to match, got %d", w.Code)
+	}
+	if w := get("/todos/"+created.ID, "If-Modified-Since", modified); w.Code != http.StatusNotModified {
+		t.Fatalf("expected 304 for If-Modified-Since, got %d", w.Code)
+	}
+	past := created.UpdatedAt.Add(-time.Hour).Format(http.TimeFormat)
+	if w := get("/todos/"+created.ID, "If-Modified-Since", past); w.Code != http.StatusOK {
+		t.Fatalf("expected 200 when modified since, got %d", w.Code)
+	}
+	// If-None-Match takes precedence over If-Modified-Since.
+	if w := get("/todos/"+created.ID, "If-None-Match", `"0"`, "If-Modified-Since", modified); w.Code != http.StatusOK {
+		t.Fatalf("expected 200 for stale ETag, got %d", w.Code)
+	}
+
+	w = get("/todos")
+	pageTag := w.Header().Get("ETag")
+	if pageTag == "" || w.Header().Get("Last-Modified") != "" {
+		t.Fatalf("expected only an ETag on the list, got %v", w.Header())
+	}
+	if w := get("/todos", "If-None-Match", pageTag); w.Code != http.StatusNotModified {
+		t.Fatalf("expected 304 for unchanged page, got %d", w.Code)
+	}
+	if w := get("/todos?envelope=true", "If-None-Match", pageTag); w.Code != http.StatusOK {
+		t.Fatalf("expected a different representation to have a different ETag, got %d", w.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodPatch, "/todos/"+created.ID, bytes.NewBufferString(`{"title":"changed"}`))
+	req.Header.Set("Content-Type", "application/json")
+	srv.ServeHTTP(httptest.NewRecorder(), req)
+	if w := get("/todos", "If-None-Match", pageTag); w.Code != http.StatusOK {
+		t.Fatalf("expected 200 once the page changed, got %d", w.Code)
+	}
+	if w := get("/todos/"+created.ID, "If-None-Match", tag); w.Code != http.StatusOK {
+		t.Fatalf("expected 200 once the todo changed, got %d", w.Code)
+	}
+
+	// Removing a todo from a page does not advance any updated_at left on
+	// it, so If-Modified-Since must not answer 304 for a listing.
+	req = httptest.NewRequest(http.MethodPost, "/todos", bytes.NewBufferString(`{"title":"delete me"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	var doomed Todo
+	_ = json.NewDecoder(w.Body).Decode(&doomed)
+	since := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+	srv.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodDelete, "/todos/"+doomed.ID, nil))
+	for _, path := range []string{"/todos", "/todos?envelope=true", "/todos?format=csv"} {
+		if w := get(path, "If-Modified-Since", since); w.Code != http.StatusOK || w.Header().Get("Last-Modified") != "" {
+			t.Fatalf("%s: expected 200 without Last-Modified after a delete, got %d %v", path, w.Code, w.Header())
+		}
+	}
+}
+
+func TestHTTP_IdempotencyKey(t *testing.T) {
+	srv := setupServer()
+
+	create := func(body string, header ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/todos", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		return w
+	}
+
+	first := create(`{"title":"pay rent"}`, "Idempotency-Key", "k1")
+	if first.Code != http.StatusCreated || first.Header().Get("Idempotent-Replayed") != "" {
+		t.Fatalf("first create: %d %v", first.Code, first.Header())
+	}
+	retry := create(`{"title":"pay rent"}`, "Idempotency-Key", "k1")
+	if retry.Code != http.StatusCreated || retry.Header().Get("Idempotent-Replayed") != "true" {
+		t.Fatalf("expected replayed 201, got %d %v", retry.Code, retry.Header())
+	}
+	if retry.Body.String() != first.Body.String() || retry.Header().Get("ETag") != first.Header().Get("ETag") {
+		t.Fatalf("replay differs:\n%s\n%s", first.Body, retry.Body)
+	}
+
+	w := create(`{"title":"pay gas"}`, "Idempotency-Key", "k1")
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422 for reused key, got %d", w.Code)
+	}
+	var e errorResponse
+	_ = json.NewDecoder(w.Body).Decode(&e)
+	if e.Code != "unprocessable_entity" {
+		t.Fatalf("unexpected error body: %+v", e)
+	}
+
+	if w := create(`{"title":"pay rent"}`, "Idempotency-Key", "k2"); w.Code != http.StatusCreated || w.Header().Get("Idempotent-Replayed") != "" {
+		t.Fatalf("expected a new key to create, got %d", w.Code)
+	}
+	if w := create(`{"title":"pay rent"}`); w.Code != http.StatusCreated {
+		t.Fatalf("expected create without key, got %d", w.Code)
+	}
+
+	// The trailing-slash alias honours keys too.
+	req := httptest.NewRequest(http.MethodPost, "/todos/", bytes.NewBufferString(`{"title":"pay rent"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", "k1")
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if w.Code != http.StatusCreated || w.Header().Get("Idempotent-Replayed") != "true" || w.Body.String() != first.Body.String() {
+		t.Fatalf("expected POST /todos/ to replay, got %d %v", w.Code, w.Header())
+	}
+	if w := create(`{"title":"pay rent"}`, "Idempotency-Key", strings.Repeat("k", 256)); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for long key, got %d", w.Code)
+	}
+
+	// Failed requests are not remembered, so the key can be retried.
+	if w := create(`{"title":""}`, "Idempotency-Key", "k3"); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for invalid body, got %d", w.Code)
+	}
+	if w := create(`{"title":""}`, "Idempotency-Key", "k3"); w.Code != http.StatusBadRequest || w.Header().Get("Idempotent-Replayed") != "" {
+		t.Fatalf("expected failed request to run again, got %d", w.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/todos?limit=100", nil)
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	var todos []Todo
+	_ = json.NewDecoder(w.Body).Decode(&todos)
+	if len(todos) != 3 {
+		t.Fatalf("expected 3 todos, got %d", len(todos))
+	}
+}
+
+func TestHTTP_IdempotencyKeyPanic(t *testing.T) {
+	store := idempotency.NewMemoryStore()
+	h := NewHTTPHandler(NewInMemoryRepository()).WithIdempotency(store, time.Hour)
+	send := func(next http.HandlerFunc) (w *httptest.ResponseRecorder, panicked bool) {
+		defer func() { panicked = recover() != nil }()
+		req := httptest.NewRequest(http.MethodPost, "/todos", bytes.NewBufferString(`{"title":"a"}`))
+		req.Header.Set("Idempotency-Key", "k")
+		w = httptest.NewRecorder()
+		h.idempotent(w, req, next)
+		return w, false
+	}
+
+	// A handler that panics releases its key, so the retry runs.
+	if _, panicked := send(func(w http.ResponseWriter, r *http.Request) { panic("boom") }); !panicked {
+		t.Fatal("expected the panic to propagate")
+	}
+	w, _ := send(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusCreated) })
+	if w.Code != http.StatusCreated || w.Header().Get("Idempotent-Replayed") != "" {
+		t.Fatalf("expected the retry to run, got %d %v", w.Code, w.Header())
+	}
+}
+
+func TestHTTP_Bulk(t *testing.T) {
+	srv := setupServer()
+
+	post := func(body string) (*httptest.ResponseRecorder, bulkResponse) {
+		req := httptest.NewRequest(http.MethodPost, "/todos/bulk", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		var resp bulkResponse
+		_ = json.Unmarshal(w.Body.Bytes(), &resp)
+		return w, resp
+	}
+
+	w, resp := post(`{"operations":[{"op":"create","todo":{"title":"a"}},{"op":"create","todo":{"title":"b","priority":"high"}}]}`)
+	if w.Code != http.StatusOK || resp.Mode != BulkAtomic || resp.Succeeded != 2 || resp.Failed != 0 {
+		t.Fatalf("bulk create: %d %s", w.Code, w.Body)
+	}
+	a, b := resp.Results[0], resp.Results[1]
+	if a.Status != http.StatusCreated || a.Todo == nil || a.ID != a.Todo.ID || b.Todo.Priority != PriorityHigh {
+		t.Fatalf("unexpected create results: %+v", resp.Results)
+	}
+
+	// One invalid operation rejects an atomic batch before anything runs.
+	w, resp = post(`{"operations":[{"op":"delete","id":"` + a.ID + `"},{"op":"create","todo":{"title":""}}]}`)
+	if w.Code != http.StatusOK || resp.Succeeded != 0 || resp.Failed != 2 {
+		t.Fatalf("atomic failure: %d %s", w.Code, w.Body)
+	}
+	if resp.Results[0].Status != http.StatusFailedDependency || resp.Results[1].Status != http.StatusBadRequest || resp.Results[1].Error.Code != "bad_request" {
+		t.Fatalf("unexpected atomic results: %+v", resp.Results)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/todos/"+a.ID, nil)
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected todo to survive the failed batch, got %d", rec.Code)
+	}
+
+	w, resp = post(`{"mode":"best_effort","operations":[
+		{"op":"update","id":"` + a.ID + `","todo":{"completed":true}},
+		{"op":"update","id":"` + b.ID + `","if_version":7,"todo":{"title":"stale"}},
+		{"op":"delete","id":"` + b.ID + `"},
+		{"op":"delete","id":"nope"},
+		{"op":"rename","id":"` + a.ID + `"}]}`)
+	if w.Code != http.StatusOK || resp.Succeeded != 2 || resp.Failed != 3 {
+		t.Fatalf("best effort: %d %s", w.Code, w.Body)
+	}
+	want := []int{http.StatusOK, http.StatusPreconditionFailed, http.StatusNoContent, http.StatusNotFound, http.StatusBadRequest}
+	for i, res := range resp.Results {
+		if res.Status != want[i] || res.Index != i {
+			t.Fatalf("result %d: got %+v, want status %d", i, res, want[i])
+		}
+	}
+	if !resp.Results[0].Todo.Completed {
+		t.Fatalf("expected update to apply: %+v", resp.Results[0].Todo)
+	}
+
+	for _, body := range []string{`{"operations":[]}`, `{"mode":"sometimes","operations":[{"op":"delete","id":"x"}]}`} {
+		if w, _ := post(body); w.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400 for %s, got %d", body, w.Code)
+		}
+	}
+}
+
+func TestHTTP_PutUpsert(t *testing.T) {
+	srv := setupServer()
+
+	put := func(id, body string, header ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/todos/"+id, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		return w
+	}
+
+	w := put("offline-42", `{"title":"draft","priority":"high","tags":["home"]}`)
+	if w.Code != http.StatusCreated || w.Header().Get("Location") != "/todos/offline-42" || w.Header().Get("ETag") != `"1"` {
+		t.Fatalf("create: %d %v", w.Code, w.Header())
+	}
+	var got Todo
+	_ = json.NewDecoder(w.Body).Decode(&got)
+	if got.ID != "offline-42" || got.Priority != PriorityHigh {
+		t.Fatalf("unexpected created todo: %+v", got)
+	}
+
+	w = put("offline-42", `{"title":"final"}`, "If-Match", `"1"`)
+	if w.Code != http.StatusOK || w.Header().Get("ETag") != `"2"` {
+		t.Fatalf("replace: %d %s", w.Code, w.Body)
+	}
+	_ = json.NewDecoder(w.Body).Decode(&got)
+	if got.Title != "final" || got.Priority != PriorityNormal || len(got.Tags) != 0 {
+		t.Fatalf("omitted fields should reset: %+v", got)
+	}
+	if w := put("offline-42", `{"title":"stale"}`, "If-Match", `"1"`); w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("expected 412 for stale replace, got %d", w.Code)
+	}
+	if w := put("offline-43", `{"title":"x"}`, "If-Match", `"1"`); w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("expected 412 for If-Match on a missing todo, got %d", w.Code)
+	}
+
+	for _, id := range []string{"bulk", "Archive", "has%20space", strings.Repeat("a", 129)} {
+		if w := put(id, `{"title":"x"}`); w.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400 for id %q, got %d", id, w.Code)
+		}
+	}
+	if w := put("offline-44", `{"title":""}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for invalid body, got %d", w.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodDelete, "/todos/offline-42", nil)
+	srv.ServeHTTP(httptest.NewRecorder(), req)
+	if w := put("offline-42", `{"title":"again"}`); w.Code != http.StatusConflict {
+		t.Fatalf("expected 409 for a trashed id, got %d", w.Code)
+	}
+}
+
+func TestHTTP_PatchDocuments(t *testing.T) {
+	srv := setupServer()
+
+	send := func(method, path, contentType, body string, header ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		return w
+	}
+	decode := func(w *httptest.ResponseRecorder) Todo {
+		var got Todo
+		_ = json.NewDecoder(w.Body).Decode(&got)
+		return got
+	}
+
+	w := send(http.MethodPost, "/todos", "application/json", `{"title":"t","description":"d","due_at":"2026-05-01T09:00:00Z","tags":["a","b"]}`)
+	created := decode(w)
+	path := "/todos/" + created.ID
+
+	// Merge patch can clear optional fields with null.
+	w = send(http.MethodPatch, path, "application/merge-patch+json", `{"description":null,"due_at":null,"priority":"high"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("merge patch: %d %s", w.Code, w.Body)
+	}
+	got := decode(w)
+	if got.Description != nil || got.DueAt != nil || got.Priority != PriorityHigh || got.Title != "t" || len(got.Tags) != 2 || got.Version != 2 {
+		t.Fatalf("unexpected merge result: %+v", got)
+	}
+
+	// JSON Patch with a test operation acts as compare-and-set.
+	w = send(http.MethodPatch, path, "application/json-patch+json",
+		`[{"op":"test","path":"/version","value":2},{"op":"remove","path":"/tags/0"},{"op":"replace","path":"/completed","value":true}]`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("json patch: %d %s", w.Code, w.Body)
+	}
+	got = decode(w)
+	if len(got.Tags) != 1 || got.Tags[0] != "b" || got.Status != StatusDone {
+		t.Fatalf("unexpected json patch result: %+v", got)
+	}
+	w = send(http.MethodPatch, path, "application/json-patch+json", `[{"op":"test","path":"/version","value":2},{"op":"replace","path":"/title","value":"x"}]`)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected 409 for failed test, got %d", w.Code)
+	}
+
+	for _, tc := range []struct {
+		contentType, body string
+		want              int
+	}{
+		{"application/merge-patch+json", `{"id":"other"}`, http.StatusBadRequest},
+		{"application/merge-patch+json", `{"version":null}`, http.StatusBadRequest},
+		{"application/merge-patch+json", `{"colour":"red"}`, http.StatusBadRequest},
+		{"application/merge-patch+json", `{"title":null}`, http.StatusBadRequest},
+		{"application/merge-patch+json", `{"status":"archived","completed":false}`, http.StatusBadRequest},
+		{"application/merge-patch+json", `{"title":`, http.StatusBadRequest},
+		{"application/json-patch+json", `[{"op":"remove","path":"/nope"}]`, http.StatusConflict},
+		{"application/json-patch+json", `{"op":"remove","path":"/title"}`, http.StatusBadRequest},
+		{"application/json-patch+json", `[{"op":"replace","path":"/created_at","value":"2020-01-01T00:00:00Z"}]`, http.StatusBadRequest},
+		{"text/plain", `title=x`, http.StatusUnsupportedMediaType},
+	} {
+		w := send(http.MethodPatch, path, tc.contentType, tc.body)
+		if w.Code != tc.want {
+			t.Fatalf("%s %s: expected %d, got %d %s", tc.contentType, tc.body, tc.want, w.Code, w.Body)
+		}
+		if w.Header().Get("Accept-Patch") == "" {
+			t.Fatalf("expected Accept-Patch on PATCH responses")
+		}
+	}
+
+	if w := send(http.MethodPatch, path, "application/merge-patch+json", `{"title":"y"}`, "If-Match", `"2"`); w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("expected 412 for stale If-Match, got %d", w.Code)
+	}
+	w = send(http.MethodPatch, path, "application/merge-patch+json", `{"title":"y"}`, "If-Match", `"3"`)
+	if got := decode(w); w.Code != http.StatusOK || got.Title != "y" || w.Header().Get("ETag") != `"4"` {
+		t.Fatalf("conditional merge patch: %d %+v", w.Code, got)
+	}
+	if w := send(http.MethodPatch, "/todos/missing", "application/merge-patch+json", `{"title":"y"}`); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", w.Code)
+	}
+}
+
+func TestHTTP_FieldsAndExpand(t *testing.T) {
+	srv := setupServer()
+
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		if body != "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		return w
+	}
+
+	var list TodoList
+	_ = json.NewDecoder(send(http.MethodPost, "/lists", `{"name":"groceries"}`).Body).Decode(&list)
+	var created Todo
+	_ = json.NewDecoder(send(http.MethodPost, "/todos", `{"title":"milk","list_id":"`+list.ID+`"}`).Body).Decode(&created)
+	send(http.MethodPost, "/todos/"+created.ID+"/items", `{"title":"oat"}`)
+	send(http.MethodPost, "/todos", `{"title":"loose"}`)
+
+	// Only the selected members are written; absent ones are null.
+	w := send(http.MethodGet, "/todos/"+created.ID+"?fields=id,title&fields=due_at", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("get with fields: %d %s", w.Code, w.Body)
+	}
+	var doc map[string]json.RawMessage
+	_ = json.NewDecoder(w.Body).Decode(&doc)
+	if len(doc) != 3 || string(doc["title"]) != `"milk"` || string(doc["due_at"]) != "null" {
+		t.Fatalf("unexpected sparse todo: %v", doc)
+	}
+	if etag := w.Header().Get("ETag"); etag == "" || etag == `"1"` {
+		t.Fatalf("a projection needs its own ETag, got %q", etag)
+	}
+
+	// Renaming an embedded list does not touch the todo, so an expanded
+	// todo validates by its ETag only.
+	w = send(http.MethodGet, "/todos/"+created.ID+"?expand=list", "")
+	if w.Header().Get("ETag") == "" || w.Header().Get("Last-Modified") != "" {
+		t.Fatalf("expected only an ETag on an expanded todo, got %v", w.Header())
+	}
+	send(http.MethodPatch, "/lists/"+list.ID, `{"name":"shopping"}`)
+	req := httptest.NewRequest(http.MethodGet, "/todos/"+created.ID+"?expand=list", nil)
+	req.Header.Set("If-Modified-Since", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"shopping"`) {
+		t.Fatalf("expected the renamed list after If-Modified-Since, got %d %s", w.Code, w.Body)
+	}
+
+	// Expansions embed the list and checklist items.
+	w = send(http.MethodGet, "/todos?sort=created_at&fields=title&expand=list,items", "")
+	var docs []struct {
+		Title string          `json:"title"`
+		List  *TodoList       `json:"list"`
+		Items []ChecklistItem `json:"items"`
+		ID    string          `json:"id"`
+	}
+	_ = json.NewDecoder(w.Body).Decode(&docs)
+	if len(docs) != 2 || docs[0].ID != "" {
+		t.Fatalf("unexpected expanded list: %+v", docs)
+	}
+	if docs[0].List == nil || docs[0].List.Name != "shopping" || len(docs[0].Items) != 1 {
+		t.Fatalf("expected milk with its list and item, got %+v", docs[0])
+	}
+	if docs[1].List != nil || docs[1].Items == nil || len(docs[1].Items) != 0 {
+		t.Fatalf("expected loose with null list and no items, got %+v", docs[1])
+	}
+
+	// The envelope keeps its metadata around projected items.
+	w = send(http.MethodGet, "/todos?envelope=true&fields=id", "")
+	var page struct {
+		Items []map[string]any `json:"items"`
+		Total int              `json:"total"`
+	}
+	_ = json.NewDecoder(w.Body).Decode(&page)
+	if page.Total != 2 || len(page.Items) != 2 || len(page.Items[0]) != 1 {
+		t.Fatalf("unexpected projected page: %+v", page)
+	}
+
+	for _, query := range []string{"fields=id,owner", "fields=", "expand=tags"} {
+		if w := send(http.MethodGet, "/todos?"+query, ""); w.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400 for %s, got %d", query, w.Code)
+		}
+		if w := send(http.MethodGet, "/todos/"+created.ID+"?"+query, ""); w.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400 for %s on a single todo, got %d", query, w.Code)
+		}
+	}
+}
+
+func TestHTTP_ContentNegotiation(t *testing.T) {
+	srv := setupServer()
+	for _, title := range []string{"=SUM(A1)", "plain, with comma"} {
+		req := httptest.NewRequest(http.MethodPost, "/todos", bytes.NewBufferString(`{"title":"`+title+`","tags":["a","b"]}`))
+		req.Header.Set("Content-Type", "application/json")
+		srv.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	get := func(path, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get("/todos?sort=created_at&fields=title,tags,due_at", "text/csv;q=0.9, application/json;q=0.5")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "text/csv" || w.Header().Get("ETag") == "" {
+		t.Fatalf("csv: %d %v", w.Code, w.Header())
+	}
+	if !strings.Contains(w.Header().Get("Vary"), "Accept") {
+		t.Fatalf("expected Vary: Accept, got %q", w.Header().Get("Vary"))
+	}
+	want := "title,tags,due_at\n'=SUM(A1),a;b,\n\"plain, with comma\",a;b,\n"
+	if got := w.Body.String(); got != want {
+		t.Fatalf("csv body:\n%s\nwant:\n%s", got, want)
+	}
+
+	w = get("/todos", "application/x-ndjson")
+	lines := strings.Split(strings.TrimSuffix(w.Body.String(), "\n"), "\n")
+	if w.Header().Get("Content-Type") != "application/x-ndjson" || len(lines) != 2 {
+		t.Fatalf("ndjson: %v %q", w.Header(), w.Body)
+	}
+	var first Todo
+	if err := json.Unmarshal([]byte(lines[0]), &first); err != nil || first.ID == "" {
+		t.Fatalf("ndjson line: %v %q", err, lines[0])
+	}
+
+	if w := get("/todos", "text/*"); w.Header().Get("Content-Type") != "text/csv" {
+		t.Fatalf("expected text/* to select csv, got %q", w.Header().Get("Content-Type"))
+	}
+	if w := get("/todos?format=ndjson", "application/json"); w.Header().Get("Content-Type") != "application/x-ndjson" {
+		t.Fatalf("expected format to override Accept, got %q", w.Header().Get("Content-Type"))
+	}
+	if w := get("/todos", "*/*"); w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("expected json for */*, got %q", w.Header().Get("Content-Type"))
+	}
+	if w := get("/todos", "application/xml"); w.Code != http.StatusNotAcceptable {
+		t.Fatalf("expected 406, got %d", w.Code)
+	}
+	if w := get("/todos", "application/json;q=0, text/csv;q=0"); w.Code != http.StatusNotAcceptable {
+		t.Fatalf("expected 406 when everything is refused, got %d", w.Code)
+	}
+	for _, path := range []string{"/todos?format=xml", "/todos?format=csv&envelope=true", "/todos?format=csv&expand=items"} {
+		if w := get(path, ""); w.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400 for %s, got %d", path, w.Code)
+		}
+	}
+}
+
+func TestHTTP_Export(t *testing.T) {
+	srv := setupServer()
+	// More than one export page, and more than the listing cap.
+	for i := 0; i < exportPageSize+20; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/todos", bytes.NewBufferString(`{"title":"t`+strconv.Itoa(i)+`","priority":"high"}`))
+		req.Header.Set("Content-Type", "application/json")
+		srv.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/todos", bytes.NewBufferString(`{"title":"low","priority":"low"}`))
+	req.Header.Set("Content-Type", "application/json")
+	srv.ServeHTTP(httptest.NewRecorder(), req)
+
+	export := func(query, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/todos/export"+query, nil)
+		req.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		return w
+	}
+
+	w := export("?priority=high&limit=5", "application/json")
+	var all []Todo
+	if err := json.NewDecoder(w.Body).Decode(&all); err != nil {
+		t.Fatalf("decode export: %v", err)
+	}
+	if w.Code != http.StatusOK || len(all) != exportPageSize+20 {
+		t.Fatalf("expected every high todo, got %d (%d)", len(all), w.Code)
+	}
+	seen := map[string]bool{}
+	for _, td := range all {
+		if seen[td.ID] || td.Priority != PriorityHigh {
+			t.Fatalf("duplicate or unfiltered todo %+v", td)
+		}
+		seen[td.ID] = true
+	}
+	if cd := w.Header().Get("Content-Disposition"); cd != `attachment; filename="todos.json"` {
+		t.Fatalf("unexpected Content-Disposition %q", cd)
+	}
+
+	// An offset skips rows once, not again on every keyset page.
+	w = export("?priority=high&offset=10", "application/json")
+	all = nil
+	if err := json.NewDecoder(w.Body).Decode(&all); err != nil || len(all) != exportPageSize+10 {
+		t.Fatalf("expected all but the first 10 high todos, got %d (%v)", len(all), err)
+	}
+
+	// Offset paging is used for orders without a cursor.
+	w = export("?sort=title&fields=id", "text/csv")
+	rows, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil || len(rows) != exportPageSize+22 || rows[0][0] != "id" {
+		t.Fatalf("csv export: %v, %d rows", err, len(rows))
+	}
+
+	w = export("", "application/x-ndjson")
+	if n := strings.Count(w.Body.String(), "\n"); n != exportPageSize+21 {
+		t.Fatalf("expected %d ndjson lines, got %d", exportPageSize+21, n)
+	}
+	if w := export("", "text/html"); w.Code != http.StatusNotAcceptable {
+		t.Fatalf("expected 406, got %d", w.Code)
+	}
+	if w := export("?sort=nope", "text/csv"); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", w.Code)
+	}
+	empty := export("?priority=urgent", "application/json")
+	if strings.TrimSpace(empty.Body.String()) != "[]" {
+		t.Fatalf("expected an empty array, got %q", empty.Body)
+	}
+}
+
+func TestHTTP_Import(t *testing.T) {
+	repo := NewInMemoryRepository()
+	h := NewHTTPHandler(repo).WithLists(repo).WithChecklists(repo).WithImportLimit(4096)
+	srv := http.NewServeMux()
+	h.RegisterRoutes(srv)
+
+	send := func(path, contentType, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		return w
+	}
+	type report struct {
+		DryRun  bool           `json:"dry_run"`
+		Created int            `json:"created"`
+		Skipped int            `json:"skipped"`
+		Invalid int            `json:"invalid"`
+		Rows    []importResult `json:"rows"`
+	}
+	decode := func(w *httptest.ResponseRecorder) report {
+		t.Helper()
+		if w.Code != http.StatusOK {
+			t.Fatalf("import: %d %s", w.Code, w.Body)
+		}
+		var rep report
+		_ = json.NewDecoder(w.Body).Decode(&rep)
+		return rep
+	}
+
+	existing, _ := repo.Create(context.Background(), CreateTodoRequest{Title: "already here"})
+	csvBody := "\ufefftitle,tags,priority,id,version\n" +
+		"'=cmd,x;y,high,,\n" +
+		"bad,,extreme,,\n" +
+		"named,,,import-1,3\n" +
+		"again,,,import-1,\n" +
+		"clash,,,\"" + existing.ID + "\",\n"
+
+	// A dry run reports what would happen without writing anything.
+	rep := decode(send("/todos/import?dry_run=true", "text/csv", csvBody))
+	if !rep.DryRun || rep.Created != 2 || rep.Skipped != 2 || rep.Invalid != 1 || len(rep.Rows) != 5 {
+		t.Fatalf("unexpected dry run report: %+v", rep)
+	}
+	if n, _ := repo.Count(context.Background(), ListOptions{}); n != 1 {
+		t.Fatalf("dry run wrote todos: %d", n)
+	}
+
+	rep = decode(send("/todos/import", "text/csv", csvBody))
+	want := []string{importCreated, importInvalid, importCreated, importSkipped, importSkipped}
+	for i, res := range rep.Rows {
+		if res.Row != i+1 || res.Status != want[i] {
+			t.Fatalf("row %d: %+v, want %s", i+1, res, want[i])
+		}
+	}
+	if !strings.Contains(rep.Rows[1].Error, "priority") {
+		t.Fatalf("expected a priority error, got %q", rep.Rows[1].Error)
+	}
+	formula, err := repo.Get(context.Background(), rep.Rows[0].ID)
+	if err != nil || formula.Title != "=cmd" || len(formula.Tags) != 2 || formula.Priority != PriorityHigh {
+		t.Fatalf("unexpected imported todo: %+v %v", formula, err)
+	}
+	if named, err := repo.Get(context.Background(), "import-1"); err != nil || named.Title != "named" || named.Version != 1 {
+		t.Fatalf("expected the id to be kept: %+v %v", named, err)
+	}
+
+	// Re-running skips what was already imported.
+	rep = decode(send("/todos/import", "application/x-ndjson", `{"id":"import-1","title":"named"}`+"\n"+`{"title":"x","completed":true}`+"\n"+`{"title":"y","nope":1}`+"\n"))
+	if rep.Created != 1 || rep.Skipped != 1 || rep.Invalid != 1 {
+		t.Fatalf("unexpected ndjson report: %+v", rep)
+	}
+	if done, _ := repo.Get(context.Background(), rep.Rows[1].ID); done.Status != StatusDone {
+		t.Fatalf("expected completed to mean done, got %s", done.Status)
+	}
+
+	rep = decode(send("/todos/import", "application/json", `[{"title":"a","list_id":"missing"},{"title":"b","id":"bad id"},{"title":"c"}]`))
+	if rep.Created != 1 || rep.Invalid != 2 || rep.Rows[0].Error != "list not found" {
+		t.Fatalf("unexpected json report: %+v", rep)
+	}
+
+	for _, tc := range []struct {
+		contentType, body string
+		status            int
+	}{
+		{"application/json", `{"title":"not an array"}`, http.StatusBadRequest},
+		{"application/json", `[{"title":"a"}`, http.StatusBadRequest},
+		{"text/csv", "title,owner\nx,y\n", http.StatusBadRequest},
+		{"text/csv", "title\n\"unterminated\n", http.StatusBadRequest},
+		{"text/plain", "title\nx\n", http.StatusUnsupportedMediaType},
+		{"text/csv", "title\n" + strings.Repeat("x\n", 4096), http.StatusRequestEntityTooLarge},
+	} {
+		if w := send("/todos/import", tc.contentType, tc.body); w.Code != tc.status {
+			t.Fatalf("%s %.30q: expected %d, got %d %s", tc.contentType, tc.body, tc.status, w.Code, w.Body)
+		}
+	}
+}
+
+func TestHTTP_ImportOutlastsServerTimeouts(t *testing.T) {
+	srv := httptest.NewUnstartedServer(setupServer())
+	srv.Config.ReadTimeout = 200 * time.Millisecond
+	srv.Config.WriteTimeout = 200 * time.Millisecond
+	srv.Start()
+	defer srv.Close()
+
+	// The body trickles in for longer than either timeout.
+	pr, pw := io.Pipe()
+	go func() {
+		for i := 0; i < 5; i++ {
+			time.Sleep(100 * time.Millisecond)
+			_, _ = io.WriteString(pw, `{"title":"row `+strconv.Itoa(i)+`"}`+"\n")
+		}
+		_ = pw.Close()
+	}()
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+"/todos/import", pr)
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	defer resp.Body.Close()
+	var report importReport
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil || resp.StatusCode != http.StatusOK || report.Created != 5 {
+		t.Fatalf("expected all rows imported, got %d %+v %v", resp.StatusCode, report, err)
+	}
+}
+
+func TestHTTP_Ownership(t *testing.T) {
+	srv := setupServer()
+	alice := reqctx.Principal{Subject: "alice", TenantID: "acme"}
+	bob := reqctx.Principal{Subject: "bob", TenantID: "globex"}
+	carol := reqctx.Principal{Subject: "carol", TenantID: "acme"}
+	do := func(p reqctx.Principal, method, path, body string, header ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		req = req.WithContext(reqctx.WithPrincipal(req.Context(), p))
+		if body != "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do(alice, http.MethodPost, "/lists", `{"name":"home"}`)
+	var list TodoList
+	_ = json.NewDecoder(w.Body).Decode(&list)
+	w = do(alice, http.MethodPost, "/todos", `{"title":"mine","tags":["secret"],"list_id":"`+list.ID+`"}`)
+	var todo Todo
+	_ = json.NewDecoder(w.Body).Decode(&todo)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create: %d %s", w.Code, w.Body)
+	}
+	if w := do(alice, http.MethodGet, "/todos/"+todo.ID, ""); w.Code != http.StatusOK {
+		t.Fatalf("owner get: %d", w.Code)
+	}
+
+	for _, path := range []string{"/todos", "/search?q=mine", "/tags", "/lists"} {
+		if w := do(alice, http.MethodGet, path, ""); !strings.Contains(w.Body.String(), todo.ID) && !strings.Contains(w.Body.String(), "secret") && !strings.Contains(w.Body.String(), list.ID) {
+			t.Fatalf("owner does not see their data at %s: %s", path, w.Body)
+		}
+	}
+
+	// Other owners, in another tenant or the same one, cannot tell it exists.
+	for _, p := range []reqctx.Principal{bob, carol, {}} {
+		for _, tc := range []struct{ method, path, body string }{
+			{http.MethodGet, "/todos/" + todo.ID, ""},
+			{http.MethodPatch, "/todos/" + todo.ID, `{"title":"stolen"}`},
+			{http.MethodDelete, "/todos/" + todo.ID, ""},
+			{http.MethodPost, "/todos/" + todo.ID + "/tags", `{"tags":["x"]}`},
+			{http.MethodGet, "/todos/" + todo.ID + "/items", ""},
+			{http.MethodPost, "/todos/" + todo.ID + "/restore", ""},
+			{http.MethodGet, "/lists/" + list.ID, ""},
+			{http.MethodDelete, "/lists/" + list.ID + "?cascade=true", ""},
+		} {
+			if w := do(p, tc.method, tc.path, tc.body); w.Code != http.StatusNotFound {
+				t.Fatalf("%q %s %s: expected 404, got %d %s", p.Subject, tc.method, tc.path, w.Code, w.Body)
+			}
+		}
+		// PUT cannot create under the id, and answers as for an id in the
+		// caller's own trash.
+		if w := do(p, http.MethodPut, "/todos/"+todo.ID, `{"title":"stolen"}`); w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), idUnavailable) {
+			t.Fatalf("%q PUT: expected 409, got %d %s", p.Subject, w.Code, w.Body)
+		}
+		if w := do(p, http.MethodPost, "/todos/import", `[{"id":"`+todo.ID+`","title":"stolen"}]`); !strings.Contains(w.Body.String(), `"status":"skipped","id":"`+todo.ID+`","error":"`+idUnavailable) {
+			t.Fatalf("%q import: expected the row to be skipped as unavailable, got %d %s", p.Subject, w.Code, w.Body)
+		}
+		if w := do(p, http.MethodPost, "/todos", `{"title":"x","list_id":"`+list.ID+`"}`); w.Code == http.StatusCreated {
+			t.Fatalf("%q could add a todo to another owner's list", p.Subject)
+		}
+		for _, path := range []string{"/todos", "/search?q=mine", "/tags", "/lists", "/trash", "/todos/export"} {
+			if w := do(p, http.MethodGet, path, ""); strings.Contains(w.Body.String(), todo.ID) || strings.Contains(w.Body.String(), "secret") || strings.Contains(w.Body.String(), list.ID) {
+				t.Fatalf("%q sees another owner's data at %s: %s", p.Subject, path, w.Body)
+			}
+		}
+	}
+	if w := do(alice, http.MethodGet, "/todos/"+todo.ID, ""); !strings.Contains(w.Body.String(), `"title":"mine"`) {
+		t.Fatalf("todo was changed by another owner: %s", w.Body)
+	}
+	do(alice, http.MethodPut, "/todos/binned", `{"title":"binned"}`)
+	do(alice, http.MethodDelete, "/todos/binned", "")
+	if w := do(alice, http.MethodPut, "/todos/binned", `{"title":"again"}`); w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), idUnavailable) {
+		t.Fatalf("expected the same 409 for an id in the trash, got %d %s", w.Code, w.Body)
+	}
+
+	// Idempotency keys are per owner.
+	first := do(alice, http.MethodPost, "/todos", `{"title":"same"}`, "Idempotency-Key", "k1")
+	second := do(bob, http.MethodPost, "/todos", `{"title":"same"}`, "Idempotency-Key", "k1")
+	if second.Code != http.StatusCreated || second.Header().Get("Idempotent-Replayed") != "" || first.Body.String() == second.Body.String() {
+		t.Fatalf("expected a separate todo for another owner's key, got %d %s", second.Code, second.Body)
+	}
+}
+
+func TestHTTP_Sharing(t *testing.T) {
+	srv := setupServer()
+	alice := reqctx.Principal{Subject: "alice", TenantID: "acme"}
+	bob := reqctx.Principal{Subject: "bob", TenantID: "acme"}
+	carol := reqctx.Principal{Subject: "carol", TenantID: "acme"}
+	dave := reqctx.Principal{Subject: "dave", TenantID: "globex"}
+	do := func(p reqctx.Principal, method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		req = req.WithContext(reqctx.WithPrincipal(req.Context(), p))
+		if body != "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		return w
+	}
+	expect := func(w *httptest.ResponseRecorder, status int, what string) {
+		t.Helper()
+		if w.Code != status {
+			t.Fatalf("%s: expected %d, got %d %s", what, status, w.Code, w.Body)
+		}
+	}
+
+	var list TodoList
+	_ = json.NewDecoder(do(alice, http.MethodPost, "/lists", `{"name":"team"}`).Body).Decode(&list)
+	var inList, solo Todo
+	_ = json.NewDecoder(do(alice, http.MethodPost, "/todos", `{"title":"in list","list_id":"`+list.ID+`"}`).Body).Decode(&inList)
+	_ = json.NewDecoder(do(alice, http.MethodPost, "/todos", `{"title":"solo"}`).Body).Decode(&solo)
+
+	// A viewer can read but not change.
+	w := do(alice, http.MethodPost, "/todos/"+solo.ID+"/grants", `{"subject":"bob","role":"viewer"}`)
+	expect(w, http.StatusCreated, "share")
+	if loc := w.Header().Get("Location"); loc != "/todos/"+solo.ID+"/grants/bob" {
+		t.Fatalf("unexpected Location %q", loc)
+	}
+	expect(do(alice, http.MethodPost, "/todos/"+solo.ID+"/grants", `{"subject":"bob","role":"editor"}`), http.StatusConflict, "share twice")
+	expect(do(alice, http.MethodPost, "/todos/"+solo.ID+"/grants", `{"subject":"bob","role":"owner"}`), http.StatusBadRequest, "grant owner")
+	expect(do(alice, http.MethodPost, "/todos/"+solo.ID+"/grants", `{"subject":"alice","role":"viewer"}`), http.StatusBadRequest, "share with owner")
+	expect(do(bob, http.MethodGet, "/todos/"+solo.ID, ""), http.StatusOK, "viewer get")
+	expect(do(bob, http.MethodPatch, "/todos/"+solo.ID, `{"title":"changed"}`), http.StatusForbidden, "viewer patch")
+	expect(do(bob, http.MethodDelete, "/todos/"+solo.ID, ""), http.StatusForbidden, "viewer delete")
+	expect(do(bob, http.MethodGet, "/todos/"+solo.ID+"/grants", ""), http.StatusForbidden, "viewer list grants")
+	expect(do(bob, http.MethodGet, "/todos/"+inList.ID, ""), http.StatusNotFound, "unshared todo")
+	if w := do(bob, http.MethodGet, "/shared", ""); !strings.Contains(w.Body.String(), `"resource_id":"`+solo.ID+`"`) {
+		t.Fatalf("expected the grant in /shared: %s", w.Body)
+	}
+	// Shared todos are not mixed into the subject's own listings.
+	if w := do(bob, http.MethodGet, "/todos", ""); strings.Contains(w.Body.String(), solo.ID) {
+		t.Fatalf("shared todo listed as bob's own: %s", w.Body)
+	}
+
+	// An editor can change it, but not delete or share it.
+	expect(do(alice, http.MethodPatch, "/todos/"+solo.ID+"/grants/bob", `{"role":"editor"}`), http.StatusOK, "change role")
+	expect(do(bob, http.MethodPatch, "/todos/"+solo.ID, `{"title":"edited by bob"}`), http.StatusOK, "editor patch")
+	expect(do(bob, http.MethodPost, "/todos/"+solo.ID+"/items", `{"title":"step"}`), http.StatusCreated, "editor add item")
+	expect(do(bob, http.MethodDelete, "/todos/"+solo.ID, ""), http.StatusForbidden, "editor delete")
+	expect(do(bob, http.MethodPost, "/todos/"+solo.ID+"/grants", `{"subject":"carol","role":"viewer"}`), http.StatusForbidden, "editor share")
+	if w := do(alice, http.MethodGet, "/todos/"+solo.ID, ""); !strings.Contains(w.Body.String(), "edited by bob") {
+		t.Fatalf("owner does not see the editor's change: %s", w.Body)
+	}
+
+	// Grants do not reach across tenants.
+	expect(do(alice, http.MethodPost, "/todos/"+solo.ID+"/grants", `{"subject":"dave","role":"admin"}`), http.StatusCreated, "share with dave")
+	expect(do(dave, http.MethodGet, "/todos/"+solo.ID, ""), http.StatusNotFound, "other tenant")
+
+	// A list grant covers the list's todos, and todos added to the list
+	// belong to its owner.
+	expect(do(alice, http.MethodPost, "/lists/"+list.ID+"/grants", `{"subject":"carol","role":"admin"}`), http.StatusCreated, "share list")
+	if w := do(carol, http.MethodGet, "/lists/"+list.ID+"/todos", ""); !strings.Contains(w.Body.String(), inList.ID) {
+		t.Fatalf("list admin cannot see the list's todos: %s", w.Body)
+	}
+	expect(do(carol, http.MethodPatch, "/todos/"+inList.ID, `{"title":"edited by carol"}`), http.StatusOK, "list admin patch")
+	w = do(carol, http.MethodPost, "/todos", `{"title":"added by carol","list_id":"`+list.ID+`"}`)
+	expect(w, http.StatusCreated, "add to shared list")
+	var added Todo
+	_ = json.NewDecoder(w.Body).Decode(&added)
+	expect(do(alice, http.MethodGet, "/todos/"+added.ID, ""), http.StatusOK, "owner gets added todo")
+	expect(do(carol, http.MethodPost, "/lists/"+list.ID+"/grants", `{"subject":"bob","role":"admin"}`), http.StatusCreated, "list admin shares")
+	expect(do(bob, http.MethodPost, "/lists/"+list.ID+"/grants", `{"subject":"erin","role":"viewer"}`), http.StatusCreated, "second admin shares")
+	expect(do(bob, http.MethodGet, "/lists/"+list.ID+"/grants", ""), http.StatusOK, "admin lists grants")
+	expect(do(carol, http.MethodDelete, "/lists/"+list.ID+"/grants/bob", ""), http.StatusNoContent, "admin revokes")
+	expect(do(bob, http.MethodGet, "/lists/"+list.ID, ""), http.StatusNotFound, "revoked list")
+
+	// Subjects can give up their own grants.
+	expect(do(bob, http.MethodDelete, "/todos/"+solo.ID+"/grants/bob", ""), http.StatusNoContent, "leave")
+	expect(do(bob, http.MethodGet, "/todos/"+solo.ID, ""), http.StatusNotFound, "after leaving")
+	expect(do(bob, http.MethodDelete, "/todos/"+solo.ID+"/grants/dave", ""), http.StatusNotFound, "revoke without access")
+
+	// Grants go away with what they share.
+	expect(do(carol, http.MethodDelete, "/lists/"+list.ID+"?cascade=true", ""), http.StatusNoContent, "list admin deletes list")
+	expect(do(carol, http.MethodGet, "/todos/"+inList.ID, ""), http.StatusNotFound, "todo of deleted list")
+	if w := do(carol, http.MethodGet, "/shared", ""); w.Body.String() != "[]\n" {
+		t.Fatalf("expected no grants left, got %s", w.Body)
+	}
+}
@@ -0,0 +1,172 @@
+package todo
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jplaulau14/go-todo-api/internal/idempotency"
+	"github.com/jplaulau14/go-todo-api/internal/reqctx"
+)
+
+// maxIdempotencyKeyLen bounds the Idempotency-Key header.
+const maxIdempotencyKeyLen = 255
+
+// idempotencyLease is how long a request holds its Idempotency-Key while
+// it runs. It outlasts the server's write timeout, so a request that is
+// still running keeps its key, while a retry can take over the key of one
+// whose server stopped before recording an outcome.
+const idempotencyLease = time.Minute
+
+// replayedHeaders are the response headers saved with an idempotent
+// response and sent again when it is replayed.
+var replayedHeaders = []string{"Content-Type", "ETag", "Location"}
+
+// WithIdempotency makes POST /todos and POST /todos/bulk honour the
+// Idempotency-Key header, remembering responses in store for ttl.
+func (h *HTTPHandler) WithIdempotency(store idempotency.Store, ttl time.Duration) *HTTPHandler {
+	h.idem, h.idemTTL = store, ttl
+	return h
+}
+
+// idempotent runs next at most once per Idempotency-Key. A retry with the
+// same key and request gets the saved response with Idempotent-Replayed
+// set; reusing a key for a different request is rejected with 422. Only
+// successful responses are saved, so a failed request can be retried with
+// the same key. Requests without the header run normally.
+func (h *HTTPHandler) idempotent(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	key := r.Header.Get("Idempotency-Key")
+	if h.idem == nil || key == "" {
+		next(w, r)
+		return
+	}
+	if !validIdempotencyKey(key) {
+		writeError(w, r, http.StatusBadRequest, "Idempotency-Key must be 1 to 255 printable ASCII characters")
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, r, http.StatusRequestEntityTooLarge, "request body too large")
+			return
+		}
+		writeError(w, r, http.StatusBadRequest, "could not read request body")
+		return
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	fingerprint := requestFingerprint(r, body)
+	key = ownedIdempotencyKey(callerOf(r.Context()), key)
+
+	rec, started, err := h.idem.Begin(r.Context(), key, fingerprint, idempotencyLease)
+	if err != nil {
+		h.logger.Error("could not begin idempotent request", "error", err, "request_id", reqctx.GetRequestID(r.Context()))
+		writeError(w, r, http.StatusInternalServerError, "could not check idempotency key")
+		return
+	}
+	if !started {
+		switch {
+		case rec.Fingerprint != fingerprint:
+			writeError(w, r, http.StatusUnprocessableEntity, "Idempotency-Key was already used for a different request")
+		case rec.Pending():
+			writeError(w, r, http.StatusConflict, "a request with this Idempotency-Key is still in progress")
+		default:
+			for name, value := range rec.Header {
+				w.Header().Set(name, value)
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(rec.Status)
+			_, _ = w.Write(rec.Body)
+		}
+		return
+	}
+
+	// The outcome is recorded even if the client has gone away, so that
+	// its retry finds it. The key is released if next fails or panics.
+	ctx := context.WithoutCancel(r.Context())
+	rw := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+	saved := false
+	defer func() {
+		if saved {
+			return
+		}
+		if err := h.idem.Abort(ctx, rec); err != nil {
+			h.logger.Error("could not release idempotency key", "error", err, "request_id", reqctx.GetRequestID(r.Context()))
+		}
+	}()
+	next(rw, r)
+	if rw.status < 200 || rw.status >= 300 {
+		return
+	}
+	header := make(map[string]string)
+	for _, name := range replayedHeaders {
+		if v := rw.Header().Get(name); v != "" {
+			header[name] = v
+		}
+	}
+	if err := h.idem.Complete(ctx, rec, h.idemTTL, rw.status, header, rw.body.Bytes()); err != nil {
+		h.logger.Error("could not save idempotent response", "error", err, "request_id", reqctx.GetRequestID(r.Context()))
+		return
+	}
+	saved = true
+}
+
+func validIdempotencyKey(key string) bool {
+	if len(key) > maxIdempotencyKeyLen {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] < 0x20 || key[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// ownedIdempotencyKey qualifies key with its owner, so that callers cannot
+// replay each other's responses by reusing a key. Keys of the anonymous
+// owner are stored as they are.
+func ownedIdempotencyKey(o Owner, key string) string {
+	if o == (Owner{}) {
+		return key
+	}
+	sum := sha256.Sum256([]byte(o.TenantID + "\x00" + o.ID))
+	return hex.EncodeToString(sum[:8]) + ":" + key
+}
+
+// requestFingerprint identifies a request by method, path and body. A
+// trailing slash is ignored, since routes accept it as an alias.
+func requestFingerprint(r *http.Request, body []byte) string {
+	sum := sha256.New()
+	_, _ = io.WriteString(sum, r.Method+" "+strings.TrimSuffix(r.URL.Path, "/")+"\n")
+	_, _ = sum.Write(body)
+	return hex.EncodeToString(sum.Sum(nil))
+}
+
+// responseRecorder passes a response through while keeping a copy.
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (rw *responseRecorder) WriteHeader(status int) {
+	if !rw.wroteHeader {
+		rw.status, rw.wroteHeader = status, true
+	}
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *responseRecorder) Write(p []byte) (int, error) {
+	rw.wroteHeader = true
+	rw.body.Write(p)
+	return rw.ResponseWriter.Write(p)
+}
@@ -0,0 +1,375 @@
+package todo
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jplaulau14/go-todo-api/internal/reqctx"
+)
+
+const (
+	// DefaultImportMaxBytes bounds the body of POST /todos/import unless
+	// WithImportLimit says otherwise.
+	DefaultImportMaxBytes = 32 << 20
+	// maxImportRows bounds the rows of one import, and so the size of its
+	// report.
+	maxImportRows = 50000
+	// importTimeout is the read deadline granted for each read of an
+	// import's body and the write deadline granted for each row, since a
+	// large import may take longer than the server's ReadTimeout and
+	// WriteTimeout overall.
+	importTimeout = 30 * time.Second
+)
+
+// Import row statuses.
+const (
+	importCreated = "created"
+	importSkipped = "skipped"
+	importInvalid = "invalid"
+)
+
+// importRow is one todo to import: a CreateTodoRequest, optionally with
+// the id to create it under. The read-only members of an exported todo
+// are accepted and ignored so that exports can be imported as they are.
+type importRow struct {
+	ID string `json:"id"`
+	CreateTodoRequest
+	// Completed is shorthand for status done or open, as in updates.
+	Completed *bool `json:"completed"`
+
+	CompletedAt     json.RawMessage `json:"completed_at"`
+	Progress        json.RawMessage `json:"progress"`
+	RecurrenceStart json.RawMessage `json:"recurrence_start"`
+	DeletedAt       json.RawMessage `json:"deleted_at"`
+	CreatedAt       json.RawMessage `json:"created_at"`
+	UpdatedAt       json.RawMessage `json:"updated_at"`
+	Version         json.RawMessage `json:"version"`
+}
+
+// request validates the row by the rules of POST /todos and returns what
+// to create.
+func (row importRow) request() (CreateTodoRequest, error) {
+	if row.ID != "" {
+		if err := validateTodoID(row.ID); err != nil {
+			return CreateTodoRequest{}, err
+		}
+	}
+	req := row.CreateTodoRequest
+	if row.Completed != nil {
+		switch {
+		case req.Status == "" && *row.Completed:
+			req.Status = StatusDone
+		case req.Status != "" && req.Status.Valid() && *row.Completed != req.Status.Completed():
+			return CreateTodoRequest{}, errors.New("completed contradicts status")
+		}
+	}
+	if err := req.Validate(); err != nil {
+		return CreateTodoRequest{}, err
+	}
+	return req, nil
+}
+
+type importResult struct {
+	// Row is the 1-based position of the todo in the import, not counting
+	// a CSV header.
+	Row    int    `json:"row"`
+	Status string `json:"status"`
+	ID     string `json:"id,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+type importReport struct {
+	DryRun  bool           `json:"dry_run"`
+	Created int            `json:"created"`
+	Skipped int            `json:"skipped"`
+	Invalid int            `json:"invalid"`
+	Rows    []importResult `json:"rows"`
+}
+
+func (rep *importReport) add(res importResult) {
+	switch res.Status {
+	case importCreated:
+		rep.Created++
+	case importSkipped:
+		rep.Skipped++
+	case importInvalid:
+		rep.Invalid++
+	}
+	rep.Rows = append(rep.Rows, res)
+}
+
+// importEntry is a decoded row awaiting creation; err is set for rows
+// that are invalid.
+type importEntry struct {
+	row importRow
+	err error
+}
+
+var (
+	// errImportSyntax wraps failures to read the body as a whole, as
+	// opposed to a single invalid row.
+	errImportSyntax = errors.New("malformed import")
+	errImportRows   = fmt.Errorf("at most %d rows can be imported at once", maxImportRows)
+)
+
+// decodeImport reads every row of body, which is CSV with a header row of
+// todo members (as written by a CSV export), NDJSON or a JSON array of
+// todos. Rows are decoded and validated one at a time as the body streams
+// in; a row that is not a valid todo is recorded rather than failing the
+// import.
+func decodeImport(body io.Reader, mediaType string) ([]importEntry, error) {
+	var entries []importEntry
+	add := func(raw json.RawMessage) error {
+		if len(entries) == maxImportRows {
+			return errImportRows
+		}
+		var e importEntry
+		if e.err = decodeStrict(raw, &e.row); e.err == nil {
+			e.row.CreateTodoRequest, e.err = e.row.request()
+		}
+		entries = append(entries, e)
+		return nil
+	}
+
+	switch mediaType {
+	case csvType:
+		cr := csv.NewReader(body)
+		header, err := cr.Read()
+		if err != nil {
+			return nil, fmt.Errorf("%w: could not read CSV header: %w", errImportSyntax, err)
+		}
+		// Spreadsheets often start UTF-8 files with a byte order mark.
+		header[0] = strings.TrimPrefix(header[0], "\ufeff")
+		for _, col := range header {
+			if !slices.Contains(todoFields, col) {
+				return nil, fmt.Errorf("%w: unknown CSV column %q", errImportSyntax, col)
+			}
+		}
+		for {
+			record, err := cr.Read()
+			if err == io.EOF {
+				return entries, nil
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%w: %w", errImportSyntax, err)
+			}
+			raw, _ := json.Marshal(csvRow(header, record))
+			if err := add(raw); err != nil {
+				return nil, err
+			}
+		}
+	case ndjsonType, jsonType:
+		dec := json.NewDecoder(body)
+		if mediaType == jsonType {
+			tok, err := dec.Token()
+			if err != nil {
+				return nil, fmt.Errorf("%w: %w", errImportSyntax, err)
+			}
+			if tok != json.Delim('[') {
+				return nil, fmt.Errorf("%w: expected a JSON array of todos", errImportSyntax)
+			}
+		}
+		for dec.More() {
+			var raw json.RawMessage
+			if err := dec.Decode(&raw); err != nil {
+				return nil, fmt.Errorf("%w: row %d: %w", errImportSyntax, len(entries)+1, err)
+			}
+			if err := add(raw); err != nil {
+				return nil, err
+			}
+		}
+		if mediaType == jsonType {
+			if _, err := dec.Token(); err != nil {
+				return nil, fmt.Errorf("%w: %w", errImportSyntax, err)
+			}
+		}
+		if _, err := dec.Token(); err != io.EOF {
+			return nil, fmt.Errorf("%w: unexpected data after the todos", errImportSyntax)
+		}
+		return entries, nil
+	default:
+		return nil, fmt.Errorf("unsupported import type %s", mediaType)
+	}
+}
+
+// csvRow turns a CSV record into the JSON object of a todo. Empty cells are
+// left out, tags are split on ";", and the quote an export puts before
+// formula-like text is removed.
+func csvRow(header, record []string) map[string]any {
+	obj := make(map[string]any, len(header))
+	for i, col := range header {
+		cell := record[i]
+		if cell == "" {
+			continue
+		}
+		switch col {
+		case "tags":
+			var tags []string
+			for _, tag := range strings.Split(cell, ";") {
+				if tag = strings.TrimSpace(unquoteCell(tag)); tag != "" {
+					tags = append(tags, tag)
+				}
+			}
+			obj[col] = tags
+		case "completed":
+			if b, err := strconv.ParseBool(cell); err == nil {
+				obj[col] = b
+			} else {
+				obj[col] = cell
+			}
+		default:
+			obj[col] = unquoteCell(cell)
+		}
+	}
+	return obj
+}
+
+// unquoteCell reverses the formula escaping of csvCell.
+func unquoteCell(cell string) string {
+	if len(cell) > 1 && cell[0] == '\'' && strings.ContainsRune("=+-@\t\r", rune(cell[1])) {
+		return cell[1:]
+	}
+	return cell
+}
+
+// importTodos creates todos from a CSV, NDJSON or JSON array body and
+// reports the outcome of every row. Rows are validated like POST /todos.
+// A row with an id is created under it, or skipped if a todo, or an
+// earlier row, already has it, so that an import can be re-run. With
+// ?dry_run=true nothing is written.
+func (h *HTTPHandler) importTodos(w http.ResponseWriter, r *http.Request) {
+	dryRun := false
+	if v := r.URL.Query().Get("dry_run"); v != "" {
+		var err error
+		if dryRun, err = strconv.ParseBool(v); err != nil {
+			writeError(w, r, http.StatusBadRequest, "dry_run must be true or false")
+			return
+		}
+	}
+	mt := mediaType(r)
+	if mt != jsonType && mt != csvType && mt != ndjsonType {
+		writeError(w, r, http.StatusUnsupportedMediaType, "content-type must be text/csv, application/x-ndjson or application/json")
+		return
+	}
+	rc := http.NewResponseController(w)
+	body := deadlineReader{r: http.MaxBytesReader(w, r.Body, h.importMaxBytes), rc: rc}
+	entries, err := decodeImport(body, mt)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		switch {
+		case errors.As(err, &tooLarge):
+			writeError(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("import is larger than %d bytes", h.importMaxBytes))
+			return
+		case errors.Is(err, errImportRows):
+			writeError(w, r, http.StatusRequestEntityTooLarge, err.Error())
+			return
+		}
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	report := importReport{DryRun: dryRun, Rows: []importResult{}}
+	seen := map[string]bool{}
+	lists := map[string]bool{}
+	for i, e := range entries {
+		_ = rc.SetWriteDeadline(time.Now().Add(importTimeout))
+		res := importResult{Row: i + 1, ID: e.row.ID}
+		switch {
+		case e.err != nil:
+			res.Status, res.Error = importInvalid, e.err.Error()
+		case e.row.ID != "" && seen[e.row.ID]:
+			res.Status, res.Error = importSkipped, "id is repeated in the import"
+		default:
+			if e.row.ID != "" {
+				seen[e.row.ID] = true
+			}
+			if res, err = h.importOne(r, res, e.row, dryRun, lists); err != nil {
+				h.logger.Error("could not import todo", "row", res.Row, "error", err, "request_id", reqctx.GetRequestID(r.Context()))
+				writeError(w, r, http.StatusInternalServerError, "could not import")
+				return
+			}
+		}
+		report.add(res)
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+// deadlineReader extends the read and write deadlines before each read of
+// an import's body, so that an upload is only cut off when the client
+// stalls.
+type deadlineReader struct {
+	r  io.Reader
+	rc *http.ResponseController
+}
+
+func (d deadlineReader) Read(p []byte) (int, error) {
+	deadline := time.Now().Add(importTimeout)
+	_ = d.rc.SetReadDeadline(deadline)
+	_ = d.rc.SetWriteDeadline(deadline)
+	return d.r.Read(p)
+}
+
+// importOne creates a valid row, or in a dry run checks what creating it
+// would find. Only unexpected failures are returned as errors. A dry run
+// cannot see ids held by trashed todos, which a real import skips.
+func (h *HTTPHandler) importOne(r *http.Request, res importResult, row importRow, dryRun bool, lists map[string]bool) (importResult, error) {
+	ctx := r.Context()
+	if dryRun {
+		if row.ID != "" {
+			_, err := h.repo.Get(ctx, row.ID)
+			if err == nil {
+				res.Status, res.Error = importSkipped, "a todo with this id already exists"
+				return res, nil
+			}
+			if !errors.Is(err, ErrNotFound) {
+				return res, err
+			}
+		}
+		if row.ListID != nil && h.lists != nil {
+			found, checked := lists[*row.ListID]
+			if !checked {
+				_, err := h.lists.GetList(ctx, *row.ListID)
+				if err != nil && !errors.Is(err, ErrListNotFound) {
+					return res, err
+				}
+				found = err == nil
+				lists[*row.ListID] = found
+			}
+			if !found {
+				res.Status, res.Error = importInvalid, "list not found"
+				return res, nil
+			}
+		}
+		res.Status = importCreated
+		return res, nil
+	}
+
+	// Create never replaces a todo, so a row whose id is taken, even by a
+	// request racing the import, is skipped.
+	req := row.CreateTodoRequest
+	req.ID = row.ID
+	t, err := h.repo.Create(ctx, req)
+	switch {
+	case errors.Is(err, ErrTodoExists):
+		res.Status, res.Error = importSkipped, "a todo with this id already exists"
+	case errors.Is(err, ErrTodoInTrash), errors.Is(err, ErrNotFound):
+		// Create reports another owner's id as not found; it is skipped
+		// like one in the trash, so the two cannot be told apart.
+		res.Status, res.Error = importSkipped, idUnavailable
+	case errors.Is(err, ErrListNotFound):
+		res.Status, res.Error = importInvalid, "list not found"
+	case err != nil:
+		return res, err
+	default:
+		res.Status, res.ID = importCreated, t.ID
+	}
+	return res, nil
+}
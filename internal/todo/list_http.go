@@ -0,0 +1,178 @@
+package todo
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/jplaulau14/go-todo-api/internal/policy"
+	"github.com/jplaulau14/go-todo-api/internal/reqctx"
+)
+
+func (h *HTTPHandler) registerListRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/lists", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			h.listLists(w, r)
+		case http.MethodPost:
+			h.createList(w, r)
+		default:
+			writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	})
+	mux.HandleFunc("/lists/", func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/lists/"), "/")
+		id, sub, _ := strings.Cut(path, "/")
+		switch {
+		case id == "":
+			switch r.Method {
+			case http.MethodGet:
+				h.listLists(w, r)
+			case http.MethodPost:
+				h.createList(w, r)
+			default:
+				writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+			}
+		case sub == "":
+			switch r.Method {
+			case http.MethodGet:
+				h.getList(w, r, id)
+			case http.MethodPatch:
+				h.updateList(w, r, id)
+			case http.MethodDelete:
+				h.deleteList(w, r, id)
+			default:
+				writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+			}
+		case sub == "todos":
+			if r.Method != http.MethodGet {
+				writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+				return
+			}
+			h.listTodosInList(w, r, id)
+		case h.grants != nil && (sub == "grants" || strings.HasPrefix(sub, "grants/")):
+			h.routeGrants(w, r, ResourceList, id, strings.TrimPrefix(strings.TrimPrefix(sub, "grants"), "/"))
+		default:
+			writeError(w, r, http.StatusNotFound, "route not found")
+		}
+	})
+}
+
+// writeListError maps list repository errors onto responses.
+func (h *HTTPHandler) writeListError(w http.ResponseWriter, r *http.Request, err error, action string) {
+	switch {
+	case errors.Is(err, ErrListNotFound):
+		writeError(w, r, http.StatusNotFound, "list not found")
+	case errors.Is(err, ErrListNotEmpty):
+		writeError(w, r, http.StatusConflict, "list is not empty; pass cascade=true to move its todos to the trash")
+	default:
+		h.logger.Error("could not "+action+" list", "error", err, "request_id", reqctx.GetRequestID(r.Context()))
+		writeError(w, r, http.StatusInternalServerError, "could not "+action+" list")
+	}
+}
+
+func (h *HTTPHandler) createList(w http.ResponseWriter, r *http.Request) {
+	var req CreateListRequest
+	if !h.decodeJSON(w, r, &req) {
+		return
+	}
+	if err := req.Validate(); err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	l, err := h.lists.CreateList(r.Context(), req)
+	if err != nil {
+		h.writeListError(w, r, err, "create")
+		return
+	}
+	writeJSON(w, http.StatusCreated, l)
+}
+
+func (h *HTTPHandler) listLists(w http.ResponseWriter, r *http.Request) {
+	opts, err := parseListOptions(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	lists, err := h.lists.Lists(r.Context(), opts.Limit, opts.Offset)
+	if err != nil {
+		h.writeListError(w, r, err, "list")
+		return
+	}
+	writeJSON(w, http.StatusOK, lists)
+}
+
+func (h *HTTPHandler) getList(w http.ResponseWriter, r *http.Request, id string) {
+	r, ok := h.authorize(w, r, ResourceList, id, policy.Read)
+	if !ok {
+		return
+	}
+	l, err := h.lists.GetList(r.Context(), id)
+	if err != nil {
+		h.writeListError(w, r, err, "get")
+		return
+	}
+	writeJSON(w, http.StatusOK, l)
+}
+
+func (h *HTTPHandler) updateList(w http.ResponseWriter, r *http.Request, id string) {
+	r, ok := h.authorize(w, r, ResourceList, id, policy.Edit)
+	if !ok {
+		return
+	}
+	var req UpdateListRequest
+	if !h.decodeJSON(w, r, &req) {
+		return
+	}
+	if err := req.Validate(); err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	l, err := h.lists.UpdateList(r.Context(), id, req)
+	if err != nil {
+		h.writeListError(w, r, err, "update")
+		return
+	}
+	writeJSON(w, http.StatusOK, l)
+}
+
+func (h *HTTPHandler) deleteList(w http.ResponseWriter, r *http.Request, id string) {
+	r, ok := h.authorize(w, r, ResourceList, id, policy.Delete)
+	if !ok {
+		return
+	}
+	cascade := false
+	if v := r.URL.Query().Get("cascade"); v != "" {
+		var err error
+		if cascade, err = strconv.ParseBool(v); err != nil {
+			writeError(w, r, http.StatusBadRequest, "cascade must be true or false")
+			return
+		}
+	}
+	if err := h.lists.DeleteList(r.Context(), id, cascade); err != nil {
+		h.writeListError(w, r, err, "delete")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// listTodosInList serves GET /lists/{id}/todos with the same query
+// parameters as GET /todos.
+func (h *HTTPHandler) listTodosInList(w http.ResponseWriter, r *http.Request, id string) {
+	r, ok := h.authorize(w, r, ResourceList, id, policy.Read)
+	if !ok {
+		return
+	}
+	if _, err := h.lists.GetList(r.Context(), id); err != nil {
+		h.writeListError(w, r, err, "get")
+		return
+	}
+	opts, err := parseListOptions(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	opts.ListID = &id
+	h.writeList(w, r, opts)
+}
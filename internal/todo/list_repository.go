@@ -0,0 +1,137 @@
+package todo
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrListNotFound = errors.New("list not found")
+	ErrListNotEmpty = errors.New("list is not empty")
+)
+
+// ListRepository stores the lists todos can be grouped into. Both
+// implementations live on the same types as Repository so that deleting a
+// list can cascade to its todos atomically.
+type ListRepository interface {
+	CreateList(ctx context.Context, req CreateListRequest) (TodoList, error)
+	GetList(ctx context.Context, id string) (TodoList, error)
+	// Lists returns lists ordered by name.
+	Lists(ctx context.Context, limit, offset int) ([]TodoList, error)
+	UpdateList(ctx context.Context, id string, update UpdateListRequest) (TodoList, error)
+	// DeleteList removes a list. If the list still holds todos it fails
+	// with ErrListNotEmpty unless cascade is set, in which case the todos
+	// are moved to the trash with it. Trashed todos do not keep a list
+	// alive; they are detached from it so they can still be restored.
+	DeleteList(ctx context.Context, id string, cascade bool) error
+}
+
+func (r *InMemoryRepository) CreateList(ctx context.Context, req CreateListRequest) (TodoList, error) {
+	now := time.Now().UTC()
+	l := TodoList{
+		ID:        uuid.NewString(),
+		Name:      strings.TrimSpace(req.Name),
+		CreatedAt: now,
+		UpdatedAt: now,
+		owner:     OwnerOf(ctx),
+	}
+	r.mu.Lock()
+	r.lists[l.ID] = l
+	r.mu.Unlock()
+	return l, nil
+}
+
+func (r *InMemoryRepository) GetList(ctx context.Context, id string) (TodoList, error) {
+	r.mu.RLock()
+	l, ok := r.list(OwnerOf(ctx), id)
+	r.mu.RUnlock()
+	if !ok {
+		return TodoList{}, ErrListNotFound
+	}
+	return l, nil
+}
+
+// list returns the list with id if o owns it. The caller must hold r.mu.
+func (r *InMemoryRepository) list(o Owner, id string) (TodoList, bool) {
+	l, ok := r.lists[id]
+	if !ok || l.owner != o {
+		return TodoList{}, false
+	}
+	return l, true
+}
+
+func (r *InMemoryRepository) Lists(ctx context.Context, limit, offset int) ([]TodoList, error) {
+	o := OwnerOf(ctx)
+	r.mu.RLock()
+	all := make([]TodoList, 0, len(r.lists))
+	for _, l := range r.lists {
+		if l.owner == o {
+			all = append(all, l)
+		}
+	}
+	r.mu.RUnlock()
+
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].Name != all[j].Name {
+			return all[i].Name < all[j].Name
+		}
+		return all[i].ID < all[j].ID
+	})
+	return paginate(all, limit, offset), nil
+}
+
+func (r *InMemoryRepository) UpdateList(ctx context.Context, id string, update UpdateListRequest) (TodoList, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	l, ok := r.list(OwnerOf(ctx), id)
+	if !ok {
+		return TodoList{}, ErrListNotFound
+	}
+	if update.Name != nil {
+		l.Name = strings.TrimSpace(*update.Name)
+	}
+	l.UpdatedAt = time.Now().UTC()
+	r.lists[id] = l
+	return l, nil
+}
+
+func (r *InMemoryRepository) DeleteList(ctx context.Context, id string, cascade bool) error {
+	o := OwnerOf(ctx)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.list(o, id); !ok {
+		return ErrListNotFound
+	}
+	var members []string
+	for tid, t := range r.store {
+		if t.owner == o && t.ListID != nil && *t.ListID == id {
+			members = append(members, tid)
+		}
+	}
+	if !cascade {
+		for _, tid := range members {
+			if r.store[tid].DeletedAt == nil {
+				return ErrListNotEmpty
+			}
+		}
+	}
+	// Todos outlive their list in the trash so they can still be restored.
+	now := time.Now().UTC()
+	for _, tid := range members {
+		t := r.store[tid]
+		t.ListID = nil
+		if t.DeletedAt == nil {
+			t.DeletedAt = &now
+		}
+		t.Version++
+		r.store[tid] = t
+	}
+	delete(r.lists, id)
+	r.dropGrants(ResourceList, id)
+	return nil
+}
@@ -1,22 +1,539 @@
 package todo
 
 import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/jplaulau14/go-todo-api/internal/policy"
+	"github.com/jplaulau14/go-todo-api/internal/recurrence"
+)
+
+type Priority string
+
+const (
+	PriorityLow    Priority = "low"
+	PriorityNormal Priority = "normal"
+	PriorityHigh   Priority = "high"
+	PriorityUrgent Priority = "urgent"
 )
 
+func (p Priority) Valid() bool {
+	switch p {
+	case PriorityLow, PriorityNormal, PriorityHigh, PriorityUrgent:
+		return true
+	}
+	return false
+}
+
+// Status is a todo's place in its lifecycle. Completed todos are done or
+// archived; archiving takes finished work out of default listings.
+type Status string
+
+const (
+	StatusOpen       Status = "open"
+	StatusInProgress Status = "in_progress"
+	StatusDone       Status = "done"
+	StatusArchived   Status = "archived"
+)
+
+// ErrInvalidTransition is returned when a todo cannot move from its current
+// status to the requested one.
+var ErrInvalidTransition = errors.New("invalid status transition")
+
+// transitions lists the statuses each status may move to. Only done todos
+// can be archived, and archived todos can only be brought back to done.
+var transitions = map[Status][]Status{
+	StatusOpen:       {StatusInProgress, StatusDone},
+	StatusInProgress: {StatusOpen, StatusDone},
+	StatusDone:       {StatusOpen, StatusInProgress, StatusArchived},
+	StatusArchived:   {StatusDone},
+}
+
+func (s Status) Valid() bool {
+	_, ok := transitions[s]
+	return ok
+}
+
+// Completed reports whether todos in this status count as completed.
+func (s Status) Completed() bool {
+	return s == StatusDone || s == StatusArchived
+}
+
+// CanTransition reports whether s may move to next. Staying put is always
+// allowed.
+func (s Status) CanTransition(next Status) bool {
+	return s == next || slices.Contains(transitions[s], next)
+}
+
 type Todo struct {
+	ID          string  `json:"id"`
+	Title       string  `json:"title"`
+	Description *string `json:"description,omitempty"`
+	// Completed mirrors Status and is kept for clients that predate it.
+	Completed bool   `json:"completed"`
+	Status    Status `json:"status"`
+	// CompletedAt is when the todo was last marked done.
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	Priority    Priority   `json:"priority"`
+	DueAt       *time.Time `json:"due_at,omitempty"`
+	Tags        []string   `json:"tags"`
+	ListID      *string    `json:"list_id,omitempty"`
+	Progress    Progress   `json:"progress"`
+	// Recurrence is an RRULE; completing the todo creates the next
+	// occurrence. RecurrenceStart is the due date of the first occurrence,
+	// which COUNT and INTERVAL are measured from.
+	Recurrence      *string    `json:"recurrence,omitempty"`
+	RecurrenceStart *time.Time `json:"recurrence_start,omitempty"`
+	// DeletedAt is set while the todo is in the trash.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	// Version starts at 1 and increases with every change. It is the ETag
+	// used for optimistic concurrency.
+	Version int64 `json:"version"`
+
+	// owner is kept by InMemoryRepository; Postgres stores it in columns.
+	owner Owner
+	// nextID is the occurrence created when the todo was first completed.
+	// It is not part of the API; it stops a reopened todo from advancing
+	// its series again.
+	nextID string
+}
+
+type CreateTodoRequest struct {
+	Title       string  `json:"title"`
+	Description *string `json:"description"`
+	// Status defaults to open; todos cannot be created archived.
+	Status     Status     `json:"status"`
+	Priority   Priority   `json:"priority"`
+	DueAt      *time.Time `json:"due_at"`
+	Tags       []string   `json:"tags"`
+	ListID     *string    `json:"list_id"`
+	Recurrence *string    `json:"recurrence"`
+	// RecurrenceStart is only set internally when spawning the next
+	// occurrence of a series; it defaults to DueAt.
+	RecurrenceStart *time.Time `json:"-"`
+	// ID is only set internally, by imports, to create the todo under an
+	// id the client chose; it defaults to a new UUID.
+	ID string `json:"-"`
+}
+
+// Validate checks the request and fills in defaults.
+func (req *CreateTodoRequest) Validate() error {
+	return req.validate(false)
+}
+
+// validate is Validate, optionally allowing the archived status for
+// requests that replace an existing todo.
+func (req *CreateTodoRequest) validate(allowArchived bool) error {
+	if req.Title == "" {
+		return errors.New("title is required")
+	}
+	tags, err := NormalizeTags(req.Tags)
+	if err != nil {
+		return err
+	}
+	req.Tags = tags
+	if req.Recurrence != nil && *req.Recurrence == "" {
+		req.Recurrence = nil
+	}
+	if req.Recurrence != nil {
+		if req.DueAt == nil {
+			return errors.New("recurrence requires due_at")
+		}
+		rule, err := normalizeRecurrence(*req.Recurrence)
+		if err != nil {
+			return err
+		}
+		req.Recurrence = &rule
+	}
+	if req.Priority == "" {
+		req.Priority = PriorityNormal
+	}
+	if !req.Priority.Valid() {
+		return errors.New("priority must be one of low, normal, high, urgent")
+	}
+	if req.Status == "" {
+		req.Status = StatusOpen
+	}
+	if allowArchived && !req.Status.Valid() {
+		return errors.New("status must be one of open, in_progress, done, archived")
+	}
+	if !allowArchived && (!req.Status.Valid() || req.Status == StatusArchived) {
+		return errors.New("status must be one of open, in_progress, done")
+	}
+	return nil
+}
+
+type UpdateTodoRequest struct {
+	Title       *string `json:"title"`
+	Description *string `json:"description"`
+	// Completed is shorthand for moving to done, or back to open.
+	Completed *bool      `json:"completed"`
+	Status    *Status    `json:"status"`
+	Priority  *Priority  `json:"priority"`
+	DueAt     *time.Time `json:"due_at"`
+	// Tags, when present, replaces the whole tag set.
+	Tags *[]string `json:"tags"`
+	// ListID moves the todo to another list; an empty string detaches it.
+	ListID *string `json:"list_id"`
+	// Recurrence replaces the RRULE and starts a new series at the due
+	// date; an empty string stops the todo from repeating.
+	Recurrence *string `json:"recurrence"`
+}
+
+// Validate checks the request and normalizes tags in place.
+func (req *UpdateTodoRequest) Validate() error {
+	if req.Title != nil && *req.Title == "" {
+		return errors.New("title cannot be empty")
+	}
+	if req.Tags != nil {
+		tags, err := NormalizeTags(*req.Tags)
+		if err != nil {
+			return err
+		}
+		req.Tags = &tags
+	}
+	if req.Priority != nil && !req.Priority.Valid() {
+		return errors.New("priority must be one of low, normal, high, urgent")
+	}
+	if req.Status != nil {
+		if !req.Status.Valid() {
+			return errors.New("status must be one of open, in_progress, done, archived")
+		}
+		if req.Completed != nil && *req.Completed != req.Status.Completed() {
+			return errors.New("completed contradicts status")
+		}
+	}
+	if req.Recurrence != nil && *req.Recurrence != "" {
+		rule, err := normalizeRecurrence(*req.Recurrence)
+		if err != nil {
+			return err
+		}
+		req.Recurrence = &rule
+	}
+	return nil
+}
+
+// ValidateFor checks rules that depend on the todo being updated.
+func (req UpdateTodoRequest) ValidateFor(current Todo) error {
+	if req.Recurrence != nil && *req.Recurrence != "" && req.DueAt == nil && current.DueAt == nil {
+		return errors.New("recurrence requires due_at")
+	}
+	return nil
+}
+
+func normalizeRecurrence(s string) (string, error) {
+	rule, err := recurrence.Parse(s)
+	if err != nil {
+		return "", fmt.Errorf("invalid recurrence: %w", err)
+	}
+	return rule.String(), nil
+}
+
+// apply copies the fields set in update onto t. It fails with
+// ErrInvalidTransition, leaving t untouched, if the status change is not
+// allowed.
+func (update UpdateTodoRequest) apply(t *Todo, now time.Time) error {
+	next := t.Status
+	switch {
+	case update.Status != nil:
+		next = *update.Status
+	case update.Completed != nil && *update.Completed != t.Status.Completed():
+		next = StatusOpen
+		if *update.Completed {
+			next = StatusDone
+		}
+	}
+	if !t.Status.CanTransition(next) {
+		return fmt.Errorf("%w from %s to %s", ErrInvalidTransition, t.Status, next)
+	}
+	t.setStatus(next, now)
+	if update.Title != nil {
+		t.Title = *update.Title
+	}
+	if update.Description != nil {
+		t.Description = update.Description
+	}
+	if update.Priority != nil {
+		t.Priority = *update.Priority
+	}
+	if update.DueAt != nil {
+		due := update.DueAt.UTC()
+		t.DueAt = &due
+	}
+	if update.Tags != nil {
+		t.Tags = append([]string{}, *update.Tags...)
+	}
+	if update.Recurrence != nil {
+		if *update.Recurrence == "" {
+			t.Recurrence, t.RecurrenceStart = nil, nil
+		} else {
+			rule := *update.Recurrence
+			t.Recurrence, t.RecurrenceStart = &rule, t.DueAt
+		}
+	}
+	if update.ListID != nil {
+		if *update.ListID == "" {
+			t.ListID = nil
+		} else {
+			listID := *update.ListID
+			t.ListID = &listID
+		}
+	}
+	return nil
+}
+
+// replace returns t with every field a client controls taken from req, as
+// a full replacement requires. The ID, creation time, version and checklist
+// progress are kept, as is the start of the recurrence series when the rule
+// is unchanged. It fails with ErrInvalidTransition if the status change is
+// not allowed.
+func (t Todo) replace(req CreateTodoRequest, now time.Time) (Todo, error) {
+	if !t.Status.CanTransition(req.Status) {
+		return Todo{}, fmt.Errorf("%w from %s to %s", ErrInvalidTransition, t.Status, req.Status)
+	}
+	next := newTodo(req, now)
+	next.ID, next.CreatedAt, next.Version, next.Progress, next.owner, next.nextID = t.ID, t.CreatedAt, t.Version, t.Progress, t.owner, t.nextID
+	next.Status, next.Completed, next.CompletedAt = t.Status, t.Completed, t.CompletedAt
+	next.setStatus(req.Status, now)
+	if next.Recurrence != nil && t.Recurrence != nil && *next.Recurrence == *t.Recurrence && t.RecurrenceStart != nil {
+		next.RecurrenceStart = t.RecurrenceStart
+	}
+	next.touch(now)
+	return next, nil
+}
+
+// setStatus moves t to status, keeping Completed and CompletedAt in step.
+// The caller checks that the transition is allowed.
+func (t *Todo) setStatus(status Status, now time.Time) {
+	if status == t.Status {
+		return
+	}
+	if !status.Completed() {
+		t.CompletedAt = nil
+	} else if !t.Status.Completed() {
+		t.CompletedAt = &now
+	}
+	t.Status, t.Completed = status, status.Completed()
+}
+
+const maxTagLength = 64
+
+// NormalizeTags lowercases and trims tags, drops duplicates and returns
+// them sorted. Tags must be non-empty, at most 64 characters and must not
+// contain whitespace, commas or slashes (they appear in URL paths).
+func NormalizeTags(in []string) ([]string, error) {
+	out := make([]string, 0, len(in))
+	seen := make(map[string]bool, len(in))
+	for _, raw := range in {
+		tag := strings.ToLower(strings.TrimSpace(raw))
+		if tag == "" {
+			return nil, errors.New("tags cannot be empty")
+		}
+		if utf8.RuneCountInString(tag) > maxTagLength {
+			return nil, errors.New("tags must be at most 64 characters")
+		}
+		if strings.ContainsFunc(tag, func(r rune) bool { return unicode.IsSpace(r) || r == ',' || r == '/' }) {
+			return nil, errors.New("tags cannot contain whitespace, commas or slashes")
+		}
+		if seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		out = append(out, tag)
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// ArchiveCompletedRequest is the body of POST /todos/archive.
+type ArchiveCompletedRequest struct {
+	// OlderThanDays archives done todos completed at least this many days
+	// ago; zero archives every done todo.
+	OlderThanDays *int `json:"older_than_days"`
+}
+
+func (req ArchiveCompletedRequest) Validate() error {
+	if req.OlderThanDays == nil {
+		return errors.New("older_than_days is required")
+	}
+	if *req.OlderThanDays < 0 {
+		return errors.New("older_than_days cannot be negative")
+	}
+	return nil
+}
+
+// TagCount is an entry in GET /tags.
+type TagCount struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+type AddTagsRequest struct {
+	Tags []string `json:"tags"`
+}
+
+// Progress summarizes a todo's checklist.
+type Progress struct {
+	Done  int `json:"done"`
+	Total int `json:"total"`
+}
+
+// ChecklistItem is a step inside a todo, exposed under /todos/{id}/items.
+type ChecklistItem struct {
 	ID        string    `json:"id"`
+	TodoID    string    `json:"todo_id"`
 	Title     string    `json:"title"`
-	Completed bool      `json:"completed"`
+	Done      bool      `json:"done"`
+	Position  int       `json:"position"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
 
-type CreateTodoRequest struct {
+type CreateItemRequest struct {
 	Title string `json:"title"`
+	// Position defaults to the end of the checklist.
+	Position *int `json:"position"`
 }
 
-type UpdateTodoRequest struct {
-	Title     *string `json:"title"`
-	Completed *bool   `json:"completed"`
+func (req CreateItemRequest) Validate() error {
+	if req.Title == "" {
+		return errors.New("title is required")
+	}
+	if req.Position != nil && *req.Position < 0 {
+		return errors.New("position cannot be negative")
+	}
+	return nil
+}
+
+type UpdateItemRequest struct {
+	Title    *string `json:"title"`
+	Done     *bool   `json:"done"`
+	Position *int    `json:"position"`
+}
+
+func (req UpdateItemRequest) Validate() error {
+	if req.Title != nil && *req.Title == "" {
+		return errors.New("title cannot be empty")
+	}
+	if req.Position != nil && *req.Position < 0 {
+		return errors.New("position cannot be negative")
+	}
+	return nil
+}
+
+func (update UpdateItemRequest) apply(it *ChecklistItem) {
+	if update.Title != nil {
+		it.Title = *update.Title
+	}
+	if update.Done != nil {
+		it.Done = *update.Done
+	}
+	if update.Position != nil {
+		it.Position = *update.Position
+	}
+}
+
+// TodoList is a named group of todos, exposed under /lists.
+type TodoList struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	owner Owner
+}
+
+type CreateListRequest struct {
+	Name string `json:"name"`
+}
+
+func (req CreateListRequest) Validate() error {
+	if strings.TrimSpace(req.Name) == "" {
+		return errors.New("name is required")
+	}
+	return nil
+}
+
+type UpdateListRequest struct {
+	Name *string `json:"name"`
+}
+
+func (req UpdateListRequest) Validate() error {
+	if req.Name != nil && strings.TrimSpace(*req.Name) == "" {
+		return errors.New("name cannot be empty")
+	}
+	return nil
+}
+
+// ResourceType names what a grant shares.
+type ResourceType string
+
+const (
+	ResourceTodo ResourceType = "todo"
+	ResourceList ResourceType = "list"
+)
+
+// Grant gives Subject a role on a todo or list of another owner in the
+// same tenant. A grant on a list covers the todos in it.
+type Grant struct {
+	ResourceType ResourceType `json:"resource_type"`
+	ResourceID   string       `json:"resource_id"`
+	Subject      string       `json:"subject"`
+	Role         policy.Role  `json:"role"`
+	GrantedBy    string       `json:"granted_by"`
+	CreatedAt    time.Time    `json:"created_at"`
+	UpdatedAt    time.Time    `json:"updated_at"`
+
+	owner Owner
+}
+
+const maxSubjectLength = 255
+
+type CreateGrantRequest struct {
+	Subject string      `json:"subject"`
+	Role    policy.Role `json:"role"`
+}
+
+func (req CreateGrantRequest) Validate() error {
+	if req.Subject == "" {
+		return errors.New("subject is required")
+	}
+	if len(req.Subject) > maxSubjectLength {
+		return errors.New("subject must be at most 255 characters")
+	}
+	return validateRole(req.Role)
+}
+
+type UpdateGrantRequest struct {
+	Role policy.Role `json:"role"`
+}
+
+func (req UpdateGrantRequest) Validate() error {
+	return validateRole(req.Role)
+}
+
+func validateRole(r policy.Role) error {
+	if !r.Grantable() {
+		return errors.New("role must be one of viewer, editor, admin")
+	}
+	return nil
+}
+
+// Page is the envelope returned by GET /todos?envelope=true. Next and Prev
+// are relative URLs and are null when there is no such page.
+type Page struct {
+	Items  []Todo  `json:"items"`
+	Total  int     `json:"total"`
+	Limit  int     `json:"limit"`
+	Offset int     `json:"offset"`
+	Next   *string `json:"next"`
+	Prev   *string `json:"prev"`
 }
@@ -0,0 +1,40 @@
+package todo
+
+import (
+	"context"
+
+	"github.com/jplaulau14/go-todo-api/internal/reqctx"
+)
+
+// Owner is who a todo or list belongs to. Repositories scope every call to
+// the owner of its context, so callers only ever see their own data; data
+// of other owners, in their tenant or any other, is reported as not found.
+type Owner struct {
+	ID       string
+	TenantID string
+}
+
+type actingKey struct{}
+
+// OwnerOf returns the owner of ctx: the owner of a shared todo or list the
+// caller has been authorized to act on, or else the caller itself.
+func OwnerOf(ctx context.Context) Owner {
+	if o, ok := ctx.Value(actingKey{}).(Owner); ok {
+		return o
+	}
+	return callerOf(ctx)
+}
+
+// callerOf returns the authenticated reqctx.Principal of ctx as an owner,
+// or the anonymous owner when authentication is off.
+func callerOf(ctx context.Context) Owner {
+	p, _ := reqctx.GetPrincipal(ctx)
+	return Owner{ID: p.Subject, TenantID: p.TenantID}
+}
+
+// actingFor returns ctx with repository calls scoped to o instead of the
+// caller. Only the authorization layer may use it, once a grant on one of
+// o's todos or lists allows what the caller is doing.
+func actingFor(ctx context.Context, o Owner) context.Context {
+	return context.WithValue(ctx, actingKey{}, o)
+}
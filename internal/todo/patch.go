@@ -0,0 +1,194 @@
+package todo
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/jplaulau14/go-todo-api/internal/jsonpatch"
+)
+
+const (
+	mergePatchType = "application/merge-patch+json"
+	jsonPatchType  = "application/json-patch+json"
+)
+
+// acceptPatch lists the media types PATCH /todos/{id} understands.
+const acceptPatch = "application/json, " + mergePatchType + ", " + jsonPatchType
+
+// maxPatchAttempts bounds how often an unconditional patch is recomputed
+// when the todo changes between reading and writing it.
+const maxPatchAttempts = 3
+
+// patchDocument holds the members of a todo's JSON document that a patch
+// may change. Every other member is read-only.
+type patchDocument struct {
+	Title       string     `json:"title"`
+	Description *string    `json:"description"`
+	Completed   bool       `json:"completed"`
+	Status      Status     `json:"status"`
+	Priority    Priority   `json:"priority"`
+	DueAt       *time.Time `json:"due_at"`
+	Tags        []string   `json:"tags"`
+	ListID      *string    `json:"list_id"`
+	Recurrence  *string    `json:"recurrence"`
+}
+
+var patchableFields = map[string]bool{
+	"title": true, "description": true, "completed": true, "status": true, "priority": true,
+	"due_at": true, "tags": true, "list_id": true, "recurrence": true,
+}
+
+// patchTodo applies a merge patch or JSON Patch to t's JSON document and
+// returns the replacement it describes. Removing or nulling an optional
+// member clears it. Changing a read-only member, adding an unknown one or
+// leaving an invalid todo are errors; so are jsonpatch errors.
+func patchTodo(t Todo, mediaType string, patch []byte) (CreateTodoRequest, error) {
+	original, err := json.Marshal(t)
+	if err != nil {
+		return CreateTodoRequest{}, err
+	}
+	var patched []byte
+	switch mediaType {
+	case mergePatchType:
+		patched, err = jsonpatch.MergePatch(original, patch)
+	case jsonPatchType:
+		var p jsonpatch.Patch
+		if p, err = jsonpatch.Decode(patch); err == nil {
+			patched, err = p.Apply(original)
+		}
+	default:
+		err = fmt.Errorf("unsupported patch type %s", mediaType)
+	}
+	if err != nil {
+		return CreateTodoRequest{}, err
+	}
+
+	var before, after map[string]any
+	_ = json.Unmarshal(original, &before)
+	if err := json.Unmarshal(patched, &after); err != nil || after == nil {
+		return CreateTodoRequest{}, errors.New("patched document must be an object")
+	}
+	keys := make([]string, 0, len(after))
+	for k := range after {
+		keys = append(keys, k)
+	}
+	for k := range before {
+		if _, ok := after[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if patchableFields[k] {
+			continue
+		}
+		if _, known := before[k]; !known {
+			return CreateTodoRequest{}, fmt.Errorf("unknown field %q", k)
+		}
+		if !reflect.DeepEqual(before[k], after[k]) {
+			return CreateTodoRequest{}, fmt.Errorf("%s is read-only", k)
+		}
+	}
+
+	var doc patchDocument
+	if err := json.NewDecoder(bytes.NewReader(patched)).Decode(&doc); err != nil {
+		return CreateTodoRequest{}, fmt.Errorf("invalid todo: %v", err)
+	}
+	// Like UpdateTodoRequest, completed is shorthand for done or open, and
+	// must agree with status when both change.
+	switch statusChanged, completedChanged := doc.Status != t.Status, doc.Completed != t.Completed; {
+	case completedChanged && !statusChanged:
+		doc.Status = StatusOpen
+		if doc.Completed {
+			doc.Status = StatusDone
+		}
+	case completedChanged && doc.Completed != doc.Status.Completed():
+		return CreateTodoRequest{}, errors.New("completed contradicts status")
+	}
+	req := CreateTodoRequest{
+		Title:       doc.Title,
+		Description: doc.Description,
+		Status:      doc.Status,
+		Priority:    doc.Priority,
+		DueAt:       doc.DueAt,
+		Tags:        doc.Tags,
+		ListID:      doc.ListID,
+		Recurrence:  doc.Recurrence,
+	}
+	if err := req.validate(true); err != nil {
+		return CreateTodoRequest{}, err
+	}
+	return req, nil
+}
+
+// patch applies a merge patch or JSON Patch to a todo. The patch is
+// computed against the todo as read and written back only if it is still
+// at that version; without If-Match a lost race is retried.
+func (h *HTTPHandler) patch(w http.ResponseWriter, r *http.Request, id string) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, r, http.StatusRequestEntityTooLarge, "request body too large")
+			return
+		}
+		writeError(w, r, http.StatusBadRequest, "could not read request body")
+		return
+	}
+	ifVersion, ok := h.ifMatchVersion(w, r, id)
+	if !ok {
+		return
+	}
+	for attempt := 1; ; attempt++ {
+		current, err := h.repo.Get(r.Context(), id)
+		if err != nil {
+			h.writeUpdateError(w, r, id, err)
+			return
+		}
+		if ifVersion != 0 && current.Version != ifVersion {
+			writePreconditionFailed(w, r)
+			return
+		}
+		req, err := patchTodo(current, mediaType(r), body)
+		if err != nil {
+			writePatchError(w, r, err)
+			return
+		}
+		updated, _, err := h.repo.Upsert(r.Context(), id, req, current.Version)
+		switch {
+		case errors.Is(err, ErrVersionMismatch) && ifVersion == 0:
+			if attempt < maxPatchAttempts {
+				continue
+			}
+			writeError(w, r, http.StatusConflict, "todo is being changed concurrently; retry")
+			return
+		case errors.Is(err, ErrTodoInTrash):
+			writeError(w, r, http.StatusNotFound, "todo not found")
+			return
+		case err != nil:
+			h.writeUpdateError(w, r, id, err)
+			return
+		}
+		setETag(w, updated)
+		writeJSON(w, http.StatusOK, updated)
+		return
+	}
+}
+
+// writePatchError maps a failure to apply a patch: a malformed patch or
+// invalid result is a bad request, while a failed test or a missing path
+// conflicts with the todo's current state.
+func writePatchError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, jsonpatch.ErrTestFailed) || errors.Is(err, jsonpatch.ErrPathNotFound) {
+		writeError(w, r, http.StatusConflict, err.Error())
+		return
+	}
+	writeError(w, r, http.StatusBadRequest, err.Error())
+}
@@ -0,0 +1,84 @@
+package todo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// errBulkRollback makes withTx roll back an atomic batch after an
+// operation failed; the failure itself is reported in the results.
+var errBulkRollback = errors.New("bulk rollback")
+
+// Bulk runs the whole batch in one transaction. In best-effort mode each
+// operation runs under a savepoint, so a failed one is rolled back alone
+// without aborting the transaction.
+func (r *PostgresRepository) Bulk(ctx context.Context, ops []BulkOperation, atomic bool) ([]BulkResult, error) {
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	results := make([]BulkResult, len(ops))
+	err := r.withTx(ctx, func(tx *sql.Tx) error {
+		for i, op := range ops {
+			if !atomic {
+				if _, err := tx.ExecContext(ctx, `SAVEPOINT bulk_op`); err != nil {
+					return err
+				}
+			}
+			t, err := applyBulk(ctx, tx, op, now)
+			if err == nil {
+				results[i].Todo = t
+				if !atomic {
+					if _, err := tx.ExecContext(ctx, `RELEASE SAVEPOINT bulk_op`); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+			results[i].Err = err
+			if atomic {
+				abortBulk(results, i)
+				return errBulkRollback
+			}
+			if _, err := tx.ExecContext(ctx, `ROLLBACK TO SAVEPOINT bulk_op`); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errBulkRollback) {
+		return nil, err
+	}
+	return results, nil
+}
+
+func applyBulk(ctx context.Context, tx *sql.Tx, op BulkOperation, now time.Time) (*Todo, error) {
+	switch op.Op {
+	case BulkCreate:
+		t, err := createTodo(ctx, tx, *op.Create, now)
+		if err != nil {
+			return nil, err
+		}
+		return &t, nil
+	case BulkUpdate:
+		current, err := getLockedTodo(ctx, tx, op.ID)
+		if err != nil {
+			return nil, err
+		}
+		if err := op.Update.ValidateFor(current); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidOperation, err)
+		}
+		t, err := updateTodo(ctx, tx, current, *op.Update, op.IfVersion, now)
+		if err != nil {
+			return nil, err
+		}
+		if err := createNext(ctx, tx, current, t, now); err != nil {
+			return nil, err
+		}
+		return &t, nil
+	case BulkDelete:
+		return nil, deleteTodo(ctx, tx, op.ID, op.IfVersion, now)
+	default:
+		return nil, fmt.Errorf("%w: unknown op %q", ErrInvalidOperation, op.Op)
+	}
+}
@@ -0,0 +1,161 @@
+package todo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const itemColumns = `id, todo_id, title, done, position, created_at, updated_at`
+
+func scanItem(row rowScanner) (ChecklistItem, error) {
+	var it ChecklistItem
+	err := row.Scan(&it.ID, &it.TodoID, &it.Title, &it.Done, &it.Position, &it.CreatedAt, &it.UpdatedAt)
+	return it, err
+}
+
+// lockTodo takes a row lock on a todo so concurrent checklist changes are
+// serialized, returning ErrNotFound if it does not exist, is trashed or
+// belongs to another owner.
+func lockTodo(ctx context.Context, tx *sql.Tx, id string) error {
+	var exists bool
+	err := tx.QueryRowContext(ctx, `SELECT TRUE FROM todos WHERE id=$1 AND deleted_at IS NULL AND `+owned("todos")+` FOR UPDATE`, id).Scan(&exists)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// checklistChanged bumps the todo's updated_at and completes it once every
+// checklist item is done, creating the next occurrence if that completes a
+// recurring todo. The caller holds the todo's row lock.
+func checklistChanged(ctx context.Context, tx *sql.Tx, todoID string, now time.Time) error {
+	current, err := getTodo(ctx, tx, todoID)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE todos SET updated_at=$1, version=version+1 WHERE id=$2`, now, todoID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE todos SET completed=TRUE, status='done', completed_at=$1
+		WHERE id=$2 AND NOT completed
+			AND EXISTS (SELECT 1 FROM checklist_items WHERE todo_id=$2)
+			AND NOT EXISTS (SELECT 1 FROM checklist_items WHERE todo_id=$2 AND NOT done)`,
+		now, todoID,
+	); err != nil {
+		return err
+	}
+	t, err := getTodo(ctx, tx, todoID)
+	if err != nil {
+		return err
+	}
+	return createNext(ctx, tx, current, t, now)
+}
+
+func (r *PostgresRepository) AddItem(ctx context.Context, todoID string, req CreateItemRequest) (ChecklistItem, error) {
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	var it ChecklistItem
+	err := r.withTx(ctx, func(tx *sql.Tx) error {
+		if err := lockTodo(ctx, tx, todoID); err != nil {
+			return err
+		}
+		var err error
+		it, err = scanItem(tx.QueryRowContext(ctx,
+			`INSERT INTO checklist_items (id, todo_id, title, done, position, created_at, updated_at)
+			VALUES ($1, $2, $3, FALSE,
+				COALESCE($4, (SELECT COALESCE(MAX(position) + 1, 0) FROM checklist_items WHERE todo_id=$2)),
+				$5, $5)
+			RETURNING `+itemColumns,
+			uuid.NewString(), todoID, req.Title, req.Position, now,
+		))
+		if err != nil {
+			return err
+		}
+		return checklistChanged(ctx, tx, todoID, now)
+	})
+	if err != nil {
+		return ChecklistItem{}, err
+	}
+	return it, nil
+}
+
+func (r *PostgresRepository) Items(ctx context.Context, todoID string) ([]ChecklistItem, error) {
+	result := []ChecklistItem{}
+	err := r.withTx(ctx, func(tx *sql.Tx) error {
+		if _, err := getTodo(ctx, tx, todoID); err != nil {
+			return err
+		}
+		rows, err := tx.QueryContext(ctx,
+			`SELECT `+itemColumns+` FROM checklist_items WHERE todo_id=$1 ORDER BY position, created_at`, todoID,
+		)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			it, err := scanItem(rows)
+			if err != nil {
+				return err
+			}
+			result = append(result, it)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (r *PostgresRepository) UpdateItem(ctx context.Context, todoID, itemID string, update UpdateItemRequest) (ChecklistItem, error) {
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	var it ChecklistItem
+	err := r.withTx(ctx, func(tx *sql.Tx) error {
+		if err := lockTodo(ctx, tx, todoID); err != nil {
+			return err
+		}
+		var err error
+		it, err = scanItem(tx.QueryRowContext(ctx,
+			`SELECT `+itemColumns+` FROM checklist_items WHERE id=$1 AND todo_id=$2`, itemID, todoID,
+		))
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrItemNotFound
+			}
+			return err
+		}
+		update.apply(&it)
+		it.UpdatedAt = now
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE checklist_items SET title=$1, done=$2, position=$3, updated_at=$4 WHERE id=$5`,
+			it.Title, it.Done, it.Position, it.UpdatedAt, itemID,
+		); err != nil {
+			return err
+		}
+		return checklistChanged(ctx, tx, todoID, now)
+	})
+	if err != nil {
+		return ChecklistItem{}, err
+	}
+	return it, nil
+}
+
+func (r *PostgresRepository) DeleteItem(ctx context.Context, todoID, itemID string) error {
+	return r.withTx(ctx, func(tx *sql.Tx) error {
+		if err := lockTodo(ctx, tx, todoID); err != nil {
+			return err
+		}
+		res, err := tx.ExecContext(ctx, `DELETE FROM checklist_items WHERE id=$1 AND todo_id=$2`, itemID, todoID)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return ErrItemNotFound
+		}
+		return checklistChanged(ctx, tx, todoID, time.Now().UTC().Truncate(time.Microsecond))
+	})
+}
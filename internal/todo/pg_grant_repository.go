@@ -0,0 +1,179 @@
+package todo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/jplaulau14/go-todo-api/internal/policy"
+)
+
+const grantColumns = `CASE WHEN todo_id IS NOT NULL THEN 'todo' ELSE 'list' END, COALESCE(todo_id, list_id),
+	subject, role, granted_by, created_at, updated_at, owner_id, tenant_id`
+
+func scanGrant(row rowScanner) (Grant, error) {
+	var g Grant
+	err := row.Scan(&g.ResourceType, &g.ResourceID, &g.Subject, &g.Role, &g.GrantedBy, &g.CreatedAt, &g.UpdatedAt, &g.owner.ID, &g.owner.TenantID)
+	return g, err
+}
+
+// grantColumn is the column of grants that refers to resources of kind.
+func grantColumn(kind ResourceType) string {
+	if kind == ResourceList {
+		return "list_id"
+	}
+	return "todo_id"
+}
+
+// checkShared returns ErrNotFound or ErrListNotFound unless the
+// transaction's owner has the todo or list with id.
+func checkShared(ctx context.Context, tx *sql.Tx, kind ResourceType, id string) error {
+	if kind == ResourceList {
+		return checkList(ctx, tx, &id)
+	}
+	return lockTodo(ctx, tx, id)
+}
+
+func queryGrants(ctx context.Context, tx *sql.Tx, query string, args ...any) ([]Grant, error) {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []Grant{}
+	for rows.Next() {
+		g, err := scanGrant(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, g)
+	}
+	return out, rows.Err()
+}
+
+func (r *PostgresRepository) Grants(ctx context.Context, kind ResourceType, id string) ([]Grant, error) {
+	var out []Grant
+	err := r.withTx(ctx, func(tx *sql.Tx) error {
+		if err := checkShared(ctx, tx, kind, id); err != nil {
+			return err
+		}
+		var err error
+		out, err = queryGrants(ctx, tx,
+			`SELECT `+grantColumns+` FROM grants WHERE `+grantColumn(kind)+`=$1 AND `+owned("grants")+` ORDER BY created_at, subject`, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (r *PostgresRepository) CreateGrant(ctx context.Context, kind ResourceType, id string, req CreateGrantRequest, grantedBy string) (Grant, error) {
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	var g Grant
+	err := r.withTx(ctx, func(tx *sql.Tx) error {
+		if err := checkShared(ctx, tx, kind, id); err != nil {
+			return err
+		}
+		var err error
+		g, err = scanGrant(tx.QueryRowContext(ctx,
+			`INSERT INTO grants (`+grantColumn(kind)+`, subject, role, granted_by, created_at, updated_at, owner_id, tenant_id)
+			VALUES ($1, $2, $3, $4, $5, $5, app_owner_id(), app_tenant_id())
+			ON CONFLICT DO NOTHING
+			RETURNING `+grantColumns,
+			id, req.Subject, string(req.Role), grantedBy, now,
+		))
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrGrantExists
+		}
+		return err
+	})
+	if err != nil {
+		return Grant{}, err
+	}
+	return g, nil
+}
+
+func (r *PostgresRepository) UpdateGrant(ctx context.Context, kind ResourceType, id, subject string, role policy.Role) (Grant, error) {
+	var g Grant
+	err := r.withTx(ctx, func(tx *sql.Tx) error {
+		var err error
+		g, err = scanGrant(tx.QueryRowContext(ctx,
+			`UPDATE grants SET role=$1, updated_at=$2 WHERE `+grantColumn(kind)+`=$3 AND subject=$4 AND `+owned("grants")+` RETURNING `+grantColumns,
+			string(role), time.Now().UTC().Truncate(time.Microsecond), id, subject,
+		))
+		return err
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Grant{}, ErrGrantNotFound
+		}
+		return Grant{}, err
+	}
+	return g, nil
+}
+
+func (r *PostgresRepository) DeleteGrant(ctx context.Context, kind ResourceType, id, subject string) error {
+	return r.withTx(ctx, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, `DELETE FROM grants WHERE `+grantColumn(kind)+`=$1 AND subject=$2 AND `+owned("grants"), id, subject)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return ErrGrantNotFound
+		}
+		return nil
+	})
+}
+
+// held restricts grants, or an alias of it, to those the transaction's
+// owner holds rather than gives.
+func held(table string) string {
+	return table + `.subject = app_owner_id() AND ` + table + `.tenant_id = app_tenant_id()`
+}
+
+func (r *PostgresRepository) Access(ctx context.Context, kind ResourceType, id string) ([]Grant, error) {
+	query := `SELECT ` + grantColumns + ` FROM grants WHERE ` + held("grants") + ` AND list_id=$1`
+	if kind == ResourceTodo {
+		// The todos_shared policy lets the subject of a list grant read the
+		// todos in the list.
+		query = `SELECT ` + grantColumns + ` FROM grants WHERE ` + held("grants") + `
+			AND (todo_id=$1 OR list_id=(SELECT t.list_id FROM todos t WHERE t.id=$1 AND t.owner_id=grants.owner_id AND t.tenant_id=grants.tenant_id))`
+	}
+	var out []Grant
+	err := r.withTx(actingFor(ctx, callerOf(ctx)), func(tx *sql.Tx) error {
+		var err error
+		out, err = queryGrants(ctx, tx, query, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (r *PostgresRepository) Shared(ctx context.Context, limit, offset int) ([]Grant, error) {
+	var q pgQuery
+	query := `SELECT ` + grantColumns + ` FROM grants WHERE ` + held("grants") + ` ORDER BY created_at DESC, todo_id, list_id`
+	if limit > 0 {
+		query += ` LIMIT ` + q.arg(limit)
+	}
+	if offset > 0 {
+		query += ` OFFSET ` + q.arg(offset)
+	}
+	var out []Grant
+	err := r.withTx(actingFor(ctx, callerOf(ctx)), func(tx *sql.Tx) error {
+		var err error
+		out, err = queryGrants(ctx, tx, query, q.args...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
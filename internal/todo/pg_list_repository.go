@@ -0,0 +1,163 @@
+package todo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const listColumns = `id, name, created_at, updated_at`
+
+func scanList(row rowScanner) (TodoList, error) {
+	var l TodoList
+	err := row.Scan(&l.ID, &l.Name, &l.CreatedAt, &l.UpdatedAt)
+	return l, err
+}
+
+// listFKError maps a violation of todos.list_id's foreign key to
+// ErrListNotFound.
+func listFKError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23503" && pgErr.ConstraintName == "todos_list_id_fkey" {
+		return ErrListNotFound
+	}
+	return err
+}
+
+// checkList returns ErrListNotFound unless the transaction's owner owns
+// the list with id, if one is given. The todos.list_id foreign key cannot
+// do this alone, since foreign key checks see every owner's lists.
+func checkList(ctx context.Context, q querier, id *string) error {
+	if id == nil {
+		return nil
+	}
+	var exists bool
+	err := q.QueryRowContext(ctx, `SELECT TRUE FROM lists WHERE id=$1 AND `+owned("lists"), *id).Scan(&exists)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrListNotFound
+	}
+	return err
+}
+
+func (r *PostgresRepository) CreateList(ctx context.Context, req CreateListRequest) (TodoList, error) {
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	l := TodoList{ID: uuid.NewString(), Name: strings.TrimSpace(req.Name), CreatedAt: now, UpdatedAt: now}
+	err := r.withTx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx,
+			`INSERT INTO lists (id, name, created_at, updated_at, owner_id, tenant_id) VALUES ($1, $2, $3, $4, app_owner_id(), app_tenant_id())`,
+			l.ID, l.Name, l.CreatedAt, l.UpdatedAt,
+		)
+		return err
+	})
+	if err != nil {
+		return TodoList{}, err
+	}
+	return l, nil
+}
+
+func (r *PostgresRepository) GetList(ctx context.Context, id string) (TodoList, error) {
+	var l TodoList
+	err := r.withTx(ctx, func(tx *sql.Tx) error {
+		var err error
+		l, err = scanList(tx.QueryRowContext(ctx, `SELECT `+listColumns+` FROM lists WHERE id=$1 AND `+owned("lists"), id))
+		return err
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return TodoList{}, ErrListNotFound
+		}
+		return TodoList{}, err
+	}
+	return l, nil
+}
+
+func (r *PostgresRepository) Lists(ctx context.Context, limit, offset int) ([]TodoList, error) {
+	var q pgQuery
+	query := `SELECT ` + listColumns + ` FROM lists WHERE ` + owned("lists") + ` ORDER BY name, id`
+	if limit > 0 {
+		query += ` LIMIT ` + q.arg(limit)
+	}
+	if offset > 0 {
+		query += ` OFFSET ` + q.arg(offset)
+	}
+	result := []TodoList{}
+	err := r.withTx(ctx, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, query, q.args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			l, err := scanList(rows)
+			if err != nil {
+				return err
+			}
+			result = append(result, l)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (r *PostgresRepository) UpdateList(ctx context.Context, id string, update UpdateListRequest) (TodoList, error) {
+	var name *string
+	if update.Name != nil {
+		n := strings.TrimSpace(*update.Name)
+		name = &n
+	}
+	var l TodoList
+	err := r.withTx(ctx, func(tx *sql.Tx) error {
+		var err error
+		l, err = scanList(tx.QueryRowContext(ctx,
+			`UPDATE lists SET name=COALESCE($1, name), updated_at=$2 WHERE id=$3 AND `+owned("lists")+` RETURNING `+listColumns,
+			name, time.Now().UTC().Truncate(time.Microsecond), id,
+		))
+		return err
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return TodoList{}, ErrListNotFound
+		}
+		return TodoList{}, err
+	}
+	return l, nil
+}
+
+func (r *PostgresRepository) DeleteList(ctx context.Context, id string, cascade bool) error {
+	return r.withTx(ctx, func(tx *sql.Tx) error {
+		// Lock the list so no todo can be added to it while we decide.
+		var exists bool
+		if err := tx.QueryRowContext(ctx, `SELECT TRUE FROM lists WHERE id=$1 AND `+owned("lists")+` FOR UPDATE`, id).Scan(&exists); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrListNotFound
+			}
+			return err
+		}
+		if !cascade {
+			var n int
+			if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM todos WHERE list_id=$1 AND deleted_at IS NULL AND `+owned("todos"), id).Scan(&n); err != nil {
+				return err
+			}
+			if n > 0 {
+				return ErrListNotEmpty
+			}
+		}
+		// Todos outlive their list in the trash so they can still be restored.
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE todos SET list_id=NULL, deleted_at=COALESCE(deleted_at, $1), version=version+1 WHERE list_id=$2 AND `+owned("todos"),
+			time.Now().UTC().Truncate(time.Microsecond), id,
+		); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, `DELETE FROM lists WHERE id=$1 AND `+owned("lists"), id)
+		return err
+	})
+}
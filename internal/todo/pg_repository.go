@@ -3,10 +3,13 @@ package todo
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
-	"github.com/google/uuid"
 	_ "github.com/jackc/pgx/v5/stdlib"
 )
 
@@ -18,25 +21,157 @@ func NewPostgresRepository(db *sql.DB) *PostgresRepository {
 	return &PostgresRepository{db: db}
 }
 
-func (r *PostgresRepository) Create(ctx context.Context, title string) (Todo, error) {
-	id := uuid.NewString()
-	now := time.Now().UTC()
-	_, err := r.db.ExecContext(ctx,
-		`INSERT INTO todos (id, title, completed, created_at, updated_at) VALUES ($1, $2, $3, $4, $5)`,
-		id, title, false, now, now,
-	)
+// querier is the subset of *sql.DB and *sql.Tx used by the repository, so
+// helpers can run either standalone or inside a transaction.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// withTx runs fn in a transaction scoped to the owner of ctx, committing
+// if it returns nil. Every query runs this way, because the owner is passed
+// to Postgres as transaction-local settings that owned and the row-level
+// security policies read.
+func (r *PostgresRepository) withTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	o := OwnerOf(ctx)
+	return r.inTx(ctx, `SELECT set_config('app.owner_id', $1, TRUE), set_config('app.tenant_id', $2, TRUE)`, []any{o.ID, o.TenantID}, fn)
+}
+
+// inTx runs setup and then fn in a transaction, committing if both succeed.
+func (r *PostgresRepository) inTx(ctx context.Context, setup string, args []any, fn func(tx *sql.Tx) error) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, setup, args...); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	if err := fn(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// owned restricts table, or an alias of it, to the rows of the owner
+// withTx set. It repeats the row-level security policy so that scoping
+// holds for roles that bypass it, such as superusers.
+func owned(table string) string {
+	return table + `.owner_id = app_owner_id() AND ` + table + `.tenant_id = app_tenant_id()`
+}
+
+// todoColumns lists the columns read by scanTodo, in order. Tags are
+// aggregated into a JSON array so they arrive with the row.
+const todoColumns = `id, title, description, completed, status, completed_at, priority, due_at,
+	COALESCE((SELECT json_agg(tt.tag ORDER BY tt.tag) FROM todo_tags tt WHERE tt.todo_id = todos.id), '[]'),
+	list_id,
+	(SELECT COUNT(*) FILTER (WHERE ci.done) FROM checklist_items ci WHERE ci.todo_id = todos.id),
+	(SELECT COUNT(*) FROM checklist_items ci WHERE ci.todo_id = todos.id),
+	recurrence, recurrence_start, deleted_at,
+	created_at, updated_at, version, COALESCE(next_id, '')`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanTodo(row rowScanner) (Todo, error) {
+	var t Todo
+	err := row.Scan(&t.ID, &t.Title, &t.Description, &t.Completed, &t.Status, &t.CompletedAt, &t.Priority, &t.DueAt, (*jsonStrings)(&t.Tags), &t.ListID,
+		&t.Progress.Done, &t.Progress.Total, &t.Recurrence, &t.RecurrenceStart, &t.DeletedAt, &t.CreatedAt, &t.UpdatedAt, &t.Version, &t.nextID)
+	return t, err
+}
+
+// jsonStrings scans a JSON array of strings.
+type jsonStrings []string
+
+func (s *jsonStrings) Scan(src any) error {
+	switch v := src.(type) {
+	case []byte:
+		return json.Unmarshal(v, (*[]string)(s))
+	case string:
+		return json.Unmarshal([]byte(v), (*[]string)(s))
+	default:
+		return fmt.Errorf("cannot scan %T into string list", src)
+	}
+}
+
+func (r *PostgresRepository) Create(ctx context.Context, req CreateTodoRequest) (Todo, error) {
+	// Postgres stores microseconds; truncate so the returned value matches
+	// what a later read would see.
+	var t Todo
+	err := r.withTx(ctx, func(tx *sql.Tx) error {
+		var err error
+		t, err = createTodo(ctx, tx, req, time.Now().UTC().Truncate(time.Microsecond))
+		return err
+	})
 	if err != nil {
 		return Todo{}, err
 	}
-	return Todo{ID: id, Title: title, Completed: false, CreatedAt: now, UpdatedAt: now}, nil
+	return t, nil
+}
+
+// createTodo inserts a new todo. If its id is taken it reports why: the
+// row is invisible when another owner holds it.
+func createTodo(ctx context.Context, tx *sql.Tx, req CreateTodoRequest, now time.Time) (Todo, error) {
+	t := newTodo(req, now)
+	inserted, err := insertTodo(ctx, tx, t)
+	if err != nil {
+		return Todo{}, err
+	}
+	if inserted {
+		return t, nil
+	}
+	var trashed bool
+	err = tx.QueryRowContext(ctx, `SELECT deleted_at IS NOT NULL FROM todos WHERE id=$1 AND `+owned("todos"), t.ID).Scan(&trashed)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return Todo{}, ErrNotFound
+	case err != nil:
+		return Todo{}, err
+	case trashed:
+		return Todo{}, ErrTodoInTrash
+	}
+	return Todo{}, ErrTodoExists
+}
+
+// insertTodo inserts t with its tags for the transaction's owner unless a
+// todo with its ID already exists, whoever owns it, reporting whether it
+// did.
+func insertTodo(ctx context.Context, tx *sql.Tx, t Todo) (bool, error) {
+	if err := checkList(ctx, tx, t.ListID); err != nil {
+		return false, err
+	}
+	res, err := tx.ExecContext(ctx,
+		`INSERT INTO todos (id, title, description, completed, status, completed_at, priority, due_at, list_id, recurrence, recurrence_start, created_at, updated_at, owner_id, tenant_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, app_owner_id(), app_tenant_id())
+		ON CONFLICT (id) DO NOTHING`,
+		t.ID, t.Title, t.Description, t.Completed, string(t.Status), t.CompletedAt, string(t.Priority), t.DueAt, t.ListID, t.Recurrence, t.RecurrenceStart, t.CreatedAt, t.UpdatedAt,
+	)
+	if err != nil {
+		return false, listFKError(err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return false, nil
+	}
+	return true, insertTags(ctx, tx, t.ID, t.Tags)
 }
 
 func (r *PostgresRepository) Get(ctx context.Context, id string) (Todo, error) {
 	var t Todo
-	row := r.db.QueryRowContext(ctx,
-		`SELECT id, title, completed, created_at, updated_at FROM todos WHERE id=$1`, id,
-	)
-	if err := row.Scan(&t.ID, &t.Title, &t.Completed, &t.CreatedAt, &t.UpdatedAt); err != nil {
+	err := r.withTx(ctx, func(tx *sql.Tx) error {
+		var err error
+		t, err = getTodo(ctx, tx, id)
+		return err
+	})
+	return t, err
+}
+
+func getTodo(ctx context.Context, q querier, id string) (Todo, error) {
+	row := q.QueryRowContext(ctx, `SELECT `+todoColumns+` FROM todos WHERE id=$1 AND deleted_at IS NULL AND `+owned("todos"), id)
+	t, err := scanTodo(row)
+	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return Todo{}, ErrNotFound
 		}
@@ -45,60 +180,495 @@ func (r *PostgresRepository) Get(ctx context.Context, id string) (Todo, error) {
 	return t, nil
 }
 
-func (r *PostgresRepository) List(ctx context.Context, limit, offset int) ([]Todo, error) {
-	rows, err := r.db.QueryContext(ctx,
-		`SELECT id, title, completed, created_at, updated_at FROM todos ORDER BY created_at DESC LIMIT $1 OFFSET $2`,
-		limit, offset,
-	)
-	if err != nil {
-		return nil, err
+func (r *PostgresRepository) List(ctx context.Context, opts ListOptions) ([]Todo, error) {
+	var q pgQuery
+	q.filter(opts)
+	if opts.After != nil {
+		op := ` > `
+		if opts.sort().Desc {
+			op = ` < `
+		}
+		q.and(`(created_at, id)` + op + `(` + q.arg(opts.After.CreatedAt) + `, ` + q.arg(opts.After.ID) + `)`)
+	}
+	query := `SELECT ` + todoColumns + ` FROM todos` + q.whereClause() + orderBy(opts.sort())
+	if opts.Limit > 0 {
+		query += ` LIMIT ` + q.arg(opts.Limit)
+	}
+	if opts.Offset > 0 {
+		query += ` OFFSET ` + q.arg(opts.Offset)
 	}
-	defer rows.Close()
+	return r.queryTodos(ctx, query, q.args...)
+}
+
+// queryTodos runs a query selecting todoColumns in a scoped transaction.
+func (r *PostgresRepository) queryTodos(ctx context.Context, query string, args ...any) ([]Todo, error) {
 	var result []Todo
-	for rows.Next() {
-		var t Todo
-		if err := rows.Scan(&t.ID, &t.Title, &t.Completed, &t.CreatedAt, &t.UpdatedAt); err != nil {
-			return nil, err
+	err := r.withTx(ctx, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, query, args...)
+		if err != nil {
+			return err
 		}
-		result = append(result, t)
-	}
-	if err := rows.Err(); err != nil {
+		defer rows.Close()
+		for rows.Next() {
+			t, err := scanTodo(rows)
+			if err != nil {
+				return err
+			}
+			result = append(result, t)
+		}
+		return rows.Err()
+	})
+	if err != nil {
 		return nil, err
 	}
 	return result, nil
 }
 
-func (r *PostgresRepository) Update(ctx context.Context, id string, update UpdateTodoRequest) (Todo, error) {
-	// Fetch current
-	current, err := r.Get(ctx, id)
+func (r *PostgresRepository) Count(ctx context.Context, opts ListOptions) (int, error) {
+	var q pgQuery
+	q.filter(opts)
+	var n int
+	err := r.withTx(ctx, func(tx *sql.Tx) error {
+		return tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM todos`+q.whereClause(), q.args...).Scan(&n)
+	})
 	if err != nil {
-		return Todo{}, err
+		return 0, err
 	}
-	if update.Title != nil {
-		current.Title = *update.Title
+	return n, nil
+}
+
+// Update creates the next occurrence of a recurring todo it completes, in
+// the same transaction.
+func (r *PostgresRepository) Update(ctx context.Context, id string, update UpdateTodoRequest, ifVersion int64) (Todo, error) {
+	var updated Todo
+	err := r.withTx(ctx, func(tx *sql.Tx) error {
+		current, err := getLockedTodo(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		now := time.Now().UTC().Truncate(time.Microsecond)
+		if updated, err = updateTodo(ctx, tx, current, update, ifVersion, now); err != nil {
+			return err
+		}
+		return createNext(ctx, tx, current, updated, now)
+	})
+	if err != nil {
+		return Todo{}, err
 	}
-	if update.Completed != nil {
-		current.Completed = *update.Completed
+	return updated, nil
+}
+
+// createNext creates the next occurrence if a write took a todo from before
+// to after, and records it as after's successor. The caller holds the
+// todo's row lock, so only one of several concurrent completions sees it go
+// from open to completed.
+func createNext(ctx context.Context, tx *sql.Tx, before, after Todo, now time.Time) error {
+	req, ok, err := nextAfter(before, after)
+	if err != nil || !ok {
+		return err
 	}
-	current.UpdatedAt = time.Now().UTC()
-	_, err = r.db.ExecContext(ctx,
-		`UPDATE todos SET title=$1, completed=$2, updated_at=$3 WHERE id=$4`,
-		current.Title, current.Completed, current.UpdatedAt, id,
-	)
+	next, err := createTodo(ctx, tx, req, now)
 	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE todos SET next_id=$1 WHERE id=$2 AND `+owned("todos"), next.ID, after.ID)
+	return err
+}
+
+// getLockedTodo locks a live todo's row and reads it, so that changes are
+// checked against the state they are applied to.
+func getLockedTodo(ctx context.Context, tx *sql.Tx, id string) (Todo, error) {
+	if err := lockTodo(ctx, tx, id); err != nil {
+		return Todo{}, err
+	}
+	return getTodo(ctx, tx, id)
+}
+
+// updateTodo applies update to current, which the caller has locked with
+// getLockedTodo, and returns the result.
+func updateTodo(ctx context.Context, tx *sql.Tx, current Todo, update UpdateTodoRequest, ifVersion int64, now time.Time) (Todo, error) {
+	if ifVersion != 0 && current.Version != ifVersion {
+		return Todo{}, ErrVersionMismatch
+	}
+	if err := update.ValidateFor(current); err != nil {
+		return Todo{}, fmt.Errorf("%w: %v", ErrInvalidUpdate, err)
+	}
+	if err := update.apply(&current, now); err != nil {
+		return Todo{}, err
+	}
+	current.touch(now)
+	if err := saveTodo(ctx, tx, current, update.Tags != nil); err != nil {
 		return Todo{}, err
 	}
 	return current, nil
 }
 
-func (r *PostgresRepository) Delete(ctx context.Context, id string) error {
-	res, err := r.db.ExecContext(ctx, `DELETE FROM todos WHERE id=$1`, id)
+// saveTodo writes every stored field of t, and its tags if they changed.
+func saveTodo(ctx context.Context, tx *sql.Tx, t Todo, tagsChanged bool) error {
+	if err := checkList(ctx, tx, t.ListID); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx,
+		`UPDATE todos SET title=$1, description=$2, completed=$3, status=$4, completed_at=$5, priority=$6, due_at=$7, list_id=$8,
+			recurrence=$9, recurrence_start=$10, updated_at=$11, version=$12 WHERE id=$13 AND `+owned("todos"),
+		t.Title, t.Description, t.Completed, string(t.Status), t.CompletedAt, string(t.Priority),
+		t.DueAt, t.ListID, t.Recurrence, t.RecurrenceStart, t.UpdatedAt, t.Version, t.ID,
+	)
 	if err != nil {
+		return listFKError(err)
+	}
+	if !tagsChanged {
+		return nil
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM todo_tags WHERE todo_id=$1`, t.ID); err != nil {
 		return err
 	}
-	n, _ := res.RowsAffected()
-	if n == 0 {
-		return ErrNotFound
+	return insertTags(ctx, tx, t.ID, t.Tags)
+}
+
+// Upsert tries the insert first; if the id is taken it locks the existing
+// row, trashed or not, and replaces it, creating the next occurrence if
+// that completes a recurring todo. An id taken by another owner is
+// reported as ErrNotFound.
+func (r *PostgresRepository) Upsert(ctx context.Context, id string, req CreateTodoRequest, ifVersion int64) (Todo, bool, error) {
+	var (
+		t       Todo
+		created bool
+	)
+	err := r.withTx(ctx, func(tx *sql.Tx) error {
+		now := time.Now().UTC().Truncate(time.Microsecond)
+		if ifVersion == 0 {
+			t = newTodo(req, now)
+			t.ID = id
+			var err error
+			if created, err = insertTodo(ctx, tx, t); err != nil || created {
+				return err
+			}
+		}
+
+		var trashed bool
+		err := tx.QueryRowContext(ctx, `SELECT deleted_at IS NOT NULL FROM todos WHERE id=$1 AND `+owned("todos")+` FOR UPDATE`, id).Scan(&trashed)
+		switch {
+		case errors.Is(err, sql.ErrNoRows) && ifVersion == 0:
+			return ErrNotFound
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrVersionMismatch
+		case err != nil:
+			return err
+		case trashed:
+			return ErrTodoInTrash
+		}
+		current, err := getTodo(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		if ifVersion != 0 && current.Version != ifVersion {
+			return ErrVersionMismatch
+		}
+		if t, err = current.replace(req, now); err != nil {
+			return err
+		}
+		if err := saveTodo(ctx, tx, t, true); err != nil {
+			return err
+		}
+		return createNext(ctx, tx, current, t, now)
+	})
+	if err != nil {
+		return Todo{}, false, err
+	}
+	return t, created, nil
+}
+
+func (r *PostgresRepository) Delete(ctx context.Context, id string, ifVersion int64) error {
+	return r.withTx(ctx, func(tx *sql.Tx) error {
+		return deleteTodo(ctx, tx, id, ifVersion, time.Now().UTC().Truncate(time.Microsecond))
+	})
+}
+
+func deleteTodo(ctx context.Context, q querier, id string, ifVersion int64, now time.Time) error {
+	res, err := q.ExecContext(ctx,
+		`UPDATE todos SET deleted_at=$1, version=version+1
+		WHERE id=$2 AND deleted_at IS NULL AND ($3::bigint = 0 OR version = $3) AND `+owned("todos"),
+		now, id, ifVersion,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return missing(ctx, q, `id=$1 AND deleted_at IS NULL`, id)
 	}
 	return nil
 }
+
+// missing explains why a conditional write matched no rows: ErrNotFound
+// if no owned todo matches cond, ErrVersionMismatch if one does and so must
+// have been at another version.
+func missing(ctx context.Context, q querier, cond, id string) error {
+	var exists bool
+	err := q.QueryRowContext(ctx, `SELECT TRUE FROM todos WHERE `+cond+` AND `+owned("todos"), id).Scan(&exists)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return ErrNotFound
+	case err != nil:
+		return err
+	}
+	return ErrVersionMismatch
+}
+
+func (r *PostgresRepository) ArchiveCompleted(ctx context.Context, cutoff time.Time) (int, error) {
+	var n int64
+	err := r.withTx(ctx, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx,
+			`UPDATE todos SET status='archived', updated_at=$1, version=version+1
+			WHERE status='done' AND completed_at < $2 AND deleted_at IS NULL AND `+owned("todos"),
+			time.Now().UTC().Truncate(time.Microsecond), cutoff,
+		)
+		if err != nil {
+			return err
+		}
+		n, err = res.RowsAffected()
+		return err
+	})
+	return int(n), err
+}
+
+func (r *PostgresRepository) Trash(ctx context.Context, limit, offset int) ([]Todo, error) {
+	var q pgQuery
+	query := `SELECT ` + todoColumns + ` FROM todos WHERE deleted_at IS NOT NULL AND ` + owned("todos") + ` ORDER BY deleted_at DESC, id DESC`
+	if limit > 0 {
+		query += ` LIMIT ` + q.arg(limit)
+	}
+	if offset > 0 {
+		query += ` OFFSET ` + q.arg(offset)
+	}
+	return r.queryTodos(ctx, query, q.args...)
+}
+
+func (r *PostgresRepository) Restore(ctx context.Context, id string) (Todo, error) {
+	var t Todo
+	err := r.withTx(ctx, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx,
+			`UPDATE todos SET deleted_at=NULL, updated_at=$1, version=version+1 WHERE id=$2 AND deleted_at IS NOT NULL AND `+owned("todos"),
+			time.Now().UTC().Truncate(time.Microsecond), id,
+		)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return ErrNotFound
+		}
+		t, err = getTodo(ctx, tx, id)
+		return err
+	})
+	if err != nil {
+		return Todo{}, err
+	}
+	return t, nil
+}
+
+// Purge relies on ON DELETE CASCADE to remove the todo's tags and
+// checklist items.
+func (r *PostgresRepository) Purge(ctx context.Context, id string, ifVersion int64) error {
+	return r.withTx(ctx, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, `DELETE FROM todos WHERE id=$1 AND ($2::bigint = 0 OR version = $2) AND `+owned("todos"), id, ifVersion)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return missing(ctx, tx, `id=$1`, id)
+		}
+		return nil
+	})
+}
+
+// PurgeTrash sets app.all_owners so that the row-level security policies
+// let it reach every owner's trash.
+func (r *PostgresRepository) PurgeTrash(ctx context.Context, cutoff time.Time) (int, error) {
+	var n int64
+	err := r.inTx(ctx, `SELECT set_config('app.all_owners', 'on', TRUE)`, nil, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, `DELETE FROM todos WHERE deleted_at < $1`, cutoff)
+		if err != nil {
+			return err
+		}
+		n, err = res.RowsAffected()
+		return err
+	})
+	return int(n), err
+}
+
+func (r *PostgresRepository) AddTags(ctx context.Context, id string, tags []string) (Todo, error) {
+	var t Todo
+	err := r.withTx(ctx, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, `UPDATE todos SET updated_at=$1, version=version+1 WHERE id=$2 AND deleted_at IS NULL AND `+owned("todos"), time.Now().UTC().Truncate(time.Microsecond), id)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return ErrNotFound
+		}
+		if err := insertTags(ctx, tx, id, tags); err != nil {
+			return err
+		}
+		t, err = getTodo(ctx, tx, id)
+		return err
+	})
+	if err != nil {
+		return Todo{}, err
+	}
+	return t, nil
+}
+
+func (r *PostgresRepository) RemoveTag(ctx context.Context, id, tag string) (Todo, error) {
+	var t Todo
+	err := r.withTx(ctx, func(tx *sql.Tx) error {
+		if err := lockTodo(ctx, tx, id); err != nil {
+			return err
+		}
+		res, err := tx.ExecContext(ctx, `DELETE FROM todo_tags WHERE todo_id=$1 AND tag=$2`, id, tag)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			if _, err := tx.ExecContext(ctx, `UPDATE todos SET updated_at=$1, version=version+1 WHERE id=$2 AND `+owned("todos"), time.Now().UTC().Truncate(time.Microsecond), id); err != nil {
+				return err
+			}
+		}
+		t, err = getTodo(ctx, tx, id)
+		return err
+	})
+	if err != nil {
+		return Todo{}, err
+	}
+	return t, nil
+}
+
+func (r *PostgresRepository) ListTags(ctx context.Context) ([]TagCount, error) {
+	result := []TagCount{}
+	err := r.withTx(ctx, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx,
+			`SELECT tt.tag, COUNT(*) FROM todo_tags tt JOIN todos t ON t.id = tt.todo_id
+			WHERE t.deleted_at IS NULL AND `+owned("t")+`
+			GROUP BY tt.tag ORDER BY COUNT(*) DESC, tt.tag`,
+		)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var tc TagCount
+			if err := rows.Scan(&tc.Name, &tc.Count); err != nil {
+				return err
+			}
+			result = append(result, tc)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// insertTags registers tags and links them to a todo, ignoring links that
+// already exist.
+func insertTags(ctx context.Context, q querier, id string, tags []string) error {
+	if len(tags) == 0 {
+		return nil
+	}
+	if _, err := q.ExecContext(ctx,
+		`INSERT INTO tags (name) SELECT unnest($1::text[]) ON CONFLICT (name) DO NOTHING`, tags,
+	); err != nil {
+		return err
+	}
+	_, err := q.ExecContext(ctx,
+		`INSERT INTO todo_tags (todo_id, tag) SELECT $1, unnest($2::text[]) ON CONFLICT DO NOTHING`, id, tags,
+	)
+	return err
+}
+
+// pgQuery accumulates WHERE conditions and their positional arguments.
+type pgQuery struct {
+	where []string
+	args  []any
+}
+
+// arg appends v to the argument list and returns its placeholder.
+func (q *pgQuery) arg(v any) string {
+	q.args = append(q.args, v)
+	return "$" + strconv.Itoa(len(q.args))
+}
+
+func (q *pgQuery) and(cond string) {
+	q.where = append(q.where, cond)
+}
+
+func (q *pgQuery) whereClause() string {
+	if len(q.where) == 0 {
+		return ""
+	}
+	return ` WHERE ` + strings.Join(q.where, ` AND `)
+}
+
+// filter adds the conditions for opts. It must stay in sync with
+// ListOptions.matches.
+func (q *pgQuery) filter(opts ListOptions) {
+	q.and(`deleted_at IS NULL`)
+	q.and(owned("todos"))
+	if opts.ListID != nil {
+		q.and(`list_id = ` + q.arg(*opts.ListID))
+	}
+	if opts.Completed != nil {
+		q.and(`completed = ` + q.arg(*opts.Completed))
+	}
+	if opts.Status != nil {
+		q.and(`status = ` + q.arg(string(*opts.Status)))
+	} else if !opts.IncludeArchived {
+		q.and(`status <> 'archived'`)
+	}
+	if opts.CreatedAfter != nil {
+		q.and(`created_at > ` + q.arg(*opts.CreatedAfter))
+	}
+	if opts.CreatedBefore != nil {
+		q.and(`created_at < ` + q.arg(*opts.CreatedBefore))
+	}
+	if opts.UpdatedSince != nil {
+		q.and(`updated_at >= ` + q.arg(*opts.UpdatedSince))
+	}
+	if opts.Priority != nil {
+		q.and(`priority = ` + q.arg(string(*opts.Priority)))
+	}
+	if opts.Overdue {
+		q.and(`NOT completed AND due_at < NOW()`)
+	}
+	if opts.DueAfter != nil {
+		q.and(`due_at >= ` + q.arg(*opts.DueAfter))
+	}
+	if opts.DueBefore != nil {
+		q.and(`due_at < ` + q.arg(*opts.DueBefore))
+	}
+	if len(opts.Tags) > 0 {
+		tagged := `(SELECT COUNT(*) FROM todo_tags tt WHERE tt.todo_id = todos.id AND tt.tag = ANY(` + q.arg(opts.Tags) + `))`
+		if opts.TagMatch == TagMatchAny {
+			q.and(tagged + ` > 0`)
+		} else {
+			q.and(tagged + ` = ` + q.arg(len(opts.Tags)))
+		}
+	}
+}
+
+func orderBy(s Sort) string {
+	col := "created_at"
+	switch s.Field {
+	case SortUpdatedAt:
+		col = "updated_at"
+	case SortTitle:
+		col = "title"
+	case SortDueAt:
+		col = "due_at"
+	}
+	dir := " ASC"
+	if s.Desc {
+		dir = " DESC"
+	}
+	return ` ORDER BY ` + col + dir + `, id` + dir
+}
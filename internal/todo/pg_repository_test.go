@@ -23,7 +23,7 @@ func TestPostgresRepository_CRUD(t *testing.T) {
 	repo := NewPostgresRepository(db)
 	ctx := context.Background()
 
-	created, err := repo.Create(ctx, "it")
+	created, err := repo.Create(ctx, CreateTodoRequest{Title: "it"})
 	if err != nil {
 		t.Fatalf("Create: %v", err)
 	}
@@ -34,17 +34,17 @@ func TestPostgresRepository_CRUD(t *testing.T) {
 	if got.ID != created.ID {
 		t.Fatalf("mismatch")
 	}
-	list, err := repo.List(ctx, 10, 0)
+	list, err := repo.List(ctx, ListOptions{Limit: 10})
 	if err != nil || len(list) == 0 {
 		t.Fatalf("List: %v len=%d", err, len(list))
 	}
 	title := "changed"
 	done := true
-	updated, err := repo.Update(ctx, created.ID, UpdateTodoRequest{Title: &title, Completed: &done})
+	updated, err := repo.Update(ctx, created.ID, UpdateTodoRequest{Title: &title, Completed: &done}, 0)
 	if err != nil || updated.Title != "changed" || !updated.Completed {
 		t.Fatalf("Update: %v %+v", err, updated)
 	}
-	if err := repo.Delete(ctx, created.ID); err != nil {
+	if err := repo.Delete(ctx, created.ID, 0); err != nil {
 		t.Fatalf("Delete: %v", err)
 	}
 	if _, err := repo.Get(ctx, created.ID); err == nil {
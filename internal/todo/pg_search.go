@@ -0,0 +1,62 @@
+package todo
+
+import (
+	"context"
+	"database/sql"
+)
+
+// searchDocument must match the text indexed by the search_vector column,
+// with the title first so highlights read naturally.
+const searchDocument = `title || COALESCE(E'\n' || description, '')`
+
+func (r *PostgresRepository) Search(ctx context.Context, opts SearchOptions) ([]SearchResult, error) {
+	var q pgQuery
+	tsquery := `websearch_to_tsquery('english', ` + q.arg(opts.Query) + `)`
+	q.and(`search_vector @@ ` + tsquery)
+	q.filter(opts.ListOptions)
+	highlight := `NULL::text`
+	if opts.Highlight {
+		highlight = `ts_headline('english', ` + searchDocument + `, ` + tsquery + `, 'HighlightAll=true')`
+	}
+	// Normalization 32 scales the rank into [0, 1) as rank/(rank+1).
+	rank := `ts_rank(search_vector, ` + tsquery + `, 32)`
+	query := `SELECT ` + todoColumns + `, ` + rank + `, ` + highlight + ` FROM todos` + q.whereClause() +
+		` ORDER BY ` + rank + ` DESC, created_at DESC, id DESC`
+	if opts.Limit > 0 {
+		query += ` LIMIT ` + q.arg(opts.Limit)
+	}
+	if opts.Offset > 0 {
+		query += ` OFFSET ` + q.arg(opts.Offset)
+	}
+	var results []SearchResult
+	err := r.withTx(ctx, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, query, q.args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var res SearchResult
+			res.Todo, err = scanTodo(extraScanner{rows, []any{&res.Rank, &res.Highlight}})
+			if err != nil {
+				return err
+			}
+			results = append(results, res)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// extraScanner scans the columns scanTodo expects followed by extra ones.
+type extraScanner struct {
+	row   rowScanner
+	extra []any
+}
+
+func (s extraScanner) Scan(dest ...any) error {
+	return s.row.Scan(append(dest, s.extra...)...)
+}
@@ -0,0 +1,206 @@
+package todo
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"time"
+)
+
+var ErrInvalidSort = errors.New("invalid sort")
+
+type SortField string
+
+const (
+	SortCreatedAt SortField = "created_at"
+	SortUpdatedAt SortField = "updated_at"
+	SortTitle     SortField = "title"
+	SortDueAt     SortField = "due_at"
+)
+
+// Sort orders a listing by a single field. Ties are always broken by ID in
+// the same direction so that pages are stable.
+type Sort struct {
+	Field SortField
+	Desc  bool
+}
+
+// DefaultSort is newest first, which is what List returned before sorting
+// became configurable.
+var DefaultSort = Sort{Field: SortCreatedAt, Desc: true}
+
+// ParseSort parses the sort query parameter. A leading "-" selects
+// descending order, e.g. "-updated_at".
+func ParseSort(s string) (Sort, error) {
+	if s == "" {
+		return DefaultSort, nil
+	}
+	var out Sort
+	if strings.HasPrefix(s, "-") {
+		out.Desc = true
+		s = s[1:]
+	}
+	switch SortField(s) {
+	case SortCreatedAt, SortUpdatedAt, SortTitle, SortDueAt:
+		out.Field = SortField(s)
+	default:
+		return Sort{}, ErrInvalidSort
+	}
+	return out, nil
+}
+
+// Keyset reports whether listings in this order can be paged with a Cursor.
+func (s Sort) Keyset() bool {
+	return s.Field == SortCreatedAt
+}
+
+func (s Sort) String() string {
+	if s.Desc {
+		return "-" + string(s.Field)
+	}
+	return string(s.Field)
+}
+
+type TagMatch string
+
+const (
+	TagMatchAll TagMatch = "all"
+	TagMatchAny TagMatch = "any"
+)
+
+// ListOptions filters, orders and pages the result of Repository.List.
+// Nil filter fields are ignored. A Limit of zero or less means no limit.
+//
+// After switches to keyset pagination: only rows strictly after the cursor
+// in the current sort direction are returned. It is only meaningful when
+// sorting by created_at; callers should reject other combinations.
+type ListOptions struct {
+	Limit  int
+	Offset int
+	After  *Cursor
+
+	ListID    *string
+	Completed *bool
+	// Status selects a single status. Without it archived todos are left
+	// out unless IncludeArchived is set.
+	Status          *Status
+	IncludeArchived bool
+
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	UpdatedSince  *time.Time
+
+	Priority *Priority
+	// Overdue selects incomplete todos whose due date has passed.
+	Overdue   bool
+	DueAfter  *time.Time
+	DueBefore *time.Time
+
+	// Tags selects todos carrying the given (normalized) tags; TagMatch
+	// decides whether any or all of them must be present.
+	Tags     []string
+	TagMatch TagMatch
+
+	Sort Sort
+}
+
+func (o ListOptions) sort() Sort {
+	if o.Sort.Field == "" {
+		return DefaultSort
+	}
+	return o.Sort
+}
+
+// matches reports whether t passes every filter in o. It is the in-memory
+// equivalent of the WHERE clause built by PostgresRepository.
+func (o ListOptions) matches(t Todo) bool {
+	if t.DeletedAt != nil {
+		return false
+	}
+	if o.ListID != nil && (t.ListID == nil || *t.ListID != *o.ListID) {
+		return false
+	}
+	if o.Completed != nil && t.Completed != *o.Completed {
+		return false
+	}
+	if o.Status != nil && t.Status != *o.Status {
+		return false
+	}
+	if o.Status == nil && !o.IncludeArchived && t.Status == StatusArchived {
+		return false
+	}
+	if o.CreatedAfter != nil && !t.CreatedAt.After(*o.CreatedAfter) {
+		return false
+	}
+	if o.CreatedBefore != nil && !t.CreatedAt.Before(*o.CreatedBefore) {
+		return false
+	}
+	if o.UpdatedSince != nil && t.UpdatedAt.Before(*o.UpdatedSince) {
+		return false
+	}
+	if o.Priority != nil && t.Priority != *o.Priority {
+		return false
+	}
+	if o.Overdue && (t.Completed || t.DueAt == nil || !t.DueAt.Before(time.Now())) {
+		return false
+	}
+	if o.DueAfter != nil && (t.DueAt == nil || t.DueAt.Before(*o.DueAfter)) {
+		return false
+	}
+	if o.DueBefore != nil && (t.DueAt == nil || !t.DueAt.Before(*o.DueBefore)) {
+		return false
+	}
+	if len(o.Tags) > 0 && !o.matchesTags(t.Tags) {
+		return false
+	}
+	return true
+}
+
+func (o ListOptions) matchesTags(tags []string) bool {
+	found := 0
+	for _, want := range o.Tags {
+		if slices.Contains(tags, want) {
+			found++
+		}
+	}
+	if o.TagMatch == TagMatchAny {
+		return found > 0
+	}
+	return found == len(o.Tags)
+}
+
+// less orders a before b according to s, falling back to ID.
+func (s Sort) less(a, b Todo) bool {
+	var c int
+	switch s.Field {
+	case SortUpdatedAt:
+		c = a.UpdatedAt.Compare(b.UpdatedAt)
+	case SortTitle:
+		c = strings.Compare(a.Title, b.Title)
+	case SortDueAt:
+		c = compareDue(a.DueAt, b.DueAt)
+	default:
+		c = a.CreatedAt.Compare(b.CreatedAt)
+	}
+	if c == 0 {
+		c = strings.Compare(a.ID, b.ID)
+	}
+	if s.Desc {
+		return c > 0
+	}
+	return c < 0
+}
+
+// compareDue orders missing due dates after every real one, matching the
+// default NULL placement of Postgres.
+func compareDue(a, b *time.Time) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return 1
+	case b == nil:
+		return -1
+	}
+	return a.Compare(*b)
+}
@@ -0,0 +1,53 @@
+package todo
+
+import (
+	"fmt"
+
+	"github.com/jplaulau14/go-todo-api/internal/recurrence"
+)
+
+// nextOccurrence returns the request that creates the occurrence following
+// t in its series. The second result is false when t does not repeat or its
+// rule is exhausted. The next due date is computed from t's due date rather
+// than from when it was completed, so finishing a chore late does not shift
+// the schedule.
+func nextOccurrence(t Todo) (CreateTodoRequest, bool, error) {
+	if t.Recurrence == nil || t.DueAt == nil {
+		return CreateTodoRequest{}, false, nil
+	}
+	rule, err := recurrence.Parse(*t.Recurrence)
+	if err != nil {
+		return CreateTodoRequest{}, false, fmt.Errorf("stored recurrence %q: %w", *t.Recurrence, err)
+	}
+	start := *t.DueAt
+	if t.RecurrenceStart != nil {
+		start = *t.RecurrenceStart
+	}
+	due, ok := rule.Next(start, *t.DueAt)
+	if !ok {
+		return CreateTodoRequest{}, false, nil
+	}
+	return CreateTodoRequest{
+		Title:           t.Title,
+		Description:     t.Description,
+		Priority:        t.Priority,
+		DueAt:           &due,
+		Tags:            append([]string{}, t.Tags...),
+		ListID:          t.ListID,
+		Recurrence:      t.Recurrence,
+		RecurrenceStart: &start,
+	}, true, nil
+}
+
+// nextAfter returns the occurrence to create when a write took a todo from
+// before to after. Only a write that completes a recurring todo creates
+// one, and repositories make it in the same write, so that concurrent
+// completions cannot both create it and a completion cannot miss it. Each
+// occurrence advances the series once: a todo that already has a successor
+// can be reopened and completed again without creating another.
+func nextAfter(before, after Todo) (CreateTodoRequest, bool, error) {
+	if before.Completed || !after.Completed || after.nextID != "" {
+		return CreateTodoRequest{}, false, nil
+	}
+	return nextOccurrence(after)
+}
@@ -3,6 +3,8 @@ package todo
 import (
 	"context"
 	"errors"
+	"fmt"
+	"slices"
 	"sort"
 	"sync"
 	"time"
@@ -12,45 +14,169 @@ import (
 
 var (
 	ErrNotFound = errors.New("todo not found")
+	// ErrVersionMismatch is returned by conditional writes when the todo
+	// has changed since the version the caller expected.
+	ErrVersionMismatch = errors.New("todo version mismatch")
+	// ErrTodoInTrash is returned by Upsert, and by Create given an id,
+	// when the id belongs to a trashed todo.
+	ErrTodoInTrash = errors.New("todo is in the trash")
+	// ErrTodoExists is returned by Create when a live todo already has the
+	// id it was given.
+	ErrTodoExists = errors.New("todo already exists")
+	// ErrInvalidUpdate wraps the failures of UpdateTodoRequest.ValidateFor,
+	// which Update checks against the todo as it changes it.
+	ErrInvalidUpdate = errors.New("invalid update")
 )
 
 type Repository interface {
-	Create(ctx context.Context, title string) (Todo, error)
+	// Create never replaces a todo: given an id that is taken it fails with
+	// ErrTodoExists or ErrTodoInTrash, or ErrNotFound if another owner
+	// holds it.
+	Create(ctx context.Context, req CreateTodoRequest) (Todo, error)
 	Get(ctx context.Context, id string) (Todo, error)
-	List(ctx context.Context, limit, offset int) ([]Todo, error)
-	Update(ctx context.Context, id string, update UpdateTodoRequest) (Todo, error)
-	Delete(ctx context.Context, id string) error
+	List(ctx context.Context, opts ListOptions) ([]Todo, error)
+	// Count returns how many todos match the filters in opts, ignoring
+	// pagination and sort.
+	Count(ctx context.Context, opts ListOptions) (int, error)
+	// Update, Delete and Purge take the version the caller last saw. When
+	// it is non-zero the write only happens if the todo is still at that
+	// version, and otherwise fails with ErrVersionMismatch. An Update or
+	// Upsert that completes a recurring todo also creates its next
+	// occurrence, atomically with the write. Update checks the rules that
+	// depend on the todo's state against the state it changes, and fails
+	// with ErrInvalidUpdate if they are broken.
+	Update(ctx context.Context, id string, update UpdateTodoRequest, ifVersion int64) (Todo, error)
+	// Upsert replaces the todo with id, keeping only its identity, creation
+	// time and checklist, or creates one with that id if none exists. It
+	// reports whether the todo was created. A non-zero ifVersion works as
+	// for Update and fails with ErrVersionMismatch if there is no todo.
+	Upsert(ctx context.Context, id string, req CreateTodoRequest, ifVersion int64) (Todo, bool, error)
+	// Delete moves a todo to the trash. Trashed todos are hidden from every
+	// other method until restored.
+	Delete(ctx context.Context, id string, ifVersion int64) error
+
+	// ArchiveCompleted archives every done todo completed before cutoff
+	// and returns how many were archived.
+	ArchiveCompleted(ctx context.Context, cutoff time.Time) (int, error)
+
+	// Trash returns soft-deleted todos, most recently deleted first.
+	Trash(ctx context.Context, limit, offset int) ([]Todo, error)
+	// Restore takes a todo out of the trash. It returns ErrNotFound if the
+	// todo is not in the trash.
+	Restore(ctx context.Context, id string) (Todo, error)
+	// Purge permanently removes a todo, whether or not it is in the trash.
+	Purge(ctx context.Context, id string, ifVersion int64) error
+	// PurgeTrash permanently removes todos deleted before cutoff, whoever
+	// owns them, and returns how many were removed. It is for maintenance
+	// jobs rather than callers.
+	PurgeTrash(ctx context.Context, cutoff time.Time) (int, error)
+
+	// AddTags attaches tags to a todo, keeping any it already has.
+	AddTags(ctx context.Context, id string, tags []string) (Todo, error)
+	// RemoveTag detaches a tag. Removing a tag the todo does not carry is
+	// not an error.
+	RemoveTag(ctx context.Context, id, tag string) (Todo, error)
+	// ListTags returns every tag in use with the number of todos carrying
+	// it, most used first.
+	ListTags(ctx context.Context) ([]TagCount, error)
+
+	// Search returns todos matching a full-text query, most relevant first.
+	Search(ctx context.Context, opts SearchOptions) ([]SearchResult, error)
+
+	// Bulk applies validated operations in order and reports a result for
+	// each. When atomic is set the first failure undoes the whole batch and
+	// every other result fails with ErrBulkAborted. The error is only set
+	// when the batch could not be run at all.
+	Bulk(ctx context.Context, ops []BulkOperation, atomic bool) ([]BulkResult, error)
 }
 
 type InMemoryRepository struct {
 	mu    sync.RWMutex
 	store map[string]Todo
+	lists map[string]TodoList
+	items map[string][]ChecklistItem
+	// grants is keyed by what is shared and with whom.
+	grants map[grantKey]Grant
 }
 
 func NewInMemoryRepository() *InMemoryRepository {
-	return &InMemoryRepository{store: make(map[string]Todo)}
+	return &InMemoryRepository{
+		store:  make(map[string]Todo),
+		lists:  make(map[string]TodoList),
+		items:  make(map[string][]ChecklistItem),
+		grants: make(map[grantKey]Grant),
+	}
 }
 
-func (r *InMemoryRepository) Create(ctx context.Context, title string) (Todo, error) {
-	_ = ctx
-	now := time.Now().UTC()
-	t := Todo{
-		ID:        uuid.NewString(),
-		Title:     title,
-		Completed: false,
-		CreatedAt: now,
-		UpdatedAt: now,
-	}
+func (r *InMemoryRepository) Create(ctx context.Context, req CreateTodoRequest) (Todo, error) {
 	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.create(OwnerOf(ctx), req, time.Now().UTC())
+}
+
+// create stores a new todo for o. r.mu must be held for writing.
+func (r *InMemoryRepository) create(o Owner, req CreateTodoRequest, now time.Time) (Todo, error) {
+	t := newTodo(req, now)
+	if current, ok := r.store[t.ID]; ok {
+		switch {
+		case current.owner != o:
+			return Todo{}, ErrNotFound
+		case current.DeletedAt != nil:
+			return Todo{}, ErrTodoInTrash
+		}
+		return Todo{}, ErrTodoExists
+	}
+	if t.ListID != nil {
+		if _, ok := r.list(o, *t.ListID); !ok {
+			return Todo{}, ErrListNotFound
+		}
+	}
+	t.owner = o
 	r.store[t.ID] = t
-	r.mu.Unlock()
 	return t, nil
 }
 
+// newTodo builds a fresh todo from a validated create request.
+func newTodo(req CreateTodoRequest, now time.Time) Todo {
+	t := Todo{
+		ID:          uuid.NewString(),
+		Title:       req.Title,
+		Description: req.Description,
+		Status:      StatusOpen,
+		Priority:    req.Priority,
+		Tags:        append([]string{}, req.Tags...),
+		ListID:      req.ListID,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+		Version:     1,
+	}
+	if req.ID != "" {
+		t.ID = req.ID
+	}
+	if t.Priority == "" {
+		t.Priority = PriorityNormal
+	}
+	if req.Status != "" {
+		t.setStatus(req.Status, now)
+	}
+	if req.DueAt != nil {
+		due := req.DueAt.UTC()
+		t.DueAt = &due
+	}
+	if req.Recurrence != nil {
+		rule := *req.Recurrence
+		t.Recurrence, t.RecurrenceStart = &rule, t.DueAt
+		if req.RecurrenceStart != nil {
+			start := req.RecurrenceStart.UTC()
+			t.RecurrenceStart = &start
+		}
+	}
+	return t
+}
+
 func (r *InMemoryRepository) Get(ctx context.Context, id string) (Todo, error) {
-	_ = ctx
 	r.mu.RLock()
-	t, ok := r.store[id]
+	t, ok := r.live(OwnerOf(ctx), id)
 	r.mu.RUnlock()
 	if !ok {
 		return Todo{}, ErrNotFound
@@ -58,60 +184,347 @@ func (r *InMemoryRepository) Get(ctx context.Context, id string) (Todo, error) {
 	return t, nil
 }
 
-func (r *InMemoryRepository) List(ctx context.Context, limit, offset int) ([]Todo, error) {
-	_ = ctx
+func (r *InMemoryRepository) List(ctx context.Context, opts ListOptions) ([]Todo, error) {
+	o := OwnerOf(ctx)
 	r.mu.RLock()
-	// Copy to slice
+	// Copy matching todos to slice
+	s := opts.sort()
 	all := make([]Todo, 0, len(r.store))
 	for _, t := range r.store {
+		if t.owner != o || !opts.matches(t) {
+			continue
+		}
+		if opts.After != nil && !opts.After.after(t, s.Desc) {
+			continue
+		}
 		all = append(all, t)
 	}
 	r.mu.RUnlock()
 
-	// Sort newest first by CreatedAt
-	sort.Slice(all, func(i, j int) bool { return all[i].CreatedAt.After(all[j].CreatedAt) })
+	sort.Slice(all, func(i, j int) bool { return s.less(all[i], all[j]) })
 
-	// Apply offset and limit safely
+	return paginate(all, opts.Limit, opts.Offset), nil
+}
+
+func (r *InMemoryRepository) Count(ctx context.Context, opts ListOptions) (int, error) {
+	o := OwnerOf(ctx)
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	n := 0
+	for _, t := range r.store {
+		if t.owner == o && opts.matches(t) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// paginate applies offset and limit safely. A limit of zero or less
+// returns everything after offset.
+func paginate[T any](all []T, limit, offset int) []T {
 	if offset < 0 {
 		offset = 0
 	}
 	if offset >= len(all) {
-		return []Todo{}, nil
+		return []T{}
 	}
-	end := offset + limit
-	if end > len(all) {
-		end = len(all)
+	end := len(all)
+	if limit > 0 && offset+limit < end {
+		end = offset + limit
 	}
-	return all[offset:end], nil
+	return all[offset:end]
 }
 
-func (r *InMemoryRepository) Update(ctx context.Context, id string, update UpdateTodoRequest) (Todo, error) {
-	_ = ctx
+// Update creates the next occurrence of a recurring todo it completes.
+func (r *InMemoryRepository) Update(ctx context.Context, id string, update UpdateTodoRequest, ifVersion int64) (Todo, error) {
+	o := OwnerOf(ctx)
 	r.mu.Lock()
-	t, ok := r.store[id]
+	defer r.mu.Unlock()
+	now := time.Now().UTC()
+	current, _ := r.live(o, id)
+	t, err := r.update(o, id, update, ifVersion, now)
+	if err != nil {
+		return Todo{}, err
+	}
+	if _, err := r.createNext(o, current, t, now); err != nil {
+		r.store[id] = current
+		return Todo{}, err
+	}
+	return t, nil
+}
+
+// createNext creates the next occurrence if a write took a todo of o from
+// before to after, records it as after's successor and returns it. r.mu
+// must be held for writing.
+func (r *InMemoryRepository) createNext(o Owner, before, after Todo, now time.Time) (*Todo, error) {
+	req, ok, err := nextAfter(before, after)
+	if err != nil || !ok {
+		return nil, err
+	}
+	t, err := r.create(o, req, now)
+	if err != nil {
+		return nil, err
+	}
+	after.nextID = t.ID
+	r.store[after.ID] = after
+	return &t, nil
+}
+
+// update applies a change to a live todo of o. r.mu must be held for
+// writing.
+func (r *InMemoryRepository) update(o Owner, id string, update UpdateTodoRequest, ifVersion int64, now time.Time) (Todo, error) {
+	t, ok := r.live(o, id)
 	if !ok {
-		r.mu.Unlock()
 		return Todo{}, ErrNotFound
 	}
-	if update.Title != nil {
-		t.Title = *update.Title
+	if ifVersion != 0 && t.Version != ifVersion {
+		return Todo{}, ErrVersionMismatch
+	}
+	if err := update.ValidateFor(t); err != nil {
+		return Todo{}, fmt.Errorf("%w: %v", ErrInvalidUpdate, err)
 	}
-	if update.Completed != nil {
-		t.Completed = *update.Completed
+	if update.ListID != nil && *update.ListID != "" {
+		if _, ok := r.list(o, *update.ListID); !ok {
+			return Todo{}, ErrListNotFound
+		}
 	}
-	t.UpdatedAt = time.Now().UTC()
+	if err := update.apply(&t, now); err != nil {
+		return Todo{}, err
+	}
+	t.touch(now)
 	r.store[id] = t
-	r.mu.Unlock()
 	return t, nil
 }
 
-func (r *InMemoryRepository) Delete(ctx context.Context, id string) error {
-	_ = ctx
+// Upsert cannot create a todo under an id another owner uses, and reports
+// ErrNotFound instead. Like Update, it creates the next occurrence of a
+// recurring todo it completes.
+func (r *InMemoryRepository) Upsert(ctx context.Context, id string, req CreateTodoRequest, ifVersion int64) (Todo, bool, error) {
+	o := OwnerOf(ctx)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if req.ListID != nil {
+		if _, ok := r.list(o, *req.ListID); !ok {
+			return Todo{}, false, ErrListNotFound
+		}
+	}
+	now := time.Now().UTC()
+	current, ok := r.store[id]
+	switch {
+	case (!ok || current.owner != o) && ifVersion != 0:
+		return Todo{}, false, ErrVersionMismatch
+	case ok && current.owner != o:
+		return Todo{}, false, ErrNotFound
+	case !ok:
+		t := newTodo(req, now)
+		t.ID, t.owner = id, o
+		r.store[id] = t
+		return t, true, nil
+	case current.DeletedAt != nil:
+		return Todo{}, false, ErrTodoInTrash
+	case ifVersion != 0 && current.Version != ifVersion:
+		return Todo{}, false, ErrVersionMismatch
+	}
+	t, err := current.replace(req, now)
+	if err != nil {
+		return Todo{}, false, err
+	}
+	r.store[id] = t
+	if _, err := r.createNext(o, current, t, now); err != nil {
+		r.store[id] = current
+		return Todo{}, false, err
+	}
+	return t, false, nil
+}
+
+func (r *InMemoryRepository) ArchiveCompleted(ctx context.Context, cutoff time.Time) (int, error) {
+	o := OwnerOf(ctx)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now().UTC()
+	n := 0
+	for id, t := range r.store {
+		if t.owner != o || t.DeletedAt != nil || t.Status != StatusDone || t.CompletedAt == nil || !t.CompletedAt.Before(cutoff) {
+			continue
+		}
+		t.setStatus(StatusArchived, now)
+		t.touch(now)
+		r.store[id] = t
+		n++
+	}
+	return n, nil
+}
+
+// touch records a change to t.
+func (t *Todo) touch(now time.Time) {
+	t.UpdatedAt = now
+	t.Version++
+}
+
+// live returns the todo with id unless it is missing, trashed or not
+// owned by o. The caller must hold r.mu.
+func (r *InMemoryRepository) live(o Owner, id string) (Todo, bool) {
+	t, ok := r.owned(o, id)
+	if !ok || t.DeletedAt != nil {
+		return Todo{}, false
+	}
+	return t, true
+}
+
+// owned returns the todo with id, trashed or not, if o owns it. The
+// caller must hold r.mu.
+func (r *InMemoryRepository) owned(o Owner, id string) (Todo, bool) {
+	t, ok := r.store[id]
+	if !ok || t.owner != o {
+		return Todo{}, false
+	}
+	return t, true
+}
+
+func (r *InMemoryRepository) Delete(ctx context.Context, id string, ifVersion int64) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	if _, ok := r.store[id]; !ok {
+	return r.delete(OwnerOf(ctx), id, ifVersion, time.Now().UTC())
+}
+
+// delete moves a todo of o to the trash. r.mu must be held for writing.
+func (r *InMemoryRepository) delete(o Owner, id string, ifVersion int64, now time.Time) error {
+	t, ok := r.live(o, id)
+	if !ok {
 		return ErrNotFound
 	}
+	if ifVersion != 0 && t.Version != ifVersion {
+		return ErrVersionMismatch
+	}
+	t.DeletedAt = &now
+	t.Version++
+	r.store[id] = t
+	return nil
+}
+
+func (r *InMemoryRepository) Trash(ctx context.Context, limit, offset int) ([]Todo, error) {
+	o := OwnerOf(ctx)
+	r.mu.RLock()
+	var all []Todo
+	for _, t := range r.store {
+		if t.owner == o && t.DeletedAt != nil {
+			all = append(all, t)
+		}
+	}
+	r.mu.RUnlock()
+
+	sort.Slice(all, func(i, j int) bool {
+		if c := all[i].DeletedAt.Compare(*all[j].DeletedAt); c != 0 {
+			return c > 0
+		}
+		return all[i].ID > all[j].ID
+	})
+	return paginate(all, limit, offset), nil
+}
+
+func (r *InMemoryRepository) Restore(ctx context.Context, id string) (Todo, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t, ok := r.owned(OwnerOf(ctx), id)
+	if !ok || t.DeletedAt == nil {
+		return Todo{}, ErrNotFound
+	}
+	t.DeletedAt = nil
+	t.touch(time.Now().UTC())
+	r.store[id] = t
+	return t, nil
+}
+
+func (r *InMemoryRepository) Purge(ctx context.Context, id string, ifVersion int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t, ok := r.owned(OwnerOf(ctx), id)
+	if !ok {
+		return ErrNotFound
+	}
+	if ifVersion != 0 && t.Version != ifVersion {
+		return ErrVersionMismatch
+	}
 	delete(r.store, id)
+	delete(r.items, id)
+	r.dropGrants(ResourceTodo, id)
 	return nil
 }
+
+func (r *InMemoryRepository) PurgeTrash(ctx context.Context, cutoff time.Time) (int, error) {
+	_ = ctx
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for id, t := range r.store {
+		if t.DeletedAt != nil && t.DeletedAt.Before(cutoff) {
+			delete(r.store, id)
+			delete(r.items, id)
+			r.dropGrants(ResourceTodo, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (r *InMemoryRepository) AddTags(ctx context.Context, id string, tags []string) (Todo, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t, ok := r.live(OwnerOf(ctx), id)
+	if !ok {
+		return Todo{}, ErrNotFound
+	}
+	merged, _ := NormalizeTags(append(append([]string{}, t.Tags...), tags...))
+	t.Tags = merged
+	t.touch(time.Now().UTC())
+	r.store[id] = t
+	return t, nil
+}
+
+func (r *InMemoryRepository) RemoveTag(ctx context.Context, id, tag string) (Todo, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t, ok := r.live(OwnerOf(ctx), id)
+	if !ok {
+		return Todo{}, ErrNotFound
+	}
+	i := slices.Index(t.Tags, tag)
+	if i < 0 {
+		return t, nil
+	}
+	t.Tags = slices.Delete(slices.Clone(t.Tags), i, i+1)
+	t.touch(time.Now().UTC())
+	r.store[id] = t
+	return t, nil
+}
+
+func (r *InMemoryRepository) ListTags(ctx context.Context) ([]TagCount, error) {
+	o := OwnerOf(ctx)
+	counts := make(map[string]int)
+	r.mu.RLock()
+	for _, t := range r.store {
+		if t.owner != o || t.DeletedAt != nil {
+			continue
+		}
+		for _, tag := range t.Tags {
+			counts[tag]++
+		}
+	}
+	r.mu.RUnlock()
+	out := make([]TagCount, 0, len(counts))
+	for name, n := range counts {
+		out = append(out, TagCount{Name: name, Count: n})
+	}
+	sortTagCounts(out)
+	return out, nil
+}
+
+// sortTagCounts orders by count descending, then name.
+func sortTagCounts(tags []TagCount) {
+	sort.Slice(tags, func(i, j int) bool {
+		if tags[i].Count != tags[j].Count {
+			return tags[i].Count > tags[j].Count
+		}
+		return tags[i].Name < tags[j].Name
+	})
+}
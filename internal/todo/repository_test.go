@@ -2,15 +2,23 @@ package todo
 
 import (
 	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
 	"testing"
 	"time"
+
+	"github.com/jplaulau14/go-todo-api/internal/policy"
+	"github.com/jplaulau14/go-todo-api/internal/reqctx"
 )
 
 func TestInMemoryRepository_CRUD(t *testing.T) {
 	repo := NewInMemoryRepository()
 	ctx := context.Background()
 
-	created, err := repo.Create(ctx, "test")
+	created, err := repo.Create(ctx, CreateTodoRequest{Title: "test"})
 	if err != nil {
 		t.Fatalf("Create error: %v", err)
 	}
@@ -26,14 +34,14 @@ func TestInMemoryRepository_CRUD(t *testing.T) {
 		t.Fatalf("Get mismatch: got %s want %s", got.ID, created.ID)
 	}
 
-	list, err := repo.List(ctx, 10, 0)
+	list, err := repo.List(ctx, ListOptions{Limit: 10})
 	if err != nil || len(list) != 1 {
 		t.Fatalf("List error or wrong len: %v len=%d", err, len(list))
 	}
 
 	newTitle := "updated"
 	done := true
-	updated, err := repo.Update(ctx, created.ID, UpdateTodoRequest{Title: &newTitle, Completed: &done})
+	updated, err := repo.Update(ctx, created.ID, UpdateTodoRequest{Title: &newTitle, Completed: &done}, 0)
 	if err != nil {
 		t.Fatalf("Update error: %v", err)
 	}
@@ -41,7 +49,7 @@ func TestInMemoryRepository_CRUD(t *testing.T) {
 		t.Fatalf("unexpected updated: %+v", updated)
 	}
 
-	if err := repo.Delete(ctx, created.ID); err != nil {
+	if err := repo.Delete(ctx, created.ID, 0); err != nil {
 		t.Fatalf("Delete error: %v", err)
 	}
 	if _, err := repo.Get(ctx, created.ID); err == nil {
@@ -53,14 +61,14 @@ func TestInMemoryRepository_ListPagination(t *testing.T) {
 	repo := NewInMemoryRepository()
 	ctx := context.Background()
 
-	a, _ := repo.Create(ctx, "a")
+	a, _ := repo.Create(ctx, CreateTodoRequest{Title: "a"})
 	time.Sleep(5 * time.Millisecond)
-	b, _ := repo.Create(ctx, "b")
+	b, _ := repo.Create(ctx, CreateTodoRequest{Title: "b"})
 	time.Sleep(5 * time.Millisecond)
-	c, _ := repo.Create(ctx, "c")
+	c, _ := repo.Create(ctx, CreateTodoRequest{Title: "c"})
 
 	// Default order is newest first by CreatedAt -> c, b, a
-	list, err := repo.List(ctx, 10, 0)
+	list, err := repo.List(ctx, ListOptions{Limit: 10})
 	if err != nil {
 		t.Fatalf("List: %v", err)
 	}
@@ -72,20 +80,868 @@ func TestInMemoryRepository_ListPagination(t *testing.T) {
 	}
 
 	// Limit 2
-	list, _ = repo.List(ctx, 2, 0)
+	list, _ = repo.List(ctx, ListOptions{Limit: 2})
 	if len(list) != 2 || list[0].ID != c.ID || list[1].ID != b.ID {
 		t.Fatalf("limit not applied: %+v", list)
 	}
 
 	// Offset 1
-	list, _ = repo.List(ctx, 2, 1)
+	list, _ = repo.List(ctx, ListOptions{Limit: 2, Offset: 1})
 	if len(list) != 2 || list[0].ID != b.ID || list[1].ID != a.ID {
 		t.Fatalf("offset not applied: %+v", list)
 	}
 
 	// Offset beyond size
-	list, _ = repo.List(ctx, 2, 5)
+	list, _ = repo.List(ctx, ListOptions{Limit: 2, Offset: 5})
 	if len(list) != 0 {
 		t.Fatalf("expected empty with large offset, got %d", len(list))
 	}
 }
+
+func TestInMemoryRepository_ListFiltersAndSort(t *testing.T) {
+	repo := NewInMemoryRepository()
+	ctx := context.Background()
+
+	a, _ := repo.Create(ctx, CreateTodoRequest{Title: "banana"})
+	time.Sleep(5 * time.Millisecond)
+	b, _ := repo.Create(ctx, CreateTodoRequest{Title: "apple"})
+	time.Sleep(5 * time.Millisecond)
+	c, _ := repo.Create(ctx, CreateTodoRequest{Title: "cherry"})
+
+	done := true
+	if _, err := repo.Update(ctx, a.ID, UpdateTodoRequest{Completed: &done}, 0); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+
+	list, _ := repo.List(ctx, ListOptions{Completed: &done})
+	if len(list) != 1 || list[0].ID != a.ID {
+		t.Fatalf("completed filter: %+v", list)
+	}
+
+	notDone := false
+	list, _ = repo.List(ctx, ListOptions{Completed: &notDone})
+	if len(list) != 2 {
+		t.Fatalf("not completed filter: %+v", list)
+	}
+
+	list, _ = repo.List(ctx, ListOptions{CreatedAfter: &a.CreatedAt, CreatedBefore: &c.CreatedAt})
+	if len(list) != 1 || list[0].ID != b.ID {
+		t.Fatalf("created window: %+v", list)
+	}
+
+	since := c.CreatedAt
+	list, _ = repo.List(ctx, ListOptions{UpdatedSince: &since})
+	if len(list) != 2 {
+		t.Fatalf("updated_since: expected a and c, got %+v", list)
+	}
+
+	list, _ = repo.List(ctx, ListOptions{Sort: Sort{Field: SortTitle}})
+	if list[0].ID != b.ID || list[1].ID != a.ID || list[2].ID != c.ID {
+		t.Fatalf("title sort: %+v", list)
+	}
+
+	list, _ = repo.List(ctx, ListOptions{Sort: Sort{Field: SortUpdatedAt, Desc: true}})
+	if list[0].ID != a.ID {
+		t.Fatalf("expected most recently updated first: %+v", list)
+	}
+}
+
+func TestParseSort(t *testing.T) {
+	s, err := ParseSort("-updated_at")
+	if err != nil || s.Field != SortUpdatedAt || !s.Desc {
+		t.Fatalf("unexpected: %+v %v", s, err)
+	}
+	if s, _ := ParseSort(""); s != DefaultSort {
+		t.Fatalf("expected default sort, got %+v", s)
+	}
+	if _, err := ParseSort("priority"); err == nil {
+		t.Fatalf("expected error for unknown field")
+	}
+}
+
+func TestInMemoryRepository_ListCursor(t *testing.T) {
+	repo := NewInMemoryRepository()
+	ctx := context.Background()
+
+	a, _ := repo.Create(ctx, CreateTodoRequest{Title: "a"})
+	time.Sleep(5 * time.Millisecond)
+	b, _ := repo.Create(ctx, CreateTodoRequest{Title: "b"})
+	time.Sleep(5 * time.Millisecond)
+	c, _ := repo.Create(ctx, CreateTodoRequest{Title: "c"})
+
+	cur := CursorFor(c)
+	list, _ := repo.List(ctx, ListOptions{After: &cur})
+	if len(list) != 2 || list[0].ID != b.ID || list[1].ID != a.ID {
+		t.Fatalf("descending cursor: %+v", list)
+	}
+
+	cur = CursorFor(a)
+	list, _ = repo.List(ctx, ListOptions{After: &cur, Sort: Sort{Field: SortCreatedAt}})
+	if len(list) != 2 || list[0].ID != b.ID || list[1].ID != c.ID {
+		t.Fatalf("ascending cursor: %+v", list)
+	}
+}
+
+func TestCursor_RoundTrip(t *testing.T) {
+	in := Cursor{CreatedAt: time.Date(2025, 8, 9, 10, 0, 0, 123456789, time.UTC), ID: "abc"}
+	out, err := DecodeCursor(in.Encode())
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if !out.CreatedAt.Equal(in.CreatedAt) || out.ID != in.ID {
+		t.Fatalf("round trip mismatch: %+v", out)
+	}
+	if _, err := DecodeCursor("not a cursor"); err == nil {
+		t.Fatalf("expected error for garbage cursor")
+	}
+}
+
+func TestInMemoryRepository_Count(t *testing.T) {
+	repo := NewInMemoryRepository()
+	ctx := context.Background()
+
+	a, _ := repo.Create(ctx, CreateTodoRequest{Title: "a"})
+	_, _ = repo.Create(ctx, CreateTodoRequest{Title: "b"})
+	_, _ = repo.Create(ctx, CreateTodoRequest{Title: "c"})
+	done := true
+	_, _ = repo.Update(ctx, a.ID, UpdateTodoRequest{Completed: &done}, 0)
+
+	if n, err := repo.Count(ctx, ListOptions{Limit: 1, Offset: 2}); err != nil || n != 3 {
+		t.Fatalf("Count should ignore pagination: n=%d err=%v", n, err)
+	}
+	if n, _ := repo.Count(ctx, ListOptions{Completed: &done}); n != 1 {
+		t.Fatalf("Count with filter: %d", n)
+	}
+}
+
+func TestInMemoryRepository_DueAndPriority(t *testing.T) {
+	repo := NewInMemoryRepository()
+	ctx := context.Background()
+
+	past := time.Now().Add(-time.Hour)
+	soon := time.Now().Add(time.Hour)
+	later := time.Now().Add(72 * time.Hour)
+	desc := "details"
+
+	overdue, _ := repo.Create(ctx, CreateTodoRequest{Title: "overdue", DueAt: &past, Priority: PriorityUrgent, Description: &desc})
+	dueSoon, _ := repo.Create(ctx, CreateTodoRequest{Title: "soon", DueAt: &soon})
+	_, _ = repo.Create(ctx, CreateTodoRequest{Title: "later", DueAt: &later})
+	_, _ = repo.Create(ctx, CreateTodoRequest{Title: "undated"})
+
+	if overdue.Description == nil || *overdue.Description != "details" || overdue.Priority != PriorityUrgent {
+		t.Fatalf("unexpected created: %+v", overdue)
+	}
+	if dueSoon.Priority != PriorityNormal {
+		t.Fatalf("expected default priority, got %q", dueSoon.Priority)
+	}
+
+	list, _ := repo.List(ctx, ListOptions{Overdue: true})
+	if len(list) != 1 || list[0].ID != overdue.ID {
+		t.Fatalf("overdue filter: %+v", list)
+	}
+
+	now := time.Now()
+	end := now.Add(24 * time.Hour)
+	list, _ = repo.List(ctx, ListOptions{DueAfter: &now, DueBefore: &end})
+	if len(list) != 1 || list[0].ID != dueSoon.ID {
+		t.Fatalf("due window filter: %+v", list)
+	}
+
+	urgent := PriorityUrgent
+	list, _ = repo.List(ctx, ListOptions{Priority: &urgent})
+	if len(list) != 1 || list[0].ID != overdue.ID {
+		t.Fatalf("priority filter: %+v", list)
+	}
+
+	list, _ = repo.List(ctx, ListOptions{Sort: Sort{Field: SortDueAt}})
+	if list[0].ID != overdue.ID || list[3].DueAt != nil {
+		t.Fatalf("due_at sort should put undated last: %+v", list)
+	}
+
+	done := true
+	_, _ = repo.Update(ctx, overdue.ID, UpdateTodoRequest{Completed: &done}, 0)
+	if list, _ := repo.List(ctx, ListOptions{Overdue: true}); len(list) != 0 {
+		t.Fatalf("completed todos are not overdue: %+v", list)
+	}
+}
+
+func TestInMemoryRepository_Tags(t *testing.T) {
+	repo := NewInMemoryRepository()
+	ctx := context.Background()
+
+	a, _ := repo.Create(ctx, CreateTodoRequest{Title: "a", Tags: []string{"work"}})
+	b, _ := repo.Create(ctx, CreateTodoRequest{Title: "b", Tags: []string{"work", "urgent"}})
+	c, _ := repo.Create(ctx, CreateTodoRequest{Title: "c"})
+
+	if c.Tags == nil || len(c.Tags) != 0 {
+		t.Fatalf("expected empty tag list, got %#v", c.Tags)
+	}
+
+	updated, err := repo.AddTags(ctx, c.ID, []string{"home", "urgent"})
+	if err != nil || len(updated.Tags) != 2 {
+		t.Fatalf("AddTags: %v %+v", err, updated)
+	}
+	updated, err = repo.AddTags(ctx, a.ID, []string{"work"})
+	if err != nil || len(updated.Tags) != 1 {
+		t.Fatalf("AddTags should not duplicate: %v %+v", err, updated)
+	}
+	if _, err := repo.AddTags(ctx, "missing", []string{"x"}); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	list, _ := repo.List(ctx, ListOptions{Tags: []string{"work", "urgent"}, TagMatch: TagMatchAll})
+	if len(list) != 1 || list[0].ID != b.ID {
+		t.Fatalf("all match: %+v", list)
+	}
+	list, _ = repo.List(ctx, ListOptions{Tags: []string{"work", "urgent"}, TagMatch: TagMatchAny})
+	if len(list) != 3 {
+		t.Fatalf("any match: %+v", list)
+	}
+
+	tags, _ := repo.ListTags(ctx)
+	if len(tags) != 3 || tags[0].Count != 2 || tags[0].Name != "urgent" || tags[1].Name != "work" {
+		t.Fatalf("unexpected tag counts: %+v", tags)
+	}
+
+	updated, err = repo.RemoveTag(ctx, b.ID, "urgent")
+	if err != nil || len(updated.Tags) != 1 || updated.Tags[0] != "work" {
+		t.Fatalf("RemoveTag: %v %+v", err, updated)
+	}
+	if _, err := repo.RemoveTag(ctx, b.ID, "urgent"); err != nil {
+		t.Fatalf("RemoveTag should be idempotent: %v", err)
+	}
+}
+
+func TestNormalizeTags(t *testing.T) {
+	got, err := NormalizeTags([]string{" Work", "home", "work"})
+	if err != nil || len(got) != 2 || got[0] != "home" || got[1] != "work" {
+		t.Fatalf("unexpected: %v %v", got, err)
+	}
+	for _, bad := range []string{"", "two words", "a,b", "a/b", strings.Repeat("x", 65)} {
+		if _, err := NormalizeTags([]string{bad}); err == nil {
+			t.Fatalf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestInMemoryRepository_Lists(t *testing.T) {
+	repo := NewInMemoryRepository()
+	ctx := context.Background()
+
+	work, _ := repo.CreateList(ctx, CreateListRequest{Name: "work"})
+	home, _ := repo.CreateList(ctx, CreateListRequest{Name: "home"})
+
+	lists, _ := repo.Lists(ctx, 10, 0)
+	if len(lists) != 2 || lists[0].ID != home.ID {
+		t.Fatalf("expected lists ordered by name: %+v", lists)
+	}
+
+	name := "office"
+	if l, err := repo.UpdateList(ctx, work.ID, UpdateListRequest{Name: &name}); err != nil || l.Name != "office" {
+		t.Fatalf("UpdateList: %v %+v", err, l)
+	}
+
+	todo, err := repo.Create(ctx, CreateTodoRequest{Title: "a", ListID: &work.ID})
+	if err != nil {
+		t.Fatalf("Create in list: %v", err)
+	}
+	missing := "missing"
+	if _, err := repo.Create(ctx, CreateTodoRequest{Title: "b", ListID: &missing}); err != ErrListNotFound {
+		t.Fatalf("expected ErrListNotFound, got %v", err)
+	}
+
+	if err := repo.DeleteList(ctx, work.ID, false); err != ErrListNotEmpty {
+		t.Fatalf("expected ErrListNotEmpty, got %v", err)
+	}
+
+	// Moving the todo out of the list lets it be deleted without cascade.
+	detach := ""
+	moved, err := repo.Update(ctx, todo.ID, UpdateTodoRequest{ListID: &detach}, 0)
+	if err != nil || moved.ListID != nil {
+		t.Fatalf("detach: %v %+v", err, moved)
+	}
+	if err := repo.DeleteList(ctx, work.ID, false); err != nil {
+		t.Fatalf("DeleteList: %v", err)
+	}
+	if _, err := repo.GetList(ctx, work.ID); err != ErrListNotFound {
+		t.Fatalf("expected ErrListNotFound, got %v", err)
+	}
+}
+
+func TestInMemoryRepository_Checklist(t *testing.T) {
+	repo := NewInMemoryRepository()
+	ctx := context.Background()
+
+	parent, _ := repo.Create(ctx, CreateTodoRequest{Title: "parent"})
+	first := 5
+	a, _ := repo.AddItem(ctx, parent.ID, CreateItemRequest{Title: "a", Position: &first})
+	b, _ := repo.AddItem(ctx, parent.ID, CreateItemRequest{Title: "b"})
+	if b.Position != 6 {
+		t.Fatalf("expected item appended after position 5, got %d", b.Position)
+	}
+
+	done := true
+	if _, err := repo.UpdateItem(ctx, parent.ID, a.ID, UpdateItemRequest{Done: &done}); err != nil {
+		t.Fatalf("UpdateItem: %v", err)
+	}
+	got, _ := repo.Get(ctx, parent.ID)
+	if got.Completed || got.Progress != (Progress{Done: 1, Total: 2}) {
+		t.Fatalf("unexpected parent: %+v", got)
+	}
+
+	// Removing the only open item leaves everything done.
+	if err := repo.DeleteItem(ctx, parent.ID, b.ID); err != nil {
+		t.Fatalf("DeleteItem: %v", err)
+	}
+	got, _ = repo.Get(ctx, parent.ID)
+	if !got.Completed || got.Progress != (Progress{Done: 1, Total: 1}) {
+		t.Fatalf("expected auto-completed parent: %+v", got)
+	}
+
+	if _, err := repo.UpdateItem(ctx, parent.ID, "missing", UpdateItemRequest{Done: &done}); err != ErrItemNotFound {
+		t.Fatalf("expected ErrItemNotFound, got %v", err)
+	}
+	if _, err := repo.AddItem(ctx, "missing", CreateItemRequest{Title: "x"}); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	// Ticking the last item of a recurring todo creates its next occurrence.
+	due := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+	rule := "FREQ=DAILY"
+	chore, _ := repo.Create(ctx, CreateTodoRequest{Title: "chore", DueAt: &due, Recurrence: &rule})
+	step, _ := repo.AddItem(ctx, chore.ID, CreateItemRequest{Title: "step"})
+	if _, err := repo.UpdateItem(ctx, chore.ID, step.ID, UpdateItemRequest{Done: &done}); err != nil {
+		t.Fatalf("UpdateItem: %v", err)
+	}
+	notDone := false
+	next, _ := repo.List(ctx, ListOptions{Completed: &notDone})
+	if len(next) != 1 || next[0].Title != "chore" || next[0].DueAt == nil || !next[0].DueAt.Equal(due.AddDate(0, 0, 1)) {
+		t.Fatalf("expected the next occurrence, got %+v", next)
+	}
+}
+
+func TestInMemoryRepository_Search(t *testing.T) {
+	repo := NewInMemoryRepository()
+	ctx := context.Background()
+	desc := func(s string) *string { return &s }
+
+	shopping, _ := repo.Create(ctx, CreateTodoRequest{Title: "Go shopping", Description: desc("buy apples and bread")})
+	apples, _ := repo.Create(ctx, CreateTodoRequest{Title: "Apples", Description: desc("pick apples at the farm")})
+	_, _ = repo.Create(ctx, CreateTodoRequest{Title: "Call the bank"})
+
+	search := func(q string) []SearchResult {
+		t.Helper()
+		res, err := repo.Search(ctx, SearchOptions{Query: q, Highlight: true})
+		if err != nil {
+			t.Fatalf("Search(%q) error: %v", q, err)
+		}
+		return res
+	}
+
+	res := search("apple")
+	if len(res) != 2 || res[0].ID != apples.ID || res[1].ID != shopping.ID {
+		t.Fatalf("expected title match ranked first, got %+v", res)
+	}
+	if res[0].Rank <= res[1].Rank || res[1].Rank <= 0 || res[0].Rank >= 1 {
+		t.Fatalf("unexpected ranks %v, %v", res[0].Rank, res[1].Rank)
+	}
+	if want := "<b>Apples</b>\npick <b>apples</b> at the farm"; res[0].Highlight == nil || *res[0].Highlight != want {
+		t.Fatalf("unexpected highlight %v", res[0].Highlight)
+	}
+
+	if res := search("shop bread"); len(res) != 1 || res[0].ID != shopping.ID {
+		t.Fatalf("expected all words to match, got %+v", res)
+	}
+	if res := search("apples -bread"); len(res) != 1 || res[0].ID != apples.ID {
+		t.Fatalf("expected negated word to exclude, got %+v", res)
+	}
+	if res := search("bank or farm"); len(res) != 2 {
+		t.Fatalf("expected or to match either, got %d", len(res))
+	}
+	if res := search("the"); len(res) != 0 {
+		t.Fatalf("expected stop words alone to match nothing, got %d", len(res))
+	}
+}
+
+func TestStem(t *testing.T) {
+	for in, want := range map[string]string{
+		"shopping": "shop",
+		"running":  "run",
+		"parties":  "party",
+		"apples":   "apple",
+		"called":   "call",
+		"glass":    "glass",
+		"bus":      "bus",
+	} {
+		if got := stem(in); got != want {
+			t.Errorf("stem(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestInMemoryRepository_Trash(t *testing.T) {
+	repo := NewInMemoryRepository()
+	ctx := context.Background()
+
+	list, _ := repo.CreateList(ctx, CreateListRequest{Name: "home"})
+	kept, _ := repo.Create(ctx, CreateTodoRequest{Title: "kept", Tags: []string{"x"}, ListID: &list.ID})
+	gone, _ := repo.Create(ctx, CreateTodoRequest{Title: "gone", Tags: []string{"x"}, ListID: &list.ID})
+
+	if err := repo.Delete(ctx, gone.ID, 0); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if err := repo.Delete(ctx, gone.ID, 0); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound deleting twice, got %v", err)
+	}
+	if _, err := repo.Get(ctx, gone.ID); err != ErrNotFound {
+		t.Fatalf("expected trashed todo hidden from Get, got %v", err)
+	}
+	if _, err := repo.Update(ctx, gone.ID, UpdateTodoRequest{}, 0); err != ErrNotFound {
+		t.Fatalf("expected trashed todo hidden from Update, got %v", err)
+	}
+	if n, _ := repo.Count(ctx, ListOptions{}); n != 1 {
+		t.Fatalf("expected trashed todo hidden from Count, got %d", n)
+	}
+	if tags, _ := repo.ListTags(ctx); len(tags) != 1 || tags[0].Count != 1 {
+		t.Fatalf("expected trashed todo ignored by tag counts: %+v", tags)
+	}
+	trash, _ := repo.Trash(ctx, 0, 0)
+	if len(trash) != 1 || trash[0].ID != gone.ID || trash[0].DeletedAt == nil {
+		t.Fatalf("unexpected trash: %+v", trash)
+	}
+
+	restored, err := repo.Restore(ctx, gone.ID)
+	if err != nil || restored.DeletedAt != nil {
+		t.Fatalf("Restore: %v %+v", err, restored)
+	}
+	if _, err := repo.Restore(ctx, gone.ID); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound restoring a live todo, got %v", err)
+	}
+
+	// Deleting the list moves its todos to the trash, detached from it.
+	if err := repo.DeleteList(ctx, list.ID, true); err != nil {
+		t.Fatalf("DeleteList: %v", err)
+	}
+	if trash, _ := repo.Trash(ctx, 0, 0); len(trash) != 2 || trash[0].ListID != nil {
+		t.Fatalf("expected list todos in trash: %+v", trash)
+	}
+	restored, err = repo.Restore(ctx, kept.ID)
+	if err != nil || restored.ListID != nil {
+		t.Fatalf("Restore after list delete: %v %+v", err, restored)
+	}
+
+	if n, err := repo.PurgeTrash(ctx, time.Now().Add(time.Second)); err != nil || n != 1 {
+		t.Fatalf("PurgeTrash: %d %v", n, err)
+	}
+	if err := repo.Purge(ctx, kept.ID, 0); err != nil {
+		t.Fatalf("Purge: %v", err)
+	}
+	if trash, _ := repo.Trash(ctx, 0, 0); len(trash) != 0 {
+		t.Fatalf("expected empty trash: %+v", trash)
+	}
+	if err := repo.Purge(ctx, kept.ID, 0); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound purging twice, got %v", err)
+	}
+}
+
+func TestPurgeTrash(t *testing.T) {
+	repo := NewInMemoryRepository()
+	ctx, cancel := context.WithCancel(context.Background())
+	old, _ := repo.Create(ctx, CreateTodoRequest{Title: "old"})
+	_ = repo.Delete(ctx, old.ID, 0)
+	time.Sleep(time.Millisecond)
+
+	done := make(chan struct{})
+	go func() {
+		PurgeTrash(ctx, repo, time.Nanosecond, time.Hour, slog.New(slog.NewTextHandler(io.Discard, nil)))
+		close(done)
+	}()
+	deadline := time.Now().Add(time.Second)
+	for {
+		if trash, _ := repo.Trash(ctx, 0, 0); len(trash) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("trash was not purged")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+}
+
+func TestInMemoryRepository_StatusTransitions(t *testing.T) {
+	repo := NewInMemoryRepository()
+	ctx := context.Background()
+	status := func(s Status) *Status { return &s }
+
+	td, _ := repo.Create(ctx, CreateTodoRequest{Title: "write report"})
+	if td.Status != StatusOpen || td.Completed || td.CompletedAt != nil {
+		t.Fatalf("unexpected new todo: %+v", td)
+	}
+	if _, err := repo.Update(ctx, td.ID, UpdateTodoRequest{Status: status(StatusArchived)}, 0); !errors.Is(err, ErrInvalidTransition) {
+		t.Fatalf("expected open -> archived to be rejected, got %v", err)
+	}
+	td, _ = repo.Update(ctx, td.ID, UpdateTodoRequest{Status: status(StatusInProgress)}, 0)
+	if td.Status != StatusInProgress || td.Completed {
+		t.Fatalf("unexpected in-progress todo: %+v", td)
+	}
+	done := true
+	td, _ = repo.Update(ctx, td.ID, UpdateTodoRequest{Completed: &done}, 0)
+	if td.Status != StatusDone || !td.Completed || td.CompletedAt == nil {
+		t.Fatalf("expected completed=true to mean done: %+v", td)
+	}
+	td, _ = repo.Update(ctx, td.ID, UpdateTodoRequest{Status: status(StatusArchived)}, 0)
+	if td.Status != StatusArchived || !td.Completed || td.CompletedAt == nil {
+		t.Fatalf("unexpected archived todo: %+v", td)
+	}
+	notDone := false
+	if _, err := repo.Update(ctx, td.ID, UpdateTodoRequest{Completed: &notDone}, 0); !errors.Is(err, ErrInvalidTransition) {
+		t.Fatalf("expected archived -> open to be rejected, got %v", err)
+	}
+
+	if list, _ := repo.List(ctx, ListOptions{}); len(list) != 0 {
+		t.Fatalf("expected archived todo hidden by default, got %d", len(list))
+	}
+	if list, _ := repo.List(ctx, ListOptions{IncludeArchived: true}); len(list) != 1 {
+		t.Fatalf("expected include archived to list it, got %d", len(list))
+	}
+	if list, _ := repo.List(ctx, ListOptions{Status: status(StatusArchived)}); len(list) != 1 {
+		t.Fatalf("expected status filter to list it, got %d", len(list))
+	}
+
+	td, _ = repo.Update(ctx, td.ID, UpdateTodoRequest{Status: status(StatusDone)}, 0)
+	td, _ = repo.Update(ctx, td.ID, UpdateTodoRequest{Status: status(StatusOpen)}, 0)
+	if td.Completed || td.CompletedAt != nil {
+		t.Fatalf("expected reopening to clear completion: %+v", td)
+	}
+}
+
+func TestInMemoryRepository_ArchiveCompleted(t *testing.T) {
+	repo := NewInMemoryRepository()
+	ctx := context.Background()
+
+	old, _ := repo.Create(ctx, CreateTodoRequest{Title: "old", Status: StatusDone})
+	_, _ = repo.Create(ctx, CreateTodoRequest{Title: "open"})
+	cutoff := time.Now().Add(time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+	recent, _ := repo.Create(ctx, CreateTodoRequest{Title: "recent", Status: StatusDone})
+
+	n, err := repo.ArchiveCompleted(ctx, cutoff)
+	if err != nil || n != 1 {
+		t.Fatalf("ArchiveCompleted: %d %v", n, err)
+	}
+	if got, _ := repo.Get(ctx, old.ID); got.Status != StatusArchived {
+		t.Fatalf("expected old todo archived: %+v", got)
+	}
+	if got, _ := repo.Get(ctx, recent.ID); got.Status != StatusDone {
+		t.Fatalf("expected recent todo left done: %+v", got)
+	}
+}
+
+func TestInMemoryRepository_Versions(t *testing.T) {
+	repo := NewInMemoryRepository()
+	ctx := context.Background()
+
+	td, _ := repo.Create(ctx, CreateTodoRequest{Title: "v"})
+	if td.Version != 1 {
+		t.Fatalf("expected version 1, got %d", td.Version)
+	}
+	title := "v2"
+	td, err := repo.Update(ctx, td.ID, UpdateTodoRequest{Title: &title}, 1)
+	if err != nil || td.Version != 2 {
+		t.Fatalf("conditional update: %v %+v", err, td)
+	}
+	if _, err := repo.Update(ctx, td.ID, UpdateTodoRequest{Title: &title}, 1); err != ErrVersionMismatch {
+		t.Fatalf("expected ErrVersionMismatch for stale update, got %v", err)
+	}
+	if td, _ = repo.AddTags(ctx, td.ID, []string{"x"}); td.Version != 3 {
+		t.Fatalf("expected tag change to bump version, got %d", td.Version)
+	}
+	if err := repo.Delete(ctx, td.ID, 2); err != ErrVersionMismatch {
+		t.Fatalf("expected ErrVersionMismatch for stale delete, got %v", err)
+	}
+	if err := repo.Delete(ctx, td.ID, 3); err != nil {
+		t.Fatalf("conditional delete: %v", err)
+	}
+	if err := repo.Purge(ctx, td.ID, 3); err != ErrVersionMismatch {
+		t.Fatalf("expected trashing to bump version, got %v", err)
+	}
+	if err := repo.Purge(ctx, td.ID, 4); err != nil {
+		t.Fatalf("conditional purge: %v", err)
+	}
+}
+
+func TestInMemoryRepository_Bulk(t *testing.T) {
+	repo := NewInMemoryRepository()
+	ctx := context.Background()
+	keep, _ := repo.Create(ctx, CreateTodoRequest{Title: "keep"})
+
+	op := func(action BulkAction, id, body string) BulkOperation {
+		o := BulkOperation{Op: action, ID: id}
+		if body != "" {
+			o.Todo = []byte(body)
+		}
+		if err := o.Validate(); err != nil {
+			t.Fatalf("validate %s: %v", action, err)
+		}
+		return o
+	}
+
+	// An atomic batch that fails part way leaves nothing behind.
+	ops := []BulkOperation{
+		op(BulkCreate, "", `{"title":"new"}`),
+		op(BulkUpdate, keep.ID, `{"title":"renamed"}`),
+		op(BulkDelete, "missing", ""),
+	}
+	results, err := repo.Bulk(ctx, ops, true)
+	if err != nil {
+		t.Fatalf("Bulk error: %v", err)
+	}
+	if !errors.Is(results[2].Err, ErrNotFound) || !errors.Is(results[0].Err, ErrBulkAborted) || !errors.Is(results[1].Err, ErrBulkAborted) {
+		t.Fatalf("unexpected atomic results: %+v", results)
+	}
+	if n, _ := repo.Count(ctx, ListOptions{}); n != 1 {
+		t.Fatalf("expected rollback to leave 1 todo, got %d", n)
+	}
+	if got, _ := repo.Get(ctx, keep.ID); got.Title != "keep" || got.Version != keep.Version {
+		t.Fatalf("update was not rolled back: %+v", got)
+	}
+
+	// Best effort applies what it can.
+	results, err = repo.Bulk(ctx, ops, false)
+	if err != nil {
+		t.Fatalf("Bulk error: %v", err)
+	}
+	if results[0].Err != nil || results[1].Err != nil || !errors.Is(results[2].Err, ErrNotFound) {
+		t.Fatalf("unexpected best-effort results: %+v", results)
+	}
+	if got, _ := repo.Get(ctx, keep.ID); got.Title != "renamed" {
+		t.Fatalf("expected update to apply, got %+v", got)
+	}
+	if n, _ := repo.Count(ctx, ListOptions{}); n != 2 {
+		t.Fatalf("expected 2 todos, got %d", n)
+	}
+
+	// Operations see the effects of earlier ones in the same batch.
+	ops = []BulkOperation{
+		op(BulkUpdate, keep.ID, `{"status":"done"}`),
+		op(BulkUpdate, keep.ID, `{"status":"archived"}`),
+		op(BulkDelete, keep.ID, ""),
+	}
+	if results, _ := repo.Bulk(ctx, ops, true); results[2].Err != nil {
+		t.Fatalf("unexpected chained results: %+v", results)
+	}
+	if _, err := repo.Get(ctx, keep.ID); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected todo to be trashed, got %v", err)
+	}
+}
+
+func TestInMemoryRepository_Upsert(t *testing.T) {
+	repo := NewInMemoryRepository()
+	ctx := context.Background()
+	due := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+	rule := "FREQ=WEEKLY"
+
+	created, isNew, err := repo.Upsert(ctx, "client-1", CreateTodoRequest{Title: "a", Status: StatusOpen, Tags: []string{"x"}, DueAt: &due, Recurrence: &rule}, 0)
+	if err != nil || !isNew || created.ID != "client-1" || created.Version != 1 {
+		t.Fatalf("create: %v %v %+v", err, isNew, created)
+	}
+	if _, err := repo.AddItem(ctx, "client-1", CreateItemRequest{Title: "step"}); err != nil {
+		t.Fatalf("AddItem: %v", err)
+	}
+	current, _ := repo.Get(ctx, "client-1")
+
+	later := due.AddDate(0, 0, 7)
+	replaced, isNew, err := repo.Upsert(ctx, "client-1", CreateTodoRequest{Title: "b", Status: StatusDone, DueAt: &later, Recurrence: &rule}, current.Version)
+	if err != nil || isNew {
+		t.Fatalf("replace: %v %v", err, isNew)
+	}
+	if replaced.Title != "b" || len(replaced.Tags) != 0 || replaced.Priority != PriorityNormal || replaced.Status != StatusDone || replaced.CompletedAt == nil {
+		t.Fatalf("fields not replaced: %+v", replaced)
+	}
+	if !replaced.CreatedAt.Equal(created.CreatedAt) || replaced.Version != current.Version+1 || replaced.Progress.Total != 1 {
+		t.Fatalf("identity not kept: %+v", replaced)
+	}
+	if replaced.RecurrenceStart == nil || !replaced.RecurrenceStart.Equal(due) {
+		t.Fatalf("expected series start to be kept, got %v", replaced.RecurrenceStart)
+	}
+
+	if _, _, err := repo.Upsert(ctx, "client-1", CreateTodoRequest{Title: "c", Status: StatusOpen}, 1); !errors.Is(err, ErrVersionMismatch) {
+		t.Fatalf("expected ErrVersionMismatch, got %v", err)
+	}
+	if _, _, err := repo.Upsert(ctx, "client-2", CreateTodoRequest{Title: "c", Status: StatusOpen}, 3); !errors.Is(err, ErrVersionMismatch) {
+		t.Fatalf("expected ErrVersionMismatch creating with a version, got %v", err)
+	}
+	missingList := "nope"
+	if _, _, err := repo.Upsert(ctx, "client-2", CreateTodoRequest{Title: "c", Status: StatusOpen, ListID: &missingList}, 0); !errors.Is(err, ErrListNotFound) {
+		t.Fatalf("expected ErrListNotFound, got %v", err)
+	}
+	_ = repo.Delete(ctx, "client-1", 0)
+	if _, _, err := repo.Upsert(ctx, "client-1", CreateTodoRequest{Title: "d", Status: StatusOpen}, 0); !errors.Is(err, ErrTodoInTrash) {
+		t.Fatalf("expected ErrTodoInTrash, got %v", err)
+	}
+}
+
+func TestInMemoryRepository_CreateWithID(t *testing.T) {
+	repo := NewInMemoryRepository()
+	ctx := context.Background()
+	other := reqctx.WithPrincipal(ctx, reqctx.Principal{Subject: "bob"})
+
+	created, err := repo.Create(ctx, CreateTodoRequest{ID: "client-1", Title: "a", Status: StatusOpen})
+	if err != nil || created.ID != "client-1" {
+		t.Fatalf("create: %v %+v", err, created)
+	}
+	if _, err := repo.Create(ctx, CreateTodoRequest{ID: "client-1", Title: "b", Status: StatusOpen}); !errors.Is(err, ErrTodoExists) {
+		t.Fatalf("expected ErrTodoExists, got %v", err)
+	}
+	if got, _ := repo.Get(ctx, "client-1"); got.Title != "a" {
+		t.Fatalf("expected the todo to be kept, got %+v", got)
+	}
+	if _, err := repo.Create(other, CreateTodoRequest{ID: "client-1", Title: "b", Status: StatusOpen}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for another owner's id, got %v", err)
+	}
+	_ = repo.Delete(ctx, "client-1", 0)
+	if _, err := repo.Create(ctx, CreateTodoRequest{ID: "client-1", Title: "b", Status: StatusOpen}); !errors.Is(err, ErrTodoInTrash) {
+		t.Fatalf("expected ErrTodoInTrash, got %v", err)
+	}
+}
+
+func TestInMemoryRepository_CompleteRecurring(t *testing.T) {
+	repo := NewInMemoryRepository()
+	ctx := context.Background()
+	due := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+	rule := "FREQ=DAILY"
+	notDone := false
+	open := func() int {
+		n, _ := repo.Count(ctx, ListOptions{Completed: &notDone})
+		return n
+	}
+
+	first, _ := repo.Create(ctx, CreateTodoRequest{Title: "water", Status: StatusOpen, DueAt: &due, Recurrence: &rule})
+	done := true
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _ = repo.Update(ctx, first.ID, UpdateTodoRequest{Completed: &done}, 0)
+		}()
+	}
+	wg.Wait()
+	if n := open(); n != 1 {
+		t.Fatalf("expected concurrent completions to create one occurrence, got %d open", n)
+	}
+
+	next, _ := repo.List(ctx, ListOptions{Completed: &notDone})
+	later := due.AddDate(0, 0, 1)
+	if _, _, err := repo.Upsert(ctx, next[0].ID, CreateTodoRequest{Title: "water", Status: StatusDone, DueAt: &later, Recurrence: &rule}, 0); err != nil {
+		t.Fatalf("Upsert: %v", err)
+	}
+	if _, _, err := repo.Upsert(ctx, next[0].ID, CreateTodoRequest{Title: "water again", Status: StatusDone, DueAt: &later, Recurrence: &rule}, 0); err != nil {
+		t.Fatalf("Upsert: %v", err)
+	}
+	if n := open(); n != 1 {
+		t.Fatalf("expected one occurrence after completing by Upsert, got %d open", n)
+	}
+
+	// Reopening an occurrence and completing it again does not advance the
+	// series a second time, whichever write completes it.
+	total, _ := repo.Count(ctx, ListOptions{})
+	for _, id := range []string{first.ID, next[0].ID} {
+		if _, err := repo.Update(ctx, id, UpdateTodoRequest{Completed: &notDone}, 0); err != nil {
+			t.Fatalf("reopen: %v", err)
+		}
+		if _, err := repo.Update(ctx, id, UpdateTodoRequest{Completed: &done}, 0); err != nil {
+			t.Fatalf("complete again: %v", err)
+		}
+	}
+	_, _ = repo.Update(ctx, first.ID, UpdateTodoRequest{Completed: &notDone}, 0)
+	results, _ := repo.Bulk(ctx, []BulkOperation{{Op: BulkUpdate, ID: first.ID, Update: &UpdateTodoRequest{Completed: &done}}}, true)
+	if results[0].Err != nil {
+		t.Fatalf("bulk complete: %v", results[0].Err)
+	}
+	if n, _ := repo.Count(ctx, ListOptions{}); n != total {
+		t.Fatalf("expected completing again to create nothing, have %d todos, want %d", n, total)
+	}
+}
+
+func TestInMemoryRepository_Owners(t *testing.T) {
+	repo := NewInMemoryRepository()
+	alice := reqctx.WithPrincipal(context.Background(), reqctx.Principal{Subject: "alice", TenantID: "acme"})
+	elsewhere := reqctx.WithPrincipal(context.Background(), reqctx.Principal{Subject: "alice", TenantID: "globex"})
+
+	mine, _ := repo.Create(alice, CreateTodoRequest{Title: "mine"})
+	if _, _, err := repo.Upsert(alice, "fixed-id", CreateTodoRequest{Title: "mine"}, 0); err != nil {
+		t.Fatal(err)
+	}
+	// The same subject in another tenant is another owner.
+	if _, err := repo.Get(elsewhere, mine.ID); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound across tenants, got %v", err)
+	}
+	if _, _, err := repo.Upsert(elsewhere, "fixed-id", CreateTodoRequest{Title: "theirs"}, 0); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for another owner's id, got %v", err)
+	}
+	results, _ := repo.Bulk(elsewhere, []BulkOperation{{Op: BulkDelete, ID: mine.ID}}, false)
+	if !errors.Is(results[0].Err, ErrNotFound) {
+		t.Fatalf("expected bulk delete of another owner's todo to fail, got %v", results[0].Err)
+	}
+	if n, _ := repo.Count(elsewhere, ListOptions{}); n != 0 {
+		t.Fatalf("expected no todos for another owner, got %d", n)
+	}
+
+	// Purging the trash is maintenance and covers every owner.
+	_ = repo.Delete(alice, mine.ID, 0)
+	time.Sleep(time.Millisecond)
+	if n, _ := repo.PurgeTrash(context.Background(), time.Now()); n != 1 {
+		t.Fatalf("expected the owner's trash to be purged, got %d", n)
+	}
+}
+
+func TestInMemoryRepository_Grants(t *testing.T) {
+	repo := NewInMemoryRepository()
+	alice := reqctx.WithPrincipal(context.Background(), reqctx.Principal{Subject: "alice", TenantID: "acme"})
+	bob := reqctx.WithPrincipal(context.Background(), reqctx.Principal{Subject: "bob", TenantID: "acme"})
+
+	l, _ := repo.CreateList(alice, CreateListRequest{Name: "team"})
+	inList, _ := repo.Create(alice, CreateTodoRequest{Title: "in list", ListID: &l.ID})
+	if _, _, err := repo.Upsert(alice, "fixed-id", CreateTodoRequest{Title: "solo"}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.CreateGrant(bob, ResourceTodo, "fixed-id", CreateGrantRequest{Subject: "carol", Role: policy.Admin}, "bob"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound sharing another owner's todo, got %v", err)
+	}
+	if _, err := repo.CreateGrant(alice, ResourceList, l.ID, CreateGrantRequest{Subject: "bob", Role: policy.Viewer}, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.CreateGrant(alice, ResourceTodo, "fixed-id", CreateGrantRequest{Subject: "bob", Role: policy.Editor}, "alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A list grant reaches the todos in the list, and only the subject's
+	// own grants are returned, whoever ctx acts for.
+	for id, want := range map[string]policy.Role{inList.ID: policy.Viewer, "fixed-id": policy.Editor} {
+		grants, err := repo.Access(actingFor(bob, OwnerOf(alice)), ResourceTodo, id)
+		if err != nil || len(grants) != 1 || grants[0].Role != want || grants[0].owner != OwnerOf(alice) {
+			t.Fatalf("Access(%s) = %+v, %v", id, grants, err)
+		}
+	}
+	if grants, _ := repo.Access(alice, ResourceTodo, inList.ID); len(grants) != 0 {
+		t.Fatalf("expected the owner to hold no grants, got %+v", grants)
+	}
+
+	// Purging a todo revokes its grants, so a new todo with its id starts
+	// unshared.
+	if err := repo.Purge(alice, "fixed-id", 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := repo.Upsert(alice, "fixed-id", CreateTodoRequest{Title: "new"}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if grants, _ := repo.Access(bob, ResourceTodo, "fixed-id"); len(grants) != 0 {
+		t.Fatalf("expected no grants on the new todo, got %+v", grants)
+	}
+	if shared, _ := repo.Shared(bob, 10, 0); len(shared) != 1 || shared[0].ResourceID != l.ID {
+		t.Fatalf("unexpected shared grants %+v", shared)
+	}
+}
@@ -0,0 +1,242 @@
+package todo
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// SearchOptions configures Repository.Search. The embedded ListOptions
+// filters and pages the results; its Sort and After fields are ignored
+// because results are ordered by relevance.
+type SearchOptions struct {
+	ListOptions
+	// Query uses web search syntax: words must all match, "or" separates
+	// alternatives, a leading "-" excludes a word and quotes group words.
+	Query string
+	// Highlight asks for the matched words to be marked up with <b> tags.
+	Highlight bool
+}
+
+// SearchResult is a todo matched by a full-text search. Rank is in [0, 1);
+// higher is more relevant. Highlight holds the title and description with
+// matches marked, when requested.
+type SearchResult struct {
+	Todo
+	Rank      float64 `json:"rank"`
+	Highlight *string `json:"highlight,omitempty"`
+}
+
+// Title matches weigh more than description matches, like the A and B
+// weights of the Postgres search vector.
+const (
+	titleWeight       = 1.0
+	descriptionWeight = 0.4
+)
+
+func (r *InMemoryRepository) Search(ctx context.Context, opts SearchOptions) ([]SearchResult, error) {
+	o := OwnerOf(ctx)
+	query := parseSearchQuery(opts.Query)
+	r.mu.RLock()
+	var results []SearchResult
+	for _, t := range r.store {
+		if t.owner != o || !opts.matches(t) {
+			continue
+		}
+		rank, ok := query.rank(t)
+		if !ok {
+			continue
+		}
+		res := SearchResult{Todo: t, Rank: rank}
+		if opts.Highlight {
+			h := query.highlight(searchText(t))
+			res.Highlight = &h
+		}
+		results = append(results, res)
+	}
+	r.mu.RUnlock()
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Rank != results[j].Rank {
+			return results[i].Rank > results[j].Rank
+		}
+		return DefaultSort.less(results[i].Todo, results[j].Todo)
+	})
+	return paginate(results, opts.Limit, opts.Offset), nil
+}
+
+// searchText is the text that is searched and highlighted. It matches the
+// expression used by PostgresRepository.
+func searchText(t Todo) string {
+	if t.Description == nil {
+		return t.Title
+	}
+	return t.Title + "\n" + *t.Description
+}
+
+// searchTerm is a stemmed word in a query, possibly negated.
+type searchTerm struct {
+	stem   string
+	negate bool
+}
+
+// searchQuery is a disjunction of conjunctions, the same shape Postgres
+// builds with websearch_to_tsquery. The in-memory version treats quoted
+// phrases as plain words and ignores their order.
+type searchQuery [][]searchTerm
+
+func parseSearchQuery(s string) searchQuery {
+	var (
+		query  searchQuery
+		clause []searchTerm
+	)
+	negate, quoted := false, false
+	flush := func() {
+		if len(clause) > 0 {
+			query = append(query, clause)
+		}
+		clause = nil
+	}
+	for _, field := range strings.FieldsFunc(s, unicode.IsSpace) {
+		if !quoted && strings.EqualFold(field, "or") {
+			flush()
+			continue
+		}
+		if !quoted {
+			negate = strings.HasPrefix(field, "-")
+		}
+		if strings.Count(field, `"`)%2 == 1 {
+			quoted = !quoted
+		}
+		for _, word := range searchWords(field) {
+			if stopWords[word] {
+				continue
+			}
+			clause = append(clause, searchTerm{stem: stem(word), negate: negate})
+		}
+	}
+	flush()
+	return query
+}
+
+// rank scores t against the query; the second result is false when t does
+// not match. Scores are normalized like ts_rank's rank/(rank+1) option.
+func (q searchQuery) rank(t Todo) (float64, bool) {
+	title := stemCounts(t.Title)
+	var description map[string]int
+	if t.Description != nil {
+		description = stemCounts(*t.Description)
+	}
+	score, matched := 0.0, false
+	for _, clause := range q {
+		clauseScore, ok := 0.0, true
+		for _, term := range clause {
+			n := title[term.stem] + description[term.stem]
+			if (n > 0) == term.negate {
+				ok = false
+				break
+			}
+			clauseScore += titleWeight*float64(title[term.stem]) + descriptionWeight*float64(description[term.stem])
+		}
+		if ok {
+			matched = true
+			score += clauseScore
+		}
+	}
+	if !matched {
+		return 0, false
+	}
+	return score / (score + 1), true
+}
+
+// highlight wraps every word of text that matches a non-negated term.
+func (q searchQuery) highlight(text string) string {
+	want := map[string]bool{}
+	for _, clause := range q {
+		for _, term := range clause {
+			if !term.negate {
+				want[term.stem] = true
+			}
+		}
+	}
+	var b strings.Builder
+	start := -1
+	flushWord := func(end int) {
+		word := text[start:end]
+		if want[stem(strings.ToLower(word))] {
+			b.WriteString("<b>" + word + "</b>")
+		} else {
+			b.WriteString(word)
+		}
+		start = -1
+	}
+	for i, r := range text {
+		if isWordRune(r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 {
+			flushWord(i)
+		}
+		b.WriteRune(r)
+	}
+	if start >= 0 {
+		flushWord(len(text))
+	}
+	return b.String()
+}
+
+func stemCounts(s string) map[string]int {
+	counts := map[string]int{}
+	for _, word := range searchWords(s) {
+		if !stopWords[word] {
+			counts[stem(word)]++
+		}
+	}
+	return counts
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// searchWords splits s into lowercase words.
+func searchWords(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool { return !isWordRune(r) })
+}
+
+// stem strips a few common English suffixes. It is far simpler than the
+// Snowball stemmer Postgres uses but catches plurals and verb forms.
+func stem(word string) string {
+	switch {
+	case len(word) > 4 && strings.HasSuffix(word, "ies"):
+		return word[:len(word)-3] + "y"
+	case len(word) > 5 && strings.HasSuffix(word, "ing"):
+		word = word[:len(word)-3]
+	case len(word) > 4 && strings.HasSuffix(word, "ed"):
+		word = word[:len(word)-2]
+	case len(word) > 3 && strings.HasSuffix(word, "s") && !strings.HasSuffix(word, "ss"):
+		return word[:len(word)-1]
+	default:
+		return word
+	}
+	// "shopping" -> "shopp" -> "shop"
+	if n := len(word); n > 2 && word[n-1] == word[n-2] && !strings.ContainsRune("aeiouls", rune(word[n-1])) {
+		word = word[:n-1]
+	}
+	return word
+}
+
+// stopWords are common English words that neither documents nor queries
+// are indexed by, as in the Postgres english configuration.
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true,
+	"be": true, "but": true, "by": true, "for": true, "from": true, "if": true,
+	"in": true, "into": true, "is": true, "it": true, "no": true, "not": true,
+	"of": true, "on": true, "or": true, "so": true, "that": true, "the": true,
+	"their": true, "then": true, "there": true, "these": true, "they": true,
+	"this": true, "to": true, "was": true, "were": true, "will": true, "with": true,
+}
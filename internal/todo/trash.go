@@ -0,0 +1,28 @@
+package todo
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// PurgeTrash permanently removes todos that have been in the trash longer
+// than retention, checking every interval until ctx is cancelled.
+func PurgeTrash(ctx context.Context, repo Repository, retention, interval time.Duration, logger *slog.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		n, err := repo.PurgeTrash(ctx, time.Now().Add(-retention))
+		switch {
+		case err != nil && ctx.Err() == nil:
+			logger.Error("could not purge trash", "error", err)
+		case n > 0:
+			logger.Info("purged trash", "count", n)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
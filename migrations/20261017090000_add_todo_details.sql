@@ -0,0 +1,15 @@
+-- +goose Up
+ALTER TABLE todos
+    ADD COLUMN IF NOT EXISTS description TEXT,
+    ADD COLUMN IF NOT EXISTS due_at TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS priority TEXT NOT NULL DEFAULT 'normal'
+        CONSTRAINT todos_priority_check CHECK (priority IN ('low', 'normal', 'high', 'urgent'));
+
+CREATE INDEX IF NOT EXISTS idx_todos_due_at ON todos (due_at) WHERE due_at IS NOT NULL;
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_todos_due_at;
+ALTER TABLE todos
+    DROP COLUMN IF EXISTS priority,
+    DROP COLUMN IF EXISTS due_at,
+    DROP COLUMN IF EXISTS description;
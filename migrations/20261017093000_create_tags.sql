@@ -0,0 +1,17 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS tags (
+    name TEXT PRIMARY KEY,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS todo_tags (
+    todo_id TEXT NOT NULL REFERENCES todos (id) ON DELETE CASCADE,
+    tag TEXT NOT NULL REFERENCES tags (name) ON DELETE CASCADE,
+    PRIMARY KEY (todo_id, tag)
+);
+
+CREATE INDEX IF NOT EXISTS idx_todo_tags_tag ON todo_tags (tag, todo_id);
+
+-- +goose Down
+DROP TABLE IF EXISTS todo_tags;
+DROP TABLE IF EXISTS tags;
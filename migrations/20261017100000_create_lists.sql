@@ -0,0 +1,20 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS lists (
+    id TEXT PRIMARY KEY,
+    name TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_lists_name ON lists (name, id);
+
+ALTER TABLE todos
+    ADD COLUMN IF NOT EXISTS list_id TEXT
+        CONSTRAINT todos_list_id_fkey REFERENCES lists (id) ON DELETE RESTRICT;
+
+CREATE INDEX IF NOT EXISTS idx_todos_list_id ON todos (list_id) WHERE list_id IS NOT NULL;
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_todos_list_id;
+ALTER TABLE todos DROP COLUMN IF EXISTS list_id;
+DROP TABLE IF EXISTS lists;
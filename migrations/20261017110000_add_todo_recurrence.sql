@@ -0,0 +1,11 @@
+-- +goose Up
+ALTER TABLE todos
+    ADD COLUMN IF NOT EXISTS recurrence TEXT,
+    ADD COLUMN IF NOT EXISTS recurrence_start TIMESTAMPTZ,
+    ADD CONSTRAINT todos_recurrence_due_check CHECK (recurrence IS NULL OR due_at IS NOT NULL);
+
+-- +goose Down
+ALTER TABLE todos
+    DROP CONSTRAINT IF EXISTS todos_recurrence_due_check,
+    DROP COLUMN IF EXISTS recurrence_start,
+    DROP COLUMN IF EXISTS recurrence;
@@ -0,0 +1,10 @@
+-- +goose Up
+ALTER TABLE todos ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+
+-- Serves GET /trash and the purger; live todos are not indexed here.
+CREATE INDEX IF NOT EXISTS idx_todos_deleted_at ON todos (deleted_at, id) WHERE deleted_at IS NOT NULL;
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_todos_deleted_at;
+DELETE FROM todos WHERE deleted_at IS NOT NULL;
+ALTER TABLE todos DROP COLUMN IF EXISTS deleted_at;
@@ -0,0 +1,21 @@
+-- +goose Up
+ALTER TABLE todos
+    ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'open'
+        CONSTRAINT todos_status_check CHECK (status IN ('open', 'in_progress', 'done', 'archived')),
+    ADD COLUMN IF NOT EXISTS completed_at TIMESTAMPTZ;
+
+-- Completion times were not recorded before; updated_at is the best guess.
+UPDATE todos SET status = 'done', completed_at = updated_at WHERE completed;
+
+-- completed is kept for existing clients and must agree with status.
+ALTER TABLE todos
+    ADD CONSTRAINT todos_completed_status_check CHECK (completed = (status IN ('done', 'archived')));
+
+CREATE INDEX IF NOT EXISTS idx_todos_done_completed_at ON todos (completed_at) WHERE status = 'done';
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_todos_done_completed_at;
+ALTER TABLE todos
+    DROP CONSTRAINT IF EXISTS todos_completed_status_check,
+    DROP COLUMN IF EXISTS completed_at,
+    DROP COLUMN IF EXISTS status;
@@ -0,0 +1,81 @@
+-- +goose Up
+-- The repository sets app.owner_id and app.tenant_id for each transaction.
+-- Unset, they mean the anonymous owner that data belongs to when
+-- authentication is off, and that every existing row is given.
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION app_owner_id() RETURNS TEXT
+    LANGUAGE sql STABLE AS $$ SELECT COALESCE(current_setting('app.owner_id', TRUE), '') $$;
+-- +goose StatementEnd
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION app_tenant_id() RETURNS TEXT
+    LANGUAGE sql STABLE AS $$ SELECT COALESCE(current_setting('app.tenant_id', TRUE), '') $$;
+-- +goose StatementEnd
+-- +goose StatementBegin
+-- app_all_owners is set by maintenance jobs, such as purging the trash,
+-- that act on every owner's rows.
+CREATE OR REPLACE FUNCTION app_all_owners() RETURNS BOOLEAN
+    LANGUAGE sql STABLE AS $$ SELECT COALESCE(current_setting('app.all_owners', TRUE), '') = 'on' $$;
+-- +goose StatementEnd
+
+ALTER TABLE todos
+    ADD COLUMN IF NOT EXISTS owner_id TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS tenant_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE lists
+    ADD COLUMN IF NOT EXISTS owner_id TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS tenant_id TEXT NOT NULL DEFAULT '';
+
+-- API keys act for their own ID unless created for another owner.
+ALTER TABLE api_keys
+    ADD COLUMN IF NOT EXISTS owner_id TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS tenant_id TEXT NOT NULL DEFAULT '';
+UPDATE api_keys SET owner_id = id WHERE owner_id = '';
+
+CREATE INDEX IF NOT EXISTS idx_todos_owner ON todos (tenant_id, owner_id, created_at, id);
+CREATE INDEX IF NOT EXISTS idx_lists_owner ON lists (tenant_id, owner_id, name, id);
+
+-- Row-level security backs up the owner checks in the queries. FORCE makes
+-- it apply to the table owner too, which the application usually connects
+-- as; superusers and BYPASSRLS roles still skip it.
+ALTER TABLE todos ENABLE ROW LEVEL SECURITY;
+ALTER TABLE todos FORCE ROW LEVEL SECURITY;
+CREATE POLICY todos_owner ON todos
+    USING (app_all_owners() OR (owner_id = app_owner_id() AND tenant_id = app_tenant_id()));
+
+ALTER TABLE lists ENABLE ROW LEVEL SECURITY;
+ALTER TABLE lists FORCE ROW LEVEL SECURITY;
+CREATE POLICY lists_owner ON lists
+    USING (app_all_owners() OR (owner_id = app_owner_id() AND tenant_id = app_tenant_id()));
+
+-- Tags and checklist items belong to whoever can see their todo; the
+-- subquery is itself filtered by the todos policy.
+ALTER TABLE todo_tags ENABLE ROW LEVEL SECURITY;
+ALTER TABLE todo_tags FORCE ROW LEVEL SECURITY;
+CREATE POLICY todo_tags_owner ON todo_tags
+    USING (EXISTS (SELECT 1 FROM todos WHERE todos.id = todo_tags.todo_id));
+
+ALTER TABLE checklist_items ENABLE ROW LEVEL SECURITY;
+ALTER TABLE checklist_items FORCE ROW LEVEL SECURITY;
+CREATE POLICY checklist_items_owner ON checklist_items
+    USING (EXISTS (SELECT 1 FROM todos WHERE todos.id = checklist_items.todo_id));
+
+-- +goose Down
+DROP POLICY IF EXISTS checklist_items_owner ON checklist_items;
+ALTER TABLE checklist_items NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE checklist_items DISABLE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS todo_tags_owner ON todo_tags;
+ALTER TABLE todo_tags NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE todo_tags DISABLE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS lists_owner ON lists;
+ALTER TABLE lists NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE lists DISABLE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS todos_owner ON todos;
+ALTER TABLE todos NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE todos DISABLE ROW LEVEL SECURITY;
+DROP INDEX IF EXISTS idx_lists_owner;
+DROP INDEX IF EXISTS idx_todos_owner;
+ALTER TABLE lists DROP COLUMN IF EXISTS tenant_id, DROP COLUMN IF EXISTS owner_id;
+ALTER TABLE todos DROP COLUMN IF EXISTS tenant_id, DROP COLUMN IF EXISTS owner_id;
+ALTER TABLE api_keys DROP COLUMN IF EXISTS tenant_id, DROP COLUMN IF EXISTS owner_id;
+DROP FUNCTION IF EXISTS app_all_owners();
+DROP FUNCTION IF EXISTS app_tenant_id();
+DROP FUNCTION IF EXISTS app_owner_id();
@@ -0,0 +1,48 @@
+-- +goose Up
+-- A grant shares one todo or one list, and goes away with it. owner_id and
+-- tenant_id are the resource owner's; the subject is in the same tenant.
+CREATE TABLE IF NOT EXISTS grants (
+    todo_id TEXT REFERENCES todos (id) ON DELETE CASCADE,
+    list_id TEXT REFERENCES lists (id) ON DELETE CASCADE,
+    subject TEXT NOT NULL,
+    role TEXT NOT NULL CHECK (role IN ('viewer', 'editor', 'admin')),
+    granted_by TEXT NOT NULL,
+    owner_id TEXT NOT NULL,
+    tenant_id TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    CHECK ((todo_id IS NULL) <> (list_id IS NULL))
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_grants_todo ON grants (todo_id, subject) WHERE todo_id IS NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_grants_list ON grants (list_id, subject) WHERE list_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_grants_subject ON grants (tenant_id, subject, created_at);
+
+-- Owners see the grants on their todos and lists, subjects the grants
+-- they hold.
+ALTER TABLE grants ENABLE ROW LEVEL SECURITY;
+ALTER TABLE grants FORCE ROW LEVEL SECURITY;
+CREATE POLICY grants_owner ON grants
+    USING (app_all_owners() OR (owner_id = app_owner_id() AND tenant_id = app_tenant_id()));
+CREATE POLICY grants_subject ON grants FOR SELECT
+    USING (subject = app_owner_id() AND tenant_id = app_tenant_id());
+
+-- Shared todos and lists can be read by their subjects, which is how the
+-- application finds the list a shared todo is in. Changes are still made
+-- as the owner, once the grant has been checked.
+CREATE POLICY todos_shared ON todos FOR SELECT
+    USING (EXISTS (
+        SELECT 1 FROM grants g
+        WHERE g.subject = app_owner_id() AND g.tenant_id = app_tenant_id()
+            AND (g.todo_id = todos.id OR g.list_id = todos.list_id)
+    ));
+CREATE POLICY lists_shared ON lists FOR SELECT
+    USING (EXISTS (
+        SELECT 1 FROM grants g
+        WHERE g.subject = app_owner_id() AND g.tenant_id = app_tenant_id() AND g.list_id = lists.id
+    ));
+
+-- +goose Down
+DROP POLICY IF EXISTS lists_shared ON lists;
+DROP POLICY IF EXISTS todos_shared ON todos;
+DROP TABLE IF EXISTS grants;
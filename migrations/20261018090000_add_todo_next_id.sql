@@ -0,0 +1,8 @@
+-- +goose Up
+-- The occurrence created when a recurring todo was first completed, so that
+-- reopening and completing it again does not advance the series twice. There
+-- is no foreign key: the successor may be deleted without reviving this.
+ALTER TABLE todos ADD COLUMN IF NOT EXISTS next_id TEXT;
+
+-- +goose Down
+ALTER TABLE todos DROP COLUMN IF EXISTS next_id;